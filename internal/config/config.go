@@ -62,9 +62,21 @@ type Https struct {
 	GenerateCertsHosts string `gcfg:"generate-certs-hosts"`     // Hostnames to generate certificates for if missing and GenerateCerts==true
 }
 
+type OFXSync struct {
+	Enabled       bool   // whether to store OFX passwords and sync accounts with them in the background
+	Key           string // secret used to encrypt stored OFX passwords
+	CheckInterval int    `gcfg:"check-interval"` // minutes between checks for accounts due to be synced
+}
+
+type Schedule struct {
+	CheckInterval int `gcfg:"check-interval"` // minutes between checks for scheduled transactions which have come due
+}
+
 type Config struct {
-	MoneyGo MoneyGo
-	Https   Https
+	MoneyGo  MoneyGo
+	Https    Https
+	OFXSync  OFXSync
+	Schedule Schedule
 }
 
 func ReadConfig(filename string) (*Config, error) {
@@ -82,11 +94,28 @@ func ReadConfig(filename string) (*Config, error) {
 			GenerateCerts:      false,
 			GenerateCertsHosts: "localhost",
 		},
+		OFXSync: OFXSync{
+			Enabled:       false,
+			CheckInterval: 5,
+		},
+		Schedule: Schedule{
+			CheckInterval: 60,
+		},
 	}
 
 	err := gcfg.ReadFileInto(&cfg, filename)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse config file: %s", err)
 	}
+
+	if cfg.OFXSync.CheckInterval <= 0 {
+		return nil, fmt.Errorf("Invalid config file: [ofxsync] check-interval must be greater than 0, not %d", cfg.OFXSync.CheckInterval)
+	}
+	if cfg.OFXSync.Enabled && len(cfg.OFXSync.Key) == 0 {
+		return nil, errors.New("Invalid config file: [ofxsync] key must be set if 'enabled = true'")
+	}
+	if cfg.Schedule.CheckInterval <= 0 {
+		return nil, fmt.Errorf("Invalid config file: [schedule] check-interval must be greater than 0, not %d", cfg.Schedule.CheckInterval)
+	}
 	return &cfg, nil
 }
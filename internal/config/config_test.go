@@ -84,6 +84,88 @@ func TestGenerateCertsConfig(t *testing.T) {
 	}
 }
 
+func TestOFXSyncConfig(t *testing.T) {
+	cfg, err := config.ReadConfig("./testdata/ofxsync_config.ini")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing config: %s\n", err)
+	}
+
+	if !cfg.OFXSync.Enabled {
+		t.Errorf("OFXSync.Enabled not true")
+	}
+	if cfg.OFXSync.Key != "correct horse battery staple" {
+		t.Errorf("OFXSync.Key '%s', not 'correct horse battery staple'", cfg.OFXSync.Key)
+	}
+	if cfg.OFXSync.CheckInterval != 15 {
+		t.Errorf("OFXSync.CheckInterval %d instead of 15", cfg.OFXSync.CheckInterval)
+	}
+
+	// Syncing is disabled unless configured, both in the example config and
+	// in configs without an [ofxsync] section
+	for _, filename := range []string{
+		"./testdata/sqlite_https_config.ini",
+		"./testdata/postgres_fcgi_config.ini",
+	} {
+		cfg, err = config.ReadConfig(filename)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing config: %s\n", err)
+		}
+		if cfg.OFXSync.Enabled {
+			t.Errorf("OFXSync.Enabled unexpectedly true in %s", filename)
+		}
+		if cfg.OFXSync.Key != "" {
+			t.Errorf("OFXSync.Key '%s' unexpectedly set in %s", cfg.OFXSync.Key, filename)
+		}
+		if cfg.OFXSync.CheckInterval != 5 {
+			t.Errorf("OFXSync.CheckInterval %d instead of 5 in %s", cfg.OFXSync.CheckInterval, filename)
+		}
+	}
+}
+
+func TestInvalidOFXSyncConfig(t *testing.T) {
+	for _, filename := range []string{
+		"./testdata/ofxsync_no_key_config.ini",
+		"./testdata/ofxsync_zero_interval_config.ini",
+	} {
+		cfg, err := config.ReadConfig(filename)
+		if err == nil || cfg != nil {
+			t.Errorf("Expected error parsing invalid config %s", filename)
+		}
+	}
+}
+
+func TestScheduleConfig(t *testing.T) {
+	cfg, err := config.ReadConfig("./testdata/schedule_config.ini")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing config: %s\n", err)
+	}
+	if cfg.Schedule.CheckInterval != 10 {
+		t.Errorf("Schedule.CheckInterval %d instead of 10", cfg.Schedule.CheckInterval)
+	}
+
+	// Both the example config and configs without a [schedule] section check
+	// hourly
+	for _, filename := range []string{
+		"./testdata/sqlite_https_config.ini",
+		"./testdata/postgres_fcgi_config.ini",
+	} {
+		cfg, err = config.ReadConfig(filename)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing config: %s\n", err)
+		}
+		if cfg.Schedule.CheckInterval != 60 {
+			t.Errorf("Schedule.CheckInterval %d instead of 60 in %s", cfg.Schedule.CheckInterval, filename)
+		}
+	}
+}
+
+func TestInvalidScheduleConfig(t *testing.T) {
+	cfg, err := config.ReadConfig("./testdata/schedule_negative_interval_config.ini")
+	if err == nil || cfg != nil {
+		t.Errorf("Expected error parsing config with negative schedule check-interval")
+	}
+}
+
 func TestNonexistentConfig(t *testing.T) {
 	cfg, err := config.ReadConfig("./testdata/nonexistent_config.ini")
 	if err == nil || cfg != nil {
@@ -130,10 +130,16 @@ func AccountHandler(r *http.Request, context *Context) ResponseWriterWriter {
 	if r.Method == "POST" {
 		if !context.LastLevel() {
 			accountid, err := context.NextID()
-			if err != nil || context.NextLevel() != "imports" {
+			if err != nil {
 				return NewError(3 /*Invalid Request*/)
 			}
-			return AccountImportHandler(context, r, user, accountid)
+			switch context.NextLevel() {
+			case "imports":
+				return AccountImportHandler(context, r, user, accountid)
+			case "reconciliations":
+				return AccountReconciliationHandler(context, r, user, accountid)
+			}
+			return NewError(3 /*Invalid Request*/)
 		}
 
 		var account models.Account
@@ -190,14 +196,29 @@ func AccountHandler(r *http.Request, context *Context) ResponseWriterWriter {
 			}
 
 			return account
-		} else if context.NextLevel() == "transactions" {
+		}
+
+		switch context.NextLevel() {
+		case "transactions":
 			return AccountTransactionsHandler(context, r, user, accountid)
+		case "imports":
+			return AccountImportHandler(context, r, user, accountid)
+		case "exports":
+			return AccountExportHandler(context, r, user, accountid)
+		case "reconciliations":
+			return AccountReconciliationHandler(context, r, user, accountid)
 		}
 	} else {
 		accountid, err := context.NextID()
 		if err != nil {
 			return NewError(3 /*Invalid Request*/)
 		}
+		if !context.LastLevel() {
+			if context.NextLevel() != "reconciliations" {
+				return NewError(3 /*Invalid Request*/)
+			}
+			return AccountReconciliationHandler(context, r, user, accountid)
+		}
 		if r.Method == "PUT" {
 			var account models.Account
 			if err := ReadJSON(r, &account); err != nil || account.AccountId != accountid {
@@ -0,0 +1,518 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InvalidBackupError is returned when a backup can't be restored because of
+// a problem with the backup itself, or because the user isn't empty
+type InvalidBackupError struct {
+	message string
+}
+
+func (e InvalidBackupError) Error() string {
+	return e.message
+}
+
+// BackupUser returns a backup of everything user owns
+func BackupUser(tx store.Tx, user *models.User) (*models.Backup, error) {
+	backup := models.Backup{
+		Version: models.BackupVersion,
+		Created: time.Now().UTC(),
+		User:    *user,
+	}
+
+	securities, err := tx.GetSecurities(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.Securities = *securities
+	sort.Slice(backup.Securities, func(i, j int) bool {
+		return backup.Securities[i].SecurityId < backup.Securities[j].SecurityId
+	})
+	for _, s := range backup.Securities {
+		prices, err := tx.GetPrices(s.SecurityId)
+		if err != nil {
+			return nil, err
+		}
+		backup.Prices = append(backup.Prices, *prices...)
+	}
+	sort.Slice(backup.Prices, func(i, j int) bool {
+		return backup.Prices[i].PriceId < backup.Prices[j].PriceId
+	})
+
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.Accounts = *accounts
+	sort.Slice(backup.Accounts, func(i, j int) bool {
+		return backup.Accounts[i].AccountId < backup.Accounts[j].AccountId
+	})
+	for _, a := range backup.Accounts {
+		exists, err := tx.CSVImportMappingExists(a.AccountId, user.UserId)
+		if err != nil {
+			return nil, err
+		} else if exists {
+			mapping, err := tx.GetCSVImportMapping(a.AccountId, user.UserId)
+			if err != nil {
+				return nil, err
+			}
+			backup.CSVImportMappings = append(backup.CSVImportMappings, mapping)
+		}
+
+		reconciliations, err := tx.GetReconciliations(a.AccountId, user.UserId)
+		if err != nil {
+			return nil, err
+		}
+		backup.Reconciliations = append(backup.Reconciliations, *reconciliations...)
+	}
+	sort.Slice(backup.Reconciliations, func(i, j int) bool {
+		return backup.Reconciliations[i].ReconciliationId < backup.Reconciliations[j].ReconciliationId
+	})
+
+	tags, err := tx.GetTags(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.Tags = *tags
+	sort.Slice(backup.Tags, func(i, j int) bool {
+		return backup.Tags[i].TagId < backup.Tags[j].TagId
+	})
+
+	transactions, err := tx.GetTransactions(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.Transactions = *transactions
+	sort.Slice(backup.Transactions, func(i, j int) bool {
+		return backup.Transactions[i].TransactionId < backup.Transactions[j].TransactionId
+	})
+
+	reports, err := tx.GetReports(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.Reports = *reports
+	sort.Slice(backup.Reports, func(i, j int) bool {
+		return backup.Reports[i].ReportId < backup.Reports[j].ReportId
+	})
+
+	rules, err := tx.GetRules(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.Rules = *rules
+
+	scheduled, err := tx.GetScheduledTransactions(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.ScheduledTransactions = *scheduled
+	sort.Slice(backup.ScheduledTransactions, func(i, j int) bool {
+		return backup.ScheduledTransactions[i].ScheduledTransactionId < backup.ScheduledTransactions[j].ScheduledTransactionId
+	})
+
+	reminders, err := tx.GetReminders(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.Reminders = *reminders
+
+	budgets, err := tx.GetBudgets(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	backup.Budgets = *budgets
+	sort.Slice(backup.Budgets, func(i, j int) bool {
+		return backup.Budgets[i].BudgetId < backup.Budgets[j].BudgetId
+	})
+
+	return &backup, nil
+}
+
+// restoreTagIds returns the restored IDs of the tags in tagids, or false if
+// any of them weren't in the backup
+func restoreTagIds(tagids []int64, tagMap map[int64]int64) ([]int64, bool) {
+	var restored []int64
+	for _, tagid := range tagids {
+		id, ok := tagMap[tagid]
+		if !ok {
+			return nil, false
+		}
+		restored = append(restored, id)
+	}
+	return restored, true
+}
+
+// RestoreUser loads backup into user, who must not have any accounts or
+// reports. The user's existing securities are replaced by those in the
+// backup, and their name, email, and default currency are set from it. Tags
+// in the backup are merged into any of the user's tags with the same name.
+func RestoreUser(tx store.Tx, user *models.User, backup *models.Backup) error {
+	if backup.Version < 1 || backup.Version > models.BackupVersion {
+		return InvalidBackupError{fmt.Sprintf("Unsupported backup version: %d", backup.Version)}
+	}
+
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return err
+	}
+	reports, err := tx.GetReports(user.UserId)
+	if err != nil {
+		return err
+	}
+	if len(*accounts) > 0 || len(*reports) > 0 {
+		return InvalidBackupError{"Backups can only be restored to users without any accounts or reports"}
+	}
+	existing, err := tx.GetSecurities(user.UserId)
+	if err != nil {
+		return err
+	}
+
+	securityMap := make(map[int64]int64)
+	for _, s := range backup.Securities {
+		security := *s
+		if security.Precision > models.MaxPrecision || (security.Type != models.Currency && security.Type != models.Stock) {
+			return InvalidBackupError{fmt.Sprintf("Invalid security: %d", s.SecurityId)}
+		}
+		if s.SecurityId == backup.User.DefaultCurrency && s.Type != models.Currency {
+			return InvalidBackupError{"User's default currency isn't a currency"}
+		}
+		security.SecurityId = -1
+		security.UserId = user.UserId
+		if err := tx.InsertSecurity(&security); err != nil {
+			return err
+		}
+		securityMap[s.SecurityId] = security.SecurityId
+	}
+
+	defaultCurrency, ok := securityMap[backup.User.DefaultCurrency]
+	if !ok {
+		return InvalidBackupError{"Backup is missing the user's default currency"}
+	}
+	user.DefaultCurrency = defaultCurrency
+	user.Name = backup.User.Name
+	user.Email = backup.User.Email
+	if err := UpdateUser(tx, user); err != nil {
+		return err
+	}
+	for _, s := range *existing {
+		if err := tx.DeleteSecurity(s); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range backup.Prices {
+		price := *p
+		var ok1, ok2 bool
+		price.SecurityId, ok1 = securityMap[p.SecurityId]
+		price.CurrencyId, ok2 = securityMap[p.CurrencyId]
+		if !ok1 || !ok2 {
+			return InvalidBackupError{fmt.Sprintf("Price %d refers to a missing security", p.PriceId)}
+		}
+		price.PriceId = -1
+		if err := tx.InsertPrice(&price); err != nil {
+			return err
+		}
+	}
+
+	// Accounts must be restored after their parents, so keep making passes
+	// through those remaining until no more can be restored
+	accountMap := make(map[int64]int64)
+	remaining := backup.Accounts
+	for len(remaining) > 0 {
+		var next []*models.Account
+		for _, a := range remaining {
+			parentId, ok := accountMap[a.ParentAccountId]
+			if a.ParentAccountId == -1 {
+				parentId, ok = -1, true
+			}
+			if !ok {
+				next = append(next, a)
+				continue
+			}
+			account := *a
+			account.AccountId = -1
+			account.UserId = user.UserId
+			account.ParentAccountId = parentId
+			account.AccountVersion = 0
+			if account.SecurityId, ok = securityMap[a.SecurityId]; !ok {
+				return InvalidBackupError{fmt.Sprintf("Account %d refers to a missing security", a.AccountId)}
+			}
+			if err := tx.InsertAccount(&account); err != nil {
+				return err
+			}
+			accountMap[a.AccountId] = account.AccountId
+		}
+		if len(next) == len(remaining) {
+			return InvalidBackupError{"Backup contains accounts with missing or circular parents"}
+		}
+		remaining = next
+	}
+
+	for _, m := range backup.CSVImportMappings {
+		mapping := *m
+		var ok bool
+		if mapping.AccountId, ok = accountMap[m.AccountId]; !ok {
+			return InvalidBackupError{fmt.Sprintf("CSV import mapping %d refers to a missing account", m.CSVImportMappingId)}
+		}
+		mapping.CSVImportMappingId = -1
+		mapping.UserId = user.UserId
+		if err := tx.InsertCSVImportMapping(&mapping); err != nil {
+			return err
+		}
+	}
+
+	// Tags are merged into any of the user's existing tags with the same name
+	tags, err := tx.GetTags(user.UserId)
+	if err != nil {
+		return err
+	}
+	tagMap := make(map[int64]int64)
+	for _, t := range backup.Tags {
+		tag := *t
+		tag.TagId = -1
+		tag.UserId = user.UserId
+		tag.Name = strings.TrimSpace(tag.Name)
+		if !tag.Valid() {
+			return InvalidBackupError{fmt.Sprintf("Tag %d is invalid", t.TagId)}
+		}
+		for _, existing := range *tags {
+			if strings.EqualFold(existing.Name, tag.Name) {
+				tag.TagId = existing.TagId
+			}
+		}
+		if tag.TagId == -1 {
+			if err := tx.InsertTag(&tag); err != nil {
+				return err
+			}
+			*tags = append(*tags, &tag)
+		}
+		tagMap[t.TagId] = tag.TagId
+	}
+
+	// Map each split in the backup to its restored split, and to the account
+	// it was in, so reconciliations can be restored
+	splitMap := make(map[int64]int64)
+	splitAccounts := make(map[int64]int64)
+	for _, t := range backup.Transactions {
+		transaction := *t
+		transaction.TransactionId = -1
+		transaction.UserId = user.UserId
+		transaction.Splits = nil
+		var ok bool
+		if transaction.TagIds, ok = restoreTagIds(t.TagIds, tagMap); !ok {
+			return InvalidBackupError{fmt.Sprintf("Transaction %d refers to a missing tag", t.TransactionId)}
+		}
+		for _, s := range t.Splits {
+			split := *s
+			if split.TagIds, ok = restoreTagIds(s.TagIds, tagMap); !ok {
+				return InvalidBackupError{fmt.Sprintf("Split %d refers to a missing tag", s.SplitId)}
+			}
+			if s.AccountId != -1 {
+				if split.AccountId, ok = accountMap[s.AccountId]; !ok {
+					return InvalidBackupError{fmt.Sprintf("Split %d refers to a missing account", s.SplitId)}
+				}
+			}
+			if s.SecurityId != -1 {
+				if split.SecurityId, ok = securityMap[s.SecurityId]; !ok {
+					return InvalidBackupError{fmt.Sprintf("Split %d refers to a missing security", s.SplitId)}
+				}
+			}
+			transaction.Splits = append(transaction.Splits, &split)
+		}
+		if !transaction.Valid() {
+			return InvalidBackupError{fmt.Sprintf("Transaction %d is invalid", t.TransactionId)}
+		}
+		if err := tx.InsertTransaction(&transaction, user); err != nil {
+			return err
+		}
+		for i, s := range t.Splits {
+			splitMap[s.SplitId] = transaction.Splits[i].SplitId
+			splitAccounts[s.SplitId] = s.AccountId
+		}
+	}
+
+	for _, r := range backup.Reconciliations {
+		reconciliation := *r
+		var ok bool
+		if reconciliation.AccountId, ok = accountMap[r.AccountId]; !ok {
+			return InvalidBackupError{fmt.Sprintf("Reconciliation %d refers to a missing account", r.ReconciliationId)}
+		}
+		reconciliation.SplitIds = nil
+		for _, splitid := range r.SplitIds {
+			restored, ok := splitMap[splitid]
+			if !ok || splitAccounts[splitid] != r.AccountId {
+				return InvalidBackupError{fmt.Sprintf("Reconciliation %d refers to a missing split", r.ReconciliationId)}
+			}
+			reconciliation.SplitIds = append(reconciliation.SplitIds, restored)
+		}
+		reconciliation.ReconciliationId = -1
+		reconciliation.UserId = user.UserId
+		if err := tx.InsertReconciliation(&reconciliation); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range backup.Rules {
+		rule := *r
+		rule.RuleId = -1
+		rule.UserId = user.UserId
+		if r.SourceAccountId != -1 {
+			var ok bool
+			if rule.SourceAccountId, ok = accountMap[r.SourceAccountId]; !ok {
+				return InvalidBackupError{fmt.Sprintf("Rule %d refers to a missing account", r.RuleId)}
+			}
+		}
+		rule.Targets = nil
+		for _, t := range r.Targets {
+			target := *t
+			var ok bool
+			if target.AccountId, ok = accountMap[t.AccountId]; !ok {
+				return InvalidBackupError{fmt.Sprintf("Rule %d refers to a missing account", r.RuleId)}
+			}
+			rule.Targets = append(rule.Targets, &target)
+		}
+		if !rule.Valid() {
+			return InvalidBackupError{fmt.Sprintf("Rule %d is invalid", r.RuleId)}
+		}
+		if err := tx.InsertRule(&rule); err != nil {
+			return err
+		}
+	}
+
+	scheduledMap := make(map[int64]int64)
+	for _, st := range backup.ScheduledTransactions {
+		scheduled := *st
+		scheduled.ScheduledTransactionId = -1
+		scheduled.UserId = user.UserId
+		scheduled.Template.Splits = nil
+		for _, s := range st.Template.Splits {
+			split := *s
+			var ok bool
+			if split.AccountId, ok = accountMap[s.AccountId]; !ok {
+				return InvalidBackupError{fmt.Sprintf("Scheduled transaction %d refers to a missing account", st.ScheduledTransactionId)}
+			}
+			scheduled.Template.Splits = append(scheduled.Template.Splits, &split)
+		}
+		if !scheduled.Valid() {
+			return InvalidBackupError{fmt.Sprintf("Scheduled transaction %d is invalid", st.ScheduledTransactionId)}
+		}
+		if err := tx.InsertScheduledTransaction(&scheduled); err != nil {
+			return err
+		}
+		scheduledMap[st.ScheduledTransactionId] = scheduled.ScheduledTransactionId
+	}
+
+	for _, r := range backup.Reminders {
+		reminder := *r
+		var ok bool
+		if reminder.ScheduledTransactionId, ok = scheduledMap[r.ScheduledTransactionId]; !ok {
+			return InvalidBackupError{fmt.Sprintf("Reminder %d refers to a missing scheduled transaction", r.ReminderId)}
+		}
+		reminder.ReminderId = -1
+		reminder.UserId = user.UserId
+		if err := tx.InsertReminder(&reminder); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range backup.Budgets {
+		budget := *b
+		budget.BudgetId = -1
+		budget.UserId = user.UserId
+		budget.Amounts = nil
+		for _, a := range b.Amounts {
+			if a == nil {
+				return InvalidBackupError{fmt.Sprintf("Budget %d is invalid", b.BudgetId)}
+			}
+			amount := *a
+			var ok bool
+			if amount.AccountId, ok = accountMap[a.AccountId]; !ok {
+				return InvalidBackupError{fmt.Sprintf("Budget %d refers to a missing account", b.BudgetId)}
+			}
+			budget.Amounts = append(budget.Amounts, &amount)
+		}
+		if !validBudget(tx, user, &budget) {
+			return InvalidBackupError{fmt.Sprintf("Budget %d is invalid", b.BudgetId)}
+		}
+		if err := tx.InsertBudget(&budget); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range backup.Reports {
+		report := *r
+		report.ReportId = -1
+		report.UserId = user.UserId
+		if err := tx.InsertReport(&report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func UserBackupHandler(context *Context, user *models.User) ResponseWriterWriter {
+	backup, err := BackupUser(context.Tx, user)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	data, err := json.Marshal(backup)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return FileWriter{
+		ContentType: "application/json",
+		Filename:    "moneygo-backup-" + backup.Created.Format("20060102") + ".json",
+		Data:        data,
+	}
+}
+
+func UserRestoreHandler(r *http.Request, context *Context, user *models.User) ResponseWriterWriter {
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	// Assume there is only one 'part' and it's the one we care about
+	part, err := multipartReader.NextPart()
+	if err != nil {
+		if err == io.EOF {
+			return NewError(3 /*Invalid Request*/)
+		} else {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+	}
+
+	var backup models.Backup
+	if err := json.NewDecoder(part).Decode(&backup); err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	if err := RestoreUser(context.Tx, user, &backup); err != nil {
+		if _, ok := err.(InvalidBackupError); ok {
+			log.Print(err)
+			return NewError(3 /*Invalid Request*/)
+		}
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return user
+}
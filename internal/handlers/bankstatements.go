@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// BankStatement holds the entries and balances parsed from a single bank
+// statement, such as those in camt.053 and MT940 files. Like other imports, it
+// refers to its account and currency using placeholder IDs.
+type BankStatement struct {
+	Securities   []models.Security
+	Accounts     []models.Account
+	Transactions []models.Transaction
+
+	// The account's balance before OpeningDate and before ClosingDate,
+	// respectively (i.e. OpeningBalance includes no entries from this
+	// statement, ClosingBalance includes all of them)
+	OpeningBalance models.Amount
+	OpeningDate    time.Time
+	ClosingBalance models.Amount
+	ClosingDate    time.Time
+}
+
+// bankStatementEntry is a single booked entry on a bank statement
+type bankStatementEntry struct {
+	Date        time.Time
+	Amount      *big.Rat
+	Reference   string // Entry reference assigned by the bank
+	Number      string
+	Description string
+	Memo        string
+}
+
+// newBankStatement returns a BankStatement for the account identified by
+// externalAccountId, with entries in the currency with the given ISO 4217 code
+func newBankStatement(externalAccountId, currency string) (*BankStatement, error) {
+	template := FindSecurityTemplate(currency, models.Currency)
+	if template == nil {
+		return nil, fmt.Errorf("Failed to find Security for \"%s\"", currency)
+	}
+	var s BankStatement
+	security := *template
+	security.SecurityId = 1
+	s.Securities = append(s.Securities, security)
+	s.Accounts = append(s.Accounts, models.Account{
+		AccountId:         1,
+		ExternalAccountId: externalAccountId,
+		SecurityId:        security.SecurityId,
+		ParentAccountId:   -1,
+		Type:              models.Bank,
+	})
+	return &s, nil
+}
+
+// bankStatementText joins s into a single line, collapsing whitespace
+func bankStatementText(s ...string) string {
+	return strings.Join(strings.Fields(strings.Join(s, " ")), " ")
+}
+
+// dateOnly returns midnight UTC of the day t falls on in its own location,
+// since bank statement dates refer to business days rather than instants
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// AddEntry adds a transaction for entry, prefixing the bank's reference for
+// it with remoteIdPrefix to create the imported split's RemoteId
+func (s *BankStatement) AddEntry(entry *bankStatementEntry, remoteIdPrefix string) error {
+	if len(entry.Reference) == 0 {
+		return errors.New("Bank statement entry is missing its reference")
+	}
+
+	var t models.Transaction
+	t.Date = dateOnly(entry.Date)
+	t.Description = entry.Description
+	if len(t.Description) == 0 {
+		t.Description = entry.Memo
+	}
+
+	var s1, s2 models.Split
+	s1.Memo = entry.Memo
+	s1.Number = entry.Number
+	s1.RemoteId = remoteIdPrefix + entry.Reference
+
+	s1.ImportSplitType = models.ImportAccount
+	s2.ImportSplitType = models.ExternalAccount
+
+	account := s.Accounts[0]
+	security := s.Securities[account.SecurityId-1]
+	amt := new(big.Rat).Set(entry.Amount)
+	s1.Amount.Rat = *amt
+	s2.Amount.Rat = *amt.Neg(amt)
+	if s1.Amount.Precision() > security.Precision {
+		return errors.New("Imported transaction amount is too precise for security")
+	}
+
+	s1.Status = models.Imported
+	s2.Status = models.Imported
+
+	s1.AccountId = account.AccountId
+	s2.AccountId = -1
+	s1.SecurityId = -1
+	s2.SecurityId = security.SecurityId
+
+	t.Splits = append(t.Splits, &s1)
+	t.Splits = append(t.Splits, &s2)
+	s.Transactions = append(s.Transactions, t)
+
+	return nil
+}
+
+// SetBalances sets the statement's opening and closing balances. Banks differ
+// on whether the opening balance is dated the last day of the previous
+// statement or the first day of this one, so the dates are moved if necessary
+// to ensure all entries fall between them.
+func (s *BankStatement) SetBalances(opening *big.Rat, openingDate time.Time, closing *big.Rat, closingDate time.Time) {
+	s.OpeningBalance.Rat = *opening
+	s.OpeningDate = openingDate
+	s.ClosingBalance.Rat = *closing
+	s.ClosingDate = closingDate
+	for _, t := range s.Transactions {
+		if t.Date.Before(s.OpeningDate) {
+			s.OpeningDate = t.Date
+		}
+		if !t.Date.Before(s.ClosingDate) {
+			s.ClosingDate = t.Date.AddDate(0, 0, 1)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// beancountRoots maps our account types to the five root accounts Beancount
+// requires every account to descend from
+var beancountRoots = map[models.AccountType]string{
+	models.Bank:       "Assets",
+	models.Cash:       "Assets",
+	models.Asset:      "Assets",
+	models.Investment: "Assets",
+	models.Receivable: "Assets",
+	models.Liability:  "Liabilities",
+	models.Payable:    "Liabilities",
+	models.Income:     "Income",
+	models.Expense:    "Expenses",
+	models.Trading:    "Equity",
+	models.Equity:     "Equity",
+}
+
+// beancountAccountName returns name as a valid Beancount account name
+// component, which must begin with a capital letter or number and contain
+// only letters, numbers, and dashes
+func beancountAccountName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	component := []rune(b.String())
+	if len(component) == 0 {
+		return "Unnamed"
+	}
+	component[0] = unicode.ToUpper(component[0])
+	if !unicode.IsUpper(component[0]) && !unicode.IsDigit(component[0]) {
+		return "X" + string(component)
+	}
+	return string(component)
+}
+
+// beancountCommodity returns symbol as a valid Beancount commodity name, or
+// the empty string if there is not enough left of it to be one. Commodities
+// must be upper-case, begin with a letter, end with a letter or number, and
+// be no longer than 24 characters.
+func beancountCommodity(symbol string) string {
+	symbol = strings.Map(func(r rune) rune {
+		r = unicode.ToUpper(r)
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("'._-", r) {
+			return r
+		}
+		return '-'
+	}, strings.TrimSpace(symbol))
+	symbol = strings.TrimLeft(symbol, "0123456789'._-")
+	if len(symbol) > 24 {
+		symbol = symbol[:24]
+	}
+	symbol = strings.TrimRight(symbol, "'._-")
+	if len(symbol) < 2 {
+		return ""
+	}
+	return symbol
+}
+
+func beancountString(s string) string {
+	s = strings.Replace(ledgerOneLine(s), "\\", "\\\\", -1)
+	return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+}
+
+func beancountDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+type beancountBalance struct {
+	date    time.Time
+	account string
+	amount  string
+}
+
+// ExportBeancount writes all of user's accounts, securities, prices, and
+// transactions to w as a Beancount ledger. The balance of each account
+// following every day containing a reconciled split is asserted, so that
+// Beancount will catch any changes to reconciled history.
+func ExportBeancount(tx store.Tx, user *models.User, w io.Writer) error {
+	securities, err := tx.GetSecurities(user.UserId)
+	if err != nil {
+		return err
+	}
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return err
+	}
+	transactions, err := tx.GetTransactions(user.UserId)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(*transactions, func(i, j int) bool {
+		ti, tj := (*transactions)[i], (*transactions)[j]
+		if ti.Date.Equal(tj.Date) {
+			return ti.TransactionId < tj.TransactionId
+		}
+		return ti.Date.Before(tj.Date)
+	})
+
+	// Beancount requires accounts be opened (and prefers commodities be
+	// declared) on or before the first day they're used
+	start := time.Now().UTC()
+	accountStart := make(map[int64]time.Time)
+	for _, t := range *transactions {
+		if t.Date.Before(start) {
+			start = t.Date
+		}
+		for _, s := range t.Splits {
+			if _, ok := accountStart[s.AccountId]; !ok {
+				accountStart[s.AccountId] = t.Date
+			}
+		}
+	}
+
+	pricesMap := make(map[int64]*[]*models.Price)
+	for _, s := range *securities {
+		prices, err := tx.GetPrices(s.SecurityId)
+		if err != nil {
+			return err
+		}
+		sort.Slice(*prices, func(i, j int) bool {
+			return (*prices)[i].Date.Before((*prices)[j].Date)
+		})
+		if len(*prices) > 0 && (*prices)[0].Date.Before(start) {
+			start = (*prices)[0].Date
+		}
+		pricesMap[s.SecurityId] = prices
+	}
+
+	bw := bufio.NewWriter(w)
+
+	// Assign each security a unique commodity, preferring ISO 4217 codes
+	// (stored as names) for currencies and symbols for everything else
+	securityMap := make(map[int64]*models.Security)
+	commodities := make(map[int64]string)
+	usedCommodities := make(map[string]bool)
+	sort.Slice(*securities, func(i, j int) bool {
+		return (*securities)[i].SecurityId < (*securities)[j].SecurityId
+	})
+	for _, s := range *securities {
+		candidates := []string{s.Symbol, s.Name}
+		if s.Type == models.Currency {
+			candidates = []string{s.Name, s.Symbol}
+		}
+		commodity := ""
+		for _, candidate := range candidates {
+			if commodity = beancountCommodity(candidate); len(commodity) > 0 {
+				break
+			}
+		}
+		if len(commodity) == 0 {
+			commodity = fmt.Sprintf("SECURITY%d", s.SecurityId)
+		}
+		base := commodity
+		for n := 2; usedCommodities[commodity]; n++ {
+			suffix := fmt.Sprintf("-%d", n)
+			if len(base)+len(suffix) > 24 {
+				base = strings.TrimRight(base[:24-len(suffix)], "'._-")
+			}
+			commodity = base + suffix
+		}
+		usedCommodities[commodity] = true
+		securityMap[s.SecurityId] = s
+		commodities[s.SecurityId] = commodity
+	}
+
+	if currency, ok := commodities[user.DefaultCurrency]; ok {
+		fmt.Fprintf(bw, "option \"operating_currency\" \"%s\"\n\n", currency)
+	}
+
+	for _, s := range *securities {
+		fmt.Fprintf(bw, "%s commodity %s\n", beancountDate(start), commodities[s.SecurityId])
+		fmt.Fprintf(bw, "  name: %s\n", beancountString(s.Name))
+		if len(s.Symbol) > 0 && s.Symbol != commodities[s.SecurityId] {
+			fmt.Fprintf(bw, "  symbol: %s\n", beancountString(s.Symbol))
+		}
+		if len(s.Description) > 0 {
+			fmt.Fprintf(bw, "  description: %s\n", beancountString(s.Description))
+		}
+		if len(s.AlternateId) > 0 {
+			fmt.Fprintf(bw, "  alternateid: %s\n", beancountString(s.AlternateId))
+		}
+		fmt.Fprintf(bw, "  precision: %d\n", s.Precision)
+		fmt.Fprintln(bw)
+	}
+
+	// Build each account's full name, making sure they are unique
+	accountMap := make(map[int64]*models.Account)
+	for _, a := range *accounts {
+		accountMap[a.AccountId] = a
+	}
+	paths := make(map[int64][]string)
+	var getPath func(a *models.Account, depth int) ([]string, error)
+	getPath = func(a *models.Account, depth int) ([]string, error) {
+		if path, ok := paths[a.AccountId]; ok {
+			return path, nil
+		}
+		if depth > len(accountMap) {
+			return nil, store.CircularAccountsError{}
+		}
+		var path []string
+		if parent, ok := accountMap[a.ParentAccountId]; ok {
+			parentPath, err := getPath(parent, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, parentPath...)
+		}
+		path = append(path, beancountAccountName(a.Name))
+		paths[a.AccountId] = path
+		return path, nil
+	}
+	names := make(map[int64]string)
+	usedNames := make(map[string]bool)
+	sort.Slice(*accounts, func(i, j int) bool {
+		return (*accounts)[i].AccountId < (*accounts)[j].AccountId
+	})
+	for _, a := range *accounts {
+		path, err := getPath(a, 0)
+		if err != nil {
+			return err
+		}
+		root, ok := beancountRoots[a.Type]
+		if !ok {
+			return fmt.Errorf("Invalid type %d for account %d", a.Type, a.AccountId)
+		}
+		// Avoid names like 'Expenses:Expenses:Groceries'
+		if path[0] == root {
+			path = path[1:]
+		}
+		name := strings.Join(append([]string{root}, path...), ":")
+		if len(path) == 0 {
+			name += ":" + beancountAccountName(a.Name)
+		}
+		unique := name
+		for n := 2; usedNames[unique]; n++ {
+			unique = fmt.Sprintf("%s-%d", name, n)
+		}
+		usedNames[unique] = true
+		names[a.AccountId] = unique
+	}
+	sort.Slice(*accounts, func(i, j int) bool {
+		return names[(*accounts)[i].AccountId] < names[(*accounts)[j].AccountId]
+	})
+	for _, a := range *accounts {
+		opened, ok := accountStart[a.AccountId]
+		if !ok {
+			opened = start
+		}
+		fmt.Fprintf(bw, "%s open %s %s\n", beancountDate(opened), names[a.AccountId], commodities[a.SecurityId])
+		if names[a.AccountId][strings.LastIndex(names[a.AccountId], ":")+1:] != a.Name {
+			fmt.Fprintf(bw, "  name: %s\n", beancountString(a.Name))
+		}
+		fmt.Fprintf(bw, "  type: \"%s\"\n", a.Type)
+	}
+	fmt.Fprintln(bw)
+
+	for _, s := range *securities {
+		prices := pricesMap[s.SecurityId]
+		for _, p := range *prices {
+			currency, ok := securityMap[p.CurrencyId]
+			if !ok {
+				return fmt.Errorf("Unable to find currency %d for price %d", p.CurrencyId, p.PriceId)
+			}
+			fmt.Fprintf(bw, "%s price %s %s %s\n", beancountDate(p.Date), commodities[s.SecurityId], ledgerAmount(&p.Value, currency), commodities[currency.SecurityId])
+		}
+		if len(*prices) > 0 {
+			fmt.Fprintln(bw)
+		}
+	}
+
+	// Balance assertions apply at the beginning of the day, so assert each
+	// account's balance at the start of the day following any of its
+	// reconciled splits
+	var balances []beancountBalance
+	totals := make(map[int64]*big.Rat)
+	reconciled := make(map[int64]time.Time)
+	flushBalances := func(before time.Time) {
+		var accountids []int64
+		for accountid, date := range reconciled {
+			if !date.After(before) {
+				accountids = append(accountids, accountid)
+			}
+		}
+		sort.Slice(accountids, func(i, j int) bool {
+			return names[accountids[i]] < names[accountids[j]]
+		})
+		for _, accountid := range accountids {
+			account := accountMap[accountid]
+			amount := models.Amount{Rat: *totals[accountid]}
+			balances = append(balances, beancountBalance{
+				date:    reconciled[accountid],
+				account: names[accountid],
+				amount:  ledgerAmount(&amount, securityMap[account.SecurityId]) + " " + commodities[account.SecurityId],
+			})
+			delete(reconciled, accountid)
+		}
+	}
+	writeBalances := func() {
+		for _, b := range balances {
+			fmt.Fprintf(bw, "%s balance %s  %s\n", beancountDate(b.date), b.account, b.amount)
+		}
+		if len(balances) > 0 {
+			fmt.Fprintln(bw)
+		}
+		balances = nil
+	}
+
+	for _, t := range *transactions {
+		date := t.Date.UTC()
+		day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		flushBalances(day)
+		writeBalances()
+
+		fmt.Fprintf(bw, "%s * %s\n", beancountDate(t.Date), beancountString(t.Description))
+		for _, s := range t.Splits {
+			account, ok := accountMap[s.AccountId]
+			if !ok {
+				return fmt.Errorf("Unable to find account %d for split %d", s.AccountId, s.SplitId)
+			}
+			security := securityMap[account.SecurityId]
+			if security == nil {
+				return fmt.Errorf("Unable to find security %d for account %d", account.SecurityId, account.AccountId)
+			}
+
+			posting := "  " + names[s.AccountId]
+			amount := ledgerAmount(&s.Amount, security) + " " + commodities[security.SecurityId]
+			padding := 60 - len(posting) - len(amount)
+			if padding < 2 {
+				padding = 2
+			}
+			fmt.Fprintln(bw, posting+strings.Repeat(" ", padding)+amount)
+			if memo := ledgerOneLine(s.Memo); len(memo) > 0 {
+				fmt.Fprintf(bw, "    memo: %s\n", beancountString(memo))
+			}
+
+			if _, ok := totals[s.AccountId]; !ok {
+				totals[s.AccountId] = new(big.Rat)
+			}
+			totals[s.AccountId].Add(totals[s.AccountId], &s.Amount.Rat)
+			if s.Status == models.Reconciled {
+				reconciled[s.AccountId] = day.AddDate(0, 0, 1)
+			}
+		}
+		fmt.Fprintln(bw)
+	}
+	flushBalances(time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC))
+	writeBalances()
+
+	return bw.Flush()
+}
+
+func BeancountExportHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportBeancount(context.Tx, user, &buf); err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return FileWriter{
+		ContentType: "text/plain; charset=utf-8",
+		Filename:    "moneygo.beancount",
+		Data:        buf.Bytes(),
+	}
+}
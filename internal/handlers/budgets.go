@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/reports"
+	"github.com/aclindsa/moneygo/internal/store"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const maxBudgetStatusPeriods = 60
+
+// validBudget returns whether budget is valid and only budgets for the user's
+// income and expense accounts
+func validBudget(tx store.Tx, user *models.User, budget *models.Budget) bool {
+	if !budget.Valid() {
+		return false
+	}
+	for _, amount := range budget.Amounts {
+		account, err := tx.GetAccount(amount.AccountId, user.UserId)
+		if err != nil {
+			return false
+		}
+		if account.Type != models.Income && account.Type != models.Expense {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeBudgetStart moves the budget's start to the beginning of the
+// period containing it, or the current period if it is unset
+func normalizeBudgetStart(budget *models.Budget) {
+	if budget.Start.IsZero() {
+		budget.Start = time.Now()
+	}
+	budget.Start = budget.PeriodBegin(budget.Start.UTC())
+}
+
+// BudgetStatusHandler returns the amounts budgeted, actually spent or earned,
+// and remaining for each of the budget's accounts in the number of periods in
+// the 'periods' query parameter (1 by default), starting with the period
+// containing the 'date' query parameter (today by default, in the form
+// 2006-01-02)
+func BudgetStatusHandler(r *http.Request, tx store.Tx, user *models.User, budget *models.Budget) ResponseWriterWriter {
+	date := time.Now().UTC()
+	periods := int64(1)
+	query, _ := url.ParseQuery(r.URL.RawQuery)
+	if datestr := query.Get("date"); len(datestr) > 0 {
+		var err error
+		date, err = time.Parse("2006-01-02", datestr)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+	}
+	if periodsstr := query.Get("periods"); len(periodsstr) > 0 {
+		var err error
+		periods, err = strconv.ParseInt(periodsstr, 10, 0)
+		if err != nil || periods < 1 || periods > maxBudgetStatusPeriods {
+			return NewError(3 /*Invalid Request*/)
+		}
+	}
+
+	status, err := reports.BudgetStatus(tx, user, budget, date, int(periods))
+	if err == reports.ErrBudgetRolloverTooLong {
+		return NewError(3 /*Invalid Request*/)
+	} else if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	return status
+}
+
+func BudgetHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method == "POST" {
+		var budget models.Budget
+		if err := ReadJSON(r, &budget); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		budget.BudgetId = -1
+		budget.UserId = user.UserId
+
+		if !validBudget(context.Tx, user, &budget) {
+			return NewError(3 /*Invalid Request*/)
+		}
+		normalizeBudgetStart(&budget)
+
+		err = context.Tx.InsertBudget(&budget)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return ResponseWrapper{201, &budget}
+	} else if r.Method == "GET" {
+		if context.LastLevel() {
+			//Return all Budgets
+			var bl models.BudgetList
+			budgets, err := context.Tx.GetBudgets(user.UserId)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			bl.Budgets = budgets
+			return &bl
+		}
+
+		budgetid, err := context.NextID()
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		budget, err := context.Tx.GetBudget(budgetid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if context.LastLevel() {
+			// Return Budget with this Id
+			return budget
+		} else if context.NextLevel() == "status" && context.LastLevel() {
+			return BudgetStatusHandler(r, context.Tx, user, budget)
+		}
+	} else {
+		budgetid, err := context.NextID()
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Ensure the budget exists and belongs to this user
+		budget, err := context.Tx.GetBudget(budgetid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if r.Method == "PUT" {
+			var newbudget models.Budget
+			if err := ReadJSON(r, &newbudget); err != nil || newbudget.BudgetId != budgetid {
+				return NewError(3 /*Invalid Request*/)
+			}
+			newbudget.UserId = user.UserId
+
+			if !validBudget(context.Tx, user, &newbudget) {
+				return NewError(3 /*Invalid Request*/)
+			}
+			normalizeBudgetStart(&newbudget)
+
+			err = context.Tx.UpdateBudget(&newbudget)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return &newbudget
+		} else if r.Method == "DELETE" {
+			err = context.Tx.DeleteBudget(budget)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return SuccessWriter{}
+		}
+	}
+	return NewError(3 /*Invalid Request*/)
+}
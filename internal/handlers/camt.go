@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// The subset of ISO 20022 camt.053 (BankToCustomerStatement) we import. Tags
+// are matched without namespaces so that any version of the message may be
+// imported.
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (d *camtDate) Time() (time.Time, error) {
+	if len(d.Date) > 0 {
+		return time.Parse("2006-01-02", strings.TrimSpace(d.Date))
+	}
+	// Only the date is significant, so ignore any time and time zone
+	datetime := strings.TrimSpace(d.DateTime)
+	if len(datetime) >= len("2006-01-02") {
+		return time.Parse("2006-01-02", datetime[:len("2006-01-02")])
+	}
+	return time.Time{}, errors.New("Missing camt.053 date")
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// signedAmount returns amount as a big.Rat, negated if indicator marks it as
+// a debit
+func (a *camtAmount) signedAmount(indicator string) (*big.Rat, error) {
+	amount, ok := new(big.Rat).SetString(strings.TrimSpace(a.Value))
+	if !ok {
+		return nil, fmt.Errorf("Unable to parse camt.053 amount: %s", a.Value)
+	}
+	switch strings.TrimSpace(indicator) {
+	case "CRDT":
+	case "DBIT":
+		amount.Neg(amount)
+	default:
+		return nil, fmt.Errorf("Invalid camt.053 credit/debit indicator: %s", indicator)
+	}
+	return amount, nil
+}
+
+type camtParty struct {
+	Name      string `xml:"Nm"`
+	PartyName string `xml:"Pty>Nm"` // Versions 8 and later
+}
+
+func (p *camtParty) name() string {
+	if len(p.Name) > 0 {
+		return p.Name
+	}
+	return p.PartyName
+}
+
+type camtTransactionDetails struct {
+	AccountServicerReference string    `xml:"Refs>AcctSvcrRef"`
+	EndToEndId               string    `xml:"Refs>EndToEndId"`
+	Debtor                   camtParty `xml:"RltdPties>Dbtr"`
+	Creditor                 camtParty `xml:"RltdPties>Cdtr"`
+	Unstructured             []string  `xml:"RmtInf>Ustrd"`
+	AdditionalInformation    string    `xml:"AddtlTxInf"`
+}
+
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"` // Versions 8 and later
+}
+
+func (s *camtStatus) code() string {
+	if len(s.Code) > 0 {
+		return strings.TrimSpace(s.Code)
+	}
+	return strings.TrimSpace(s.Value)
+}
+
+type camtEntry struct {
+	EntryReference           string                   `xml:"NtryRef"`
+	Amount                   camtAmount               `xml:"Amt"`
+	CreditDebitIndicator     string                   `xml:"CdtDbtInd"`
+	Status                   camtStatus               `xml:"Sts"`
+	BookingDate              camtDate                 `xml:"BookgDt"`
+	ValueDate                camtDate                 `xml:"ValDt"`
+	AccountServicerReference string                   `xml:"AcctSvcrRef"`
+	TransactionDetails       []camtTransactionDetails `xml:"NtryDtls>TxDtls"`
+	AdditionalInformation    string                   `xml:"AddtlNtryInf"`
+}
+
+type camtBalance struct {
+	Type                 string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount               camtAmount `xml:"Amt"`
+	CreditDebitIndicator string     `xml:"CdtDbtInd"`
+	Date                 camtDate   `xml:"Dt"`
+}
+
+type camtStatement struct {
+	Id       string        `xml:"Id"`
+	IBAN     string        `xml:"Acct>Id>IBAN"`
+	OtherId  string        `xml:"Acct>Id>Othr>Id"`
+	Currency string        `xml:"Acct>Ccy"`
+	Balances []camtBalance `xml:"Bal"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+func (i *camtEntry) bankStatementEntry() (*bankStatementEntry, error) {
+	var entry bankStatementEntry
+	var err error
+
+	entry.Amount, err = i.Amount.signedAmount(i.CreditDebitIndicator)
+	if err != nil {
+		return nil, err
+	}
+	entry.Date, err = i.BookingDate.Time()
+	if err != nil {
+		entry.Date, err = i.ValueDate.Time()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry.Reference = strings.TrimSpace(i.AccountServicerReference)
+	if len(entry.Reference) == 0 {
+		entry.Reference = strings.TrimSpace(i.EntryReference)
+	}
+	entry.Memo = bankStatementText(i.AdditionalInformation)
+
+	// Entries may be batches of several transactions, in which case only
+	// the entry's own information describes it
+	if len(i.TransactionDetails) == 1 {
+		details := i.TransactionDetails[0]
+		if len(entry.Reference) == 0 {
+			entry.Reference = strings.TrimSpace(details.AccountServicerReference)
+		}
+		if ref := strings.TrimSpace(details.EndToEndId); ref != "NOTPROVIDED" {
+			entry.Number = ref
+		}
+		if entry.Amount.Sign() < 0 {
+			entry.Description = bankStatementText(details.Creditor.name())
+		} else {
+			entry.Description = bankStatementText(details.Debtor.name())
+		}
+		if remittance := bankStatementText(details.Unstructured...); len(remittance) > 0 {
+			entry.Memo = remittance
+		} else if info := bankStatementText(details.AdditionalInformation); len(info) > 0 {
+			entry.Memo = info
+		}
+	}
+	return &entry, nil
+}
+
+// ImportCamt053 parses the statements in an ISO 20022 camt.053 file. Only
+// booked entries are imported, since those are the only ones reflected in the
+// statements' balances.
+func ImportCamt053(r io.Reader) ([]*BankStatement, error) {
+	var doc camtDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Statements) == 0 {
+		return nil, errors.New("No statements found in camt.053 file")
+	}
+
+	var statements []*BankStatement
+	for _, stmt := range doc.Statements {
+		accountId := strings.TrimSpace(stmt.IBAN)
+		if len(accountId) == 0 {
+			accountId = strings.TrimSpace(stmt.OtherId)
+		}
+		currency := strings.TrimSpace(stmt.Currency)
+		if len(currency) == 0 && len(stmt.Balances) > 0 {
+			currency = stmt.Balances[0].Amount.Currency
+		}
+		statement, err := newBankStatement(accountId, currency)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range stmt.Entries {
+			if e.Status.code() != "BOOK" {
+				continue
+			}
+			if e.Amount.Currency != currency {
+				return nil, fmt.Errorf("camt.053 entry in %s for statement in %s", e.Amount.Currency, currency)
+			}
+			entry, err := e.bankStatementEntry()
+			if err != nil {
+				return nil, err
+			}
+			if err := statement.AddEntry(entry, "camt:"); err != nil {
+				return nil, fmt.Errorf("camt.053 statement %s: %s", stmt.Id, err)
+			}
+		}
+
+		var opening, closing *big.Rat
+		var openingDate, closingDate time.Time
+		for _, b := range stmt.Balances {
+			if b.Type != "OPBD" && b.Type != "PRCD" && b.Type != "CLBD" {
+				continue
+			}
+			amount, err := b.Amount.signedAmount(b.CreditDebitIndicator)
+			if err != nil {
+				return nil, err
+			}
+			date, err := b.Date.Time()
+			if err != nil {
+				return nil, err
+			}
+			switch b.Type {
+			case "OPBD":
+				// Balance at the beginning of the date
+				opening, openingDate = amount, date
+			case "PRCD":
+				// Closing balance of the previous statement, at the end of
+				// the date
+				if opening == nil {
+					opening, openingDate = amount, date.AddDate(0, 0, 1)
+				}
+			case "CLBD":
+				closing, closingDate = amount, date.AddDate(0, 0, 1)
+			}
+		}
+		if opening == nil || closing == nil {
+			return nil, fmt.Errorf("camt.053 statement %s is missing its opening or closing balance", stmt.Id)
+		}
+		statement.SetBalances(opening, openingDate, closing, closingDate)
+
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
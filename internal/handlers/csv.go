@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type CSVImport struct {
+	Securities   []models.Security
+	Accounts     []models.Account
+	Transactions []models.Transaction
+}
+
+// Translate the human-friendly date format tokens we accept (i.e. YYYY, MM,
+// DD) into a Go time layout
+var csvDateFormatReplacer = strings.NewReplacer(
+	"YYYY", "2006",
+	"YY", "06",
+	"MM", "01",
+	"M", "1",
+	"DD", "02",
+	"D", "2",
+)
+
+// csvDateLayout returns the Go time layout for a CSVImportMapping.DateFormat.
+// Formats without a year token are assumed to already be Go time layouts.
+func csvDateLayout(format string) string {
+	if !strings.Contains(format, "YY") {
+		return format
+	}
+	return csvDateFormatReplacer.Replace(format)
+}
+
+// NewCSVImportMapping returns a mapping with every column unused, for clients'
+// mappings to be decoded into so any columns they leave out aren't mistaken for
+// the first column
+func NewCSVImportMapping() *models.CSVImportMapping {
+	return &models.CSVImportMapping{
+		DateColumn:        -1,
+		DescriptionColumn: -1,
+		MemoColumn:        -1,
+		NumberColumn:      -1,
+		AmountColumn:      -1,
+		DebitColumn:       -1,
+		CreditColumn:      -1,
+	}
+}
+
+// ValidateCSVImportMapping checks that mapping is complete enough to import
+// transactions with and fills in defaults for any optional fields left empty
+func ValidateCSVImportMapping(mapping *models.CSVImportMapping) error {
+	if len(mapping.Delimiter) == 0 {
+		mapping.Delimiter = ","
+	}
+	if len(mapping.DecimalSeparator) == 0 {
+		mapping.DecimalSeparator = "."
+	}
+	if len(mapping.DateFormat) == 0 {
+		mapping.DateFormat = "YYYY-MM-DD"
+	}
+
+	if utf8.RuneCountInString(mapping.Delimiter) != 1 {
+		return errors.New("CSV delimiter must be a single character")
+	}
+	if mapping.DecimalSeparator != "." && mapping.DecimalSeparator != "," {
+		return errors.New("CSV decimal separator must be '.' or ','")
+	}
+	if mapping.HeaderRows < 0 {
+		return errors.New("CSV header rows must not be negative")
+	}
+	if mapping.DateColumn < 0 {
+		return errors.New("CSV date column is required")
+	}
+	if mapping.AmountColumn < 0 && mapping.DebitColumn < 0 && mapping.CreditColumn < 0 {
+		return errors.New("CSV amount column, or debit and/or credit columns are required")
+	}
+	used := make(map[int64]bool)
+	for _, column := range []int64{mapping.DateColumn, mapping.DescriptionColumn, mapping.MemoColumn, mapping.NumberColumn, mapping.AmountColumn, mapping.DebitColumn, mapping.CreditColumn} {
+		if column < -1 {
+			return errors.New("CSV columns must be -1 if unused")
+		}
+		if column >= 0 {
+			if used[column] {
+				return fmt.Errorf("CSV column %d is mapped more than once", column)
+			}
+			used[column] = true
+		}
+	}
+	return nil
+}
+
+// parseCSVAmount parses amounts as they commonly appear in bank CSV exports,
+// ignoring currency symbols and thousands separators, and treating amounts in
+// parentheses as negative
+func parseCSVAmount(value, decimalSeparator string) (*big.Rat, error) {
+	value = strings.TrimSpace(value)
+	negative := false
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		negative = true
+		value = value[1 : len(value)-1]
+	}
+
+	var cleaned strings.Builder
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			cleaned.WriteRune(r)
+		} else if string(r) == decimalSeparator {
+			cleaned.WriteRune('.')
+		} else if r == '-' {
+			negative = !negative
+		}
+	}
+	if cleaned.Len() == 0 {
+		return nil, nil
+	}
+
+	amount, ok := new(big.Rat).SetString(cleaned.String())
+	if !ok {
+		return nil, fmt.Errorf("Unable to parse CSV amount: \"%s\"", value)
+	}
+	if negative {
+		amount.Neg(amount)
+	}
+	return amount, nil
+}
+
+func csvColumn(record []string, column int64) (string, error) {
+	if column < 0 {
+		return "", nil
+	}
+	if column >= int64(len(record)) {
+		return "", fmt.Errorf("CSV column %d out of range for row with %d columns", column, len(record))
+	}
+	return strings.TrimSpace(record[column]), nil
+}
+
+func (i *CSVImport) AddRecord(record []string, remoteid string, mapping *models.CSVImportMapping, account *models.Account) error {
+	var t models.Transaction
+
+	date, err := csvColumn(record, mapping.DateColumn)
+	if err != nil {
+		return err
+	}
+	t.Date, err = time.Parse(csvDateLayout(mapping.DateFormat), date)
+	if err != nil {
+		return fmt.Errorf("Unable to parse CSV date \"%s\": %s", date, err)
+	}
+
+	var s1, s2 models.Split
+
+	t.Description, err = csvColumn(record, mapping.DescriptionColumn)
+	if err != nil {
+		return err
+	}
+	s1.Memo, err = csvColumn(record, mapping.MemoColumn)
+	if err != nil {
+		return err
+	}
+	if len(t.Description) == 0 {
+		t.Description = s1.Memo
+	}
+	s1.Number, err = csvColumn(record, mapping.NumberColumn)
+	if err != nil {
+		return err
+	}
+
+	amt := big.NewRat(0, 1)
+	var found bool
+	for _, column := range []struct {
+		index  int64
+		negate bool
+	}{
+		{mapping.AmountColumn, false},
+		{mapping.DebitColumn, true},
+		{mapping.CreditColumn, false},
+	} {
+		value, err := csvColumn(record, column.index)
+		if err != nil {
+			return err
+		}
+		a, err := parseCSVAmount(value, mapping.DecimalSeparator)
+		if err != nil {
+			return err
+		}
+		if a == nil {
+			continue
+		}
+		// Debits are often exported as positive numbers, so only their
+		// magnitude is considered
+		if column.negate {
+			a.Abs(a)
+			a.Neg(a)
+		}
+		amt.Add(amt, a)
+		found = true
+	}
+	if !found {
+		return errors.New("No amount found in CSV row")
+	}
+
+	if account.SecurityId < 1 || account.SecurityId > int64(len(i.Securities)) {
+		return errors.New("Internal error: security index not found in CSV import\n")
+	}
+	security := i.Securities[account.SecurityId-1]
+
+	s1.RemoteId = remoteid
+
+	s1.ImportSplitType = models.ImportAccount
+	s2.ImportSplitType = models.ExternalAccount
+
+	s1.Amount.Rat = *amt
+	s2.Amount.Rat = *amt.Neg(amt)
+	if s1.Amount.Precision() > security.Precision {
+		return errors.New("Imported transaction amount is too precise for security")
+	}
+
+	s1.Status = models.Imported
+	s2.Status = models.Imported
+
+	s1.AccountId = account.AccountId
+	s2.AccountId = -1
+	s1.SecurityId = -1
+	s2.SecurityId = security.SecurityId
+
+	t.Splits = append(t.Splits, &s1)
+	t.Splits = append(t.Splits, &s2)
+	i.Transactions = append(i.Transactions, t)
+
+	return nil
+}
+
+// ImportCSV parses the CSV file in r according to mapping. All transactions
+// are assumed to be in security, which should be the security of the account
+// being imported into.
+func ImportCSV(r io.Reader, mapping *models.CSVImportMapping, security *models.Security) (*CSVImport, error) {
+	var i CSVImport
+
+	if err := ValidateCSVImportMapping(mapping); err != nil {
+		return nil, err
+	}
+
+	s := *security
+	s.SecurityId = 1
+	i.Securities = append(i.Securities, s)
+
+	account := models.Account{
+		AccountId:       1,
+		SecurityId:      s.SecurityId,
+		ParentAccountId: -1,
+	}
+	i.Accounts = append(i.Accounts, account)
+
+	reader := csv.NewReader(r)
+	reader.Comma, _ = utf8.DecodeRuneInString(mapping.Delimiter)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	// Rows may legitimately appear more than once (i.e. two identical
+	// purchases on the same day), so include the number of times we've seen
+	// each row in its RemoteId
+	seen := make(map[string]int)
+
+	for row := int64(0); ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if row < mapping.HeaderRows {
+			continue
+		}
+		if len(record) == 0 || (len(record) == 1 && len(strings.TrimSpace(record[0])) == 0) {
+			continue
+		}
+
+		rowstring := strings.Join(record, "\x1f")
+		seen[rowstring]++
+		hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x1e%d", rowstring, seen[rowstring])))
+		remoteid := fmt.Sprintf("csv:%x", hash)
+
+		if err := i.AddRecord(record, remoteid, mapping, &account); err != nil {
+			return nil, fmt.Errorf("CSV row %d: %s", row+1, err)
+		}
+	}
+
+	return &i, nil
+}
@@ -35,6 +35,9 @@ var error_codes = map[int]string{
 	//  5:   "Connection Failed", //reserved for client-side error
 	6:   "Import Error",
 	7:   "In Use Error",
+	8:   "Edited Since Import",
+	9:   "Unbalanced Reconciliation",
+	10:  "Reconciled Split",
 	999: "Internal Error",
 }
 
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+)
+
+func ExportHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	if r.Method != "GET" {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	route := context.NextLevel()
+	switch route {
+	case "ledger":
+		return LedgerExportHandler(r, context)
+	case "beancount":
+		return BeancountExportHandler(r, context)
+	case "gnucash":
+		return GnucashExportHandler(r, context)
+	default:
+		return NewError(3 /*Invalid Request*/)
+	}
+}
+
+/*
+ * Assumes the User is a valid, signed-in user, but accountid has not yet been validated
+ */
+func AccountExportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
+	route := context.NextLevel()
+	switch route {
+	case "ofx":
+		return OFXExportHandler(context, r, user, accountid)
+	default:
+		return NewError(3 /*Invalid Request*/)
+	}
+}
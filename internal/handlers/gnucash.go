@@ -3,23 +3,39 @@ package handlers
 import (
 	"bufio"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
 	"io"
+	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
 	"time"
 )
 
+// GnucashGUID is the identifier Gnucash assigns each object, which is always
+// written with its type
+type GnucashGUID string
+
+func (g GnucashGUID) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "type"}, Value: "guid"})
+	return e.EncodeElement(string(g), start)
+}
+
+// GnucashXMLCommodity is used both for commodity definitions and references to
+// them, the latter only including Type and Name
 type GnucashXMLCommodity struct {
-	Name        string `xml:"http://www.gnucash.org/XML/cmdty id"`
-	Description string `xml:"http://www.gnucash.org/XML/cmdty name"`
+	Version     string `xml:"version,attr,omitempty"`
 	Type        string `xml:"http://www.gnucash.org/XML/cmdty space"`
-	Fraction    int    `xml:"http://www.gnucash.org/XML/cmdty fraction"`
-	XCode       string `xml:"http://www.gnucash.org/XML/cmdty xcode"`
+	Name        string `xml:"http://www.gnucash.org/XML/cmdty id"`
+	Description string `xml:"http://www.gnucash.org/XML/cmdty name,omitempty"`
+	XCode       string `xml:"http://www.gnucash.org/XML/cmdty xcode,omitempty"`
+	Fraction    int    `xml:"http://www.gnucash.org/XML/cmdty fraction,omitempty"`
 }
 
 type GnucashCommodity struct{ models.Security }
@@ -29,7 +45,10 @@ func (gc *GnucashCommodity) UnmarshalXML(d *xml.Decoder, start xml.StartElement)
 	if err := d.DecodeElement(&gxc, &start); err != nil {
 		return err
 	}
+	return gc.fromXMLCommodity(&gxc)
+}
 
+func (gc *GnucashCommodity) fromXMLCommodity(gxc *GnucashXMLCommodity) error {
 	gc.Name = gxc.Name
 	gc.Symbol = gxc.Name
 	gc.Description = gxc.Description
@@ -56,6 +75,39 @@ func (gc *GnucashCommodity) UnmarshalXML(d *xml.Decoder, start xml.StartElement)
 	return nil
 }
 
+// gnucashSecuritySpace is the Gnucash namespace we export securities other than
+// currencies in, since we don't track which exchange they trade on
+const gnucashSecuritySpace = "MONEYGO"
+
+// toXMLCommodity is the inverse of fromXMLCommodity
+func (gc *GnucashCommodity) toXMLCommodity() GnucashXMLCommodity {
+	var gxc GnucashXMLCommodity
+	if gc.Security.Type == models.Currency {
+		gxc.Type = "ISO4217"
+		gxc.Name = gc.Name
+		return gxc
+	}
+	gxc.Type = gnucashSecuritySpace
+	gxc.Name = gc.Symbol
+	if len(gxc.Name) == 0 {
+		gxc.Name = gc.Name
+	}
+	gxc.Description = gc.Description
+	if len(gxc.Description) == 0 {
+		gxc.Description = gc.Name
+	}
+	gxc.XCode = gc.AlternateId
+	gxc.Fraction = int(math.Pow10(int(gc.Precision)))
+	return gxc
+}
+
+// MarshalXML writes a reference to the commodity, as used by prices. The
+// commodity itself is defined using the result of toXMLCommodity.
+func (gc GnucashCommodity) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	gxc := gc.toXMLCommodity()
+	return e.EncodeElement(GnucashXMLCommodity{Type: gxc.Type, Name: gxc.Name}, start)
+}
+
 type GnucashTime struct{ time.Time }
 
 func (g *GnucashTime) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
@@ -68,52 +120,73 @@ func (g *GnucashTime) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error
 	return err
 }
 
+func (g GnucashTime) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(g.Format("2006-01-02 15:04:05 -0700"), start)
+}
+
 type GnucashDate struct {
 	Date GnucashTime `xml:"http://www.gnucash.org/XML/ts date"`
 }
 
 type GnucashPrice struct {
-	Id        string           `xml:"http://www.gnucash.org/XML/price id"`
+	Id        GnucashGUID      `xml:"http://www.gnucash.org/XML/price id"`
 	Commodity GnucashCommodity `xml:"http://www.gnucash.org/XML/price commodity"`
 	Currency  GnucashCommodity `xml:"http://www.gnucash.org/XML/price currency"`
 	Date      GnucashDate      `xml:"http://www.gnucash.org/XML/price time"`
 	Source    string           `xml:"http://www.gnucash.org/XML/price source"`
-	Type      string           `xml:"http://www.gnucash.org/XML/price type"`
+	Type      string           `xml:"http://www.gnucash.org/XML/price type,omitempty"`
 	Value     string           `xml:"http://www.gnucash.org/XML/price value"`
 }
 
 type GnucashPriceDB struct {
-	Prices []GnucashPrice `xml:"price"`
+	Version string         `xml:"version,attr"`
+	Prices  []GnucashPrice `xml:"price"`
 }
 
 type GnucashAccount struct {
 	Version         string              `xml:"version,attr"`
 	accountid       int64               // Used to map Gnucash guid's to integer ones
-	AccountId       string              `xml:"http://www.gnucash.org/XML/act id"`
-	ParentAccountId string              `xml:"http://www.gnucash.org/XML/act parent"`
 	Name            string              `xml:"http://www.gnucash.org/XML/act name"`
-	Description     string              `xml:"http://www.gnucash.org/XML/act description"`
+	AccountId       GnucashGUID         `xml:"http://www.gnucash.org/XML/act id"`
 	Type            string              `xml:"http://www.gnucash.org/XML/act type"`
 	Commodity       GnucashXMLCommodity `xml:"http://www.gnucash.org/XML/act commodity"`
+	CommoditySCU    int                 `xml:"http://www.gnucash.org/XML/act commodity-scu,omitempty"`
+	Description     string              `xml:"http://www.gnucash.org/XML/act description,omitempty"`
+	ParentAccountId GnucashGUID         `xml:"http://www.gnucash.org/XML/act parent,omitempty"`
 }
 
 type GnucashTransaction struct {
-	TransactionId string              `xml:"http://www.gnucash.org/XML/trn id"`
-	Description   string              `xml:"http://www.gnucash.org/XML/trn description"`
-	Number        string              `xml:"http://www.gnucash.org/XML/trn num"`
+	Version       string              `xml:"version,attr"`
+	TransactionId GnucashGUID         `xml:"http://www.gnucash.org/XML/trn id"`
+	Commodity     GnucashXMLCommodity `xml:"http://www.gnucash.org/XML/trn currency"`
+	Number        string              `xml:"http://www.gnucash.org/XML/trn num,omitempty"`
 	DatePosted    GnucashDate         `xml:"http://www.gnucash.org/XML/trn date-posted"`
 	DateEntered   GnucashDate         `xml:"http://www.gnucash.org/XML/trn date-entered"`
-	Commodity     GnucashXMLCommodity `xml:"http://www.gnucash.org/XML/trn currency"`
+	Description   string              `xml:"http://www.gnucash.org/XML/trn description"`
 	Splits        []GnucashSplit      `xml:"http://www.gnucash.org/XML/trn splits>split"`
 }
 
+// MarshalXML writes the transaction, explicitly writing the namespace of the
+// element containing its splits, which encoding/xml omits
+func (gt GnucashTransaction) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type transaction GnucashTransaction
+	t := struct {
+		transaction
+		Splits struct {
+			Splits []GnucashSplit `xml:"http://www.gnucash.org/XML/trn split"`
+		} `xml:"http://www.gnucash.org/XML/trn splits"`
+	}{transaction: transaction(gt)}
+	t.Splits.Splits = gt.Splits
+	return e.EncodeElement(t, start)
+}
+
 type GnucashSplit struct {
-	SplitId   string `xml:"http://www.gnucash.org/XML/split id"`
-	Status    string `xml:"http://www.gnucash.org/XML/split reconciled-state"`
-	AccountId string `xml:"http://www.gnucash.org/XML/split account"`
-	Memo      string `xml:"http://www.gnucash.org/XML/split memo"`
-	Amount    string `xml:"http://www.gnucash.org/XML/split quantity"`
-	Value     string `xml:"http://www.gnucash.org/XML/split value"`
+	SplitId   GnucashGUID `xml:"http://www.gnucash.org/XML/split id"`
+	Memo      string      `xml:"http://www.gnucash.org/XML/split memo,omitempty"`
+	Status    string      `xml:"http://www.gnucash.org/XML/split reconciled-state"`
+	Value     string      `xml:"http://www.gnucash.org/XML/split value"`
+	Amount    string      `xml:"http://www.gnucash.org/XML/split quantity"`
+	AccountId GnucashGUID `xml:"http://www.gnucash.org/XML/split account"`
 }
 
 type GnucashXMLImport struct {
@@ -133,7 +206,6 @@ type GnucashImport struct {
 
 func ImportGnucash(r io.Reader) (*GnucashImport, error) {
 	var gncxml GnucashXMLImport
-	var gncimport GnucashImport
 
 	// Perform initial parsing of xml into structs
 	decoder := xml.NewDecoder(r)
@@ -142,6 +214,14 @@ func ImportGnucash(r io.Reader) (*GnucashImport, error) {
 		return nil, err
 	}
 
+	return gnucashImportFromXML(&gncxml)
+}
+
+// gnucashImportFromXML translates the Gnucash book in gncxml (which may have
+// been read from an SQLite book instead of XML) into our own format
+func gnucashImportFromXML(gncxml *GnucashXMLImport) (*GnucashImport, error) {
+	var gncimport GnucashImport
+
 	// Fixup securities, making a map of them as we go
 	securityMap := make(map[string]models.Security)
 	for i := range gncxml.Commodities {
@@ -186,14 +266,14 @@ func ImportGnucash(r io.Reader) (*GnucashImport, error) {
 			p.Value.Round(currency.Precision)
 		}
 
-		p.RemoteId = "gnucash:" + price.Id
+		p.RemoteId = "gnucash:" + string(price.Id)
 		gncimport.Prices = append(gncimport.Prices, p)
 	}
 
 	//find root account, while simultaneously creating map of GUID's to
 	//accounts
 	var rootAccount GnucashAccount
-	accountMap := make(map[string]GnucashAccount)
+	accountMap := make(map[GnucashGUID]GnucashAccount)
 	for i := range gncxml.Accounts {
 		gncxml.Accounts[i].accountid = int64(i + 1)
 		if gncxml.Accounts[i].Type == "ROOT" {
@@ -289,7 +369,7 @@ func ImportGnucash(r io.Reader) (*GnucashImport, error) {
 			}
 			s.SecurityId = -1
 
-			s.RemoteId = "gnucash:" + gs.SplitId
+			s.RemoteId = "gnucash:" + string(gs.SplitId)
 			s.Number = gt.Number
 			s.Memo = gs.Memo
 
@@ -319,38 +399,31 @@ func GnucashImportHandler(r *http.Request, context *Context) ResponseWriterWrite
 		return NewError(3 /*Invalid Request*/)
 	}
 
-	multipartReader, err := r.MultipartReader()
-	if err != nil {
-		return NewError(3 /*Invalid Request*/)
+	var options models.ImportOptions
+	part, errWriter := importFilePart(r, &options)
+	if errWriter != nil {
+		return errWriter
 	}
 
-	// Assume there is only one 'part' and it's the one we care about
-	part, err := multipartReader.NextPart()
-	if err != nil {
-		if err == io.EOF {
-			return NewError(3 /*Invalid Request*/)
-		} else {
-			log.Print(err)
-			return NewError(999 /*Internal Error*/)
-		}
-	}
-
-	bufread := bufio.NewReader(part)
-	gzHeader, err := bufread.Peek(2)
-	if err != nil {
+	hash := sha256.New()
+	bufread := bufio.NewReader(io.TeeReader(part, hash))
+	header, err := bufread.Peek(len(sqliteHeader))
+	if err != nil && err != io.EOF {
 		log.Print(err)
 		return NewError(999 /*Internal Error*/)
 	}
 
-	// Does this look like a gzipped file?
+	// Does this look like a gzipped file or an SQLite database?
 	var gnucashImport *GnucashImport
-	if gzHeader[0] == 0x1f && gzHeader[1] == 0x8b {
+	if len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b {
 		gzr, err2 := gzip.NewReader(bufread)
 		if err2 != nil {
-			log.Print(err)
+			log.Print(err2)
 			return NewError(999 /*Internal Error*/)
 		}
 		gnucashImport, err = ImportGnucash(gzr)
+	} else if string(header) == sqliteHeader {
+		gnucashImport, err = ImportGnucashSQLite(bufread)
 	} else {
 		gnucashImport, err = ImportGnucash(bufread)
 	}
@@ -360,12 +433,35 @@ func GnucashImportHandler(r *http.Request, context *Context) ResponseWriterWrite
 		return NewError(3 /*Invalid Request*/)
 	}
 
-	// Import securities, building map from Gnucash security IDs to our
+	// Hash any trailing data the parser didn't need to read, too
+	if _, err := io.Copy(ioutil.Discard, bufread); err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	state, err := newImportState(context.Tx, user, options)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	state.record(models.GnucashImport, hex.EncodeToString(hash.Sum(nil)))
+
+	return importBookHelper(context.Tx, user, state, gnucashImport.Securities, gnucashImport.Prices, gnucashImport.Accounts, gnucashImport.Transactions)
+}
+
+// importBookHelper imports a user's entire book (or a portion thereof),
+// matching or creating securities, prices, and accounts, and inserting any
+// transactions not already imported. Securities, accounts, and transactions
+// passed in refer to each other using placeholder IDs, which are mapped to
+// the user's actual IDs as they are created. Only the transactions accepted by
+// state are imported, and if a preview is requested, nothing is committed.
+func importBookHelper(tx store.Tx, user *models.User, state *importState, securities []models.Security, prices []models.Price, accounts []models.Account, transactions []models.Transaction) ResponseWriterWriter {
+	// Import securities, building map from imported security IDs to our
 	// internal IDs
 	securityMap := make(map[int64]int64)
-	for _, security := range gnucashImport.Securities {
+	for _, security := range securities {
 		securityId := security.SecurityId // save off because it could be updated
-		s, err := ImportGetCreateSecurity(context.Tx, user.UserId, &security)
+		s, err := ImportGetCreateSecurity(tx, user.UserId, &security)
 		if err != nil {
 			log.Print(err)
 			log.Print(security)
@@ -375,25 +471,27 @@ func GnucashImportHandler(r *http.Request, context *Context) ResponseWriterWrite
 	}
 
 	// Import prices, setting security and currency IDs from securityMap
-	for _, price := range gnucashImport.Prices {
+	for _, price := range prices {
 		price.SecurityId = securityMap[price.SecurityId]
 		price.CurrencyId = securityMap[price.CurrencyId]
 		price.PriceId = 0
 
-		err := CreatePriceIfNotExist(context.Tx, &price)
+		created, err := CreatePriceIfNotExist(tx, &price)
 		if err != nil {
 			log.Print(err)
 			return NewError(6 /*Import Error*/)
+		} else if created {
+			state.createdPrice(&price)
 		}
 	}
 
-	// Get/create accounts in the database, building a map from Gnucash account
-	// IDs to our internal IDs as we go
+	// Get/create accounts in the database, building a map from imported
+	// account IDs to our internal IDs as we go
 	accountMap := make(map[int64]int64)
-	accountsRemaining := len(gnucashImport.Accounts)
+	accountsRemaining := len(accounts)
 	accountsRemainingLast := accountsRemaining
 	for accountsRemaining > 0 {
-		for _, account := range gnucashImport.Accounts {
+		for _, account := range accounts {
 
 			// If the account has already been added to the map, skip it
 			_, ok := accountMap[account.AccountId]
@@ -409,7 +507,7 @@ func GnucashImportHandler(r *http.Request, context *Context) ResponseWriterWrite
 					account.ParentAccountId = accountMap[account.ParentAccountId]
 				}
 				account.SecurityId = securityMap[account.SecurityId]
-				a, err := GetCreateAccount(context.Tx, account)
+				a, err := GetCreateAccount(tx, account)
 				if err != nil {
 					log.Print(err)
 					return NewError(999 /*Internal Error*/)
@@ -420,7 +518,7 @@ func GnucashImportHandler(r *http.Request, context *Context) ResponseWriterWrite
 		}
 		if accountsRemaining == accountsRemainingLast {
 			//We didn't make any progress in importing the next level of accounts, so there must be a circular parent-child relationship, so give up and tell the user they're wrong
-			log.Print(fmt.Errorf("Circular account parent-child relationship when importing %s", part.FileName()))
+			log.Print(errors.New("Circular account parent-child relationship when importing"))
 			return NewError(999 /*Internal Error*/)
 		}
 		accountsRemainingLast = accountsRemaining
@@ -428,7 +526,12 @@ func GnucashImportHandler(r *http.Request, context *Context) ResponseWriterWrite
 
 	// Insert transactions, fixing up account IDs to match internal ones from
 	// above
-	for _, transaction := range gnucashImport.Transactions {
+	for _, transaction := range transactions {
+		index, accepted := state.next()
+		if !accepted {
+			continue
+		}
+
 		var already_imported bool
 		for _, split := range transaction.Splits {
 			acctId, ok := accountMap[split.AccountId]
@@ -438,7 +541,7 @@ func GnucashImportHandler(r *http.Request, context *Context) ResponseWriterWrite
 			}
 			split.AccountId = acctId
 
-			exists, err := context.Tx.SplitExists(split)
+			exists, err := tx.SplitExists(split)
 			if err != nil {
 				log.Print("Error checking if split was already imported:", err)
 				return NewError(999 /*Internal Error*/)
@@ -447,13 +550,16 @@ func GnucashImportHandler(r *http.Request, context *Context) ResponseWriterWrite
 			}
 		}
 		if !already_imported {
-			err := context.Tx.InsertTransaction(&transaction, user)
+			err := tx.InsertTransaction(&transaction, user)
 			if err != nil {
 				log.Print(err)
 				return NewError(999 /*Internal Error*/)
 			}
+			state.imported(index, transaction)
+		} else {
+			state.duplicate(index, transaction)
 		}
 	}
 
-	return SuccessWriter{}
+	return state.result(tx, user)
 }
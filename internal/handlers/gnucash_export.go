@@ -0,0 +1,482 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const gnucashNamespace = "http://www.gnucash.org/XML/"
+
+// gnucashPrefixes are the prefixes of the namespaces used in the books we
+// export, which are declared on the root element
+var gnucashPrefixes = []string{"gnc", "act", "book", "cd", "cmdty", "price", "slot", "split", "trn", "ts"}
+
+var gnucashGUIDRE = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type GnucashCountData struct {
+	Type  string `xml:"http://www.gnucash.org/XML/cd type,attr"`
+	Count int    `xml:",chardata"`
+}
+
+type GnucashXMLBook struct {
+	Version      string                `xml:"version,attr"`
+	Id           GnucashGUID           `xml:"http://www.gnucash.org/XML/book id"`
+	Counts       []GnucashCountData    `xml:"http://www.gnucash.org/XML/gnc count-data"`
+	Commodities  []GnucashXMLCommodity `xml:"http://www.gnucash.org/XML/gnc commodity"`
+	PriceDB      *GnucashPriceDB       `xml:"http://www.gnucash.org/XML/gnc pricedb,omitempty"`
+	Accounts     []GnucashAccount      `xml:"http://www.gnucash.org/XML/gnc account"`
+	Transactions []GnucashTransaction  `xml:"http://www.gnucash.org/XML/gnc transaction"`
+}
+
+type GnucashXMLExport struct {
+	XMLName xml.Name         `xml:"gnc-v2"`
+	Count   GnucashCountData `xml:"http://www.gnucash.org/XML/gnc count-data"`
+	Book    GnucashXMLBook   `xml:"http://www.gnucash.org/XML/gnc book"`
+}
+
+// writeGnucashXML writes v as XML, replacing the namespaces encoding/xml
+// declares on each element with the prefixes declared on the root element.
+// Gnucash matches elements by their prefixed names, so the namespaces alone
+// aren't enough.
+func writeGnucashXML(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	decoder := xml.NewDecoder(&buf)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	var names []xml.Name
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			var attrs []xml.Attr
+			if len(names) == 0 {
+				for _, prefix := range gnucashPrefixes {
+					attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "xmlns:" + prefix}, Value: gnucashNamespace + prefix})
+				}
+			}
+			name := xml.Name{Local: t.Name.Local}
+			attrPrefixes := make(map[string]string)
+			for _, a := range t.Attr {
+				if a.Name.Space == "" && a.Name.Local == "xmlns" {
+					name.Local = strings.TrimPrefix(a.Value, gnucashNamespace) + ":" + t.Name.Local
+				} else if a.Name.Space == "xmlns" {
+					attrPrefixes[a.Name.Local] = strings.TrimPrefix(a.Value, gnucashNamespace)
+				}
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space == "" && a.Name.Local != "xmlns" {
+					attrs = append(attrs, a)
+				} else if prefix, ok := attrPrefixes[a.Name.Space]; ok {
+					attrs = append(attrs, xml.Attr{Name: xml.Name{Local: prefix + ":" + a.Name.Local}, Value: a.Value})
+				}
+			}
+			names = append(names, name)
+			token = xml.StartElement{Name: name, Attr: attrs}
+		case xml.EndElement:
+			token = xml.EndElement{Name: names[len(names)-1]}
+			names = names[:len(names)-1]
+		}
+		if err := encoder.EncodeToken(token); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// gnucashGUID returns a GUID for one of user's objects which doesn't already
+// have one, which is the same each time the book is exported
+func gnucashGUID(user *models.User, kind string, id int64) GnucashGUID {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("moneygo:%d:%s:%d", user.UserId, kind, id)))
+	return GnucashGUID(hex.EncodeToString(hash[:16]))
+}
+
+// gnucashRemoteGUID returns the GUID an object was imported from Gnucash with,
+// if any
+func gnucashRemoteGUID(remoteId string) (GnucashGUID, bool) {
+	guid := strings.TrimPrefix(remoteId, "gnucash:")
+	if guid == remoteId || !gnucashGUIDRE.MatchString(guid) {
+		return "", false
+	}
+	return GnucashGUID(guid), true
+}
+
+// gnucashAmount formats amount as a Gnucash rational number with a
+// denominator of 10^precision, rounding if necessary
+func gnucashAmount(amount *big.Rat, precision uint64) string {
+	var rounded models.Amount
+	rounded.Set(amount)
+	rounded.Round(precision)
+	denom := new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(precision), nil)
+	num := new(big.Int).Mul(rounded.Num(), denom)
+	num.Quo(num, rounded.Denom())
+	return fmt.Sprintf("%s/%s", num, denom)
+}
+
+func gnucashAccountType(account *models.Account, security *models.Security) string {
+	switch account.Type {
+	case models.Cash:
+		return "CASH"
+	case models.Asset:
+		return "ASSET"
+	case models.Liability:
+		return "LIABILITY"
+	case models.Investment:
+		// Gnucash expects stock accounts to hold securities, so cash held
+		// in brokerage accounts is treated as though it's in a bank
+		if security.Type == models.Currency {
+			return "BANK"
+		}
+		return "STOCK"
+	case models.Income:
+		return "INCOME"
+	case models.Expense:
+		return "EXPENSE"
+	case models.Trading:
+		return "TRADING"
+	case models.Equity:
+		return "EQUITY"
+	case models.Receivable:
+		return "RECEIVABLE"
+	case models.Payable:
+		return "PAYABLE"
+	default:
+		return "BANK"
+	}
+}
+
+func gnucashSplitStatus(status int64) string {
+	switch status {
+	case models.Cleared:
+		return "c"
+	case models.Reconciled:
+		return "y"
+	case models.Voided:
+		return "v"
+	default:
+		return "n"
+	}
+}
+
+// ExportGnucash writes all of user's securities, prices, accounts, and
+// transactions to w as an uncompressed Gnucash XML book
+func ExportGnucash(tx store.Tx, user *models.User, w io.Writer) error {
+	securities, err := tx.GetSecurities(user.UserId)
+	if err != nil {
+		return err
+	}
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return err
+	}
+	transactions, err := tx.GetTransactions(user.UserId)
+	if err != nil {
+		return err
+	}
+
+	var book GnucashXMLBook
+	book.Version = "2.0.0"
+	book.Id = gnucashGUID(user, "book", user.UserId)
+
+	// Gnucash identifies commodities by their namespace and ID, so if we
+	// have several securities which map to the same commodity (such as
+	// duplicate currencies), only define it once
+	sort.Slice(*securities, func(i, j int) bool {
+		return (*securities)[i].SecurityId < (*securities)[j].SecurityId
+	})
+	securityMap := make(map[int64]*models.Security)
+	commodities := make(map[int64]GnucashXMLCommodity)
+	defined := make(map[GnucashXMLCommodity]bool)
+	for _, s := range *securities {
+		gc := GnucashCommodity{*s}
+		gxc := gc.toXMLCommodity()
+		ref := GnucashXMLCommodity{Type: gxc.Type, Name: gxc.Name}
+		securityMap[s.SecurityId] = s
+		commodities[s.SecurityId] = ref
+		if !defined[ref] {
+			defined[ref] = true
+			gxc.Version = "2.0.0"
+			book.Commodities = append(book.Commodities, gxc)
+		}
+	}
+
+	var pricedb GnucashPriceDB
+	pricedb.Version = "1"
+	for _, s := range *securities {
+		prices, err := tx.GetPrices(s.SecurityId)
+		if err != nil {
+			return err
+		}
+		sort.Slice(*prices, func(i, j int) bool {
+			return (*prices)[i].Date.Before((*prices)[j].Date)
+		})
+		for _, p := range *prices {
+			currency, ok := securityMap[p.CurrencyId]
+			if !ok {
+				return fmt.Errorf("Unable to find currency %d for price %d", p.CurrencyId, p.PriceId)
+			}
+			guid, ok := gnucashRemoteGUID(p.RemoteId)
+			if !ok {
+				guid = gnucashGUID(user, "price", p.PriceId)
+			}
+			pricedb.Prices = append(pricedb.Prices, GnucashPrice{
+				Id:        guid,
+				Commodity: GnucashCommodity{*s},
+				Currency:  GnucashCommodity{*currency},
+				Date:      GnucashDate{GnucashTime{p.Date}},
+				Source:    "user:price",
+				Value:     fmt.Sprintf("%s/%s", p.Value.Num(), p.Value.Denom()),
+			})
+		}
+	}
+	if len(pricedb.Prices) > 0 {
+		book.PriceDB = &pricedb
+	}
+
+	// Gnucash requires accounts' parents be defined before them, so add
+	// them to the book in depth-first order starting from the root
+	defaultCurrency, ok := securityMap[user.DefaultCurrency]
+	if !ok {
+		return fmt.Errorf("Unable to find default currency %d", user.DefaultCurrency)
+	}
+	rootGUID := gnucashGUID(user, "account", -1)
+	book.Accounts = append(book.Accounts, GnucashAccount{
+		Version:      "2.0.0",
+		Name:         "Root Account",
+		AccountId:    rootGUID,
+		Type:         "ROOT",
+		Commodity:    commodities[defaultCurrency.SecurityId],
+		CommoditySCU: int(math.Pow10(int(defaultCurrency.Precision))),
+	})
+
+	sort.Slice(*accounts, func(i, j int) bool {
+		return (*accounts)[i].AccountId < (*accounts)[j].AccountId
+	})
+	accountMap := make(map[int64]*models.Account)
+	for _, a := range *accounts {
+		accountMap[a.AccountId] = a
+	}
+	children := make(map[int64][]*models.Account)
+	for _, a := range *accounts {
+		parentId := a.ParentAccountId
+		if _, ok := accountMap[parentId]; !ok {
+			parentId = -1
+		}
+		children[parentId] = append(children[parentId], a)
+	}
+	accountGUIDs := map[int64]GnucashGUID{-1: rootGUID}
+	var addAccounts func(parentId int64)
+	addAccounts = func(parentId int64) {
+		for _, a := range children[parentId] {
+			security := securityMap[a.SecurityId]
+			accountGUIDs[a.AccountId] = gnucashGUID(user, "account", a.AccountId)
+			book.Accounts = append(book.Accounts, GnucashAccount{
+				Version:         "2.0.0",
+				Name:            a.Name,
+				AccountId:       accountGUIDs[a.AccountId],
+				Type:            gnucashAccountType(a, security),
+				Commodity:       commodities[a.SecurityId],
+				CommoditySCU:    int(math.Pow10(int(security.Precision))),
+				ParentAccountId: accountGUIDs[parentId],
+			})
+			addAccounts(a.AccountId)
+		}
+	}
+	addAccounts(-1)
+
+	sort.Slice(*transactions, func(i, j int) bool {
+		ti, tj := (*transactions)[i], (*transactions)[j]
+		if ti.Date.Equal(tj.Date) {
+			return ti.TransactionId < tj.TransactionId
+		}
+		return ti.Date.Before(tj.Date)
+	})
+	usedGUIDs := make(map[GnucashGUID]bool)
+	for _, t := range *transactions {
+		// Gnucash requires every split belong to an account, so leave out
+		// any which don't. Gnucash will balance the transaction when it's
+		// opened.
+		var splits []*models.Split
+		for _, s := range t.Splits {
+			if _, ok := accountMap[s.AccountId]; ok {
+				splits = append(splits, s)
+			}
+		}
+		if len(splits) == 0 {
+			continue
+		}
+
+		// Use the user's default currency for the transaction if it has
+		// any splits in it, otherwise the first currency used
+		var currency *models.Security
+		for _, s := range splits {
+			security := securityMap[accountMap[s.AccountId].SecurityId]
+			if security.SecurityId == defaultCurrency.SecurityId {
+				currency = security
+				break
+			} else if currency == nil && security.Type == models.Currency {
+				currency = security
+			}
+		}
+		if currency == nil {
+			currency = defaultCurrency
+		}
+
+		// Gnucash records the value of each split in the transaction's
+		// currency. Because all securities balance in our transactions,
+		// any price keeps the transaction balanced, so use the one implied
+		// by the transaction itself, if any.
+		var currencyTotal big.Rat
+		quantities := make(map[int64]*big.Rat)
+		for _, s := range splits {
+			securityId := accountMap[s.AccountId].SecurityId
+			if s.Amount.Sign() <= 0 {
+				continue
+			}
+			if securityId == currency.SecurityId {
+				currencyTotal.Add(&currencyTotal, &s.Amount.Rat)
+			} else {
+				if quantities[securityId] == nil {
+					quantities[securityId] = new(big.Rat)
+				}
+				quantities[securityId].Add(quantities[securityId], &s.Amount.Rat)
+			}
+		}
+		prices := make(map[int64]*big.Rat)
+		for securityId, quantity := range quantities {
+			if currencyTotal.Sign() > 0 {
+				prices[securityId] = new(big.Rat).Quo(&currencyTotal, quantity)
+			} else if price, err := tx.GetLatestPrice(securityMap[securityId], currency, &t.Date); err == nil {
+				prices[securityId] = &price.Value.Rat
+			} else {
+				prices[securityId] = new(big.Rat)
+			}
+		}
+
+		gt := GnucashTransaction{
+			Version:       "2.0.0",
+			TransactionId: gnucashGUID(user, "transaction", t.TransactionId),
+			Commodity:     commodities[currency.SecurityId],
+			DatePosted:    GnucashDate{GnucashTime{t.Date}},
+			DateEntered:   GnucashDate{GnucashTime{t.Date}},
+			Description:   t.Description,
+		}
+		var values []big.Rat
+		for _, s := range splits {
+			if len(gt.Number) == 0 {
+				gt.Number = s.Number
+			}
+			security := securityMap[accountMap[s.AccountId].SecurityId]
+
+			var value big.Rat
+			if security.SecurityId == currency.SecurityId {
+				value.Set(&s.Amount.Rat)
+			} else {
+				value.Mul(&s.Amount.Rat, prices[security.SecurityId])
+				value.SetString(value.FloatString(int(currency.Precision)))
+			}
+			values = append(values, value)
+
+			guid, ok := gnucashRemoteGUID(s.RemoteId)
+			if !ok || usedGUIDs[guid] {
+				guid = gnucashGUID(user, "split", s.SplitId)
+			}
+			usedGUIDs[guid] = true
+
+			gt.Splits = append(gt.Splits, GnucashSplit{
+				SplitId:   guid,
+				Memo:      s.Memo,
+				Status:    gnucashSplitStatus(s.Status),
+				Amount:    gnucashAmount(&s.Amount.Rat, security.Precision),
+				AccountId: accountGUIDs[s.AccountId],
+			})
+		}
+
+		// Ensure rounding values didn't unbalance the transaction by
+		// assigning any remainder to the last split not in its currency
+		var total big.Rat
+		for i := range values {
+			total.Add(&total, &values[i])
+		}
+		if total.Sign() != 0 {
+			for i := len(splits) - 1; i >= 0; i-- {
+				if accountMap[splits[i].AccountId].SecurityId != currency.SecurityId {
+					values[i].Sub(&values[i], &total)
+					break
+				}
+			}
+		}
+		for i := range values {
+			gt.Splits[i].Value = gnucashAmount(&values[i], currency.Precision)
+		}
+
+		book.Transactions = append(book.Transactions, gt)
+	}
+
+	book.Counts = []GnucashCountData{
+		{Type: "commodity", Count: len(book.Commodities)},
+		{Type: "account", Count: len(book.Accounts)},
+		{Type: "transaction", Count: len(book.Transactions)},
+		{Type: "price", Count: len(pricedb.Prices)},
+	}
+
+	return writeGnucashXML(w, &GnucashXMLExport{
+		Count: GnucashCountData{Type: "book", Count: 1},
+		Book:  book,
+	})
+}
+
+func GnucashExportHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	// Gnucash compresses its XML books by default
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	if err := ExportGnucash(context.Tx, user, gzw); err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	if err := gzw.Close(); err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return FileWriter{
+		ContentType: "application/x-gnucash",
+		Filename:    "moneygo.gnucash",
+		Data:        buf.Bytes(),
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// sqliteHeader is the string every SQLite database file starts with, used to
+// detect books saved using Gnucash's SQLite backend
+const sqliteHeader = "SQLite format 3\x00"
+
+// gnucashSQLiteTime parses the timestamps in Gnucash's SQLite backend, which
+// are in UTC and either of the form "2006-01-02 15:04:05" or, for books
+// written by older versions of Gnucash, "20060102150405"
+func gnucashSQLiteTime(s sql.NullString) (GnucashTime, error) {
+	if !s.Valid {
+		return GnucashTime{}, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "20060102150405"} {
+		if t, err := time.Parse(layout, s.String); err == nil {
+			return GnucashTime{t}, nil
+		}
+	}
+	return GnucashTime{}, fmt.Errorf("Unable to parse Gnucash SQLite date: %s", s.String)
+}
+
+// readGnucashSQLite reads the tables of a Gnucash SQLite book into the same
+// structs used when decoding Gnucash XML. Accounts and transactions belonging
+// to scheduled transaction templates are skipped, since they don't appear in
+// the XML book either.
+func readGnucashSQLite(db *sql.DB) (*GnucashXMLImport, error) {
+	var gncxml GnucashXMLImport
+
+	var rootAccount, rootTemplate GnucashGUID
+	err := db.QueryRow("SELECT root_account_guid, root_template_guid FROM books").Scan(&rootAccount, &rootTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	commodities := make(map[string]GnucashXMLCommodity)
+	rows, err := db.Query("SELECT guid, namespace, mnemonic, fullname, cusip, fraction FROM commodities")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var guid string
+		var fullname, cusip sql.NullString
+		var gxc GnucashXMLCommodity
+		if err := rows.Scan(&guid, &gxc.Type, &gxc.Name, &fullname, &cusip, &gxc.Fraction); err != nil {
+			return nil, err
+		}
+		gxc.Description = fullname.String
+		gxc.XCode = cusip.String
+
+		var gc GnucashCommodity
+		if err := gc.fromXMLCommodity(&gxc); err != nil {
+			return nil, err
+		}
+		gncxml.Commodities = append(gncxml.Commodities, gc)
+		commodities[guid] = gxc
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT guid, commodity_guid, currency_guid, date, source, type, value_num, value_denom FROM prices")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p GnucashPrice
+		var commodity, currency string
+		var date, source, priceType sql.NullString
+		var num, denom int64
+		if err := rows.Scan(&p.Id, &commodity, &currency, &date, &source, &priceType, &num, &denom); err != nil {
+			return nil, err
+		}
+		p.Commodity.Name = commodities[commodity].Name
+		p.Currency.Name = commodities[currency].Name
+		if p.Date.Date, err = gnucashSQLiteTime(date); err != nil {
+			return nil, err
+		}
+		p.Source = source.String
+		p.Type = priceType.String
+		p.Value = fmt.Sprintf("%d/%d", num, denom)
+		gncxml.PriceDB.Prices = append(gncxml.PriceDB.Prices, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var accounts []GnucashAccount
+	parents := make(map[GnucashGUID]GnucashGUID)
+	rows, err = db.Query("SELECT guid, name, account_type, commodity_guid, parent_guid, description FROM accounts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a GnucashAccount
+		var commodity, parent, description sql.NullString
+		if err := rows.Scan(&a.AccountId, &a.Name, &a.Type, &commodity, &parent, &description); err != nil {
+			return nil, err
+		}
+		a.Commodity = commodities[commodity.String]
+		a.ParentAccountId = GnucashGUID(parent.String)
+		a.Description = description.String
+		parents[a.AccountId] = a.ParentAccountId
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only keep accounts descending from the book's root account
+	templateAccounts := make(map[GnucashGUID]bool)
+	for _, a := range accounts {
+		guid := a.AccountId
+		for depth := 0; guid != rootAccount && guid != rootTemplate && len(guid) > 0 && depth <= len(accounts); depth++ {
+			guid = parents[guid]
+		}
+		if guid == rootAccount {
+			gncxml.Accounts = append(gncxml.Accounts, a)
+		} else {
+			templateAccounts[a.AccountId] = true
+		}
+	}
+
+	splits := make(map[GnucashGUID][]GnucashSplit)
+	skipTransactions := make(map[GnucashGUID]bool)
+	rows, err = db.Query("SELECT guid, tx_guid, account_guid, memo, reconcile_state, value_num, value_denom, quantity_num, quantity_denom FROM splits")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s GnucashSplit
+		var txGuid GnucashGUID
+		var memo sql.NullString
+		var valueNum, valueDenom, quantityNum, quantityDenom int64
+		if err := rows.Scan(&s.SplitId, &txGuid, &s.AccountId, &memo, &s.Status, &valueNum, &valueDenom, &quantityNum, &quantityDenom); err != nil {
+			return nil, err
+		}
+		s.Memo = memo.String
+		s.Value = fmt.Sprintf("%d/%d", valueNum, valueDenom)
+		s.Amount = fmt.Sprintf("%d/%d", quantityNum, quantityDenom)
+		if templateAccounts[s.AccountId] {
+			skipTransactions[txGuid] = true
+		}
+		splits[txGuid] = append(splits[txGuid], s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT guid, currency_guid, num, post_date, enter_date, description FROM transactions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t GnucashTransaction
+		var currency string
+		var postDate, enterDate, description sql.NullString
+		if err := rows.Scan(&t.TransactionId, &currency, &t.Number, &postDate, &enterDate, &description); err != nil {
+			return nil, err
+		}
+		if skipTransactions[t.TransactionId] {
+			continue
+		}
+		t.Commodity = commodities[currency]
+		if t.DatePosted.Date, err = gnucashSQLiteTime(postDate); err != nil {
+			return nil, err
+		}
+		if t.DateEntered.Date, err = gnucashSQLiteTime(enterDate); err != nil {
+			return nil, err
+		}
+		t.Description = description.String
+		t.Splits = splits[t.TransactionId]
+		gncxml.Transactions = append(gncxml.Transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &gncxml, nil
+}
+
+// ImportGnucashSQLite imports a Gnucash book saved using its SQLite backend.
+// Because SQLite needs a file to open, r is first copied to a temporary file.
+func ImportGnucashSQLite(r io.Reader) (*GnucashImport, error) {
+	f, err := ioutil.TempFile("", "moneygo-gnucash-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+f.Name()+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	gncxml, err := readGnucashSQLite(db)
+	if err != nil {
+		return nil, err
+	}
+	return gnucashImportFromXML(gncxml)
+}
@@ -46,7 +46,8 @@ func (c *Context) LastLevel() bool {
 type Handler func(*http.Request, *Context) ResponseWriterWriter
 
 type APIHandler struct {
-	Store store.Store
+	Store   store.Store
+	OFXSync *OFXSyncer // nil unless OFX passwords may be stored for syncing
 }
 
 func (ah *APIHandler) txWrapper(h Handler, r *http.Request, context *Context) (writer ResponseWriterWriter) {
@@ -62,6 +63,8 @@ func (ah *APIHandler) txWrapper(h Handler, r *http.Request, context *Context) (w
 		}
 		if _, ok := writer.(*Error); ok {
 			tx.Rollback()
+		} else if _, ok := writer.(RollbackWriter); ok {
+			tx.Rollback()
 		} else {
 			err = tx.Commit()
 			if err != nil {
@@ -99,8 +102,27 @@ func (ah *APIHandler) route(r *http.Request) ResponseWriterWriter {
 		return ah.txWrapper(TransactionHandler, r, context)
 	case "imports":
 		return ah.txWrapper(ImportHandler, r, context)
+	case "exports":
+		return ah.txWrapper(ExportHandler, r, context)
 	case "reports":
 		return ah.txWrapper(ReportHandler, r, context)
+	case "rules":
+		return ah.txWrapper(RuleHandler, r, context)
+	case "scheduledtransactions":
+		return ah.txWrapper(ScheduledTransactionHandler, r, context)
+	case "reminders":
+		return ah.txWrapper(ReminderHandler, r, context)
+	case "budgets":
+		return ah.txWrapper(BudgetHandler, r, context)
+	case "tags":
+		return ah.txWrapper(TagHandler, r, context)
+	case "ofxdiscovery":
+		return ah.txWrapper(OFXDiscoveryHandler, r, context)
+	case "ofxsyncs":
+		if ah.OFXSync == nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		return ah.txWrapper(ah.OFXSync.OFXSyncHandler, r, context)
 	default:
 		return NewError(3 /*Invalid Request*/)
 	}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// transactionHash returns a hash of the parts of a transaction a user can
+// edit, for detecting whether an imported transaction has been edited since
+func transactionHash(t *models.Transaction) string {
+	var splits []string
+	for _, s := range t.Splits {
+		splits = append(splits, fmt.Sprintf("%d|%d|%d|%d|%q|%q|%q|%s", s.Status, s.ImportSplitType, s.AccountId, s.SecurityId, s.RemoteId, s.Number, s.Memo, s.Amount.RatString()))
+	}
+	// Don't depend on the order splits are returned in
+	sort.Strings(splits)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%q|%d\n", t.Description, t.Date.Unix())
+	fmt.Fprint(hash, strings.Join(splits, "\n"))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// existingTagIds returns the IDs in tagids which are in existing. It never
+// returns nil, since that would leave a transaction's tags as they are when
+// updating it.
+func existingTagIds(tagids []int64, existing map[int64]bool) []int64 {
+	result := []int64{}
+	for _, tagid := range tagids {
+		if existing[tagid] {
+			result = append(result, tagid)
+		}
+	}
+	return result
+}
+
+// rollbackImportBatch deletes what was created by batch, restores the
+// transactions it merged into, corrected, or voided to how they were before,
+// and then deletes batch itself. If any of its transactions have been edited
+// since it was imported, nothing is changed unless force is set. Accounts and
+// securities which are still in use by anything not created by batch are left
+// alone, as are any transactions, accounts, securities, or prices which have
+// already been deleted.
+func rollbackImportBatch(tx store.Tx, user *models.User, batch *models.ImportBatch, force bool) *Error {
+	transactions, err := tx.GetTransactions(user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	transactionMap := make(map[int64]*models.Transaction)
+	for _, t := range *transactions {
+		transactionMap[t.TransactionId] = t
+	}
+
+	var batchTransactions []*models.Transaction
+	inBatch := make(map[int64]bool)
+	for i, transactionid := range batch.TransactionIds {
+		t, ok := transactionMap[transactionid]
+		if !ok {
+			continue
+		}
+		if transactionHash(t) != batch.TransactionHashes[i] && !force {
+			return NewError(8 /*Edited Since Import*/)
+		}
+		batchTransactions = append(batchTransactions, t)
+		inBatch[transactionid] = true
+	}
+
+	var previousTransactions []*models.Transaction
+	for i, transactionid := range batch.ModifiedTransactionIds {
+		t, ok := transactionMap[transactionid]
+		if !ok {
+			continue
+		}
+		if transactionHash(t) != batch.ModifiedTransactionHashes[i] && !force {
+			return NewError(8 /*Edited Since Import*/)
+		}
+		previousTransactions = append(previousTransactions, batch.PreviousTransactions[i])
+	}
+
+	for _, t := range batchTransactions {
+		if err := tx.DeleteTransaction(t, user); err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+	}
+	if len(previousTransactions) > 0 {
+		tags, err := tx.GetTags(user.UserId)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		existingTags := make(map[int64]bool)
+		for _, tag := range *tags {
+			existingTags[tag.TagId] = true
+		}
+		for _, previous := range previousTransactions {
+			// Don't restore tags which have been deleted since
+			previous.TagIds = existingTagIds(previous.TagIds, existingTags)
+			for _, split := range previous.Splits {
+				split.TagIds = existingTagIds(split.TagIds, existingTags)
+			}
+		}
+	}
+	for _, previous := range previousTransactions {
+		if err := tx.UpdateTransaction(previous, user); err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		transactionMap[previous.TransactionId] = previous
+	}
+
+	// Find the accounts still used by the remaining transactions
+	usedAccounts := make(map[int64]bool)
+	for _, t := range transactionMap {
+		if inBatch[t.TransactionId] {
+			continue
+		}
+		for _, split := range t.Splits {
+			usedAccounts[split.AccountId] = true
+		}
+	}
+
+	// Delete prices, then find the securities used by the remaining ones
+	securities, err := tx.GetSecurities(user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	batchPrices := make(map[int64]bool)
+	for _, priceid := range batch.PriceIds {
+		batchPrices[priceid] = true
+	}
+	usedSecurities := make(map[int64]bool)
+	for _, security := range *securities {
+		prices, err := tx.GetPrices(security.SecurityId)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		for _, price := range *prices {
+			if batchPrices[price.PriceId] {
+				if err := tx.DeletePrice(price); err != nil {
+					log.Print(err)
+					return NewError(999 /*Internal Error*/)
+				}
+			} else {
+				usedSecurities[price.SecurityId] = true
+				usedSecurities[price.CurrencyId] = true
+			}
+		}
+	}
+
+	// Delete accounts in the reverse of the order they were created in, so
+	// children are deleted before their parents
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	accountMap := make(map[int64]*models.Account)
+	for _, account := range *accounts {
+		accountMap[account.AccountId] = account
+	}
+	for i := len(batch.AccountIds) - 1; i >= 0; i-- {
+		account, ok := accountMap[batch.AccountIds[i]]
+		if !ok || usedAccounts[account.AccountId] {
+			continue
+		}
+		var hasChildren bool
+		for _, a := range accountMap {
+			if a.ParentAccountId == account.AccountId {
+				hasChildren = true
+				break
+			}
+		}
+		if hasChildren {
+			continue
+		}
+		if err := tx.DeleteAccount(account); err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		delete(accountMap, account.AccountId)
+	}
+
+	batchSecurities := make(map[int64]bool)
+	for _, securityid := range batch.SecurityIds {
+		batchSecurities[securityid] = true
+	}
+	for _, security := range *securities {
+		if !batchSecurities[security.SecurityId] || usedSecurities[security.SecurityId] {
+			continue
+		}
+		err := tx.DeleteSecurity(security)
+		if _, ok := err.(store.SecurityInUseError); ok {
+			continue
+		} else if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+	}
+
+	if err := tx.DeleteImportBatch(batch); err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	return nil
+}
+
+// ImportBatchesHandler returns the history of the user's imports
+func ImportBatchesHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method != "GET" {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	var bl models.ImportBatchList
+	batches, err := context.Tx.GetImportBatches(user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	bl.ImportBatches = batches
+	return &bl
+}
+
+// ImportBatchHandler returns or rolls back one of the user's imports. Rolling
+// back an import whose transactions have been edited since fails with an
+// 'Edited Since Import' error, unless the 'force' query parameter is 'true'.
+func ImportBatchHandler(r *http.Request, context *Context, importbatchid int64) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if !context.LastLevel() {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	batch, err := context.Tx.GetImportBatch(importbatchid, user.UserId)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	if r.Method == "GET" {
+		return batch
+	} else if r.Method == "DELETE" {
+		query, _ := url.ParseQuery(r.URL.RawQuery)
+		force := query.Get("force") == "true"
+
+		if err := rollbackImportBatch(context.Tx, user, batch, force); err != nil {
+			return err
+		}
+		return SuccessWriter{}
+	}
+	return NewError(3 /*Invalid Request*/)
+}
@@ -1,14 +1,22 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/aclindsa/moneygo/internal/models"
 	"github.com/aclindsa/moneygo/internal/store"
 	"github.com/aclindsa/ofxgo"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/big"
+	"mime/multipart"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -17,6 +25,7 @@ type OFXDownload struct {
 	OFXPassword string
 	StartDate   time.Time
 	EndDate     time.Time
+	models.ImportOptions
 }
 
 func (od *OFXDownload) Read(json_str string) error {
@@ -24,84 +33,757 @@ func (od *OFXDownload) Read(json_str string) error {
 	return dec.Decode(od)
 }
 
-func ofxImportHelper(tx store.Tx, r io.Reader, user *models.User, accountid int64) ResponseWriterWriter {
-	itl, err := ImportOFX(r)
+// importState tracks which of an import's transactions should be imported,
+// and what the import did so it can be previewed or recorded as a batch
+type importState struct {
+	options    models.ImportOptions
+	rules      *importRules
+	suggester  *suggester
+	index      int
+	matched    map[int64]bool // existing splits imported ones were merged into
+	matches    []*models.ImportMatch
+	corrected  []*models.ImportedTransaction
+	rejected   []*models.ImportedTransaction
+	discrepant []*models.BalanceDiscrepancy
+	unmatched  []*models.Account
+	preview    models.ImportPreview
+	accounts   map[int64]bool      // accounts which existed before the import
+	securities map[int64]bool      // securities which existed before the import
+	batch      *models.ImportBatch // nil unless the import is being recorded
+}
 
+func newImportState(tx store.Tx, user *models.User, options models.ImportOptions) (*importState, error) {
+	rules, err := getImportRules(tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	suggester, err := newSuggester(tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	state := importState{options: options, rules: rules, suggester: suggester, matched: make(map[int64]bool)}
+
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	state.accounts = make(map[int64]bool)
+	for _, account := range *accounts {
+		state.accounts[account.AccountId] = true
+	}
+
+	securities, err := tx.GetSecurities(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	state.securities = make(map[int64]bool)
+	for _, security := range *securities {
+		state.securities[security.SecurityId] = true
+	}
+	return &state, nil
+}
+
+// next returns the index of the next transaction in the import, and whether
+// it should be imported
+func (s *importState) next() (int, bool) {
+	index := s.index
+	s.index++
+	if s.options.Accept == nil {
+		return index, true
+	}
+	for _, accepted := range s.options.Accept {
+		if accepted == index {
+			return index, true
+		}
+	}
+	return index, false
+}
+
+// record causes what the import creates to be recorded in a batch with
+// source and the hex-encoded SHA-256 hash of the imported file, unless it's a
+// preview
+func (s *importState) record(source int64, filehash string) {
+	if !s.options.Preview {
+		s.batch = &models.ImportBatch{Source: source, FileHash: filehash}
+	}
+}
+
+func (s *importState) imported(index int, transaction models.Transaction) {
+	if s.options.Preview {
+		s.preview.Transactions = append(s.preview.Transactions, &models.ImportedTransaction{Index: index, Transaction: &transaction})
+	}
+	if s.batch != nil {
+		s.batch.TransactionIds = append(s.batch.TransactionIds, transaction.TransactionId)
+		s.batch.TransactionHashes = append(s.batch.TransactionHashes, transactionHash(&transaction))
+	}
+}
+
+// modifying records the existing transaction with the given ID as it is
+// before the import changes it, so it can be restored if the import is rolled
+// back. modified must be called once it has been changed.
+func (s *importState) modifying(tx store.Tx, user *models.User, transactionid int64) error {
+	if s.batch == nil {
+		return nil
+	}
+	for _, id := range s.batch.ModifiedTransactionIds {
+		if id == transactionid {
+			// Only the state from before the import's first change matters
+			return nil
+		}
+	}
+	previous, err := tx.GetTransaction(transactionid, user.UserId)
+	if err != nil {
+		return err
+	}
+	s.batch.ModifiedTransactionIds = append(s.batch.ModifiedTransactionIds, transactionid)
+	s.batch.ModifiedTransactionHashes = append(s.batch.ModifiedTransactionHashes, "")
+	s.batch.PreviousTransactions = append(s.batch.PreviousTransactions, previous)
+	return nil
+}
+
+// modified records the hash of transaction after the import has changed it
+func (s *importState) modified(transaction *models.Transaction) {
+	if s.batch == nil {
+		return
+	}
+	for i, id := range s.batch.ModifiedTransactionIds {
+		if id == transaction.TransactionId {
+			s.batch.ModifiedTransactionHashes[i] = transactionHash(transaction)
+		}
+	}
+}
+
+// createdPrice records that the import created price
+func (s *importState) createdPrice(price *models.Price) {
+	if s.batch != nil {
+		s.batch.PriceIds = append(s.batch.PriceIds, price.PriceId)
+	}
+}
+
+func (s *importState) duplicate(index int, transaction models.Transaction) {
+	if s.options.Preview {
+		s.preview.Duplicates = append(s.preview.Duplicates, &models.ImportedTransaction{Index: index, Transaction: &transaction})
+	}
+}
+
+func (s *importState) correction(index int, transaction models.Transaction) {
+	s.corrected = append(s.corrected, &models.ImportedTransaction{Index: index, Transaction: &transaction})
+}
+
+// rejection records that the imported transaction with the given index
+// wasn't applied as a correction because it would change reconciled splits
+func (s *importState) rejection(index int, transaction models.Transaction) {
+	s.rejected = append(s.rejected, &models.ImportedTransaction{Index: index, Transaction: &transaction})
+}
+
+// matchSimilarity is the minimum similarity between the descriptions of an
+// imported transaction and one entered by hand for them to be merged
+const matchSimilarity float64 = 0.5
+
+// descriptionSimilarity returns the fraction of the payee tokens in the
+// shorter of two descriptions which are also found in the other, so that
+// abbreviated descriptions entered by hand can match the more verbose ones
+// banks use
+func descriptionSimilarity(a, b string) float64 {
+	tokensA, tokensB := payeeTokens(a), payeeTokens(b)
+	if len(tokensA) > len(tokensB) {
+		tokensA, tokensB = tokensB, tokensA
+	}
+	if len(tokensA) == 0 {
+		return 0
+	}
+	var common int
+	for token := range tokensA {
+		if tokensB[token] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(tokensA))
+}
+
+// match looks for a transaction entered by hand which transaction, being
+// imported into account, duplicates. The existing transaction must have an
+// Entered split in account for the same amount as the imported transaction's
+// only split in account, be dated within the import's MatchDays, and have a
+// similar description. If more than one matches, the one with the most
+// similar description, and then the closest date, is returned along with its
+// matching split.
+func (s *importState) match(tx store.Tx, user *models.User, account *models.Account, transaction *models.Transaction) (*models.Transaction, *models.Split, error) {
+	days := s.options.MatchDays
+	if days < 0 {
+		return nil, nil, nil
+	} else if days == 0 {
+		days = models.DefaultMatchDays
+	}
+
+	var imported *models.Split
+	for _, split := range transaction.Splits {
+		if split.AccountId == account.AccountId {
+			if imported != nil {
+				return nil, nil, nil
+			}
+			imported = split
+		}
+	}
+	if imported == nil {
+		return nil, nil, nil
+	}
+
+	begin := transaction.Date.AddDate(0, 0, -days)
+	end := transaction.Date.AddDate(0, 0, days)
+	candidates, err := tx.FindEnteredTransactions(user, imported, &begin, &end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var best *models.Transaction
+	var bestSplit *models.Split
+	var bestSimilarity float64
+	var bestDistance time.Duration
+	for _, candidate := range *candidates {
+		similarity := descriptionSimilarity(transaction.Description, candidate.Description)
+		if similarity < matchSimilarity {
+			continue
+		}
+		distance := candidate.Date.Sub(transaction.Date)
+		if distance < 0 {
+			distance = -distance
+		}
+		if best != nil && (similarity < bestSimilarity || (similarity == bestSimilarity && distance >= bestDistance)) {
+			continue
+		}
+		for _, split := range candidate.Splits {
+			if split.AccountId == imported.AccountId && split.Status == models.Entered &&
+				split.Amount.Cmp(&imported.Amount.Rat) == 0 && !s.matched[split.SplitId] {
+				best, bestSplit, bestSimilarity, bestDistance = candidate, split, similarity, distance
+				break
+			}
+		}
+	}
+	return best, bestSplit, nil
+}
+
+// merge marks split, from existing, as having been imported as part of the
+// transaction with the given index, instead of importing that transaction
+func (s *importState) merge(tx store.Tx, user *models.User, index int, existing *models.Transaction, split *models.Split, transaction *models.Transaction) error {
+	for _, imported := range transaction.Splits {
+		if imported.AccountId == split.AccountId {
+			split.RemoteId = imported.RemoteId
+		}
+	}
+	split.Status = models.Cleared
+	if err := s.modifying(tx, user, existing.TransactionId); err != nil {
+		return err
+	}
+	if err := tx.UpdateTransaction(existing, user); err != nil {
+		return err
+	}
+	s.modified(existing)
+	s.matched[split.SplitId] = true
+	s.matches = append(s.matches, &models.ImportMatch{Index: index, SplitId: split.SplitId, Transaction: existing})
+	return nil
+}
+
+// result returns the response for a successful import, after saving its
+// batch if it is being recorded. For previews, this is the ImportPreview, and
+// causes everything done by the import to be rolled back.
+func (s *importState) result(tx store.Tx, user *models.User) ResponseWriterWriter {
+	accounts, err := tx.GetAccounts(user.UserId)
 	if err != nil {
-		//TODO is this necessarily an invalid request (what if it was an error on our end)?
 		log.Print(err)
-		return NewError(3 /*Invalid Request*/)
+		return NewError(999 /*Internal Error*/)
+	}
+	var created []*models.Account
+	for _, account := range *accounts {
+		if !s.accounts[account.AccountId] {
+			created = append(created, account)
+		}
 	}
+	// Accounts are created with increasing IDs, and parents before children
+	sort.Slice(created, func(i, j int) bool { return created[i].AccountId < created[j].AccountId })
 
-	if len(itl.Accounts) != 1 {
-		log.Printf("Found %d accounts when importing OFX, expected 1", len(itl.Accounts))
-		return NewError(3 /*Invalid Request*/)
+	securities, err := tx.GetSecurities(user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
 	}
 
-	// Return Account with this Id
-	account, err := tx.GetAccount(accountid, user.UserId)
+	if !s.options.Preview {
+		if s.batch != nil {
+			s.batch.UserId = user.UserId
+			s.batch.Date = time.Now().UTC().Truncate(time.Second)
+			for _, account := range created {
+				s.batch.AccountIds = append(s.batch.AccountIds, account.AccountId)
+			}
+			for _, security := range *securities {
+				if !s.securities[security.SecurityId] {
+					s.batch.SecurityIds = append(s.batch.SecurityIds, security.SecurityId)
+				}
+			}
+			if err := tx.InsertImportBatch(s.batch); err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+		}
+		return &models.ImportResult{Matches: s.matches, Corrections: s.corrected, Rejected: s.rejected, Discrepancies: s.discrepant, Unmatched: s.unmatched}
+	}
+
+	s.preview.Matches = s.matches
+	s.preview.Corrections = s.corrected
+	s.preview.Rejected = s.rejected
+	s.preview.Discrepancies = s.discrepant
+	s.preview.Unmatched = s.unmatched
+	s.preview.Accounts = created
+	for _, security := range *securities {
+		if !s.securities[security.SecurityId] {
+			s.preview.Securities = append(s.preview.Securities, security)
+		}
+	}
+
+	return RollbackWriter{&s.preview}
+}
+
+// importFilePart returns the part of a multipart import request containing
+// the file to be imported. Any parts preceding it named "options" are decoded
+// as JSON into options.
+func importFilePart(r *http.Request, options *models.ImportOptions) (*multipart.Part, *Error) {
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		return nil, NewError(3 /*Invalid Request*/)
+	}
+
+	for {
+		part, err := multipartReader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				log.Print("Encountered unexpected EOF")
+				return nil, NewError(3 /*Invalid Request*/)
+			} else {
+				log.Print(err)
+				return nil, NewError(999 /*Internal Error*/)
+			}
+		}
+
+		if part.FormName() != "options" {
+			return part, nil
+		}
+		if err := json.NewDecoder(io.LimitReader(part, 1024*1024 /*1Mb*/)).Decode(options); err != nil {
+			return nil, NewError(3 /*Invalid Request*/)
+		}
+	}
+}
+
+// ofxImportHelper imports every statement in the OFX response read from r. If
+// accountid isn't -1 and the response contains a single statement, it is
+// imported into that account; otherwise, statements are imported into the
+// accounts matching them, and accountid (if any) only supplies the OFX
+// connection details of accounts created for the others.
+func ofxImportHelper(tx store.Tx, r io.Reader, user *models.User, accountid int64, options models.ImportOptions) ResponseWriterWriter {
+	state, err := newImportState(tx, user, options)
 	if err != nil {
 		log.Print(err)
-		return NewError(3 /*Invalid Request*/)
+		return NewError(999 /*Internal Error*/)
 	}
 
-	importedAccount := itl.Accounts[0]
+	hash := sha256.New()
+	r = io.TeeReader(r, hash)
+	itl, err := ImportOFX(r)
 
-	if len(account.ExternalAccountId) > 0 &&
-		account.ExternalAccountId != importedAccount.ExternalAccountId {
-		log.Printf("OFX import has \"%s\" as ExternalAccountId, but the account being imported to has\"%s\"",
-			importedAccount.ExternalAccountId,
-			account.ExternalAccountId)
+	if err != nil {
+		//TODO is this necessarily an invalid request (what if it was an error on our end)?
+		log.Print(err)
 		return NewError(3 /*Invalid Request*/)
 	}
 
+	// Hash any trailing data the parser didn't need to read, too
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	state.record(models.OFXImport, hex.EncodeToString(hash.Sum(nil)))
+
 	// Find matching existing securities or create new ones for those
 	// referenced by the OFX import. Also create a map from placeholder import
 	// SecurityIds to the actual SecurityIDs
-	var securitymap = make(map[int64]models.Security)
-	for _, ofxsecurity := range itl.Securities {
-		// save off since ImportGetCreateSecurity overwrites SecurityId on
-		// ofxsecurity
-		oldsecurityid := ofxsecurity.SecurityId
-		security, err := ImportGetCreateSecurity(tx, user.UserId, &ofxsecurity)
+	securitymap, err := importSecurities(tx, user, itl.Securities)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	var connection *models.Account
+	if accountid != -1 {
+		connection, err = tx.GetAccount(accountid, user.UserId)
 		if err != nil {
+			log.Print(err)
+			return NewError(3 /*Invalid Request*/)
+		}
+	}
+	existing, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	// Import each statement into its account, keeping track of which
+	// accounts were imported into to check their balances afterwards
+	var imported []*models.Account
+	var importedIds []int64
+	for _, importedAccount := range itl.Accounts {
+		transactions, corrections := itl.accountTransactions(importedAccount.AccountId)
+
+		var account *models.Account
+		if connection != nil && len(itl.Accounts) == 1 {
+			account = connection
+			if len(account.ExternalAccountId) > 0 &&
+				account.ExternalAccountId != importedAccount.ExternalAccountId {
+				log.Printf("OFX import has \"%s\" as ExternalAccountId, but the account being imported to has\"%s\"",
+					importedAccount.ExternalAccountId,
+					account.ExternalAccountId)
+				return NewError(3 /*Invalid Request*/)
+			}
+		} else {
+			account = matchOFXAccount(existing, &importedAccount)
+		}
+
+		if account == nil {
+			unmatched := importedAccount
+			unmatched.AccountId = -1
+			unmatched.UserId = user.UserId
+			unmatched.SecurityId = securitymap[importedAccount.SecurityId].SecurityId
+			if connection != nil {
+				setOFXFields(&unmatched, connection)
+				unmatched.OFXBankID = importedAccount.OFXBankID
+				unmatched.OFXAcctID = importedAccount.OFXAcctID
+				unmatched.OFXAcctType = importedAccount.OFXAcctType
+			}
+
+			if !state.options.CreateAccounts {
+				// Skip this statement's transactions, so the indices of the
+				// rest are the same as if they were imported
+				for range transactions {
+					state.next()
+				}
+				state.unmatched = append(state.unmatched, &unmatched)
+				continue
+			}
+			if err := tx.InsertAccount(&unmatched); err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			account = &unmatched
+		}
+
+		if account.SecurityId != securitymap[importedAccount.SecurityId].SecurityId {
+			log.Printf("OFX import account's SecurityId (%d) does not match this account's (%d)", securitymap[importedAccount.SecurityId].SecurityId, account.SecurityId)
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if err := importTransactionsHelper(tx, user, account, importedAccount.AccountId, securitymap, transactions, corrections, state); err != nil {
+			return err
+		}
+		imported = append(imported, account)
+		importedIds = append(importedIds, importedAccount.AccountId)
+	}
+
+	if err := state.importPrices(tx, securitymap, itl.Prices); err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	for i, account := range imported {
+		if err := state.checkBalances(tx, user, account, importedIds[i], securitymap, itl.Balances); err != nil {
 			log.Print(err)
 			return NewError(999 /*Internal Error*/)
 		}
+	}
+
+	return state.result(tx, user)
+}
+
+// matchOFXAccount returns the first of accounts whose ExternalAccountId, or
+// failing that whose OFXAcctID, matches the account ID of the statement
+// imported as importedAccount, or nil if there isn't one
+func matchOFXAccount(accounts *[]*models.Account, importedAccount *models.Account) *models.Account {
+	if len(importedAccount.ExternalAccountId) == 0 {
+		return nil
+	}
+	for _, account := range *accounts {
+		if account.ExternalAccountId == importedAccount.ExternalAccountId {
+			return account
+		}
+	}
+	for _, account := range *accounts {
+		if account.OFXAcctID == importedAccount.ExternalAccountId {
+			return account
+		}
+	}
+	return nil
+}
+
+// ImportBalance is a balance reported by an import for one of its accounts,
+// using the import's placeholder AccountId and SecurityIds. SecurityId is the
+// account's own security for its cash balance, or that of a security held in
+// the account for the number of units of it held.
+type ImportBalance struct {
+	AccountId  int64
+	SecurityId int64
+	Date       time.Time
+	Amount     models.Amount
+}
+
+// importPrices creates the prices reported by an import, after mapping their
+// placeholder SecurityIds to real ones, unless they were created by a previous
+// import
+func (s *importState) importPrices(tx store.Tx, securitymap map[int64]models.Security, prices []models.Price) error {
+	for _, price := range prices {
+		security, ok := securitymap[price.SecurityId]
+		currency, ok2 := securitymap[price.CurrencyId]
+		if !ok || !ok2 {
+			return errors.New("Couldn't find price's SecurityId or CurrencyId in map during import")
+		}
+		price.SecurityId = security.SecurityId
+		price.CurrencyId = currency.SecurityId
+		created, err := CreatePriceIfNotExist(tx, &price)
+		if err != nil {
+			return err
+		} else if created {
+			s.createdPrice(&price)
+		}
+	}
+	return nil
+}
+
+// checkBalances compares the balances an import reported for the account with
+// placeholder importedAccountId to those computed for account as of the end
+// of the day each was reported, recording any which differ. Balances of
+// securities other than the account's own are summed over the sub-accounts of
+// account holding them.
+func (s *importState) checkBalances(tx store.Tx, user *models.User, account *models.Account, importedAccountId int64, securitymap map[int64]models.Security, balances []ImportBalance) error {
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return err
+	}
+
+	for _, balance := range balances {
+		if balance.AccountId != importedAccountId {
+			continue
+		}
+		security, ok := securitymap[balance.SecurityId]
+		if !ok {
+			return errors.New("Couldn't find balance's SecurityId in map during import")
+		}
+
+		year, month, day := balance.Date.Date()
+		end := time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+
+		var computed models.Amount
+		for _, a := range *accounts {
+			if security.SecurityId == account.SecurityId && a.AccountId != account.AccountId {
+				continue
+			} else if security.SecurityId != account.SecurityId && (a.ParentAccountId != account.AccountId || a.SecurityId != security.SecurityId) {
+				continue
+			}
+			amount, err := tx.GetAccountBalanceDate(user, a.AccountId, &end)
+			if err != nil {
+				return err
+			}
+			computed.Add(&computed.Rat, &amount.Rat)
+		}
+
+		if computed.Cmp(&balance.Amount.Rat) != 0 {
+			s.discrepant = append(s.discrepant, &models.BalanceDiscrepancy{
+				AccountId:  account.AccountId,
+				SecurityId: security.SecurityId,
+				Date:       balance.Date,
+				Reported:   balance.Amount,
+				Computed:   computed,
+			})
+		}
+	}
+	return nil
+}
+
+// checkStatementBalance compares the balance a bank statement reported for
+// account before date to the one computed from its transactions, recording it
+// if they differ
+func (s *importState) checkStatementBalance(tx store.Tx, user *models.User, account *models.Account, date time.Time, reported *models.Amount) error {
+	computed, err := tx.GetAccountBalanceDate(user, account.AccountId, &date)
+	if err != nil {
+		return err
+	}
+	if computed.Cmp(&reported.Rat) != 0 {
+		s.discrepant = append(s.discrepant, &models.BalanceDiscrepancy{
+			AccountId:  account.AccountId,
+			SecurityId: account.SecurityId,
+			Date:       date.AddDate(0, 0, -1),
+			Reported:   *reported,
+			Computed:   *computed,
+		})
+	}
+	return nil
+}
+
+// importSecurities finds matching existing securities or creates new ones for
+// those referenced by an import, returning a map from the import's
+// placeholder SecurityIds to the user's actual securities
+func importSecurities(tx store.Tx, user *models.User, securities []models.Security) (map[int64]models.Security, error) {
+	var securitymap = make(map[int64]models.Security)
+	for _, importsecurity := range securities {
+		// save off since ImportGetCreateSecurity overwrites SecurityId on
+		// importsecurity
+		oldsecurityid := importsecurity.SecurityId
+		security, err := ImportGetCreateSecurity(tx, user.UserId, &importsecurity)
+		if err != nil {
+			return nil, err
+		}
 		securitymap[oldsecurityid] = *security
 	}
+	return securitymap, nil
+}
 
-	if account.SecurityId != securitymap[importedAccount.SecurityId].SecurityId {
-		log.Printf("OFX import account's SecurityId (%d) does not match this account's (%d)", securitymap[importedAccount.SecurityId].SecurityId, account.SecurityId)
-		return NewError(3 /*Invalid Request*/)
+// ImportCorrection describes how a transaction in an import corrects one
+// imported previously
+type ImportCorrection struct {
+	RemoteId string // RemoteId of the corrected transaction's splits
+	Delete   bool   // Whether the corrected transaction is voided rather than replaced
+}
+
+// findCorrected returns the existing transaction with a split having remoteid
+// in account or one of its sub-accounts, or nil if there isn't one
+func findCorrected(tx store.Tx, user *models.User, account *models.Account, remoteid string) (*models.Transaction, error) {
+	transactions, err := tx.GetTransactionsByRemoteId(remoteid, user.UserId)
+	if err != nil {
+		return nil, err
 	}
+	for _, t := range *transactions {
+		for _, split := range t.Splits {
+			if split.RemoteId != remoteid || split.AccountId == -1 {
+				continue
+			} else if split.AccountId == account.AccountId {
+				return t, nil
+			}
+			splitAccount, err := tx.GetAccount(split.AccountId, user.UserId)
+			if err != nil {
+				return nil, err
+			}
+			if splitAccount.ParentAccountId == account.AccountId {
+				return t, nil
+			}
+		}
+	}
+	return nil, nil
+}
 
+// voidTransaction zeroes the amounts of all of t's splits and marks them
+// Voided, so it no longer affects any balances but is still recognized as a
+// duplicate if imported again
+func voidTransaction(tx store.Tx, user *models.User, t *models.Transaction) error {
+	for _, split := range t.Splits {
+		split.Status = models.Voided
+		split.Amount = models.Amount{}
+	}
+	return tx.UpdateTransaction(t, user)
+}
+
+// pendingTransaction returns the index of the transaction in transactions with
+// a split having remoteid, or -1 if there isn't one
+func pendingTransaction(transactions []models.Transaction, remoteid string) int {
+	for i, t := range transactions {
+		for _, split := range t.Splits {
+			if split.RemoteId == remoteid {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// importTransactionsHelper inserts transactions parsed from a statement for a
+// single account, after mapping their placeholder AccountIds and SecurityIds
+// to real ones, correcting any imbalances, and skipping those which have
+// already been imported. importedAccountId is the placeholder AccountId used
+// by the parsed transactions for account. corrections maps the indices of any
+// parsed transactions which correct previously-imported ones (or ones earlier
+// in the same import) to how they do so. Only transactions accepted by state
+// are imported. Corrections which would change reconciled splits are rejected
+// rather than applied.
+func importTransactionsHelper(tx store.Tx, user *models.User, account *models.Account, importedAccountId int64, securitymap map[int64]models.Security, importedTransactions []models.Transaction, corrections map[int]ImportCorrection, state *importState) *Error {
 	// TODO Ensure all transactions have at least one split in the account
 	// we're importing to?
 
 	var transactions []models.Transaction
-	for _, transaction := range itl.Transactions {
+	var indices []int
+
+	// dropPending removes the transaction waiting to be inserted which has a
+	// split with remoteid, if any, and returns whether there was one
+	dropPending := func(remoteid string) bool {
+		pending := pendingTransaction(transactions, remoteid)
+		if pending < 0 {
+			return false
+		}
+		transactions = append(transactions[:pending], transactions[pending+1:]...)
+		indices = append(indices[:pending], indices[pending+1:]...)
+		return true
+	}
+
+	for i, transaction := range importedTransactions {
+		index, accepted := state.next()
+		if !accepted {
+			continue
+		}
 		transaction.UserId = user.UserId
 
 		if !transaction.Valid() {
-			log.Print("Unexpected invalid transaction from OFX import")
+			log.Print("Unexpected invalid transaction from import")
 			return NewError(999 /*Internal Error*/)
 		}
 
+		// Deletions void the transaction they correct instead of being
+		// imported themselves
+		correction, corrects := corrections[i]
+		if corrects && correction.Delete {
+			if dropPending(correction.RemoteId) {
+				continue
+			}
+			existing, err := findCorrected(tx, user, account, correction.RemoteId)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			} else if existing != nil && existing.Splits[0].Status != models.Voided {
+				if reconciledSplitsChanged(existing, nil) {
+					state.rejection(index, transaction)
+					continue
+				}
+				if err := state.modifying(tx, user, existing.TransactionId); err != nil {
+					log.Print(err)
+					return NewError(999 /*Internal Error*/)
+				}
+				if err := voidTransaction(tx, user, existing); err != nil {
+					log.Print(err)
+					return NewError(999 /*Internal Error*/)
+				}
+				state.modified(existing)
+				state.correction(index, *existing)
+			}
+			continue
+		}
+
 		// Ensure that either AccountId or SecurityId is set for this split,
 		// and fixup the SecurityId to be a valid one for this user's actual
 		// securities instead of a placeholder from the import
 		for _, split := range transaction.Splits {
 			split.Status = models.Imported
 			if split.AccountId != -1 {
-				if split.AccountId != importedAccount.AccountId {
+				if split.AccountId != importedAccountId {
 					log.Print("Imported split's AccountId wasn't -1 but also didn't match the account")
 					return NewError(999 /*Internal Error*/)
 				}
 				split.AccountId = account.AccountId
 			} else if split.SecurityId != -1 {
 				if sec, ok := securitymap[split.SecurityId]; ok {
-					// TODO try to auto-match splits to existing accounts based on past transactions that look like this one
 					if split.ImportSplitType == models.TradingAccount {
 						// Find/make trading account if we're that type of split
 						trading_account, err := GetTradingAccount(tx, user.UserId, sec.SecurityId)
@@ -130,15 +812,24 @@ func ofxImportHelper(tx store.Tx, r io.Reader, user *models.User, accountid int6
 						split.SecurityId = sec.SecurityId
 					}
 				} else {
-					log.Print("Couldn't find split's SecurityId in map during OFX import")
+					log.Print("Couldn't find split's SecurityId in map during import")
 					return NewError(999 /*Internal Error*/)
 				}
 			} else {
-				log.Print("Neither Split.AccountId Split.SecurityId was set during OFX import")
+				log.Print("Neither Split.AccountId Split.SecurityId was set during import")
 				return NewError(999 /*Internal Error*/)
 			}
 		}
 
+		// Categorize any splits which weren't associated with an account
+		// using the user's rules, and then what's been learned from their
+		// existing transactions
+		if err := state.rules.categorize(tx, user, account, &transaction); err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		state.suggester.categorize(account, &transaction)
+
 		imbalances, err := GetTransactionImbalances(tx, &transaction)
 		if err != nil {
 			log.Print(err)
@@ -200,38 +891,87 @@ func ofxImportHelper(tx store.Tx, r io.Reader, user *models.User, accountid int6
 			}
 		}
 
-		if !already_imported {
-			transactions = append(transactions, transaction)
+		if already_imported {
+			// If this is a replacement which was already imported, then the
+			// transaction it replaced shouldn't be imported again either
+			if corrects {
+				dropPending(correction.RemoteId)
+			}
+			state.duplicate(index, transaction)
+			continue
+		}
+
+		// Replacements take the place of the transaction they correct, if it
+		// has been imported
+		if corrects {
+			if pending := pendingTransaction(transactions, correction.RemoteId); pending >= 0 {
+				transactions[pending] = transaction
+				indices[pending] = index
+				continue
+			}
+			existing, err := findCorrected(tx, user, account, correction.RemoteId)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			} else if existing != nil {
+				if reconciledSplitsChanged(existing, &transaction) {
+					state.rejection(index, transaction)
+					continue
+				}
+				transaction.TransactionId = existing.TransactionId
+				if err := state.modifying(tx, user, existing.TransactionId); err != nil {
+					log.Print(err)
+					return NewError(999 /*Internal Error*/)
+				}
+				if err := tx.UpdateTransaction(&transaction, user); err != nil {
+					log.Print(err)
+					return NewError(999 /*Internal Error*/)
+				}
+				state.modified(&transaction)
+				state.correction(index, transaction)
+				continue
+			}
+		}
+
+		// Merge this transaction into any matching one entered by hand
+		existing, split, err := state.match(tx, user, account, &transaction)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		} else if existing != nil {
+			if err := state.merge(tx, user, index, existing, split, &transaction); err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			continue
 		}
+
+		transactions = append(transactions, transaction)
+		indices = append(indices, index)
 	}
 
-	for _, transaction := range transactions {
+	for i, transaction := range transactions {
 		err := tx.InsertTransaction(&transaction, user)
 		if err != nil {
 			log.Print(err)
 			return NewError(999 /*Internal Error*/)
 		}
+		state.imported(indices[i], transaction)
 	}
 
-	return SuccessWriter{}
+	return nil
 }
 
-func OFXImportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
-	var ofxdownload OFXDownload
-	if err := ReadJSON(r, &ofxdownload); err != nil {
-		return NewError(3 /*Invalid Request*/)
-	}
-
-	account, err := context.Tx.GetAccount(accountid, user.UserId)
-	if err != nil {
-		return NewError(3 /*Invalid Request*/)
-	}
-
+// ofxClientRequest returns a client and a request, with only the signon filled
+// in, for connecting to the financial institution with the OFX connection
+// details of account
+func ofxClientRequest(account *models.Account, password string) (*ofxgo.BasicClient, *ofxgo.Request, *Error) {
 	ofxver := ofxgo.OfxVersion203
 	if len(account.OFXVersion) != 0 {
+		var err error
 		ofxver, err = ofxgo.NewOfxVersion(account.OFXVersion)
 		if err != nil {
-			return NewError(3 /*Invalid Request*/)
+			return nil, nil, NewError(3 /*Invalid Request*/)
 		}
 	}
 
@@ -246,14 +986,34 @@ func OFXImportHandler(context *Context, r *http.Request, user *models.User, acco
 	query.URL = account.OFXURL
 	query.Signon.ClientUID = ofxgo.UID(account.OFXClientUID)
 	query.Signon.UserID = ofxgo.String(account.OFXUser)
-	query.Signon.UserPass = ofxgo.String(ofxdownload.OFXPassword)
+	query.Signon.UserPass = ofxgo.String(password)
 	query.Signon.Org = ofxgo.String(account.OFXORG)
 	query.Signon.Fid = ofxgo.String(account.OFXFID)
 
+	return &client, &query, nil
+}
+
+// ofxStatementRequest builds the request for account's statement from its
+// financial institution, including transactions between start and end (if
+// non-zero)
+func ofxStatementRequest(account *models.Account, password string, start, end time.Time) (*ofxgo.BasicClient, *ofxgo.Request, *Error) {
+	client, query, e := ofxClientRequest(account, password)
+	if e != nil {
+		return nil, nil, e
+	}
+
 	transactionuid, err := ofxgo.RandomUID()
 	if err != nil {
 		log.Println("Error creating uid for transaction:", err)
-		return NewError(999 /*Internal Error*/)
+		return nil, nil, NewError(999 /*Internal Error*/)
+	}
+
+	var dtstart, dtend *ofxgo.Date
+	if !start.IsZero() {
+		dtstart = &ofxgo.Date{Time: start}
+	}
+	if !end.IsZero() {
+		dtend = &ofxgo.Date{Time: end}
 	}
 
 	if account.Type == models.Investment {
@@ -264,6 +1024,8 @@ func OFXImportHandler(context *Context, r *http.Request, user *models.User, acco
 				BrokerID: ofxgo.String(account.OFXBankID),
 				AcctID:   ofxgo.String(account.OFXAcctID),
 			},
+			DtStart:        dtstart,
+			DtEnd:          dtend,
 			Include:        true,
 			IncludeOO:      true,
 			IncludePos:     true,
@@ -279,6 +1041,8 @@ func OFXImportHandler(context *Context, r *http.Request, user *models.User, acco
 			CCAcctFrom: ofxgo.CCAcct{
 				AcctID: ofxgo.String(account.OFXAcctID),
 			},
+			DtStart: dtstart,
+			DtEnd:   dtend,
 			Include: true,
 		}
 		query.CreditCard = append(query.CreditCard, &statementRequest)
@@ -286,7 +1050,7 @@ func OFXImportHandler(context *Context, r *http.Request, user *models.User, acco
 		// Import generic bank transactions
 		acctTypeEnum, err := ofxgo.NewAcctType(account.OFXAcctType)
 		if err != nil {
-			return NewError(3 /*Invalid Request*/)
+			return nil, nil, NewError(3 /*Invalid Request*/)
 		}
 		statementRequest := ofxgo.StatementRequest{
 			TrnUID: *transactionuid,
@@ -295,12 +1059,33 @@ func OFXImportHandler(context *Context, r *http.Request, user *models.User, acco
 				AcctID:   ofxgo.String(account.OFXAcctID),
 				AcctType: acctTypeEnum,
 			},
+			DtStart: dtstart,
+			DtEnd:   dtend,
 			Include: true,
 		}
 		query.Bank = append(query.Bank, &statementRequest)
 	}
 
-	response, err := client.RequestNoParse(&query)
+	return client, query, nil
+}
+
+func OFXImportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
+	var ofxdownload OFXDownload
+	if err := ReadJSON(r, &ofxdownload); err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	account, err := context.Tx.GetAccount(accountid, user.UserId)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	client, query, e := ofxStatementRequest(account, ofxdownload.OFXPassword, ofxdownload.StartDate, ofxdownload.EndDate)
+	if e != nil {
+		return e
+	}
+
+	response, err := client.RequestNoParse(query)
 	if err != nil {
 		// TODO this could be an error talking with the OFX server...
 		log.Print(err)
@@ -308,10 +1093,94 @@ func OFXImportHandler(context *Context, r *http.Request, user *models.User, acco
 	}
 	defer response.Body.Close()
 
-	return ofxImportHelper(context.Tx, response.Body, user, accountid)
+	return ofxImportHelper(context.Tx, response.Body, user, accountid, ofxdownload.ImportOptions)
+}
+
+// UserOFXFileImportHandler imports an OFX file without a particular account
+// to import it into, importing each of its statements into the account which
+// matches it
+func UserOFXFileImportHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method != "POST" {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	return OFXFileImportHandler(context, r, user, -1)
 }
 
 func OFXFileImportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
+	var options models.ImportOptions
+	part, err := importFilePart(r, &options)
+	if err != nil {
+		return err
+	}
+
+	return ofxImportHelper(context.Tx, part, user, accountid, options)
+}
+
+// bankStatementImportHelper imports statements in order, checking that each
+// statement's opening balance matches the account's existing balance before
+// importing its entries, and that its closing balance matches afterwards.
+func bankStatementImportHelper(tx store.Tx, statements []*BankStatement, user *models.User, accountid int64) ResponseWriterWriter {
+	account, err := tx.GetAccount(accountid, user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	state, err := newImportState(tx, user, models.ImportOptions{})
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	for _, statement := range statements {
+		importedAccount := statement.Accounts[0]
+
+		if len(account.ExternalAccountId) > 0 &&
+			account.ExternalAccountId != importedAccount.ExternalAccountId {
+			log.Printf("Bank statement import has \"%s\" as ExternalAccountId, but the account being imported to has\"%s\"",
+				importedAccount.ExternalAccountId,
+				account.ExternalAccountId)
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		securitymap, err := importSecurities(tx, user, statement.Securities)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		if account.SecurityId != securitymap[importedAccount.SecurityId].SecurityId {
+			log.Printf("Bank statement import account's SecurityId (%d) does not match this account's (%d)", securitymap[importedAccount.SecurityId].SecurityId, account.SecurityId)
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if err := state.checkStatementBalance(tx, user, account, statement.OpeningDate, &statement.OpeningBalance); err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		if err := importTransactionsHelper(tx, user, account, importedAccount.AccountId, securitymap, statement.Transactions, nil, state); err != nil {
+			return err
+		}
+
+		if err := state.checkStatementBalance(tx, user, account, statement.ClosingDate, &statement.ClosingBalance); err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+	}
+
+	return state.result(tx, user)
+}
+
+// bankStatementFileImportHandler imports the bank statements in the request's
+// first multipart part, parsed using importStatements
+func bankStatementFileImportHandler(context *Context, r *http.Request, user *models.User, accountid int64, importStatements func(io.Reader) ([]*BankStatement, error)) ResponseWriterWriter {
 	multipartReader, err := r.MultipartReader()
 	if err != nil {
 		return NewError(3 /*Invalid Request*/)
@@ -329,7 +1198,135 @@ func OFXFileImportHandler(context *Context, r *http.Request, user *models.User,
 		}
 	}
 
-	return ofxImportHelper(context.Tx, part, user, accountid)
+	statements, err := importStatements(part)
+	if err != nil {
+		log.Print(err)
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	return bankStatementImportHelper(context.Tx, statements, user, accountid)
+}
+
+func Camt053ImportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
+	return bankStatementFileImportHandler(context, r, user, accountid, ImportCamt053)
+}
+
+func MT940ImportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
+	return bankStatementFileImportHandler(context, r, user, accountid, ImportMT940)
+}
+
+/*
+ * Imports the CSV file in the multipart request's "file" part. If a JSON
+ * models.CSVImportMapping is supplied in a part named "mapping", it is used
+ * and saved for this account, otherwise the mapping previously saved for this
+ * account is used.
+ */
+func CSVImportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
+	account, err := context.Tx.GetAccount(accountid, user.UserId)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	var savedMapping *models.CSVImportMapping
+	exists, err := context.Tx.CSVImportMappingExists(account.AccountId, user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	} else if exists {
+		savedMapping, err = context.Tx.GetCSVImportMapping(account.AccountId, user.UserId)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+	}
+
+	if r.Method == "GET" {
+		if savedMapping == nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		return savedMapping
+	}
+
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	var mapping *models.CSVImportMapping
+	var file []byte
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Print(err)
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		contents, err := ioutil.ReadAll(io.LimitReader(part, 10*1024*1024 /*10Mb*/))
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		if part.FormName() == "mapping" {
+			mapping = NewCSVImportMapping()
+			if err := json.Unmarshal(contents, mapping); err != nil {
+				return NewError(3 /*Invalid Request*/)
+			}
+		} else {
+			file = contents
+		}
+	}
+
+	if file == nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+	if mapping == nil {
+		if savedMapping == nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		mapping = savedMapping
+	}
+
+	security, err := context.Tx.GetSecurity(account.SecurityId, user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	itl, err := ImportCSV(bytes.NewReader(file), mapping, security)
+	if err != nil {
+		log.Print(err)
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	// Save this mapping for next time if it changed
+	mapping.UserId = user.UserId
+	mapping.AccountId = account.AccountId
+	if savedMapping == nil {
+		mapping.CSVImportMappingId = -1
+		err = context.Tx.InsertCSVImportMapping(mapping)
+	} else if mapping != savedMapping {
+		mapping.CSVImportMappingId = savedMapping.CSVImportMappingId
+		err = context.Tx.UpdateCSVImportMapping(mapping)
+	}
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	state, err := newImportState(context.Tx, user, models.ImportOptions{})
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	securitymap := map[int64]models.Security{itl.Securities[0].SecurityId: *security}
+	if err := importTransactionsHelper(context.Tx, user, account, itl.Accounts[0].AccountId, securitymap, itl.Transactions, nil, state); err != nil {
+		return err
+	}
+	return state.result(context.Tx, user)
 }
 
 /*
@@ -338,11 +1335,20 @@ func OFXFileImportHandler(context *Context, r *http.Request, user *models.User,
 func AccountImportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
 
 	importType := context.NextLevel()
+	if r.Method == "GET" && importType != "csv" {
+		return NewError(3 /*Invalid Request*/)
+	}
 	switch importType {
 	case "ofx":
 		return OFXImportHandler(context, r, user, accountid)
 	case "ofxfile":
 		return OFXFileImportHandler(context, r, user, accountid)
+	case "csv":
+		return CSVImportHandler(context, r, user, accountid)
+	case "camt053":
+		return Camt053ImportHandler(context, r, user, accountid)
+	case "mt940":
+		return MT940ImportHandler(context, r, user, accountid)
 	default:
 		return NewError(3 /*Invalid Request*/)
 	}
@@ -350,8 +1356,23 @@ func AccountImportHandler(context *Context, r *http.Request, user *models.User,
 
 func ImportHandler(r *http.Request, context *Context) ResponseWriterWriter {
 	route := context.NextLevel()
-	if route != "gnucash" {
-		return NewError(3 /*Invalid Request*/)
+	switch route {
+	case "gnucash":
+		return GnucashImportHandler(r, context)
+	case "qif":
+		return QIFImportHandler(r, context)
+	case "ledger":
+		return LedgerImportHandler(r, context)
+	case "ofxfile":
+		return UserOFXFileImportHandler(r, context)
+	case "":
+		return ImportBatchesHandler(r, context)
+	default:
+		// Otherwise, this should be the ID of an import batch
+		importbatchid, err := strconv.ParseInt(route, 0, 64)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		return ImportBatchHandler(r, context, importbatchid)
 	}
-	return GnucashImportHandler(r, context)
 }
@@ -0,0 +1,1162 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ledger journals have nowhere to store much of what we know about accounts
+// and securities, so we write it as 'key: value' comments on the lines
+// directly following their 'account' and 'commodity' directives. These are
+// top-level comments, so they are ignored by ledger and hledger.
+
+var ledgerStatusNames = map[int64]string{
+	models.Imported:   "Imported",
+	models.Entered:    "Entered",
+	models.Cleared:    "Cleared",
+	models.Reconciled: "Reconciled",
+	models.Voided:     "Voided",
+}
+
+// ledgerStatusMarks maps split statuses to ledger's cleared (*) and pending
+// (!) marks. All other statuses are unmarked.
+var ledgerStatusMarks = map[int64]string{
+	models.Reconciled: "*",
+	models.Cleared:    "!",
+}
+
+type LedgerImport struct {
+	Securities   []models.Security
+	Accounts     []models.Account
+	Transactions []models.Transaction
+	Prices       []models.Price
+}
+
+// ledgerAccountName returns name with any characters which have special
+// meaning in ledger account names replaced
+func ledgerAccountName(name string) string {
+	name = strings.Replace(name, ":", "-", -1)
+	name = strings.Join(strings.Fields(name), " ")
+	if len(name) == 0 {
+		name = "Unnamed"
+	}
+	if strings.ContainsAny(name[:1], "*!([;#%|") {
+		name = "_" + name
+	}
+	return name
+}
+
+// ledgerCommodity returns symbol formatted for use as a ledger commodity,
+// quoting it if it contains characters not allowed in bare commodities
+func ledgerCommodity(symbol string) string {
+	symbol = strings.Replace(strings.Join(strings.Fields(symbol), " "), "\"", "'", -1)
+	if strings.ContainsAny(symbol, " 0123456789-+.,;:?!*/^&|=<>{}[]()@'~") {
+		return "\"" + symbol + "\""
+	}
+	return symbol
+}
+
+func ledgerOneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// ledgerAmount formats amount with at least the precision of security, so
+// that it is never rounded
+func ledgerAmount(amount *models.Amount, security *models.Security) string {
+	precision := security.Precision
+	if amount.Precision() > precision {
+		precision = amount.Precision()
+	}
+	return amount.FloatString(int(precision))
+}
+
+func ledgerDate(t time.Time) string {
+	t = t.UTC()
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// ExportLedger writes all of user's accounts, securities, prices, and
+// transactions to w as a ledger-cli/hledger journal
+func ExportLedger(tx store.Tx, user *models.User, w io.Writer) error {
+	securities, err := tx.GetSecurities(user.UserId)
+	if err != nil {
+		return err
+	}
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return err
+	}
+	transactions, err := tx.GetTransactions(user.UserId)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	// Assign each security a unique commodity
+	securityMap := make(map[int64]*models.Security)
+	commodities := make(map[int64]string)
+	usedCommodities := make(map[string]bool)
+	sort.Slice(*securities, func(i, j int) bool {
+		return (*securities)[i].SecurityId < (*securities)[j].SecurityId
+	})
+	for _, s := range *securities {
+		symbol := s.Symbol
+		if len(strings.TrimSpace(symbol)) == 0 {
+			symbol = s.Name
+		}
+		commodity := ledgerCommodity(symbol)
+		for n := 2; usedCommodities[commodity]; n++ {
+			commodity = ledgerCommodity(fmt.Sprintf("%s_%d", symbol, n))
+		}
+		usedCommodities[commodity] = true
+		securityMap[s.SecurityId] = s
+		commodities[s.SecurityId] = commodity
+
+		fmt.Fprintf(bw, "commodity %s\n", commodity)
+		if commodity != s.Symbol {
+			fmt.Fprintf(bw, ";   symbol: %s\n", ledgerOneLine(s.Symbol))
+		}
+		fmt.Fprintf(bw, ";   name: %s\n", ledgerOneLine(s.Name))
+		if len(s.Description) > 0 {
+			fmt.Fprintf(bw, ";   description: %s\n", ledgerOneLine(s.Description))
+		}
+		if s.Type == models.Currency {
+			fmt.Fprintf(bw, ";   securitytype: Currency\n")
+		} else {
+			fmt.Fprintf(bw, ";   securitytype: Stock\n")
+		}
+		fmt.Fprintf(bw, ";   precision: %d\n", s.Precision)
+		if len(s.AlternateId) > 0 {
+			fmt.Fprintf(bw, ";   alternateid: %s\n", ledgerOneLine(s.AlternateId))
+		}
+		fmt.Fprintln(bw)
+	}
+
+	// Build each account's full path, making sure they are unique
+	accountMap := make(map[int64]*models.Account)
+	for _, a := range *accounts {
+		accountMap[a.AccountId] = a
+	}
+	paths := make(map[int64]string)
+	usedPaths := make(map[string]bool)
+	var getPath func(a *models.Account, depth int) (string, error)
+	getPath = func(a *models.Account, depth int) (string, error) {
+		if path, ok := paths[a.AccountId]; ok {
+			return path, nil
+		}
+		if depth > len(accountMap) {
+			return "", store.CircularAccountsError{}
+		}
+		prefix := ""
+		if parent, ok := accountMap[a.ParentAccountId]; ok {
+			parentPath, err := getPath(parent, depth+1)
+			if err != nil {
+				return "", err
+			}
+			prefix = parentPath + ":"
+		}
+		name := ledgerAccountName(a.Name)
+		path := prefix + name
+		for n := 2; usedPaths[path]; n++ {
+			path = fmt.Sprintf("%s%s-%d", prefix, name, n)
+		}
+		usedPaths[path] = true
+		paths[a.AccountId] = path
+		return path, nil
+	}
+	sort.Slice(*accounts, func(i, j int) bool {
+		return (*accounts)[i].AccountId < (*accounts)[j].AccountId
+	})
+	for _, a := range *accounts {
+		if _, err := getPath(a, 0); err != nil {
+			return err
+		}
+	}
+	sort.Slice(*accounts, func(i, j int) bool {
+		return paths[(*accounts)[i].AccountId] < paths[(*accounts)[j].AccountId]
+	})
+	for _, a := range *accounts {
+		path := paths[a.AccountId]
+		fmt.Fprintf(bw, "account %s\n", path)
+		if path[strings.LastIndex(path, ":")+1:] != a.Name {
+			fmt.Fprintf(bw, ";   name: %s\n", ledgerOneLine(a.Name))
+		}
+		fmt.Fprintf(bw, ";   type: %s\n", a.Type)
+		fmt.Fprintf(bw, ";   commodity: %s\n", commodities[a.SecurityId])
+		fmt.Fprintln(bw)
+	}
+
+	for _, s := range *securities {
+		prices, err := tx.GetPrices(s.SecurityId)
+		if err != nil {
+			return err
+		}
+		sort.Slice(*prices, func(i, j int) bool {
+			return (*prices)[i].Date.Before((*prices)[j].Date)
+		})
+		for _, p := range *prices {
+			currency, ok := securityMap[p.CurrencyId]
+			if !ok {
+				return fmt.Errorf("Unable to find currency %d for price %d", p.CurrencyId, p.PriceId)
+			}
+			fmt.Fprintf(bw, "P %s %s %s %s\n", ledgerDate(p.Date), commodities[s.SecurityId], ledgerAmount(&p.Value, currency), commodities[currency.SecurityId])
+		}
+		if len(*prices) > 0 {
+			fmt.Fprintln(bw)
+		}
+	}
+
+	sort.Slice(*transactions, func(i, j int) bool {
+		ti, tj := (*transactions)[i], (*transactions)[j]
+		if ti.Date.Equal(tj.Date) {
+			return ti.TransactionId < tj.TransactionId
+		}
+		return ti.Date.Before(tj.Date)
+	})
+	for _, t := range *transactions {
+		// Use the transaction's code for split numbers if they all match
+		code := ""
+		if len(t.Splits) > 0 {
+			code = t.Splits[0].Number
+			for _, s := range t.Splits {
+				if s.Number != code {
+					code = ""
+					break
+				}
+			}
+		}
+
+		header := t.Date.UTC().Format("2006-01-02")
+		if len(code) > 0 {
+			header += " (" + strings.Replace(ledgerOneLine(code), ")", "", -1) + ")"
+		}
+		header += " " + ledgerOneLine(t.Description)
+		fmt.Fprintln(bw, strings.TrimSpace(header))
+		if date := ledgerDate(t.Date); len(date) > len("2006-01-02") {
+			fmt.Fprintf(bw, "    ; datetime: %s\n", t.Date.UTC().Format(time.RFC3339))
+		}
+
+		for _, s := range t.Splits {
+			account, ok := accountMap[s.AccountId]
+			if !ok {
+				return fmt.Errorf("Unable to find account %d for split %d", s.AccountId, s.SplitId)
+			}
+			security := securityMap[account.SecurityId]
+			if security == nil {
+				return fmt.Errorf("Unable to find security %d for account %d", account.SecurityId, account.AccountId)
+			}
+
+			posting := "    "
+			if mark, ok := ledgerStatusMarks[s.Status]; ok {
+				posting += mark + " "
+			}
+			posting += paths[s.AccountId]
+			amount := ledgerAmount(&s.Amount, security) + " " + commodities[security.SecurityId]
+			padding := 60 - len(posting) - len(amount)
+			if padding < 2 {
+				padding = 2
+			}
+			posting += strings.Repeat(" ", padding) + amount
+			if memo := ledgerOneLine(s.Memo); len(memo) > 0 {
+				posting += "  ; " + memo
+			}
+			fmt.Fprintln(bw, posting)
+
+			if _, marked := ledgerStatusMarks[s.Status]; !marked && s.Status != models.Entered {
+				fmt.Fprintf(bw, "        ; status: %s\n", ledgerStatusNames[s.Status])
+			}
+			if len(code) == 0 && len(s.Number) > 0 {
+				fmt.Fprintf(bw, "        ; number: %s\n", ledgerOneLine(s.Number))
+			}
+			if len(s.RemoteId) > 0 {
+				fmt.Fprintf(bw, "        ; remoteid: %s\n", ledgerOneLine(s.RemoteId))
+			}
+		}
+		fmt.Fprintln(bw)
+	}
+
+	return bw.Flush()
+}
+
+func LedgerExportHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportLedger(context.Tx, user, &buf); err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return FileWriter{
+		ContentType: "text/plain; charset=utf-8",
+		Filename:    "moneygo.journal",
+		Data:        buf.Bytes(),
+	}
+}
+
+type ledgerPosting struct {
+	status        string
+	account       string
+	commodity     string
+	amount        *big.Rat // nil if elided
+	costCommodity string
+	cost          *big.Rat // total cost, if any
+	memo          string
+	tags          map[string]string
+}
+
+type ledgerTransaction struct {
+	date        time.Time
+	status      string
+	code        string
+	description string
+	tags        map[string]string
+	postings    []*ledgerPosting
+	remoteid    string
+	line        int
+}
+
+type ledgerPrice struct {
+	date      time.Time
+	commodity string
+	value     *big.Rat
+	currency  string
+}
+
+type ledgerImporter struct {
+	LedgerImport
+
+	currency models.Security
+
+	accountTags   map[string]map[string]string
+	commodityTags map[string]map[string]string
+	prices        []ledgerPrice
+	transactions  []*ledgerTransaction
+
+	securities        map[string]int64  // commodity -> security ID
+	precisions        map[string]uint64 // commodity -> most precise amount seen
+	accounts          map[string]int64  // account path -> account ID
+	accountSecurities map[string]int64  // account path -> security ID of first posting
+	commodityAccounts map[string]int64  // account/security IDs -> sub-account ID
+}
+
+var ledgerDateRegexp = regexp.MustCompile(`^(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})$`)
+
+func parseLedgerDate(s string) (time.Time, error) {
+	// Ignore any auxiliary date
+	if i := strings.Index(s, "="); i >= 0 {
+		s = s[:i]
+	}
+	m := ledgerDateRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("Unable to parse ledger date \"%s\"", s)
+	}
+	year, _ := strconv.Atoi(m[1])
+	month, _ := strconv.Atoi(m[2])
+	day, _ := strconv.Atoi(m[3])
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), nil
+}
+
+var ledgerAmountRegexp = regexp.MustCompile(`^([-+]?)\s*("[^"]*"|[^-+\d\s.,"]+)?\s*([-+]?)\s*(\d[\d,]*(?:\.\d*)?|\.\d+)\s*("[^"]*"|[^-+\d\s.,"]+)?$`)
+
+// parseLedgerAmount parses amounts such as "-5.60 USD", "$-5.60", "-$5.60",
+// and "10 \"VANGUARD 500\""
+func parseLedgerAmount(s string) (*big.Rat, string, error) {
+	s = strings.TrimSpace(s)
+	m := ledgerAmountRegexp.FindStringSubmatch(s)
+	if m == nil || (len(m[2]) > 0 && len(m[5]) > 0) {
+		return nil, "", fmt.Errorf("Unable to parse ledger amount \"%s\"", s)
+	}
+	amount, ok := new(big.Rat).SetString(strings.Replace(m[4], ",", "", -1))
+	if !ok {
+		return nil, "", fmt.Errorf("Unable to parse ledger amount \"%s\"", s)
+	}
+	if (m[1] == "-") != (m[3] == "-") {
+		amount.Neg(amount)
+	}
+	commodity := m[2] + m[5]
+	commodity = strings.TrimSuffix(strings.TrimPrefix(commodity, "\""), "\"")
+	return amount, commodity, nil
+}
+
+// ledgerTag parses comments of the form 'key: value'
+func ledgerTag(comment string) (string, string, bool) {
+	comment = strings.TrimSpace(comment)
+	i := strings.Index(comment, ":")
+	if i <= 0 || strings.ContainsAny(comment[:i], " \t") {
+		return "", "", false
+	}
+	return strings.ToLower(comment[:i]), strings.TrimSpace(comment[i+1:]), true
+}
+
+// splitLedgerComment splits a line into the content before any comment and
+// the comment itself
+func splitLedgerComment(line string) (string, string) {
+	inQuote := false
+	for i, r := range line {
+		if r == '"' {
+			inQuote = !inQuote
+		} else if r == ';' && !inQuote {
+			return line[:i], strings.TrimSpace(line[i+1:])
+		}
+	}
+	return line, ""
+}
+
+func (i *ledgerImporter) parsePosting(line string) (*ledgerPosting, error) {
+	content, comment := splitLedgerComment(strings.TrimSpace(line))
+	p := ledgerPosting{memo: comment, tags: make(map[string]string)}
+
+	if len(content) > 0 && (content[0] == '*' || content[0] == '!') {
+		p.status = content[:1]
+		content = strings.TrimSpace(content[1:])
+	}
+
+	// The account name ends at a tab or two consecutive spaces
+	end := len(content)
+	if idx := strings.Index(content, "\t"); idx >= 0 && idx < end {
+		end = idx
+	}
+	if idx := strings.Index(content, "  "); idx >= 0 && idx < end {
+		end = idx
+	}
+	p.account = strings.TrimSpace(content[:end])
+	amount := strings.TrimSpace(content[end:])
+
+	if strings.HasPrefix(p.account, "(") && strings.HasSuffix(p.account, ")") {
+		// Virtual postings needn't balance, and have no equivalent
+		return nil, nil
+	}
+	p.account = strings.TrimSuffix(strings.TrimPrefix(p.account, "["), "]")
+	if len(p.account) == 0 {
+		return nil, errors.New("Ledger posting is missing its account")
+	}
+
+	// Ignore balance assertions
+	if idx := strings.Index(amount, "="); idx >= 0 {
+		amount = strings.TrimSpace(amount[:idx])
+	}
+	if len(amount) == 0 {
+		return &p, nil
+	}
+
+	var cost string
+	var totalCost bool
+	if idx := strings.Index(amount, "@@"); idx >= 0 {
+		cost = amount[idx+2:]
+		amount = amount[:idx]
+		totalCost = true
+	} else if idx := strings.Index(amount, "@"); idx >= 0 {
+		cost = amount[idx+1:]
+		amount = amount[:idx]
+	}
+	// Ignore lot prices and dates
+	if idx := strings.IndexAny(amount, "{["); idx >= 0 {
+		amount = amount[:idx]
+	}
+
+	var err error
+	p.amount, p.commodity, err = parseLedgerAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	if len(cost) > 0 {
+		p.cost, p.costCommodity, err = parseLedgerAmount(cost)
+		if err != nil {
+			return nil, err
+		}
+		p.cost.Abs(p.cost)
+		if !totalCost {
+			p.cost.Mul(p.cost, new(big.Rat).Abs(p.amount))
+		}
+		if p.amount.Sign() < 0 {
+			p.cost.Neg(p.cost)
+		}
+	}
+	return &p, nil
+}
+
+func (i *ledgerImporter) parseTransactionHeader(line string, lineno int) (*ledgerTransaction, error) {
+	content, _ := splitLedgerComment(line)
+	t := ledgerTransaction{tags: make(map[string]string), line: lineno}
+
+	fields := strings.SplitN(strings.TrimSpace(content), " ", 2)
+	date, err := parseLedgerDate(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	t.date = date
+
+	rest := ""
+	if len(fields) > 1 {
+		rest = strings.TrimSpace(fields[1])
+	}
+	if len(rest) > 0 && (rest[0] == '*' || rest[0] == '!') {
+		t.status = rest[:1]
+		rest = strings.TrimSpace(rest[1:])
+	}
+	if strings.HasPrefix(rest, "(") {
+		if end := strings.Index(rest, ")"); end > 0 {
+			t.code = rest[1:end]
+			rest = strings.TrimSpace(rest[end+1:])
+		}
+	}
+	t.description = rest
+	return &t, nil
+}
+
+func (i *ledgerImporter) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var transaction *ledgerTransaction
+	var lines []string
+	var directiveTags map[string]string
+	var lastPosting *ledgerPosting
+	seen := make(map[string]int)
+
+	finishTransaction := func() {
+		if transaction != nil {
+			text := strings.Join(lines, "\n")
+			seen[text]++
+			hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x1e%d", text, seen[text])))
+			transaction.remoteid = fmt.Sprintf("ledger:%x", hash)
+			i.transactions = append(i.transactions, transaction)
+		}
+		transaction = nil
+		lastPosting = nil
+		lines = nil
+	}
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if lineno == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if len(strings.TrimSpace(line)) == 0 {
+			finishTransaction()
+			directiveTags = nil
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			trimmed := strings.TrimSpace(line)
+			if transaction != nil {
+				lines = append(lines, trimmed)
+				if trimmed[0] == ';' {
+					if key, value, ok := ledgerTag(trimmed[1:]); ok {
+						if lastPosting != nil {
+							lastPosting.tags[key] = value
+						} else {
+							transaction.tags[key] = value
+						}
+					}
+					continue
+				}
+				p, err := i.parsePosting(trimmed)
+				if err != nil {
+					return fmt.Errorf("Ledger line %d: %s", lineno, err)
+				}
+				if p != nil {
+					transaction.postings = append(transaction.postings, p)
+				}
+				lastPosting = p
+			} else if directiveTags != nil && strings.HasPrefix(trimmed, "note ") {
+				directiveTags["description"] = strings.TrimSpace(trimmed[len("note "):])
+			}
+			// Other directives' sub-directives are ignored
+			continue
+		}
+
+		finishTransaction()
+		switch line[0] {
+		case ';', '#', '%', '|', '*':
+			if directiveTags != nil && line[0] == ';' {
+				if key, value, ok := ledgerTag(line[1:]); ok {
+					directiveTags[key] = value
+				}
+			}
+			continue
+		}
+		directiveTags = nil
+
+		content, _ := splitLedgerComment(line)
+		fields := strings.Fields(content)
+		switch {
+		case line[0] >= '0' && line[0] <= '9':
+			t, err := i.parseTransactionHeader(line, lineno)
+			if err != nil {
+				return fmt.Errorf("Ledger line %d: %s", lineno, err)
+			}
+			transaction = t
+			lines = append(lines, strings.TrimSpace(line))
+		case fields[0] == "account" && len(fields) > 1:
+			name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(content), "account"))
+			if _, ok := i.accountTags[name]; !ok {
+				i.accountTags[name] = make(map[string]string)
+			}
+			directiveTags = i.accountTags[name]
+		case fields[0] == "commodity" && len(fields) > 1:
+			commodity := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(content), "commodity"))
+			if !strings.HasPrefix(commodity, "\"") && strings.ContainsAny(commodity, "0123456789") {
+				// A format such as "1,000.00 USD", rather than just a
+				// commodity
+				_, c, err := parseLedgerAmount(commodity)
+				if err != nil {
+					return fmt.Errorf("Ledger line %d: %s", lineno, err)
+				}
+				commodity = c
+			}
+			commodity = strings.TrimSuffix(strings.TrimPrefix(commodity, "\""), "\"")
+			if _, ok := i.commodityTags[commodity]; !ok {
+				i.commodityTags[commodity] = make(map[string]string)
+			}
+			directiveTags = i.commodityTags[commodity]
+		case fields[0] == "P" && len(fields) >= 4:
+			p, err := i.parsePrice(fields[1:])
+			if err != nil {
+				return fmt.Errorf("Ledger line %d: %s", lineno, err)
+			}
+			i.prices = append(i.prices, *p)
+		}
+		// Anything else (include, alias, year, automated or periodic
+		// transactions, etc.) is not supported and ignored
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	finishTransaction()
+	return nil
+}
+
+// parsePrice parses the fields of a price directive following the 'P'
+func (i *ledgerImporter) parsePrice(fields []string) (*ledgerPrice, error) {
+	var p ledgerPrice
+	var err error
+	p.date, err = parseLedgerDate(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	fields = fields[1:]
+	if t, err := time.Parse("15:04:05", fields[0]); err == nil && len(fields) >= 3 {
+		p.date = p.date.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second)
+		fields = fields[1:]
+	}
+
+	rest := strings.Join(fields, " ")
+	if strings.HasPrefix(rest, "\"") {
+		end := strings.Index(rest[1:], "\"")
+		if end < 0 {
+			return nil, errors.New("Unterminated ledger commodity")
+		}
+		p.commodity = rest[1 : end+1]
+		rest = rest[end+2:]
+	} else {
+		p.commodity = fields[0]
+		rest = strings.Join(fields[1:], " ")
+	}
+	p.value, p.currency, err = parseLedgerAmount(rest)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// getSecurity returns the placeholder ID for commodity, creating a security
+// for it if necessary from its commodity directive's tags or, failing that,
+// any matching currency template
+func (i *ledgerImporter) getSecurity(commodity string) (int64, error) {
+	if id, ok := i.securities[commodity]; ok {
+		return id, nil
+	}
+	if len(commodity) == 0 {
+		return i.currency.SecurityId, nil
+	}
+
+	var s models.Security
+	tags := i.commodityTags[commodity]
+	if name, ok := tags["name"]; ok {
+		s.Name = name
+		s.Symbol = commodity
+		if symbol, ok := tags["symbol"]; ok {
+			s.Symbol = symbol
+		}
+		s.Description = tags["description"]
+		s.AlternateId = tags["alternateid"]
+		s.Type = models.GetSecurityType(tags["securitytype"])
+		if s.Type == 0 {
+			s.Type = models.Stock
+		}
+		precision, err := strconv.ParseUint(tags["precision"], 10, 64)
+		if err != nil || precision > models.MaxPrecision {
+			return 0, fmt.Errorf("Invalid precision for ledger commodity \"%s\"", commodity)
+		}
+		s.Precision = precision
+	} else {
+		templateName := commodity
+		if commodity == "$" {
+			templateName = "USD"
+		}
+		if template := FindSecurityTemplate(templateName, models.Currency); template != nil {
+			s = *template
+			s.Symbol = commodity
+		} else {
+			s = models.Security{
+				Name:        commodity,
+				Description: tags["description"],
+				Symbol:      commodity,
+				Type:        models.Stock,
+				Precision:   i.precisions[commodity],
+			}
+		}
+	}
+
+	s.SecurityId = int64(len(i.Securities) + 1)
+	i.Securities = append(i.Securities, s)
+	i.securities[commodity] = s.SecurityId
+	return s.SecurityId, nil
+}
+
+// ledgerAccountTypes maps names of moneygo account types, hledger account
+// types, and common top-level account names to our account types
+var ledgerAccountTypes = map[string]models.AccountType{
+	"a":           models.Asset,
+	"asset":       models.Asset,
+	"assets":      models.Asset,
+	"l":           models.Liability,
+	"liability":   models.Liability,
+	"liabilities": models.Liability,
+	"e":           models.Equity,
+	"equity":      models.Equity,
+	"r":           models.Income,
+	"revenue":     models.Income,
+	"revenues":    models.Income,
+	"income":      models.Income,
+	"x":           models.Expense,
+	"expense":     models.Expense,
+	"expenses":    models.Expense,
+	"c":           models.Cash,
+	"cash":        models.Cash,
+	"v":           models.Trading,
+	"conversion":  models.Trading,
+	"trading":     models.Trading,
+}
+
+func ledgerAccountType(name string) models.AccountType {
+	for _, t := range models.AccountTypes {
+		if strings.EqualFold(t.String(), name) {
+			return t
+		}
+	}
+	return ledgerAccountTypes[strings.ToLower(name)]
+}
+
+// getAccount returns the placeholder ID for the account with the given path,
+// creating it and its parents if necessary
+func (i *ledgerImporter) getAccount(path string) (int64, error) {
+	if id, ok := i.accounts[path]; ok {
+		return id, nil
+	}
+
+	var parentid int64 = -1
+	var parentType models.AccountType
+	name := path
+	if idx := strings.LastIndex(path, ":"); idx >= 0 {
+		var err error
+		parentid, err = i.getAccount(path[:idx])
+		if err != nil {
+			return 0, err
+		}
+		parentType = i.Accounts[parentid-1].Type
+		name = path[idx+1:]
+	}
+
+	tags := i.accountTags[path]
+	a := models.Account{
+		Name:            strings.TrimSpace(name),
+		ParentAccountId: parentid,
+		SecurityId:      i.currency.SecurityId,
+		Type:            ledgerAccountType(tags["type"]),
+	}
+	if n, ok := tags["name"]; ok {
+		a.Name = n
+	}
+	if a.Type == 0 {
+		a.Type = parentType
+	}
+	if a.Type == 0 {
+		a.Type = ledgerAccountType(a.Name)
+	}
+	if a.Type == 0 {
+		a.Type = models.Asset
+	}
+	if commodity, ok := tags["commodity"]; ok {
+		id, err := i.getSecurity(strings.TrimSuffix(strings.TrimPrefix(commodity, "\""), "\""))
+		if err != nil {
+			return 0, err
+		}
+		a.SecurityId = id
+	} else if id, ok := i.accountSecurities[path]; ok {
+		a.SecurityId = id
+	}
+
+	a.AccountId = int64(len(i.Accounts) + 1)
+	i.Accounts = append(i.Accounts, a)
+	i.accounts[path] = a.AccountId
+	return a.AccountId, nil
+}
+
+// getPostingAccount returns the account for postings to path in the security
+// with the given ID. Accounts can only hold one security, so postings in other
+// securities go to sub-accounts, much like investment accounts imported from
+// OFX.
+func (i *ledgerImporter) getPostingAccount(path string, securityid int64) (int64, error) {
+	id, err := i.getAccount(path)
+	if err != nil {
+		return 0, err
+	}
+	account := i.Accounts[id-1]
+	if account.SecurityId == securityid {
+		return id, nil
+	}
+
+	key := fmt.Sprintf("%d\x1f%d", id, securityid)
+	if subid, ok := i.commodityAccounts[key]; ok {
+		return subid, nil
+	}
+	security := i.Securities[securityid-1]
+	sub := models.Account{
+		AccountId:       int64(len(i.Accounts) + 1),
+		Name:            security.Symbol,
+		ParentAccountId: id,
+		SecurityId:      securityid,
+		Type:            account.Type,
+	}
+	i.Accounts = append(i.Accounts, sub)
+	i.commodityAccounts[key] = sub.AccountId
+	return sub.AccountId, nil
+}
+
+func ledgerStatus(mark string, tags map[string]string) int64 {
+	switch mark {
+	case "*":
+		return models.Reconciled
+	case "!":
+		return models.Cleared
+	}
+	for status, name := range ledgerStatusNames {
+		if strings.EqualFold(tags["status"], name) {
+			return status
+		}
+	}
+	return models.Entered
+}
+
+func (i *ledgerImporter) build() error {
+	// Find the most precise amount in each commodity, for those without
+	// commodity directives specifying their precision
+	for _, t := range i.transactions {
+		for _, p := range t.postings {
+			if p.amount != nil {
+				amount := models.Amount{Rat: *p.amount}
+				if amount.Precision() > i.precisions[p.commodity] {
+					i.precisions[p.commodity] = amount.Precision()
+				}
+			}
+		}
+	}
+
+	for _, lt := range i.transactions {
+		if err := i.inferAmounts(lt); err != nil {
+			return fmt.Errorf("Ledger transaction on line %d: %s", lt.line, err)
+		}
+	}
+
+	// Each account's security defaults to that of its first posting
+	for _, t := range i.transactions {
+		for _, p := range t.postings {
+			if _, ok := i.accountSecurities[p.account]; ok || p.amount == nil {
+				continue
+			}
+			id, err := i.getSecurity(p.commodity)
+			if err != nil {
+				return err
+			}
+			i.accountSecurities[p.account] = id
+		}
+	}
+
+	paths := make([]string, 0, len(i.accountTags))
+	for path := range i.accountTags {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if _, err := i.getAccount(path); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range i.prices {
+		securityid, err := i.getSecurity(p.commodity)
+		if err != nil {
+			return err
+		}
+		currencyid, err := i.getSecurity(p.currency)
+		if err != nil {
+			return err
+		}
+		var price models.Price
+		price.PriceId = int64(len(i.Prices) + 1)
+		price.SecurityId = securityid
+		price.CurrencyId = currencyid
+		price.Date = p.date
+		price.Value.Rat = *p.value
+		price.RemoteId = fmt.Sprintf("ledger:%s:%s:%s", p.commodity, p.currency, p.date.Format(time.RFC3339))
+		i.Prices = append(i.Prices, price)
+	}
+
+	for _, lt := range i.transactions {
+		if err := i.buildTransaction(lt); err != nil {
+			return fmt.Errorf("Ledger transaction on line %d: %s", lt.line, err)
+		}
+	}
+	return nil
+}
+
+// inferAmounts fills in the amount of any posting without one, using costs
+// where supplied, the same way ledger does
+func (i *ledgerImporter) inferAmounts(lt *ledgerTransaction) error {
+	var elided *ledgerPosting
+	costSums := make(map[string]*big.Rat)
+	var commodities []string
+	add := func(sums map[string]*big.Rat, commodity string, amount *big.Rat) {
+		if _, ok := sums[commodity]; !ok {
+			sums[commodity] = new(big.Rat)
+			commodities = append(commodities, commodity)
+		}
+		sums[commodity].Add(sums[commodity], amount)
+	}
+	for _, p := range lt.postings {
+		if p.amount == nil {
+			if elided != nil {
+				return errors.New("Only one posting per transaction may omit its amount")
+			}
+			elided = p
+		} else if p.cost != nil {
+			add(costSums, p.costCommodity, p.cost)
+		} else {
+			add(costSums, p.commodity, p.amount)
+		}
+	}
+	if elided != nil {
+		var postings []*ledgerPosting
+		for _, p := range lt.postings {
+			if p != elided {
+				postings = append(postings, p)
+			}
+		}
+		for _, commodity := range commodities {
+			if costSums[commodity].Sign() == 0 {
+				continue
+			}
+			p := *elided
+			p.commodity = commodity
+			p.amount = new(big.Rat).Neg(costSums[commodity])
+			postings = append(postings, &p)
+		}
+		lt.postings = postings
+	}
+	return nil
+}
+
+func (i *ledgerImporter) buildTransaction(lt *ledgerTransaction) error {
+	var t models.Transaction
+	t.Date = lt.date
+	if datetime, ok := lt.tags["datetime"]; ok {
+		d, err := time.Parse(time.RFC3339, datetime)
+		if err != nil {
+			return err
+		}
+		t.Date = d.UTC()
+	}
+	t.Description = lt.description
+
+	// We balance transactions involving more than one security using
+	// trading accounts rather than costs
+	postings := lt.postings
+	sums := make(map[string]*big.Rat)
+	var commodities []string
+	for _, p := range postings {
+		if _, ok := sums[p.commodity]; !ok {
+			sums[p.commodity] = new(big.Rat)
+			commodities = append(commodities, p.commodity)
+		}
+		sums[p.commodity].Add(sums[p.commodity], p.amount)
+	}
+	var unbalanced []string
+	for _, commodity := range commodities {
+		if sums[commodity].Sign() != 0 {
+			unbalanced = append(unbalanced, commodity)
+		}
+	}
+	if len(unbalanced) == 1 {
+		return errors.New("Transaction doesn't balance")
+	}
+	for _, commodity := range unbalanced {
+		securityid, err := i.getSecurity(commodity)
+		if err != nil {
+			return err
+		}
+		tradingid, err := i.getAccount("Trading")
+		if err != nil {
+			return err
+		}
+		if i.Accounts[tradingid-1].Type != models.Trading {
+			return errors.New("Unable to balance transaction because 'Trading' account isn't a trading account")
+		}
+		path := "Trading:" + ledgerAccountName(i.Securities[securityid-1].Name)
+		if _, ok := i.accountSecurities[path]; !ok {
+			i.accountSecurities[path] = securityid
+		}
+		postings = append(postings, &ledgerPosting{
+			account:   path,
+			commodity: commodity,
+			amount:    new(big.Rat).Neg(sums[commodity]),
+			tags:      map[string]string{"status": "Imported"},
+		})
+	}
+
+	for _, p := range postings {
+		securityid, err := i.getSecurity(p.commodity)
+		if err != nil {
+			return err
+		}
+		accountid, err := i.getPostingAccount(p.account, securityid)
+		if err != nil {
+			return err
+		}
+
+		s := models.Split{
+			Status:     ledgerStatus(p.status, p.tags),
+			AccountId:  accountid,
+			SecurityId: -1,
+			RemoteId:   lt.remoteid,
+			Number:     lt.code,
+			Memo:       p.memo,
+		}
+		if len(p.status) == 0 && len(p.tags["status"]) == 0 {
+			s.Status = ledgerStatus(lt.status, p.tags)
+		}
+		if remoteid, ok := p.tags["remoteid"]; ok {
+			s.RemoteId = remoteid
+		}
+		if number, ok := p.tags["number"]; ok {
+			s.Number = number
+		}
+		s.Amount.Rat = *p.amount
+
+		security := &i.Securities[securityid-1]
+		if s.Amount.Precision() > security.Precision {
+			return fmt.Errorf("Amount %s is more precise than commodity \"%s\" allows", s.Amount.String(), p.commodity)
+		}
+		t.Splits = append(t.Splits, &s)
+	}
+
+	i.Transactions = append(i.Transactions, t)
+	return nil
+}
+
+// ImportLedger parses the ledger-cli/hledger journal in r, such as those
+// written by ExportLedger. Amounts without a commodity are assumed to be in
+// currency.
+func ImportLedger(r io.Reader, currency *models.Security) (*LedgerImport, error) {
+	i := ledgerImporter{
+		accountTags:       make(map[string]map[string]string),
+		commodityTags:     make(map[string]map[string]string),
+		securities:        make(map[string]int64),
+		precisions:        make(map[string]uint64),
+		accounts:          make(map[string]int64),
+		accountSecurities: make(map[string]int64),
+		commodityAccounts: make(map[string]int64),
+	}
+	i.currency = *currency
+	i.currency.SecurityId = 1
+	i.Securities = append(i.Securities, i.currency)
+
+	if err := i.parse(r); err != nil {
+		return nil, err
+	}
+	if err := i.build(); err != nil {
+		return nil, err
+	}
+	return &i.LedgerImport, nil
+}
+
+func LedgerImportHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method != "POST" {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	currency, err := context.Tx.GetSecurity(user.DefaultCurrency, user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	// Assume there is only one 'part' and it's the one we care about
+	part, err := multipartReader.NextPart()
+	if err != nil {
+		if err == io.EOF {
+			return NewError(3 /*Invalid Request*/)
+		} else {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+	}
+
+	ledgerImport, err := ImportLedger(part, currency)
+	if err != nil {
+		log.Print(err)
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	state, err := newImportState(context.Tx, user, models.ImportOptions{})
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return importBookHelper(context.Tx, user, state, ledgerImport.Securities, ledgerImport.Prices, ledgerImport.Accounts, ledgerImport.Transactions)
+}
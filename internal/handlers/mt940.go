@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type mt940Field struct {
+	tag   string
+	value string
+}
+
+var mt940FieldRE = regexp.MustCompile(`^:([0-9]{2}[A-Z]?):(.*)$`)
+
+// Balances (fields 60 and 62) are of the form 'C171130EUR1234,56'
+var mt940BalanceRE = regexp.MustCompile(`^([CD])([0-9]{6})([A-Z]{3})([0-9]+,[0-9]*)$`)
+
+// Statement lines (field 61) are of the form
+// 'YYMMDD[MMDD](C|D|RC|RD)[funds code]amount(type code)customer ref[//bank ref]'
+var mt940EntryRE = regexp.MustCompile(`^([0-9]{6})([0-9]{4})?(RC|RD|C|D)([A-Z])?([0-9]+,[0-9]*)([NFS][A-Z0-9]{3})(.*)$`)
+
+// Structured information to account owner (field 86) in the German format
+// consists of subfields of the form '?NN'
+var mt940SubfieldRE = regexp.MustCompile(`\?([0-9]{2})`)
+
+// readMT940Fields splits an MT940 file into its fields, discarding any SWIFT
+// message headers and trailers
+func readMT940Fields(r io.Reader) ([]mt940Field, error) {
+	var fields []mt940Field
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "{") {
+			index := strings.Index(line, "{4:")
+			if index < 0 {
+				continue
+			}
+			line = line[index+len("{4:"):]
+		}
+		if line == "-}" || line == "-" {
+			continue
+		}
+		if m := mt940FieldRE.FindStringSubmatch(line); m != nil {
+			fields = append(fields, mt940Field{tag: m[1], value: m[2]})
+		} else if len(fields) > 0 && len(strings.TrimSpace(line)) > 0 {
+			fields[len(fields)-1].value += "\n" + line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func parseMT940Date(s string) (time.Time, error) {
+	return time.Parse("060102", s)
+}
+
+func parseMT940Amount(s string) (*big.Rat, error) {
+	s = strings.Replace(s, ",", ".", 1)
+	s = strings.TrimSuffix(s, ".")
+	amount, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("Unable to parse MT940 amount: %s", s)
+	}
+	return amount, nil
+}
+
+// parseMT940Balance returns the amount, currency, and date of a balance field
+func parseMT940Balance(value string) (*big.Rat, string, time.Time, error) {
+	m := mt940BalanceRE.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return nil, "", time.Time{}, fmt.Errorf("Unable to parse MT940 balance: %s", value)
+	}
+	date, err := parseMT940Date(m[2])
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	amount, err := parseMT940Amount(m[4])
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	if m[1] == "D" {
+		amount.Neg(amount)
+	}
+	return amount, m[3], date, nil
+}
+
+// parseMT940Entry parses a statement line (field 61)
+func parseMT940Entry(value string) (*bankStatementEntry, error) {
+	lines := strings.SplitN(value, "\n", 2)
+	m := mt940EntryRE.FindStringSubmatch(strings.TrimSpace(lines[0]))
+	if m == nil {
+		return nil, fmt.Errorf("Unable to parse MT940 statement line: %s", lines[0])
+	}
+
+	var entry bankStatementEntry
+	valueDate, err := parseMT940Date(m[1])
+	if err != nil {
+		return nil, err
+	}
+	entry.Date = valueDate
+	if len(m[2]) > 0 {
+		// The entry (booking) date doesn't include a year, so use the one
+		// closest to the value date
+		entryDate, err := time.Parse("0102", m[2])
+		if err != nil {
+			return nil, err
+		}
+		entry.Date = time.Date(valueDate.Year(), entryDate.Month(), entryDate.Day(), 0, 0, 0, 0, time.UTC)
+		if entry.Date.Sub(valueDate) > 180*24*time.Hour {
+			entry.Date = entry.Date.AddDate(-1, 0, 0)
+		} else if valueDate.Sub(entry.Date) > 180*24*time.Hour {
+			entry.Date = entry.Date.AddDate(1, 0, 0)
+		}
+	}
+
+	entry.Amount, err = parseMT940Amount(m[5])
+	if err != nil {
+		return nil, err
+	}
+	// Reversals of credits are debits, and reversals of debits credits
+	if m[3] == "D" || m[3] == "RC" {
+		entry.Amount.Neg(entry.Amount)
+	}
+
+	references := strings.SplitN(m[7], "//", 2)
+	if customer := strings.TrimSpace(references[0]); customer != "NONREF" {
+		entry.Number = customer
+	}
+	if len(references) > 1 {
+		entry.Reference = strings.TrimSpace(references[1])
+	}
+	if len(lines) > 1 {
+		entry.Memo = bankStatementText(lines[1])
+	}
+	return &entry, nil
+}
+
+// parseMT940Information fills in entry's description and memo from its
+// information to account owner (field 86). This is free text, but the
+// German and SEPA subfield formats are recognized.
+func parseMT940Information(entry *bankStatementEntry, value string) {
+	joined := strings.Replace(value, "\n", "", -1)
+	if indexes := mt940SubfieldRE.FindAllStringSubmatchIndex(joined, -1); len(indexes) > 0 {
+		var name, purpose []string
+		for n, index := range indexes {
+			end := len(joined)
+			if n+1 < len(indexes) {
+				end = indexes[n+1][0]
+			}
+			subfield := joined[index[2]:index[3]]
+			content := joined[index[1]:end]
+			switch {
+			case subfield == "32" || subfield == "33":
+				name = append(name, content)
+			case (subfield >= "20" && subfield <= "29") || (subfield >= "60" && subfield <= "63"):
+				purpose = append(purpose, content)
+			}
+		}
+		entry.Description = bankStatementText(strings.Join(name, ""))
+		if len(purpose) > 0 {
+			entry.Memo = bankStatementText(strings.Join(purpose, ""))
+		}
+		return
+	}
+
+	if strings.HasPrefix(joined, "/") {
+		parts := strings.Split(joined, "/")
+		for n := 1; n+1 < len(parts); n += 2 {
+			switch parts[n] {
+			case "NAME":
+				entry.Description = bankStatementText(parts[n+1])
+			case "REMI":
+				entry.Memo = bankStatementText(parts[n+1])
+			}
+		}
+		if len(entry.Description) > 0 || len(entry.Memo) > 0 {
+			return
+		}
+	}
+
+	entry.Description = bankStatementText(value)
+}
+
+// ImportMT940 parses the statements in a SWIFT MT940 file. Entries without a
+// bank reference are given one based on their contents.
+func ImportMT940(r io.Reader) ([]*BankStatement, error) {
+	fields, err := readMT940Fields(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []*BankStatement
+	var statement *BankStatement
+	var entries []*bankStatementEntry
+	var entryFields []string
+	var accountId, currency string
+	var opening *big.Rat
+	var openingDate time.Time
+	for n, field := range fields {
+		switch field.tag {
+		case "20":
+			statement, entries, entryFields = nil, nil, nil
+		case "25":
+			accountId = strings.TrimSpace(field.value)
+		case "60F", "60M":
+			opening, currency, openingDate, err = parseMT940Balance(field.value)
+			if err != nil {
+				return nil, err
+			}
+			statement, err = newBankStatement(accountId, currency)
+			if err != nil {
+				return nil, err
+			}
+			// The opening balance is as of the end of the date
+			openingDate = openingDate.AddDate(0, 0, 1)
+		case "61":
+			if statement == nil {
+				return nil, errors.New("MT940 statement line found before opening balance")
+			}
+			entry, err := parseMT940Entry(field.value)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+			entryFields = append(entryFields, field.value)
+		case "86":
+			if n > 0 && fields[n-1].tag == "61" {
+				parseMT940Information(entries[len(entries)-1], field.value)
+				entryFields[len(entryFields)-1] += "\x1e" + field.value
+			}
+		case "62F", "62M":
+			if statement == nil {
+				return nil, errors.New("MT940 closing balance found before opening balance")
+			}
+			closing, closingCurrency, closingDate, err := parseMT940Balance(field.value)
+			if err != nil {
+				return nil, err
+			}
+			if closingCurrency != currency {
+				return nil, fmt.Errorf("MT940 closing balance in %s for statement in %s", closingCurrency, currency)
+			}
+
+			// Entries may legitimately appear more than once, so include the
+			// number of times we've seen each in generated references
+			seen := make(map[string]int)
+			for i, entry := range entries {
+				if len(entry.Reference) == 0 {
+					seen[entryFields[i]]++
+					hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x1e%s\x1e%d", accountId, entryFields[i], seen[entryFields[i]])))
+					entry.Reference = fmt.Sprintf("%x", hash)
+				}
+				if err := statement.AddEntry(entry, "mt940:"); err != nil {
+					return nil, err
+				}
+			}
+			statement.SetBalances(opening, openingDate, closing, closingDate.AddDate(0, 0, 1))
+			statements = append(statements, statement)
+			statement, entries, entryFields = nil, nil, nil
+		}
+	}
+	if statement != nil {
+		return nil, errors.New("MT940 statement is missing its closing balance")
+	}
+	if len(statements) == 0 {
+		return nil, errors.New("No statements found in MT940 file")
+	}
+	return statements, nil
+}
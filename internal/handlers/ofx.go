@@ -7,13 +7,64 @@ import (
 	"github.com/aclindsa/ofxgo"
 	"io"
 	"math/big"
+	"time"
 )
 
 type OFXImport struct {
-	Securities   []models.Security
-	Accounts     []models.Account
-	Transactions []models.Transaction
-	//	Balances     map[int64]string // map AccountIDs to ending balances
+	Securities          []models.Security
+	Accounts            []models.Account // one for each statement, in the order they were imported
+	Transactions        []models.Transaction
+	TransactionAccounts []int64                  // the AccountId of the statement each of Transactions is from
+	Corrections         map[int]ImportCorrection // map indices into Transactions to the corrections they make
+	Prices              []models.Price           // prices of securities held, from positions
+	Balances            []ImportBalance          // balances reported for the imported accounts
+}
+
+// addTransaction adds t, from the statement for account
+func (i *OFXImport) addTransaction(t *models.Transaction, account *models.Account) {
+	i.Transactions = append(i.Transactions, *t)
+	i.TransactionAccounts = append(i.TransactionAccounts, account.AccountId)
+}
+
+// accountTransactions returns the transactions from the statement for the
+// account with accountid, and the corrections they make, indexed by their
+// position in the returned transactions
+func (i *OFXImport) accountTransactions(accountid int64) ([]models.Transaction, map[int]ImportCorrection) {
+	var transactions []models.Transaction
+	var corrections map[int]ImportCorrection
+	for idx, t := range i.Transactions {
+		if i.TransactionAccounts[idx] != accountid {
+			continue
+		}
+		if correction, ok := i.Corrections[idx]; ok {
+			if corrections == nil {
+				corrections = make(map[int]ImportCorrection)
+			}
+			corrections[len(transactions)] = correction
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, corrections
+}
+
+// addBalance records that account was reported to hold amount of the
+// security with securityid at the end of the day of date
+func (i *OFXImport) addBalance(account *models.Account, securityid int64, date time.Time, amount *big.Rat) {
+	i.Balances = append(i.Balances, ImportBalance{
+		AccountId:  account.AccountId,
+		SecurityId: securityid,
+		Date:       date.UTC(),
+		Amount:     models.Amount{*amount},
+	})
+}
+
+// correct records that the next transaction added corrects the one
+// previously imported with remoteid
+func (i *OFXImport) correct(remoteid string, delete bool) {
+	if i.Corrections == nil {
+		i.Corrections = make(map[int]ImportCorrection)
+	}
+	i.Corrections[len(i.Transactions)] = ImportCorrection{RemoteId: remoteid, Delete: delete}
 }
 
 func (i *OFXImport) GetSecurity(ofxsecurityid int64) (*models.Security, error) {
@@ -92,7 +143,6 @@ func (i *OFXImport) AddTransaction(tran *ofxgo.Transaction, account *models.Acco
 	}
 
 	s1.RemoteId = "ofx:" + tran.FiTID.String()
-	// TODO CorrectFiTID/CorrectAction?
 
 	s1.ImportSplitType = models.ImportAccount
 	s2.ImportSplitType = models.ExternalAccount
@@ -114,7 +164,10 @@ func (i *OFXImport) AddTransaction(tran *ofxgo.Transaction, account *models.Acco
 
 	t.Splits = append(t.Splits, &s1)
 	t.Splits = append(t.Splits, &s2)
-	i.Transactions = append(i.Transactions, t)
+	if len(tran.CorrectFiTID) > 0 {
+		i.correct("ofx:"+tran.CorrectFiTID.String(), tran.CorrectAction == ofxgo.CorrectActionDelete)
+	}
+	i.addTransaction(&t, account)
 
 	return nil
 }
@@ -130,7 +183,11 @@ func (i *OFXImport) importOFXBank(stmt *ofxgo.StatementResponse) error {
 		ExternalAccountId: stmt.BankAcctFrom.AcctID.String(),
 		SecurityId:        security.SecurityId,
 		ParentAccountId:   -1,
+		Name:              stmt.BankAcctFrom.AcctID.String(),
 		Type:              models.Bank,
+		OFXBankID:         stmt.BankAcctFrom.BankID.String(),
+		OFXAcctID:         stmt.BankAcctFrom.AcctID.String(),
+		OFXAcctType:       stmt.BankAcctFrom.AcctType.String(),
 	}
 
 	if stmt.BankTranList != nil {
@@ -141,6 +198,7 @@ func (i *OFXImport) importOFXBank(stmt *ofxgo.StatementResponse) error {
 		}
 	}
 
+	i.addBalance(&account, security.SecurityId, stmt.DtAsOf.Time, &stmt.BalAmt.Rat)
 	i.Accounts = append(i.Accounts, account)
 
 	return nil
@@ -157,7 +215,10 @@ func (i *OFXImport) importOFXCC(stmt *ofxgo.CCStatementResponse) error {
 		ExternalAccountId: stmt.CCAcctFrom.AcctID.String(),
 		SecurityId:        security.SecurityId,
 		ParentAccountId:   -1,
+		Name:              stmt.CCAcctFrom.AcctID.String(),
 		Type:              models.Liability,
+		OFXAcctID:         stmt.CCAcctFrom.AcctID.String(),
+		OFXAcctType:       "CC",
 	}
 	i.Accounts = append(i.Accounts, account)
 
@@ -169,7 +230,7 @@ func (i *OFXImport) importOFXCC(stmt *ofxgo.CCStatementResponse) error {
 		}
 	}
 
-	// TODO balance(s)
+	i.addBalance(&account, security.SecurityId, stmt.DtAsOf.Time, &stmt.BalAmt.Rat)
 
 	return nil
 }
@@ -258,7 +319,6 @@ func (i *OFXImport) GetInvBuyTran(buy *ofxgo.InvBuy, curdef *models.Security, ac
 
 	if num := commission.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Commission,
 			AccountId:       -1,
@@ -270,7 +330,6 @@ func (i *OFXImport) GetInvBuyTran(buy *ofxgo.InvBuy, curdef *models.Security, ac
 	}
 	if num := taxes.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Taxes,
 			AccountId:       -1,
@@ -282,7 +341,6 @@ func (i *OFXImport) GetInvBuyTran(buy *ofxgo.InvBuy, curdef *models.Security, ac
 	}
 	if num := fees.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Fees,
 			AccountId:       -1,
@@ -294,7 +352,6 @@ func (i *OFXImport) GetInvBuyTran(buy *ofxgo.InvBuy, curdef *models.Security, ac
 	}
 	if num := load.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Load,
 			AccountId:       -1,
@@ -305,7 +362,6 @@ func (i *OFXImport) GetInvBuyTran(buy *ofxgo.InvBuy, curdef *models.Security, ac
 		})
 	}
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ImportAccount,
 		AccountId:       account.AccountId,
@@ -315,7 +371,6 @@ func (i *OFXImport) GetInvBuyTran(buy *ofxgo.InvBuy, curdef *models.Security, ac
 		Amount:          models.Amount{total},
 	})
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.TradingAccount,
 		AccountId:       -1,
@@ -328,7 +383,6 @@ func (i *OFXImport) GetInvBuyTran(buy *ofxgo.InvBuy, curdef *models.Security, ac
 	var units big.Rat
 	units.Abs(&buy.Units.Rat)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.SubAccount,
 		AccountId:       -1,
@@ -339,7 +393,6 @@ func (i *OFXImport) GetInvBuyTran(buy *ofxgo.InvBuy, curdef *models.Security, ac
 	})
 	units.Neg(&units)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.TradingAccount,
 		AccountId:       -1,
@@ -374,7 +427,6 @@ func (i *OFXImport) GetIncomeTran(income *ofxgo.Income, curdef *models.Security,
 	}
 
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ImportAccount,
 		AccountId:       account.AccountId,
@@ -385,7 +437,6 @@ func (i *OFXImport) GetIncomeTran(income *ofxgo.Income, curdef *models.Security,
 	})
 	total.Neg(&total)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.IncomeAccount,
 		AccountId:       -1,
@@ -419,7 +470,6 @@ func (i *OFXImport) GetInvExpenseTran(expense *ofxgo.InvExpense, curdef *models.
 	}
 
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ImportAccount,
 		AccountId:       account.AccountId,
@@ -430,7 +480,6 @@ func (i *OFXImport) GetInvExpenseTran(expense *ofxgo.InvExpense, curdef *models.
 	})
 	total.Neg(&total)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ExpenseAccount,
 		AccountId:       -1,
@@ -458,7 +507,6 @@ func (i *OFXImport) GetMarginInterestTran(marginint *ofxgo.MarginInterest, curde
 	}
 
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ImportAccount,
 		AccountId:       account.AccountId,
@@ -469,7 +517,6 @@ func (i *OFXImport) GetMarginInterestTran(marginint *ofxgo.MarginInterest, curde
 	})
 	total.Neg(&total)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.IncomeAccount,
 		AccountId:       -1,
@@ -522,7 +569,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 
 	if num := commission.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Commission,
 			AccountId:       -1,
@@ -534,7 +580,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 	}
 	if num := taxes.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Taxes,
 			AccountId:       -1,
@@ -546,7 +591,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 	}
 	if num := fees.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Fees,
 			AccountId:       -1,
@@ -558,7 +602,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 	}
 	if num := load.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Load,
 			AccountId:       -1,
@@ -569,7 +612,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 		})
 	}
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ImportAccount,
 		AccountId:       account.AccountId,
@@ -580,7 +622,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 	})
 
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.IncomeAccount,
 		AccountId:       -1,
@@ -591,7 +632,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 	})
 	total.Neg(&total)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ImportAccount,
 		AccountId:       account.AccountId,
@@ -601,7 +641,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 		Amount:          models.Amount{total},
 	})
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.TradingAccount,
 		AccountId:       -1,
@@ -614,7 +653,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 	var units big.Rat
 	units.Abs(&reinvest.Units.Rat)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.SubAccount,
 		AccountId:       -1,
@@ -625,7 +663,6 @@ func (i *OFXImport) GetReinvestTran(reinvest *ofxgo.Reinvest, curdef *models.Sec
 	})
 	units.Neg(&units)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.TradingAccount,
 		AccountId:       -1,
@@ -659,7 +696,6 @@ func (i *OFXImport) GetRetOfCapTran(retofcap *ofxgo.RetOfCap, curdef *models.Sec
 	}
 
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ImportAccount,
 		AccountId:       account.AccountId,
@@ -670,7 +706,6 @@ func (i *OFXImport) GetRetOfCapTran(retofcap *ofxgo.RetOfCap, curdef *models.Sec
 	})
 	total.Neg(&total)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.IncomeAccount,
 		AccountId:       -1,
@@ -726,7 +761,6 @@ func (i *OFXImport) GetInvSellTran(sell *ofxgo.InvSell, curdef *models.Security,
 
 	if num := commission.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Commission,
 			AccountId:       -1,
@@ -738,7 +772,6 @@ func (i *OFXImport) GetInvSellTran(sell *ofxgo.InvSell, curdef *models.Security,
 	}
 	if num := taxes.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Taxes,
 			AccountId:       -1,
@@ -750,7 +783,6 @@ func (i *OFXImport) GetInvSellTran(sell *ofxgo.InvSell, curdef *models.Security,
 	}
 	if num := fees.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Fees,
 			AccountId:       -1,
@@ -762,7 +794,6 @@ func (i *OFXImport) GetInvSellTran(sell *ofxgo.InvSell, curdef *models.Security,
 	}
 	if num := load.Num(); !num.IsInt64() || num.Int64() != 0 {
 		t.Splits = append(t.Splits, &models.Split{
-			// TODO ReversalFiTID?
 			Status:          models.Imported,
 			ImportSplitType: models.Load,
 			AccountId:       -1,
@@ -773,7 +804,6 @@ func (i *OFXImport) GetInvSellTran(sell *ofxgo.InvSell, curdef *models.Security,
 		})
 	}
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ImportAccount,
 		AccountId:       account.AccountId,
@@ -783,7 +813,6 @@ func (i *OFXImport) GetInvSellTran(sell *ofxgo.InvSell, curdef *models.Security,
 		Amount:          models.Amount{total},
 	})
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.TradingAccount,
 		AccountId:       -1,
@@ -796,7 +825,6 @@ func (i *OFXImport) GetInvSellTran(sell *ofxgo.InvSell, curdef *models.Security,
 	var units big.Rat
 	units.Abs(&sell.Units.Rat)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.TradingAccount,
 		AccountId:       -1,
@@ -807,7 +835,6 @@ func (i *OFXImport) GetInvSellTran(sell *ofxgo.InvSell, curdef *models.Security,
 	})
 	units.Neg(&units)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.SubAccount,
 		AccountId:       -1,
@@ -838,7 +865,6 @@ func (i *OFXImport) GetTransferTran(transfer *ofxgo.Transfer, account *models.Ac
 	}
 
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.SubAccount,
 		AccountId:       -1,
@@ -849,7 +875,6 @@ func (i *OFXImport) GetTransferTran(transfer *ofxgo.Transfer, account *models.Ac
 	})
 	units.Neg(&units)
 	t.Splits = append(t.Splits, &models.Split{
-		// TODO ReversalFiTID?
 		Status:          models.Imported,
 		ImportSplitType: models.ExternalAccount,
 		AccountId:       -1,
@@ -862,53 +887,285 @@ func (i *OFXImport) GetTransferTran(transfer *ofxgo.Transfer, account *models.Ac
 	return &t, nil
 }
 
+// GetSplitTran imports a stock split, adjusting the number of shares held
+func (i *OFXImport) GetSplitTran(split *ofxgo.Split, curdef *models.Security, account *models.Account) (*models.Transaction, error) {
+	t := i.GetInvTran(&split.InvTran)
+
+	security, err := i.GetSecurityAlternateId(string(split.SecID.UniqueID), models.Stock)
+	if err != nil {
+		return nil, err
+	}
+
+	memo := string(split.InvTran.Memo)
+	if len(memo) == 0 {
+		memo = fmt.Sprintf("%d:%d split of %s", split.Numerator, split.Denominator, security.Symbol)
+	}
+
+	// Prefer the number of shares after the split as reported, falling back
+	// to applying the split ratio to the number before it
+	var units big.Rat
+	if split.NewUnits.Sign() != 0 {
+		units.Set(&split.NewUnits.Rat)
+	} else if split.Denominator != 0 {
+		units.Mul(&split.OldUnits.Rat, big.NewRat(int64(split.Numerator), int64(split.Denominator)))
+	} else {
+		return nil, errors.New("OFX stock split has neither NewUnits nor a valid ratio")
+	}
+	units.Sub(&units, &split.OldUnits.Rat)
+
+	t.Splits = append(t.Splits, &models.Split{
+		Status:          models.Imported,
+		ImportSplitType: models.SubAccount,
+		AccountId:       -1,
+		SecurityId:      security.SecurityId,
+		RemoteId:        "ofx:" + split.InvTran.FiTID.String(),
+		Memo:            memo,
+		Amount:          models.Amount{units},
+	})
+	units.Neg(&units)
+	t.Splits = append(t.Splits, &models.Split{
+		Status:          models.Imported,
+		ImportSplitType: models.TradingAccount,
+		AccountId:       -1,
+		SecurityId:      security.SecurityId,
+		RemoteId:        "ofx:" + split.InvTran.FiTID.String(),
+		Memo:            memo,
+		Amount:          models.Amount{units},
+	})
+
+	// Any cash paid in lieu of fractional shares was for selling them
+	var fracCash big.Rat
+	fracCash.Set(&split.FracCash.Rat)
+	if ok, _ := split.Currency.Valid(); ok {
+		fracCash.Mul(&fracCash, &split.Currency.CurRate.Rat)
+	}
+	if num := fracCash.Num(); !num.IsInt64() || num.Int64() != 0 {
+		t.Splits = append(t.Splits, &models.Split{
+			Status:          models.Imported,
+			ImportSplitType: models.ImportAccount,
+			AccountId:       account.AccountId,
+			SecurityId:      -1,
+			RemoteId:        "ofx:" + split.InvTran.FiTID.String(),
+			Memo:            memo + " (cash in lieu)",
+			Amount:          models.Amount{fracCash},
+		})
+		fracCash.Neg(&fracCash)
+		t.Splits = append(t.Splits, &models.Split{
+			Status:          models.Imported,
+			ImportSplitType: models.TradingAccount,
+			AccountId:       -1,
+			SecurityId:      curdef.SecurityId,
+			RemoteId:        "ofx:" + split.InvTran.FiTID.String(),
+			Memo:            memo + " (cash in lieu)",
+			Amount:          models.Amount{fracCash},
+		})
+	}
+
+	return &t, nil
+}
+
+// GetJrnlFundTran imports a journal of cash between sub-accounts of an
+// investment account. Since we don't keep track of cash in margin, short,
+// etc. sub-accounts separately, this moves cash out of and back into the same
+// account.
+func (i *OFXImport) GetJrnlFundTran(jrnl *ofxgo.JrnlFund, account *models.Account) (*models.Transaction, error) {
+	t := i.GetInvTran(&jrnl.InvTran)
+
+	memo := string(jrnl.InvTran.Memo)
+	if len(memo) > 0 {
+		memo += " "
+	}
+
+	var total big.Rat
+	total.Abs(&jrnl.Total.Rat)
+	total.Neg(&total)
+	t.Splits = append(t.Splits, &models.Split{
+		Status:          models.Imported,
+		ImportSplitType: models.ImportAccount,
+		AccountId:       account.AccountId,
+		SecurityId:      -1,
+		RemoteId:        "ofx:" + jrnl.InvTran.FiTID.String(),
+		Memo:            memo + "(from " + jrnl.SubAcctFrom.String() + ")",
+		Amount:          models.Amount{total},
+	})
+	total.Neg(&total)
+	t.Splits = append(t.Splits, &models.Split{
+		Status:          models.Imported,
+		ImportSplitType: models.ImportAccount,
+		AccountId:       account.AccountId,
+		SecurityId:      -1,
+		RemoteId:        "ofx:" + jrnl.InvTran.FiTID.String(),
+		Memo:            memo + "(to " + jrnl.SubAcctTo.String() + ")",
+		Amount:          models.Amount{total},
+	})
+
+	return &t, nil
+}
+
+// GetJrnlSecTran imports a journal of securities between sub-accounts of an
+// investment account. Like GetJrnlFundTran, the security is moved out of and
+// back into the same account.
+func (i *OFXImport) GetJrnlSecTran(jrnl *ofxgo.JrnlSec, account *models.Account) (*models.Transaction, error) {
+	t := i.GetInvTran(&jrnl.InvTran)
+
+	security, err := i.GetSecurityAlternateId(string(jrnl.SecID.UniqueID), models.Stock)
+	if err != nil {
+		return nil, err
+	}
+
+	memo := string(jrnl.InvTran.Memo)
+	if len(memo) > 0 {
+		memo += " "
+	}
+
+	var units big.Rat
+	units.Abs(&jrnl.Units.Rat)
+	units.Neg(&units)
+	t.Splits = append(t.Splits, &models.Split{
+		Status:          models.Imported,
+		ImportSplitType: models.SubAccount,
+		AccountId:       -1,
+		SecurityId:      security.SecurityId,
+		RemoteId:        "ofx:" + jrnl.InvTran.FiTID.String(),
+		Memo:            memo + "(from " + jrnl.SubAcctFrom.String() + ")",
+		Amount:          models.Amount{units},
+	})
+	units.Neg(&units)
+	t.Splits = append(t.Splits, &models.Split{
+		Status:          models.Imported,
+		ImportSplitType: models.SubAccount,
+		AccountId:       -1,
+		SecurityId:      security.SecurityId,
+		RemoteId:        "ofx:" + jrnl.InvTran.FiTID.String(),
+		Memo:            memo + "(to " + jrnl.SubAcctTo.String() + ")",
+		Amount:          models.Amount{units},
+	})
+
+	return &t, nil
+}
+
+// GetClosureOptTran imports the closing of an option position by exercise,
+// assignment, or expiry. Any resulting purchase or sale of the underlying
+// security is reported as a separate transaction.
+func (i *OFXImport) GetClosureOptTran(closure *ofxgo.ClosureOpt, account *models.Account) (*models.Transaction, error) {
+	t := i.GetInvTran(&closure.InvTran)
+
+	security, err := i.GetSecurityAlternateId(string(closure.SecID.UniqueID), models.Stock)
+	if err != nil {
+		return nil, err
+	}
+
+	memo := string(closure.InvTran.Memo)
+	if len(memo) == 0 {
+		switch closure.OptAction {
+		case ofxgo.OptActionExercise:
+			memo = "Exercise of " + security.Symbol
+		case ofxgo.OptActionAssign:
+			memo = "Assignment of " + security.Symbol
+		case ofxgo.OptActionExpire:
+			memo = "Expiration of " + security.Symbol
+		}
+	}
+
+	// Options which were written, rather than bought, are held short, so
+	// closing them increases the number held
+	var units big.Rat
+	units.Abs(&closure.Units.Rat)
+	if closure.OptAction != ofxgo.OptActionAssign && closure.SubAcctSec != ofxgo.SubAcctTypeShort {
+		units.Neg(&units)
+	}
+
+	t.Splits = append(t.Splits, &models.Split{
+		Status:          models.Imported,
+		ImportSplitType: models.SubAccount,
+		AccountId:       -1,
+		SecurityId:      security.SecurityId,
+		RemoteId:        "ofx:" + closure.InvTran.FiTID.String(),
+		Memo:            memo,
+		Amount:          models.Amount{units},
+	})
+	units.Neg(&units)
+	t.Splits = append(t.Splits, &models.Split{
+		Status:          models.Imported,
+		ImportSplitType: models.TradingAccount,
+		AccountId:       -1,
+		SecurityId:      security.SecurityId,
+		RemoteId:        "ofx:" + closure.InvTran.FiTID.String(),
+		Memo:            memo,
+		Amount:          models.Amount{units},
+	})
+
+	return &t, nil
+}
+
 func (i *OFXImport) AddInvTransaction(invtran *ofxgo.InvTransaction, account *models.Account, curdef *models.Security) error {
 	if curdef.SecurityId < 1 || curdef.SecurityId > int64(len(i.Securities)) {
 		return errors.New("Internal error: security index not found in OFX import\n")
 	}
 
 	var t *models.Transaction
+	var reversal ofxgo.String
 	var err error
 	if tran, ok := (*invtran).(ofxgo.BuyDebt); ok {
 		t, err = i.GetInvBuyTran(&tran.InvBuy, curdef, account)
+		reversal = tran.InvBuy.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.BuyMF); ok {
 		t, err = i.GetInvBuyTran(&tran.InvBuy, curdef, account)
+		reversal = tran.InvBuy.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.BuyOpt); ok {
 		t, err = i.GetInvBuyTran(&tran.InvBuy, curdef, account)
+		reversal = tran.InvBuy.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.BuyOther); ok {
 		t, err = i.GetInvBuyTran(&tran.InvBuy, curdef, account)
+		reversal = tran.InvBuy.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.BuyStock); ok {
 		t, err = i.GetInvBuyTran(&tran.InvBuy, curdef, account)
-		//	} else if tran, ok := (*invtran).(ofxgo.ClosureOpt); ok {
-		// TODO implementme
+		reversal = tran.InvBuy.InvTran.ReversalFiTID
+	} else if tran, ok := (*invtran).(ofxgo.ClosureOpt); ok {
+		t, err = i.GetClosureOptTran(&tran, account)
+		reversal = tran.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.Income); ok {
 		t, err = i.GetIncomeTran(&tran, curdef, account)
+		reversal = tran.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.InvExpense); ok {
 		t, err = i.GetInvExpenseTran(&tran, curdef, account)
-		//	} else if tran, ok := (*invtran).(ofxgo.JrnlFund); ok {
-		// TODO implementme
-		//	} else if tran, ok := (*invtran).(ofxgo.JrnlSec); ok {
-		// TODO implementme
+		reversal = tran.InvTran.ReversalFiTID
+	} else if tran, ok := (*invtran).(ofxgo.JrnlFund); ok {
+		t, err = i.GetJrnlFundTran(&tran, account)
+		reversal = tran.InvTran.ReversalFiTID
+	} else if tran, ok := (*invtran).(ofxgo.JrnlSec); ok {
+		t, err = i.GetJrnlSecTran(&tran, account)
+		reversal = tran.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.MarginInterest); ok {
 		t, err = i.GetMarginInterestTran(&tran, curdef, account)
+		reversal = tran.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.Reinvest); ok {
 		t, err = i.GetReinvestTran(&tran, curdef, account)
+		reversal = tran.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.RetOfCap); ok {
 		t, err = i.GetRetOfCapTran(&tran, curdef, account)
+		reversal = tran.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.SellDebt); ok {
 		t, err = i.GetInvSellTran(&tran.InvSell, curdef, account)
+		reversal = tran.InvSell.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.SellMF); ok {
 		t, err = i.GetInvSellTran(&tran.InvSell, curdef, account)
+		reversal = tran.InvSell.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.SellOpt); ok {
 		t, err = i.GetInvSellTran(&tran.InvSell, curdef, account)
+		reversal = tran.InvSell.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.SellOther); ok {
 		t, err = i.GetInvSellTran(&tran.InvSell, curdef, account)
+		reversal = tran.InvSell.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.SellStock); ok {
 		t, err = i.GetInvSellTran(&tran.InvSell, curdef, account)
-		//	} else if tran, ok := (*invtran).(ofxgo.Split); ok {
-		// TODO implementme
+		reversal = tran.InvSell.InvTran.ReversalFiTID
+	} else if tran, ok := (*invtran).(ofxgo.Split); ok {
+		t, err = i.GetSplitTran(&tran, curdef, account)
+		reversal = tran.InvTran.ReversalFiTID
 	} else if tran, ok := (*invtran).(ofxgo.Transfer); ok {
 		t, err = i.GetTransferTran(&tran, account)
+		reversal = tran.InvTran.ReversalFiTID
 	} else {
 		return errors.New("Unrecognized type satisfying ofxgo.InvTransaction interface: " + (*invtran).TransactionType())
 
@@ -918,7 +1175,12 @@ func (i *OFXImport) AddInvTransaction(invtran *ofxgo.InvTransaction, account *mo
 		return err
 	}
 
-	i.Transactions = append(i.Transactions, *t)
+	// Reversals undo the transactions they refer to, so are imported as
+	// deletions of them
+	if len(reversal) > 0 {
+		i.correct("ofx:"+reversal.String(), true)
+	}
+	i.addTransaction(t, account)
 
 	return nil
 }
@@ -934,7 +1196,10 @@ func (i *OFXImport) importOFXInv(stmt *ofxgo.InvStatementResponse) error {
 		ExternalAccountId: stmt.InvAcctFrom.AcctID.String(),
 		SecurityId:        security.SecurityId,
 		ParentAccountId:   -1,
+		Name:              stmt.InvAcctFrom.AcctID.String(),
 		Type:              models.Investment,
+		OFXBankID:         stmt.InvAcctFrom.BrokerID.String(),
+		OFXAcctID:         stmt.InvAcctFrom.AcctID.String(),
 	}
 	i.Accounts = append(i.Accounts, account)
 
@@ -955,17 +1220,129 @@ func (i *OFXImport) importOFXInv(stmt *ofxgo.InvStatementResponse) error {
 		}
 	}
 
-	// TODO InvPosList
-	// TODO InvBal
-	// TODO Inv401K and INV401kBal???
+	for _, position := range stmt.InvPosList {
+		if err := i.addPosition(position, &account, security); err != nil {
+			return err
+		}
+	}
+
+	// Check the cash balance, using the 401(k) balance for 401(k) accounts
+	// which don't report it separately
+	if stmt.InvBal != nil {
+		i.addBalance(&account, security.SecurityId, stmt.DtAsOf.Time, &stmt.InvBal.AvailCash.Rat)
+	} else if stmt.Inv401KBal != nil {
+		i.addBalance(&account, security.SecurityId, stmt.DtAsOf.Time, &stmt.Inv401KBal.CashBal.Rat)
+	}
 
 	return nil
 }
 
+// addPosition records the number of units of a security held in an
+// investment account, and the security's price, from a position reported
+// for it
+func (i *OFXImport) addPosition(position ofxgo.Position, account *models.Account, curdef *models.Security) error {
+	var pos *ofxgo.InvPosition
+	switch p := position.(type) {
+	case ofxgo.DebtPosition:
+		pos = &p.InvPos
+	case ofxgo.MFPosition:
+		pos = &p.InvPos
+	case ofxgo.OptPosition:
+		pos = &p.InvPos
+	case ofxgo.OtherPosition:
+		pos = &p.InvPos
+	case ofxgo.StockPosition:
+		pos = &p.InvPos
+	default:
+		return errors.New("Unrecognized type satisfying ofxgo.Position interface: " + position.PositionType())
+	}
+
+	security, err := i.GetSecurityAlternateId(string(pos.SecID.UniqueID), models.Stock)
+	if err != nil {
+		return err
+	}
+
+	var units big.Rat
+	units.Abs(&pos.Units.Rat)
+	if pos.PosType == ofxgo.PosTypeShort {
+		units.Neg(&units)
+	}
+	i.addBalance(account, security.SecurityId, pos.DtPriceAsOf.Time, &units)
+
+	var price big.Rat
+	price.Set(&pos.UnitPrice.Rat)
+	if pos.Currency != nil {
+		if ok, _ := pos.Currency.Valid(); ok {
+			price.Mul(&price, &pos.Currency.CurRate.Rat)
+		}
+	}
+	if price.Sign() > 0 {
+		date := pos.DtPriceAsOf.UTC()
+		i.Prices = append(i.Prices, models.Price{
+			SecurityId: security.SecurityId,
+			CurrencyId: curdef.SecurityId,
+			Date:       date,
+			Value:      models.Amount{price},
+			RemoteId:   "ofx:" + security.AlternateId + "@" + date.Format(time.RFC3339),
+		})
+	}
+	return nil
+}
+
+// bankTransactionLists returns the lists of banking transactions from all the
+// bank and credit card statements in response
+func bankTransactionLists(response *ofxgo.Response) []*ofxgo.TransactionList {
+	var lists []*ofxgo.TransactionList
+	for _, bank := range response.Bank {
+		if stmt, ok := bank.(*ofxgo.StatementResponse); ok && stmt.BankTranList != nil {
+			lists = append(lists, stmt.BankTranList)
+		}
+	}
+	for _, cc := range response.CreditCard {
+		if stmt, ok := cc.(*ofxgo.CCStatementResponse); ok && stmt.BankTranList != nil {
+			lists = append(lists, stmt.BankTranList)
+		}
+	}
+	return lists
+}
+
+// parseOFXResponse parses and validates an OFX response like
+// ofxgo.ParseResponse does. ofxgo's validation rejects transactions whose
+// CorrectAction is valid rather than those whose CorrectAction is invalid, so
+// it is done with the CorrectActions of corrections cleared and they are
+// checked separately.
+func parseOFXResponse(r io.Reader) (*ofxgo.Response, error) {
+	response, err := ofxgo.DecodeResponse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var restore []func()
+	for _, list := range bankTransactionLists(response) {
+		for idx := range list.Transactions {
+			tran := &list.Transactions[idx]
+			if len(tran.CorrectFiTID) == 0 {
+				continue
+			} else if !tran.CorrectAction.Valid() {
+				return nil, errors.New("Transaction.CorrectFiTID nonempty but CorrectAction invalid")
+			}
+			action := tran.CorrectAction
+			restore = append(restore, func() { tran.CorrectAction = action })
+			tran.CorrectAction = 0
+		}
+	}
+
+	_, err = response.Valid()
+	for _, f := range restore {
+		f()
+	}
+	return response, err
+}
+
 func ImportOFX(r io.Reader) (*OFXImport, error) {
 	var i OFXImport
 
-	response, err := ofxgo.ParseResponse(r)
+	response, err := parseOFXResponse(r)
 	if err != nil {
 		return nil, fmt.Errorf("Unexpected error parsing OFX response: %s\n", err)
 	}
@@ -981,7 +1358,6 @@ func ImportOFX(r io.Reader) (*OFXImport, error) {
 			if err != nil {
 				return nil, err
 			}
-			return &i, nil
 		}
 	}
 	for _, cc := range response.CreditCard {
@@ -990,7 +1366,6 @@ func ImportOFX(r io.Reader) (*OFXImport, error) {
 			if err != nil {
 				return nil, err
 			}
-			return &i, nil
 		}
 	}
 	for _, seclist := range response.SecList {
@@ -1007,9 +1382,11 @@ func ImportOFX(r io.Reader) (*OFXImport, error) {
 			if err != nil {
 				return nil, err
 			}
-			return &i, nil
 		}
 	}
 
+	if len(i.Accounts) > 0 {
+		return &i, nil
+	}
 	return nil, errors.New("No OFX statement found")
 }
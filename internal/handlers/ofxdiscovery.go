@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/ofxgo"
+	"log"
+	"net/http"
+	"time"
+)
+
+// setOFXFields copies the OFX connection details of from to account
+func setOFXFields(account, from *models.Account) {
+	account.OFXURL = from.OFXURL
+	account.OFXORG = from.OFXORG
+	account.OFXFID = from.OFXFID
+	account.OFXUser = from.OFXUser
+	account.OFXBankID = from.OFXBankID
+	account.OFXAcctID = from.OFXAcctID
+	account.OFXAcctType = from.OFXAcctType
+	account.OFXClientUID = from.OFXClientUID
+	account.OFXAppID = from.OFXAppID
+	account.OFXAppVer = from.OFXAppVer
+	account.OFXVersion = from.OFXVersion
+	account.OFXNoIndent = from.OFXNoIndent
+}
+
+// discoveredAccount returns a new account, with its OFX fields filled in from
+// connection and info, for an account found at a financial institution. It
+// returns nil for types of accounts which can't be imported.
+func discoveredAccount(user *models.User, connection *models.Account, info *ofxgo.AcctInfo) *models.Account {
+	account := &models.Account{
+		AccountId:       -1,
+		UserId:          user.UserId,
+		SecurityId:      user.DefaultCurrency,
+		ParentAccountId: -1,
+	}
+	setOFXFields(account, connection)
+
+	if info.BankAcctInfo != nil {
+		account.Type = models.Bank
+		account.OFXBankID = info.BankAcctInfo.BankAcctFrom.BankID.String()
+		account.OFXAcctID = info.BankAcctInfo.BankAcctFrom.AcctID.String()
+		account.OFXAcctType = info.BankAcctInfo.BankAcctFrom.AcctType.String()
+	} else if info.CCAcctInfo != nil {
+		account.Type = models.Liability
+		account.OFXAcctID = info.CCAcctInfo.CCAcctFrom.AcctID.String()
+		account.OFXAcctType = "CC"
+	} else if info.InvAcctInfo != nil {
+		account.Type = models.Investment
+		account.OFXBankID = info.InvAcctInfo.InvAcctFrom.BrokerID.String()
+		account.OFXAcctID = info.InvAcctInfo.InvAcctFrom.AcctID.String()
+	} else {
+		return nil
+	}
+
+	if len(info.Desc) > 0 {
+		account.Name = info.Desc.String()
+	} else if len(info.Name) > 0 {
+		account.Name = info.Name.String()
+	} else {
+		account.Name = account.OFXAcctID
+	}
+	return account
+}
+
+/*
+ * Lists the accounts a user has at a financial institution, found using an OFX
+ * ACCTINFO request, and creates new accounts for them or links them to
+ * existing ones as requested
+ */
+func OFXDiscoveryHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method != "POST" || !context.LastLevel() {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	var discovery models.OFXDiscovery
+	if err := ReadJSON(r, &discovery); err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	connection := models.Account{
+		OFXURL:       discovery.OFXURL,
+		OFXORG:       discovery.OFXORG,
+		OFXFID:       discovery.OFXFID,
+		OFXUser:      discovery.OFXUser,
+		OFXClientUID: discovery.OFXClientUID,
+		OFXAppID:     discovery.OFXAppID,
+		OFXAppVer:    discovery.OFXAppVer,
+		OFXVersion:   discovery.OFXVersion,
+		OFXNoIndent:  discovery.OFXNoIndent,
+	}
+	client, query, e := ofxClientRequest(&connection, discovery.OFXPassword)
+	if e != nil {
+		return e
+	}
+
+	transactionuid, err := ofxgo.RandomUID()
+	if err != nil {
+		log.Println("Error creating uid for transaction:", err)
+		return NewError(999 /*Internal Error*/)
+	}
+	query.Signup = append(query.Signup, &ofxgo.AcctInfoRequest{
+		TrnUID: *transactionuid,
+		// Ask for all accounts, rather than those updated since some date
+		DtAcctUp: ofxgo.Date{Time: time.Date(1990, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	})
+
+	response, err := client.Request(query)
+	if err != nil {
+		log.Print(err)
+		return NewError(3 /*Invalid Request*/)
+	}
+	if response.Signon.Status.Code != 0 {
+		meaning, _ := response.Signon.Status.CodeMeaning()
+		log.Printf("Nonzero signon status (%d: %s) with message: %s", response.Signon.Status.Code, meaning, response.Signon.Status.Message)
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	existing, err := context.Tx.GetAccounts(user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	discovered := []*models.DiscoveredAccount{}
+	for _, message := range response.Signup {
+		acctinfo, ok := message.(*ofxgo.AcctInfoResponse)
+		if !ok {
+			continue
+		}
+		if acctinfo.Status.Code != 0 {
+			meaning, _ := acctinfo.Status.CodeMeaning()
+			log.Printf("Nonzero ACCTINFO status (%d: %s) with message: %s", acctinfo.Status.Code, meaning, acctinfo.Status.Message)
+			return NewError(3 /*Invalid Request*/)
+		}
+		for i := range acctinfo.AcctInfo {
+			account := discoveredAccount(user, &connection, &acctinfo.AcctInfo[i])
+			if account == nil {
+				continue
+			}
+			d := &models.DiscoveredAccount{Index: len(discovered), Account: account, LinkedAccountId: -1}
+			for _, a := range *existing {
+				if a.OFXAcctID == account.OFXAcctID && a.OFXBankID == account.OFXBankID {
+					d.LinkedAccountId = a.AccountId
+					break
+				}
+			}
+			discovered = append(discovered, d)
+		}
+	}
+
+	acted := make(map[int]bool)
+	for _, action := range discovery.Actions {
+		if action.Index < 0 || action.Index >= len(discovered) || acted[action.Index] {
+			return NewError(3 /*Invalid Request*/)
+		}
+		acted[action.Index] = true
+		d := discovered[action.Index]
+
+		if action.AccountId == -1 {
+			if err := context.Tx.InsertAccount(d.Account); err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+		} else {
+			account, err := context.Tx.GetAccount(action.AccountId, user.UserId)
+			if err != nil {
+				return NewError(3 /*Invalid Request*/)
+			}
+			// Whether statements are requested as for an investment account
+			// depends on the account's type
+			if (account.Type == models.Investment) != (d.Account.Type == models.Investment) {
+				return NewError(3 /*Invalid Request*/)
+			}
+			setOFXFields(account, d.Account)
+			if err := context.Tx.UpdateAccount(account); err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			d.Account = account
+		}
+		d.LinkedAccountId = d.Account.AccountId
+	}
+
+	return &models.DiscoveredAccountList{Accounts: &discovered}
+}
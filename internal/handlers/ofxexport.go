@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"github.com/aclindsa/ofxgo"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseExportDate parses dates passed to exports as query parameters, which
+// may be either RFC 3339 timestamps or plain dates
+func parseExportDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// ofxFiTID returns the FITID to export split with, which is the one it was
+// originally imported with, if any
+func ofxFiTID(split *models.Split) string {
+	if len(split.RemoteId) > 0 {
+		return strings.TrimPrefix(split.RemoteId, "ofx:")
+	}
+	return strconv.FormatInt(split.SplitId, 10)
+}
+
+func ofxStatementTransaction(t *models.Transaction, split *models.Split) ofxgo.Transaction {
+	var tran ofxgo.Transaction
+	tran.DtPosted = ofxgo.Date{Time: t.Date}
+	tran.TrnAmt = ofxgo.Amount{Rat: split.Amount.Rat}
+	tran.FiTID = ofxgo.String(ofxFiTID(split))
+	if split.Amount.Sign() < 0 {
+		tran.TrnType = ofxgo.TrnTypeDebit
+	} else {
+		tran.TrnType = ofxgo.TrnTypeCredit
+	}
+	if len(split.Number) > 0 {
+		tran.CheckNum = ofxgo.String(split.Number)
+	}
+	// NAME is limited to 32 characters
+	name := []rune(strings.TrimSpace(t.Description))
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	tran.Name = ofxgo.String(string(name))
+	tran.Memo = ofxgo.String(split.Memo)
+	return tran
+}
+
+func ofxSecurityID(security *models.Security) ofxgo.SecurityID {
+	if len(security.AlternateId) > 0 {
+		return ofxgo.SecurityID{UniqueID: ofxgo.String(security.AlternateId), UniqueIDType: "CUSIP"}
+	}
+	return ofxgo.SecurityID{UniqueID: ofxgo.String(security.Symbol), UniqueIDType: "TICKER"}
+}
+
+// ofxUnitPrice returns the absolute value of total/units, rounded to a
+// reasonable precision for OFX
+func ofxUnitPrice(total, units *big.Rat) ofxgo.Amount {
+	var price ofxgo.Amount
+	if units.Sign() == 0 {
+		return price
+	}
+	price.Quo(total, units)
+	price.Abs(&price.Rat)
+	price.SetString(price.FloatString(6))
+	return price
+}
+
+// ofxAccountTransactions returns all transactions dated in [begin, end) with
+// splits in any of the accounts in accountids, sorted by date
+func ofxAccountTransactions(tx store.Tx, user *models.User, accountids map[int64]bool, begin, end time.Time) ([]*models.Transaction, error) {
+	transactions, err := tx.GetTransactions(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	var matching []*models.Transaction
+	for _, t := range *transactions {
+		if t.Date.Before(begin) || !t.Date.Before(end) {
+			continue
+		}
+		for _, s := range t.Splits {
+			if accountids[s.AccountId] {
+				matching = append(matching, t)
+				break
+			}
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		if matching[i].Date.Equal(matching[j].Date) {
+			return matching[i].TransactionId < matching[j].TransactionId
+		}
+		return matching[i].Date.Before(matching[j].Date)
+	})
+	return matching, nil
+}
+
+// ofxDtStart returns the start date to report for a statement, which is begin
+// unless it wasn't supplied
+func ofxDtStart(begin, end time.Time, transactions []*models.Transaction) ofxgo.Date {
+	if begin.IsZero() {
+		if len(transactions) > 0 {
+			return ofxgo.Date{Time: transactions[0].Date}
+		}
+		return ofxgo.Date{Time: end}
+	}
+	return ofxgo.Date{Time: begin}
+}
+
+// ofxInvStatement builds an investment statement for account. Splits in the
+// account itself are cash transactions, while splits in its child accounts
+// holding other securities are buys and sells (if balanced by cash in the
+// account) or transfers of those securities.
+func ofxInvStatement(tx store.Tx, user *models.User, account *models.Account, currency *models.Security, begin, end time.Time) (*ofxgo.InvStatementResponse, *ofxgo.SecurityList, error) {
+	var stmt ofxgo.InvStatementResponse
+	stmt.InvAcctFrom.BrokerID = ofxgo.String(account.OFXBankID)
+
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	accountids := map[int64]bool{account.AccountId: true}
+	holdings := make(map[int64]*models.Security)
+	var holdingAccounts []*models.Account
+	for _, a := range *accounts {
+		if a.ParentAccountId == account.AccountId && a.SecurityId != account.SecurityId {
+			security, err := tx.GetSecurity(a.SecurityId, user.UserId)
+			if err != nil {
+				return nil, nil, err
+			}
+			accountids[a.AccountId] = true
+			holdings[a.AccountId] = security
+			holdingAccounts = append(holdingAccounts, a)
+		}
+	}
+
+	transactions, err := ofxAccountTransactions(tx, user, accountids, begin, end)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stmt.InvTranList = &ofxgo.InvTranList{
+		DtStart: ofxDtStart(begin, end, transactions),
+		DtEnd:   ofxgo.Date{Time: end},
+	}
+	securities := make(map[int64]*models.Security)
+	for _, t := range transactions {
+		var cash, held []*models.Split
+		for _, s := range t.Splits {
+			if s.AccountId == account.AccountId {
+				cash = append(cash, s)
+			} else if _, ok := holdings[s.AccountId]; ok {
+				held = append(held, s)
+			}
+		}
+
+		if len(held) == 1 && len(cash) > 0 {
+			security := holdings[held[0].AccountId]
+			securities[security.SecurityId] = security
+			var total big.Rat
+			for _, s := range cash {
+				total.Add(&total, &s.Amount.Rat)
+			}
+			invtran := ofxgo.InvTran{
+				FiTID:   ofxgo.String(ofxFiTID(held[0])),
+				DtTrade: ofxgo.Date{Time: t.Date},
+				Memo:    ofxgo.String(t.Description),
+			}
+			if held[0].Amount.Sign() >= 0 {
+				stmt.InvTranList.InvTransactions = append(stmt.InvTranList.InvTransactions, ofxgo.BuyStock{
+					InvBuy: ofxgo.InvBuy{
+						InvTran:     invtran,
+						SecID:       ofxSecurityID(security),
+						Units:       ofxgo.Amount{Rat: held[0].Amount.Rat},
+						UnitPrice:   ofxUnitPrice(&total, &held[0].Amount.Rat),
+						Total:       ofxgo.Amount{Rat: total},
+						SubAcctSec:  ofxgo.SubAcctTypeCash,
+						SubAcctFund: ofxgo.SubAcctTypeCash,
+					},
+					BuyType: ofxgo.BuyTypeBuy,
+				})
+			} else {
+				stmt.InvTranList.InvTransactions = append(stmt.InvTranList.InvTransactions, ofxgo.SellStock{
+					InvSell: ofxgo.InvSell{
+						InvTran:     invtran,
+						SecID:       ofxSecurityID(security),
+						Units:       ofxgo.Amount{Rat: held[0].Amount.Rat},
+						UnitPrice:   ofxUnitPrice(&total, &held[0].Amount.Rat),
+						Total:       ofxgo.Amount{Rat: total},
+						SubAcctSec:  ofxgo.SubAcctTypeCash,
+						SubAcctFund: ofxgo.SubAcctTypeCash,
+					},
+					SellType: ofxgo.SellTypeSell,
+				})
+			}
+			continue
+		}
+
+		for _, s := range held {
+			security := holdings[s.AccountId]
+			securities[security.SecurityId] = security
+			transfer := ofxgo.Transfer{
+				InvTran: ofxgo.InvTran{
+					FiTID:   ofxgo.String(ofxFiTID(s)),
+					DtTrade: ofxgo.Date{Time: t.Date},
+					Memo:    ofxgo.String(t.Description),
+				},
+				SecID:      ofxSecurityID(security),
+				SubAcctSec: ofxgo.SubAcctTypeCash,
+				TferAction: ofxgo.TferActionIn,
+				PosType:    ofxgo.PosTypeLong,
+			}
+			transfer.Units.Abs(&s.Amount.Rat)
+			if s.Amount.Sign() < 0 {
+				transfer.TferAction = ofxgo.TferActionOut
+			}
+			stmt.InvTranList.InvTransactions = append(stmt.InvTranList.InvTransactions, transfer)
+		}
+		for _, s := range cash {
+			stmt.InvTranList.BankTransactions = append(stmt.InvTranList.BankTransactions, ofxgo.InvBankTransaction{
+				Transactions: []ofxgo.Transaction{ofxStatementTransaction(t, s)},
+				SubAcctFund:  ofxgo.SubAcctTypeCash,
+			})
+		}
+	}
+
+	for _, a := range holdingAccounts {
+		security := holdings[a.AccountId]
+		units, err := tx.GetAccountBalanceDate(user, a.AccountId, &end)
+		if err != nil {
+			return nil, nil, err
+		}
+		if units.Sign() == 0 {
+			continue
+		}
+		securities[security.SecurityId] = security
+
+		position := ofxgo.StockPosition{
+			InvPos: ofxgo.InvPosition{
+				SecID:       ofxSecurityID(security),
+				HeldInAcct:  ofxgo.SubAcctTypeCash,
+				PosType:     ofxgo.PosTypeLong,
+				Units:       ofxgo.Amount{Rat: units.Rat},
+				DtPriceAsOf: ofxgo.Date{Time: time.Date(1990, time.January, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		}
+		if units.Sign() < 0 {
+			position.InvPos.PosType = ofxgo.PosTypeShort
+		}
+		if price, err := tx.GetLatestPrice(security, currency, &end); err == nil {
+			position.InvPos.UnitPrice = ofxgo.Amount{Rat: price.Value.Rat}
+			position.InvPos.MktVal.Mul(&units.Rat, &price.Value.Rat)
+			position.InvPos.DtPriceAsOf = ofxgo.Date{Time: price.Date}
+		}
+		stmt.InvPosList = append(stmt.InvPosList, position)
+	}
+
+	cash, err := tx.GetAccountBalanceDate(user, account.AccountId, &end)
+	if err != nil {
+		return nil, nil, err
+	}
+	stmt.InvBal = &ofxgo.InvBalance{AvailCash: ofxgo.Amount{Rat: cash.Rat}}
+
+	var ids []int64
+	for id := range securities {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var seclist ofxgo.SecurityList
+	for _, id := range ids {
+		security := securities[id]
+		seclist.Securities = append(seclist.Securities, ofxgo.StockInfo{
+			SecInfo: ofxgo.SecInfo{
+				SecID:   ofxSecurityID(security),
+				SecName: ofxgo.String(security.Description),
+				Ticker:  ofxgo.String(security.Symbol),
+			},
+		})
+	}
+
+	return &stmt, &seclist, nil
+}
+
+// ExportOFX returns an OFX statement for account containing the transactions
+// dated in [begin, end), and its balance as of end
+func ExportOFX(tx store.Tx, user *models.User, account *models.Account, begin, end time.Time) (*bytes.Buffer, error) {
+	currency, err := tx.GetSecurity(account.SecurityId, user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if currency.Type != models.Currency {
+		return nil, errors.New("OFX statements can only be exported for accounts holding currencies")
+	}
+	curdef, err := ofxgo.NewCurrSymbol(currency.Name)
+	if err != nil {
+		return nil, err
+	}
+	trnuid, err := ofxgo.RandomUID()
+	if err != nil {
+		return nil, err
+	}
+	status := ofxgo.Status{Code: 0, Severity: "INFO"}
+
+	response := ofxgo.Response{
+		Version: ofxgo.OfxVersion203,
+		Signon: ofxgo.SignonResponse{
+			Status:   status,
+			DtServer: ofxgo.Date{Time: time.Now()},
+			Language: "ENG",
+			Org:      ofxgo.String(account.OFXORG),
+			Fid:      ofxgo.String(account.OFXFID),
+		},
+	}
+	if version, err := ofxgo.NewOfxVersion(account.OFXVersion); err == nil && version >= ofxgo.OfxVersion200 {
+		response.Version = version
+	}
+
+	acctid := account.OFXAcctID
+	if len(acctid) == 0 {
+		acctid = account.ExternalAccountId
+	}
+	if len(acctid) == 0 {
+		acctid = strconv.FormatInt(account.AccountId, 10)
+	}
+
+	if account.Type == models.Investment {
+		stmt, seclist, err := ofxInvStatement(tx, user, account, currency, begin, end)
+		if err != nil {
+			return nil, err
+		}
+		stmt.TrnUID = *trnuid
+		stmt.Status = status
+		stmt.DtAsOf = ofxgo.Date{Time: end}
+		stmt.CurDef = *curdef
+		stmt.InvAcctFrom.AcctID = ofxgo.String(acctid)
+		response.InvStmt = append(response.InvStmt, stmt)
+		if len(seclist.Securities) > 0 {
+			response.SecList = append(response.SecList, seclist)
+		}
+		return response.Marshal()
+	}
+
+	transactions, err := ofxAccountTransactions(tx, user, map[int64]bool{account.AccountId: true}, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	tranlist := ofxgo.TransactionList{
+		DtStart: ofxDtStart(begin, end, transactions),
+		DtEnd:   ofxgo.Date{Time: end},
+	}
+	for _, t := range transactions {
+		for _, s := range t.Splits {
+			if s.AccountId == account.AccountId {
+				tranlist.Transactions = append(tranlist.Transactions, ofxStatementTransaction(t, s))
+			}
+		}
+	}
+	balance, err := tx.GetAccountBalanceDate(user, account.AccountId, &end)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.OFXAcctType == "CC" {
+		response.CreditCard = append(response.CreditCard, &ofxgo.CCStatementResponse{
+			TrnUID:       *trnuid,
+			Status:       status,
+			CurDef:       *curdef,
+			CCAcctFrom:   ofxgo.CCAcct{AcctID: ofxgo.String(acctid)},
+			BankTranList: &tranlist,
+			BalAmt:       ofxgo.Amount{Rat: balance.Rat},
+			DtAsOf:       ofxgo.Date{Time: end},
+		})
+	} else {
+		accttype := ofxgo.AcctTypeChecking
+		if len(account.OFXAcctType) > 0 {
+			if accttype, err = ofxgo.NewAcctType(account.OFXAcctType); err != nil {
+				return nil, err
+			}
+		}
+		// BANKID is required, but we don't know it unless the account
+		// was set up to download statements
+		bankid := account.OFXBankID
+		if len(bankid) == 0 {
+			bankid = "0"
+		}
+		response.Bank = append(response.Bank, &ofxgo.StatementResponse{
+			TrnUID: *trnuid,
+			Status: status,
+			CurDef: *curdef,
+			BankAcctFrom: ofxgo.BankAcct{
+				BankID:   ofxgo.String(bankid),
+				AcctID:   ofxgo.String(acctid),
+				AcctType: accttype,
+			},
+			BankTranList: &tranlist,
+			BalAmt:       ofxgo.Amount{Rat: balance.Rat},
+			DtAsOf:       ofxgo.Date{Time: end},
+		})
+	}
+	return response.Marshal()
+}
+
+/*
+ * Exports account's transactions as an OFX statement. The optional 'begin'
+ * and 'end' query parameters limit the transactions to those dated on or after
+ * begin and before end. The balance is reported as of end, which defaults to
+ * now.
+ */
+func OFXExportHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
+	account, err := context.Tx.GetAccount(accountid, user.UserId)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	query, _ := url.ParseQuery(r.URL.RawQuery)
+	var begin time.Time
+	end := time.Now()
+	if beginstring := query.Get("begin"); beginstring != "" {
+		if begin, err = parseExportDate(beginstring); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+	}
+	if endstring := query.Get("end"); endstring != "" {
+		if end, err = parseExportDate(endstring); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+	}
+	if end.Before(begin) {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	security, err := context.Tx.GetSecurity(account.SecurityId, user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+	if security.Type != models.Currency {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	ofx, err := ExportOFX(context.Tx, user, account, begin, end)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return FileWriter{
+		ContentType: "application/x-ofx",
+		Filename:    "moneygo.ofx",
+		Data:        ofx.Bytes(),
+	}
+}
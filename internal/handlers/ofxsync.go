@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"github.com/aclindsa/ofxgo"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ofxSyncTimeout limits how long a financial institution has to respond to an
+// OFX request made while syncing, unless OFXSyncer.Client is set
+const ofxSyncTimeout = 2 * time.Minute
+
+var ofxSyncClient = &http.Client{Timeout: ofxSyncTimeout}
+
+// OFXSyncer stores OFX passwords, encrypted, and uses them to periodically
+// sync accounts with their financial institutions via OFX direct connect
+type OFXSyncer struct {
+	Store  store.Store
+	Client *http.Client // used to make OFX requests, one with a timeout of ofxSyncTimeout if nil
+	aead   cipher.AEAD
+}
+
+// NewOFXSyncer returns an OFXSyncer for the accounts in store, which encrypts
+// stored passwords using a key derived from secret
+func NewOFXSyncer(store store.Store, secret string) (*OFXSyncer, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("A key is required to store OFX passwords")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &OFXSyncer{Store: store, aead: aead}, nil
+}
+
+// passwordData is authenticated along with a sync's password, so an encrypted
+// password can't be used for another user's or account's sync
+func passwordData(sync *models.OFXSync) []byte {
+	return []byte(fmt.Sprintf("%d/%d", sync.UserId, sync.AccountId))
+}
+
+// encryptPassword sets sync's EncryptedPassword from its Password, and clears
+// Password
+func (s *OFXSyncer) encryptPassword(sync *models.OFXSync) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(sync.Password), passwordData(sync))
+	sync.EncryptedPassword = base64.StdEncoding.EncodeToString(sealed)
+	sync.Password = ""
+	return nil
+}
+
+func (s *OFXSyncer) decryptPassword(sync *models.OFXSync) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(sync.EncryptedPassword)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("Encrypted OFX password too short")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	password, err := s.aead.Open(nil, nonce, ciphertext, passwordData(sync))
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// Run syncs the accounts which are due to be, checking every interval, until
+// stop is closed
+func (s *OFXSyncer) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.SyncDue(time.Now()); err != nil {
+			log.Print(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncDue syncs every account whose next sync is due by now. Failing to sync
+// an account (including panicking while doing so) is recorded in its OFXSync
+// rather than returned.
+func (s *OFXSyncer) SyncDue(now time.Time) error {
+	now = now.UTC().Truncate(time.Second)
+
+	tx, err := s.Store.Begin()
+	if err != nil {
+		return err
+	}
+	syncs, err := tx.GetDueOFXSyncs(&now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, sync := range *syncs {
+		syncErr := s.syncRecover(sync, now)
+		if syncErr != nil {
+			log.Printf("Error syncing account %d via OFX: %s", sync.AccountId, syncErr)
+		}
+
+		// The user may have changed or deleted the sync while it was
+		// running, so only update its status, and only if it still exists
+		tx, err := s.Store.Begin()
+		if err != nil {
+			return err
+		}
+		current, err := tx.GetOFXSync(sync.OFXSyncId, sync.UserId)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			continue
+		} else if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if syncErr != nil {
+			current.Error = syncErr.Error()
+		} else {
+			current.Error = ""
+			current.LastSuccess = now
+		}
+		current.LastAttempt = now
+		current.NextSync = now.Add(time.Duration(current.Interval) * time.Minute)
+		if err := tx.UpdateOFXSync(current); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncRecover calls sync, returning an error instead of panicking if it does
+// so a single account can't stop others from being synced
+func (s *OFXSyncer) syncRecover(sync *models.OFXSync, now time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic while syncing: %v", r)
+		}
+	}()
+	return s.sync(sync, now)
+}
+
+// sync downloads the statement for sync's account with the transactions since
+// its last successful sync, and imports it
+func (s *OFXSyncer) sync(sync *models.OFXSync, now time.Time) error {
+	tx, err := s.Store.Begin()
+	if err != nil {
+		return err
+	}
+	user, err := tx.GetUser(sync.UserId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	account, err := tx.GetAccount(sync.AccountId, sync.UserId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	password, err := s.decryptPassword(sync)
+	if err != nil {
+		return err
+	}
+	client, query, e := ofxStatementRequest(account, password, sync.LastSuccess, now)
+	if e != nil {
+		return e
+	}
+	httpClient := s.Client
+	if httpClient == nil {
+		httpClient = ofxSyncClient
+	}
+	response, err := (&ofxHTTPClient{client, httpClient}).RequestNoParse(query)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	tx, err = s.Store.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+	if e, ok := ofxImportHelper(tx, response.Body, user, account.AccountId, models.ImportOptions{}).(*Error); ok {
+		tx.Rollback()
+		return e
+	}
+	return tx.Commit()
+}
+
+// ofxHTTPClient is an ofxgo.BasicClient which makes its requests using a
+// particular http.Client instead of http.DefaultClient
+type ofxHTTPClient struct {
+	*ofxgo.BasicClient
+	client *http.Client
+}
+
+func (c *ofxHTTPClient) RawRequest(URL string, r io.Reader) (*http.Response, error) {
+	if !strings.HasPrefix(URL, "https://") {
+		return nil, errors.New("Refusing to send OFX request with possible plain-text password over non-https protocol")
+	}
+
+	request, err := http.NewRequest("POST", URL, r)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-ofx")
+	request.Header.Add("Accept", "*/*, application/x-ofx")
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != 200 {
+		response.Body.Close()
+		return nil, errors.New("OFXQuery request status: " + response.Status)
+	}
+
+	return response, nil
+}
+
+func (c *ofxHTTPClient) RequestNoParse(r *ofxgo.Request) (*http.Response, error) {
+	r.SetClientFields(c)
+
+	b, err := r.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.RawRequest(r.URL, b)
+}
+
+// validOFXSync returns whether sync refers to one of user's accounts which is
+// configured for OFX direct connect, and has a positive interval
+func validOFXSync(tx store.Tx, user *models.User, sync *models.OFXSync) bool {
+	if sync.Interval <= 0 {
+		return false
+	}
+	account, err := tx.GetAccount(sync.AccountId, user.UserId)
+	if err != nil {
+		return false
+	}
+	return len(account.OFXURL) > 0
+}
+
+func (s *OFXSyncer) OFXSyncHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method == "POST" {
+		var sync models.OFXSync
+		if err := ReadJSON(r, &sync); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		sync.OFXSyncId = -1
+		sync.UserId = user.UserId
+
+		if !validOFXSync(context.Tx, user, &sync) || len(sync.Password) == 0 {
+			return NewError(3 /*Invalid Request*/)
+		}
+		exists, err := context.Tx.OFXSyncExists(sync.AccountId, user.UserId)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		} else if exists {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if err := s.encryptPassword(&sync); err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		// New syncs are due immediately
+		sync.LastAttempt = time.Time{}
+		sync.LastSuccess = time.Time{}
+		sync.NextSync = time.Now().UTC().Truncate(time.Second)
+		sync.Error = ""
+
+		err = context.Tx.InsertOFXSync(&sync)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return ResponseWrapper{201, &sync}
+	} else if r.Method == "GET" {
+		if context.LastLevel() {
+			//Return all OFXSyncs
+			var sl models.OFXSyncList
+			syncs, err := context.Tx.GetOFXSyncs(user.UserId)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			sl.OFXSyncs = syncs
+			return &sl
+		}
+
+		ofxsyncid, err := context.NextID()
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Return OFXSync with this Id
+		sync, err := context.Tx.GetOFXSync(ofxsyncid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		return sync
+	} else {
+		ofxsyncid, err := context.NextID()
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Ensure the sync exists and belongs to this user
+		sync, err := context.Tx.GetOFXSync(ofxsyncid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if r.Method == "PUT" {
+			var newsync models.OFXSync
+			if err := ReadJSON(r, &newsync); err != nil || newsync.OFXSyncId != ofxsyncid || newsync.AccountId != sync.AccountId {
+				return NewError(3 /*Invalid Request*/)
+			}
+			newsync.UserId = user.UserId
+
+			if !validOFXSync(context.Tx, user, &newsync) || len(newsync.Password) == 0 {
+				return NewError(3 /*Invalid Request*/)
+			}
+
+			// If the user didn't supply a new password, keep their old one
+			if newsync.Password != models.BogusPassword {
+				if err := s.encryptPassword(&newsync); err != nil {
+					log.Print(err)
+					return NewError(999 /*Internal Error*/)
+				}
+			} else {
+				newsync.Password = ""
+				newsync.EncryptedPassword = sync.EncryptedPassword
+			}
+
+			// The status of the sync can't be changed, but its next sync is
+			// rescheduled for the new interval
+			newsync.LastAttempt = sync.LastAttempt
+			newsync.LastSuccess = sync.LastSuccess
+			newsync.Error = sync.Error
+			newsync.NextSync = sync.NextSync
+			if !sync.LastAttempt.IsZero() {
+				newsync.NextSync = sync.LastAttempt.Add(time.Duration(newsync.Interval) * time.Minute)
+			}
+
+			err = context.Tx.UpdateOFXSync(&newsync)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return &newsync
+		} else if r.Method == "DELETE" {
+			err = context.Tx.DeleteOFXSync(sync)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return SuccessWriter{}
+		}
+	}
+	return NewError(3 /*Invalid Request*/)
+}
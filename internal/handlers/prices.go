@@ -7,29 +7,31 @@ import (
 	"net/http"
 )
 
-func CreatePriceIfNotExist(tx store.Tx, price *models.Price) error {
+// CreatePriceIfNotExist creates price unless one with the same RemoteId
+// already exists, and returns whether it was created
+func CreatePriceIfNotExist(tx store.Tx, price *models.Price) (bool, error) {
 	if len(price.RemoteId) == 0 {
 		// Always create a new price if we can't match on the RemoteId
 		err := tx.InsertPrice(price)
 		if err != nil {
-			return err
+			return false, err
 		}
-		return nil
+		return true, nil
 	}
 
 	exists, err := tx.PriceExists(price)
 	if err != nil {
-		return err
+		return false, err
 	}
 	if exists {
-		return nil // price already exists
+		return false, nil // price already exists
 	}
 
 	err = tx.InsertPrice(price)
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	return true, nil
 }
 
 func PriceHandler(r *http.Request, context *Context, user *models.User, securityid int64) ResponseWriterWriter {
@@ -0,0 +1,984 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type QIFImport struct {
+	Securities   []models.Security
+	Accounts     []models.Account
+	Transactions []models.Transaction
+	Prices       []models.Price
+}
+
+// qifImporter holds the state needed while parsing a QIF file. Securities and
+// accounts are assigned placeholder IDs (their index in QIFImport's slices,
+// plus one) which are mapped to real IDs when the results are imported.
+type qifImporter struct {
+	QIFImport
+
+	currency           models.Security
+	defaultAccountName string
+	currentAccount     int64
+
+	qifAccounts    map[string]int64 // QIF account name -> account ID
+	childAccounts  map[string]int64 // parent/name/type/security -> account ID
+	categories     map[string]int64 // full category name -> account ID
+	categoryIncome map[string]bool  // category name -> whether it is an income category
+	securities     map[string]int64 // security name or symbol -> security ID
+
+	// Transfers between two accounts both present in the file show up once
+	// in each account's register. The first side seen is imported, and its
+	// split in the other account is saved here (keyed by qifTransferKey plus
+	// the ID of the account it was seen in) so the second side can be
+	// skipped.
+	pendingTransfers map[string][]*models.Split
+
+	holdings map[int64]*big.Rat // security sub-account ID -> shares held
+	seen     map[string]int     // used to make RemoteIds unique
+}
+
+type qifSplit struct {
+	category string
+	memo     string
+	amount   *big.Rat
+}
+
+// qifAccountTypes maps the account types from '!Type:' headers and account
+// list 'T' fields to our own
+var qifAccountTypes = map[string]models.AccountType{
+	"bank":    models.Bank,
+	"ccard":   models.Liability,
+	"cash":    models.Cash,
+	"otha":    models.Asset,
+	"othl":    models.Liability,
+	"invst":   models.Investment,
+	"port":    models.Investment,
+	"401(k)":  models.Investment,
+	"403(b)":  models.Investment,
+	"401k":    models.Investment,
+	"403b":    models.Investment,
+	"mutual":  models.Investment,
+	"invest":  models.Investment,
+	"invstmt": models.Investment,
+}
+
+func qifNormalize(s string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(s), " ", "", -1))
+}
+
+// parseQIFDate parses the many date formats found in the wild in QIF files,
+// i.e. "1/2/98", "1/ 2'05", "01/02/2005", and "2005-01-02". Dates with the
+// year first are assumed to be year-month-day, all others month-day-year.
+func parseQIFDate(value string) (time.Time, error) {
+	s := strings.Replace(value, " ", "", -1)
+	apostrophe := strings.Contains(s, "'")
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '\''
+	})
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("Unable to parse QIF date \"%s\"", value)
+	}
+	var numbers [3]int
+	for i := range parts {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Unable to parse QIF date \"%s\"", value)
+		}
+		numbers[i] = n
+	}
+
+	var year, month, day int
+	if len(parts[0]) == 4 {
+		year, month, day = numbers[0], numbers[1], numbers[2]
+	} else {
+		month, day, year = numbers[0], numbers[1], numbers[2]
+		if len(parts[2]) <= 2 {
+			// Quicken writes years after 1999 with an apostrophe
+			// separating them from the day
+			if apostrophe || year < 70 {
+				year += 2000
+			} else {
+				year += 1900
+			}
+		}
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, fmt.Errorf("Unable to parse QIF date \"%s\"", value)
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), nil
+}
+
+func parseQIFAmount(value string) (*big.Rat, error) {
+	s := strings.Replace(strings.Replace(strings.TrimSpace(value), ",", "", -1), "$", "", -1)
+	if len(s) == 0 {
+		return nil, nil
+	}
+	amount, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("Unable to parse QIF amount \"%s\"", value)
+	}
+	return amount, nil
+}
+
+func qifNeg(r *big.Rat) *big.Rat {
+	if r == nil {
+		return nil
+	}
+	return new(big.Rat).Neg(r)
+}
+
+func qifStatus(value string) int64 {
+	switch strings.TrimSpace(value) {
+	case "*", "c", "C":
+		return models.Cleared
+	case "X", "x", "R", "r":
+		return models.Reconciled
+	default:
+		return models.Imported
+	}
+}
+
+// qifTransferKey identifies a transfer of amount into accountid from otherid
+// on date such that the same transfer seen from otherid's side has the same
+// key
+func qifTransferKey(date time.Time, accountid, otherid int64, amount *big.Rat) string {
+	a := new(big.Rat).Set(amount)
+	if otherid < accountid {
+		accountid, otherid = otherid, accountid
+		a.Neg(a)
+	}
+	return fmt.Sprintf("%s\x1f%d\x1f%d\x1f%s", date.Format("2006-01-02"), accountid, otherid, a.RatString())
+}
+
+func (i *qifImporter) addAccount(a models.Account) int64 {
+	a.AccountId = int64(len(i.Accounts) + 1)
+	i.Accounts = append(i.Accounts, a)
+	return a.AccountId
+}
+
+func (i *qifImporter) getChildAccount(name string, t models.AccountType, parentid, securityid int64) int64 {
+	key := fmt.Sprintf("%d\x1f%s\x1f%d\x1f%d", parentid, name, t, securityid)
+	if id, ok := i.childAccounts[key]; ok {
+		return id
+	}
+	id := i.addAccount(models.Account{
+		Name:            name,
+		Type:            t,
+		ParentAccountId: parentid,
+		SecurityId:      securityid,
+	})
+	i.childAccounts[key] = id
+	return id
+}
+
+// getQIFAccount returns the ID of the top-level account with the given name,
+// creating it if necessary. Accounts referenced before their type is known
+// (i.e. transfers to accounts which appear later in the file) are assumed to
+// be bank accounts until an account list entry says otherwise.
+func (i *qifImporter) getQIFAccount(name string, t models.AccountType) int64 {
+	if id, ok := i.qifAccounts[name]; ok {
+		if t != 0 {
+			i.Accounts[id-1].Type = t
+		}
+		return id
+	}
+	if t == 0 {
+		t = models.Bank
+	}
+	id := i.addAccount(models.Account{
+		Name:            name,
+		Type:            t,
+		ParentAccountId: -1,
+		SecurityId:      i.currency.SecurityId,
+	})
+	i.qifAccounts[name] = id
+	return id
+}
+
+// getCategory returns the ID of the income or expense account corresponding
+// to a QIF category such as "Auto:Fuel", creating it and its parents as
+// needed. Categories listed in the file's category list keep the type given
+// there, others are assumed to be income categories if money is flowing into
+// the account being imported.
+func (i *qifImporter) getCategory(category string, income bool) int64 {
+	// Strip off any class
+	if idx := strings.Index(category, "/"); idx >= 0 {
+		category = category[:idx]
+	}
+	category = strings.TrimSpace(category)
+	if len(category) == 0 {
+		category = "Uncategorized"
+	}
+	if id, ok := i.categories[category]; ok {
+		return id
+	}
+
+	names := strings.Split(category, ":")
+	if isIncome, ok := i.categoryIncome[category]; ok {
+		income = isIncome
+	} else if isIncome, ok := i.categoryIncome[names[0]]; ok {
+		income = isIncome
+	}
+
+	var parentid int64
+	if income {
+		parentid = i.getChildAccount("Income", models.Income, -1, i.currency.SecurityId)
+	} else {
+		parentid = i.getChildAccount("Expenses", models.Expense, -1, i.currency.SecurityId)
+	}
+	t := i.Accounts[parentid-1].Type
+	for _, name := range names {
+		parentid = i.getChildAccount(strings.TrimSpace(name), t, parentid, i.currency.SecurityId)
+	}
+	i.categories[category] = parentid
+	return parentid
+}
+
+// getCounterAccount returns the account ID for a category or transfer ('L'
+// or 'S') field and whether it was a transfer
+func (i *qifImporter) getCounterAccount(field string, income bool) (int64, bool) {
+	field = strings.TrimSpace(field)
+	if strings.HasPrefix(field, "[") {
+		name := field[1:]
+		if end := strings.Index(name, "]"); end >= 0 {
+			name = name[:end]
+		}
+		return i.getQIFAccount(strings.TrimSpace(name), 0), true
+	}
+	return i.getCategory(field, income), false
+}
+
+// getAccount returns the account the current register belongs to. Files
+// exported from a single account often contain no account information at
+// all, so create one using the default name if necessary.
+func (i *qifImporter) getAccount(t models.AccountType) int64 {
+	if i.currentAccount == 0 {
+		i.currentAccount = i.getQIFAccount(i.defaultAccountName, t)
+	}
+	return i.currentAccount
+}
+
+func (i *qifImporter) getSecurity(name string) int64 {
+	name = strings.TrimSpace(name)
+	if id, ok := i.securities[name]; ok {
+		return id
+	}
+	// QIF doesn't specify precision, so start with the same default as OFX
+	// imports and raise it if we see more precise quantities
+	s := models.Security{
+		SecurityId:  int64(len(i.Securities) + 1),
+		Name:        name,
+		Description: name,
+		Symbol:      name,
+		Precision:   5,
+		Type:        models.Stock,
+	}
+	i.Securities = append(i.Securities, s)
+	i.securities[name] = s.SecurityId
+	return s.SecurityId
+}
+
+func (i *qifImporter) remoteId(accountid int64, lines []string) string {
+	record := i.Accounts[accountid-1].Name + "\x1e" + strings.Join(lines, "\x1f")
+	i.seen[record]++
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x1e%d", record, i.seen[record])))
+	return fmt.Sprintf("qif:%x", hash)
+}
+
+func (i *qifImporter) checkPrecision(amount *models.Amount, securityid int64) error {
+	security := &i.Securities[securityid-1]
+	if amount.Precision() <= security.Precision {
+		return nil
+	}
+	if security.Type == models.Currency {
+		return errors.New("Imported transaction amount is too precise for security")
+	}
+	if amount.Precision() > models.MaxPrecision {
+		return errors.New("Imported quantity is too precise")
+	}
+	security.Precision = amount.Precision()
+	return nil
+}
+
+// addSplit adds a split to t if amount is non-zero
+func (i *qifImporter) addSplit(t *models.Transaction, accountid int64, amount *big.Rat, status int64, remoteid, number, memo string) (*models.Split, error) {
+	if amount == nil || amount.Sign() == 0 {
+		return nil, nil
+	}
+	s := &models.Split{
+		Status:     status,
+		AccountId:  accountid,
+		SecurityId: -1,
+		RemoteId:   remoteid,
+		Number:     number,
+		Memo:       memo,
+	}
+	s.Amount.Rat = *new(big.Rat).Set(amount)
+	if err := i.checkPrecision(&s.Amount, i.Accounts[accountid-1].SecurityId); err != nil {
+		return nil, err
+	}
+	t.Splits = append(t.Splits, s)
+	return s, nil
+}
+
+func (i *qifImporter) processAccount(lines []string) {
+	var name string
+	var t models.AccountType
+	for _, line := range lines {
+		value := strings.TrimSpace(line[1:])
+		switch line[0] {
+		case 'N':
+			name = value
+		case 'T':
+			t = qifAccountTypes[qifNormalize(value)]
+		}
+	}
+	if len(name) > 0 {
+		i.currentAccount = i.getQIFAccount(name, t)
+	}
+}
+
+func (i *qifImporter) processCategory(lines []string) {
+	var name string
+	var income bool
+	for _, line := range lines {
+		switch line[0] {
+		case 'N':
+			name = strings.TrimSpace(line[1:])
+		case 'I':
+			income = true
+		case 'E':
+			income = false
+		}
+	}
+	if len(name) > 0 {
+		i.categoryIncome[name] = income
+	}
+}
+
+func (i *qifImporter) processSecurity(lines []string) {
+	var name, symbol, description string
+	for _, line := range lines {
+		value := strings.TrimSpace(line[1:])
+		switch line[0] {
+		case 'N':
+			name = value
+		case 'S':
+			symbol = value
+		case 'D':
+			description = value
+		}
+	}
+	if len(name) == 0 {
+		return
+	}
+	id := i.getSecurity(name)
+	s := &i.Securities[id-1]
+	if len(symbol) > 0 {
+		s.Symbol = symbol
+		i.securities[symbol] = id
+	}
+	if len(description) > 0 {
+		s.Description = description
+	}
+}
+
+// processPrices handles lines like: "IBM",123.45," 1/ 2'05"
+func (i *qifImporter) processPrices(lines []string) error {
+	for _, line := range lines {
+		reader := csv.NewReader(strings.NewReader(line))
+		reader.LazyQuotes = true
+		fields, err := reader.Read()
+		if err != nil {
+			return err
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("Unable to parse QIF price \"%s\"", line)
+		}
+		date, err := parseQIFDate(fields[2])
+		if err != nil {
+			return err
+		}
+		value, err := parseQIFAmount(fields[1])
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+
+		var p models.Price
+		p.SecurityId = i.getSecurity(fields[0])
+		p.CurrencyId = i.currency.SecurityId
+		p.Date = date
+		p.Value.Rat = *value
+		if p.Value.Precision() > i.currency.Precision {
+			p.Value.Round(i.currency.Precision)
+		}
+		p.RemoteId = fmt.Sprintf("qif:%s:%s", strings.TrimSpace(fields[0]), date.Format("2006-01-02"))
+		p.PriceId = int64(len(i.Prices) + 1)
+		i.Prices = append(i.Prices, p)
+	}
+	return nil
+}
+
+// addCashTransaction adds a transaction moving total into accountid,
+// balanced by splits into the categories or transfer accounts in splits
+func (i *qifImporter) addCashTransaction(t *models.Transaction, accountid int64, total *big.Rat, status int64, remoteid, number, memo string, splits []qifSplit) error {
+	remaining := new(big.Rat).Set(total)
+	for _, s := range splits {
+		remaining.Sub(remaining, s.amount)
+	}
+	if remaining.Sign() != 0 {
+		splits = append(splits, qifSplit{amount: remaining})
+	}
+
+	accountAmount := new(big.Rat).Set(total)
+	var counterSplits []*models.Split
+	for _, s := range splits {
+		if s.amount.Sign() == 0 {
+			continue
+		}
+		counterid, transfer := i.getCounterAccount(s.category, s.amount.Sign() > 0)
+		var key string
+		if transfer {
+			key = qifTransferKey(t.Date, accountid, counterid, s.amount)
+			otherKey := key + "\x1e" + strconv.FormatInt(counterid, 10)
+			if pending := i.pendingTransfers[otherKey]; len(pending) > 0 {
+				// The other side of this transfer was already imported
+				pending[0].Status = status
+				i.pendingTransfers[otherKey] = pending[1:]
+				accountAmount.Sub(accountAmount, s.amount)
+				continue
+			}
+		}
+		split, err := i.addSplit(t, counterid, new(big.Rat).Neg(s.amount), models.Imported, remoteid, "", s.memo)
+		if err != nil {
+			return err
+		}
+		counterSplits = append(counterSplits, split)
+		if transfer {
+			key += "\x1e" + strconv.FormatInt(accountid, 10)
+			i.pendingTransfers[key] = append(i.pendingTransfers[key], split)
+		}
+	}
+	if len(counterSplits) == 0 {
+		return nil
+	}
+
+	split, err := i.addSplit(t, accountid, accountAmount, status, remoteid, number, memo)
+	if err != nil {
+		return err
+	}
+	if split != nil {
+		// Put the account's split first, like the other importers
+		t.Splits = append(t.Splits[len(t.Splits)-1:], t.Splits[:len(t.Splits)-1]...)
+	}
+	i.Transactions = append(i.Transactions, *t)
+	return nil
+}
+
+func (i *qifImporter) processBankTransaction(lines []string, accountType models.AccountType) error {
+	accountid := i.getAccount(accountType)
+
+	var t models.Transaction
+	var total *big.Rat
+	var err error
+	var haveDate bool
+	var status int64 = models.Imported
+	var number, memo, payee, category string
+	var splits []qifSplit
+
+	for _, line := range lines {
+		value := strings.TrimSpace(line[1:])
+		switch line[0] {
+		case 'D':
+			t.Date, err = parseQIFDate(value)
+			if err != nil {
+				return err
+			}
+			haveDate = true
+		case 'T', 'U':
+			if total == nil {
+				total, err = parseQIFAmount(value)
+				if err != nil {
+					return err
+				}
+			}
+		case 'C':
+			status = qifStatus(value)
+		case 'N':
+			number = value
+		case 'P':
+			payee = value
+		case 'M':
+			memo = value
+		case 'L':
+			category = value
+		case 'S':
+			splits = append(splits, qifSplit{category: value, amount: new(big.Rat)})
+		case 'E':
+			if len(splits) > 0 {
+				splits[len(splits)-1].memo = value
+			}
+		case '$':
+			if len(splits) > 0 {
+				amount, err := parseQIFAmount(value)
+				if err != nil {
+					return err
+				}
+				if amount != nil {
+					splits[len(splits)-1].amount = amount
+				}
+			}
+		}
+	}
+	if !haveDate {
+		return errors.New("QIF transaction is missing a date")
+	}
+	if total == nil {
+		total = new(big.Rat)
+		for _, s := range splits {
+			total.Add(total, s.amount)
+		}
+	}
+	if len(splits) == 0 {
+		splits = append(splits, qifSplit{category: category, amount: total})
+	}
+
+	t.Description = payee
+	if len(t.Description) == 0 {
+		t.Description = memo
+	}
+
+	remoteid := i.remoteId(accountid, lines)
+	return i.addCashTransaction(&t, accountid, total, status, remoteid, number, memo, splits)
+}
+
+// qifIncomeCategories are the categories used for investment income actions
+// which don't specify their own
+var qifIncomeCategories = map[string]string{
+	"Div":     "Dividends",
+	"IntInc":  "Interest",
+	"CGLong":  "Capital Gains:Long-Term",
+	"CGMid":   "Capital Gains:Mid-Term",
+	"CGShort": "Capital Gains:Short-Term",
+	"MiscInc": "Miscellaneous",
+	"RtrnCap": "Return of Capital",
+}
+
+var qifReinvestActions = map[string]string{
+	"ReinvDiv": "Div",
+	"ReinvInt": "IntInc",
+	"ReinvLg":  "CGLong",
+	"ReinvMd":  "CGMid",
+	"ReinvSh":  "CGShort",
+}
+
+func (i *qifImporter) processInvTransaction(lines []string) error {
+	accountid := i.getAccount(models.Investment)
+
+	var t models.Transaction
+	var err error
+	var haveDate bool
+	var status int64 = models.Imported
+	var action, security, payee, memo, category string
+	var price, quantity, total, commission, transferAmount *big.Rat
+
+	for _, line := range lines {
+		value := strings.TrimSpace(line[1:])
+		switch line[0] {
+		case 'D':
+			t.Date, err = parseQIFDate(value)
+			if err != nil {
+				return err
+			}
+			haveDate = true
+		case 'N':
+			action = value
+		case 'Y':
+			security = value
+		case 'I':
+			price, err = parseQIFAmount(value)
+		case 'Q':
+			quantity, err = parseQIFAmount(value)
+		case 'T', 'U':
+			if total == nil {
+				total, err = parseQIFAmount(value)
+			}
+		case 'O':
+			commission, err = parseQIFAmount(value)
+		case '$':
+			transferAmount, err = parseQIFAmount(value)
+		case 'C':
+			status = qifStatus(value)
+		case 'P':
+			payee = value
+		case 'M':
+			memo = value
+		case 'L':
+			category = value
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !haveDate {
+		return errors.New("QIF transaction is missing a date")
+	}
+	if commission == nil {
+		commission = new(big.Rat)
+	}
+	if quantity != nil {
+		quantity.Abs(quantity)
+	}
+	if total == nil && price != nil && quantity != nil {
+		total = new(big.Rat).Mul(price, quantity)
+		if strings.HasPrefix(action, "Buy") {
+			total.Add(total, commission)
+		} else if strings.HasPrefix(action, "Sell") {
+			total.Sub(total, commission)
+		}
+	}
+	if total == nil {
+		total = new(big.Rat)
+	}
+	// Amounts are usually positive, with the action determining the
+	// direction money moves, except for the 'Cash' action
+	negative := total.Sign() < 0
+	total.Abs(total)
+
+	t.Description = payee
+	if len(t.Description) == 0 {
+		t.Description = action
+		if len(security) > 0 {
+			t.Description += " " + security
+		}
+	}
+	remoteid := i.remoteId(accountid, lines)
+
+	// Actions ending in 'X' move the cash involved to or from another
+	// account instead of the investment account itself
+	xfer := len(action) > 1 && strings.HasSuffix(action, "X")
+	if xfer {
+		action = action[:len(action)-1]
+	}
+
+	switch action {
+	case "XIn", "XOut", "Cash":
+		amount := new(big.Rat).Set(total)
+		if action == "XOut" || (action == "Cash" && negative) {
+			amount.Neg(amount)
+		}
+		return i.addCashTransaction(&t, accountid, amount, status, remoteid, "", memo, []qifSplit{{category: category, amount: amount}})
+	}
+
+	// cash adds a split moving amount into the investment account, or into
+	// the transfer account for actions ending in 'X'
+	cash := func(amount *big.Rat) error {
+		if !xfer {
+			_, err := i.addSplit(&t, accountid, amount, status, remoteid, "", memo)
+			return err
+		}
+		if transferAmount != nil && new(big.Rat).Abs(transferAmount).Cmp(total) != 0 {
+			return errors.New("QIF investment transfer amount doesn't match transaction total")
+		}
+		if !strings.HasPrefix(strings.TrimSpace(category), "[") {
+			return errors.New("QIF investment transfer is missing its transfer account")
+		}
+		otherid, _ := i.getCounterAccount(category, false)
+		key := qifTransferKey(t.Date, otherid, accountid, amount)
+		otherKey := key + "\x1e" + strconv.FormatInt(otherid, 10)
+		if pending := i.pendingTransfers[otherKey]; len(pending) > 0 {
+			// The transfer was already imported from the other account's
+			// side, so use the cash it moved into the investment account
+			pending[0].Status = status
+			i.pendingTransfers[otherKey] = pending[1:]
+			_, err := i.addSplit(&t, accountid, amount, status, remoteid, "", memo)
+			return err
+		}
+		split, err := i.addSplit(&t, otherid, amount, models.Imported, remoteid, "", memo)
+		if err != nil {
+			return err
+		}
+		key += "\x1e" + strconv.FormatInt(accountid, 10)
+		i.pendingTransfers[key] = append(i.pendingTransfers[key], split)
+		return nil
+	}
+
+	// shares adds splits moving quantity shares of the security into the
+	// investment account, balanced by the security's trading account
+	shares := func(quantity *big.Rat) error {
+		if quantity == nil || len(security) == 0 {
+			return errors.New("QIF investment transaction is missing its security or quantity")
+		}
+		securityid := i.getSecurity(security)
+		subaccountid := i.getChildAccount(i.Securities[securityid-1].Name, models.Investment, accountid, securityid)
+		tradingid := i.getChildAccount(i.Securities[securityid-1].Name, models.Trading, i.tradingAccount(), securityid)
+		if _, err := i.addSplit(&t, subaccountid, quantity, status, remoteid, "", memo); err != nil {
+			return err
+		}
+		if _, err := i.addSplit(&t, tradingid, new(big.Rat).Neg(quantity), models.Imported, remoteid, "", memo); err != nil {
+			return err
+		}
+		if _, ok := i.holdings[subaccountid]; !ok {
+			i.holdings[subaccountid] = new(big.Rat)
+		}
+		i.holdings[subaccountid].Add(i.holdings[subaccountid], quantity)
+		return nil
+	}
+
+	// currencyTrading balances the currency spent buying (or received
+	// selling) securities
+	currencyTrading := func(amount *big.Rat) error {
+		tradingid := i.getChildAccount(i.currency.Name, models.Trading, i.tradingAccount(), i.currency.SecurityId)
+		_, err := i.addSplit(&t, tradingid, amount, models.Imported, remoteid, "", memo)
+		return err
+	}
+
+	// actionCategory returns the category from the transaction, if it has
+	// one which isn't a transfer, or the default
+	actionCategory := func(defaultCategory string, income bool) int64 {
+		if len(category) > 0 && !strings.HasPrefix(strings.TrimSpace(category), "[") {
+			return i.getCategory(category, income)
+		}
+		return i.getCategory(defaultCategory, income)
+	}
+
+	addCommission := func() error {
+		if commission.Sign() == 0 {
+			return nil
+		}
+		_, err := i.addSplit(&t, i.getCategory("Commissions", false), commission, models.Imported, remoteid, "", memo)
+		return err
+	}
+
+	switch action {
+	case "Buy":
+		err = cash(new(big.Rat).Neg(total))
+		if err == nil {
+			err = addCommission()
+		}
+		if err == nil {
+			err = currencyTrading(new(big.Rat).Sub(total, commission))
+		}
+		if err == nil {
+			err = shares(quantity)
+		}
+	case "Sell":
+		err = cash(total)
+		if err == nil {
+			err = addCommission()
+		}
+		if err == nil {
+			err = currencyTrading(new(big.Rat).Neg(new(big.Rat).Add(total, commission)))
+		}
+		if err == nil {
+			err = shares(qifNeg(quantity))
+		}
+	case "Div", "IntInc", "CGLong", "CGMid", "CGShort", "MiscInc", "RtrnCap":
+		err = cash(total)
+		if err == nil {
+			_, err = i.addSplit(&t, actionCategory(qifIncomeCategories[action], true), new(big.Rat).Neg(total), models.Imported, remoteid, "", memo)
+		}
+	case "MiscExp", "MargInt":
+		err = cash(new(big.Rat).Neg(total))
+		if err == nil {
+			_, err = i.addSplit(&t, actionCategory("Miscellaneous", false), total, models.Imported, remoteid, "", memo)
+		}
+	case "ReinvDiv", "ReinvInt", "ReinvLg", "ReinvMd", "ReinvSh":
+		_, err = i.addSplit(&t, actionCategory(qifIncomeCategories[qifReinvestActions[action]], true), new(big.Rat).Neg(total), models.Imported, remoteid, "", memo)
+		if err == nil {
+			err = currencyTrading(total)
+		}
+		if err == nil {
+			err = shares(quantity)
+		}
+	case "ShrsIn":
+		err = shares(quantity)
+	case "ShrsOut":
+		err = shares(qifNeg(quantity))
+	case "StkSplit":
+		// Quicken records the split ratio multiplied by 10, so a 2-for-1
+		// split has a quantity of 20
+		if quantity == nil || len(security) == 0 {
+			return errors.New("QIF stock split is missing its security or ratio")
+		}
+		securityid := i.getSecurity(security)
+		subaccountid := i.getChildAccount(i.Securities[securityid-1].Name, models.Investment, accountid, securityid)
+		held, ok := i.holdings[subaccountid]
+		if !ok {
+			held = new(big.Rat)
+		}
+		ratio := new(big.Rat).Quo(quantity, big.NewRat(10, 1))
+		err = shares(new(big.Rat).Mul(held, ratio.Sub(ratio, big.NewRat(1, 1))))
+	default:
+		return fmt.Errorf("Unsupported QIF investment action \"%s\"", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(t.Splits) > 0 {
+		i.Transactions = append(i.Transactions, t)
+	}
+	return nil
+}
+
+// tradingAccount returns the top-level trading account, structured the same
+// way as those created by GetTradingAccount
+func (i *qifImporter) tradingAccount() int64 {
+	return i.getChildAccount("Trading", models.Trading, -1, i.currency.SecurityId)
+}
+
+func (i *qifImporter) processRecord(section string, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	switch section {
+	case "account":
+		i.processAccount(lines)
+	case "type:cat":
+		i.processCategory(lines)
+	case "type:security":
+		i.processSecurity(lines)
+	case "type:prices":
+		return i.processPrices(lines)
+	case "type:invst":
+		return i.processInvTransaction(lines)
+	case "type:bank", "type:ccard", "type:cash", "type:otha", "type:othl":
+		return i.processBankTransaction(lines, qifAccountTypes[section[len("type:"):]])
+	}
+	// Anything else (classes, memorized transactions, etc.) is ignored
+	return nil
+}
+
+// ImportQIF parses the QIF file in r. QIF files contain no currency
+// information, so all amounts are assumed to be in currency. Transactions not
+// preceded by an account header are imported into an account named
+// defaultAccountName.
+func ImportQIF(r io.Reader, currency *models.Security, defaultAccountName string) (*QIFImport, error) {
+	i := qifImporter{
+		defaultAccountName: defaultAccountName,
+		qifAccounts:        make(map[string]int64),
+		childAccounts:      make(map[string]int64),
+		categories:         make(map[string]int64),
+		categoryIncome:     make(map[string]bool),
+		securities:         make(map[string]int64),
+		pendingTransfers:   make(map[string][]*models.Split),
+		holdings:           make(map[int64]*big.Rat),
+		seen:               make(map[string]int),
+	}
+
+	i.currency = *currency
+	i.currency.SecurityId = 1
+	i.Securities = append(i.Securities, i.currency)
+
+	var section string
+	var record []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if lineno == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		switch line[0] {
+		case '!':
+			header := qifNormalize(line[1:])
+			if strings.HasPrefix(header, "option:") || strings.HasPrefix(header, "clear:") {
+				continue
+			}
+			if err := i.processRecord(section, record); err != nil {
+				return nil, fmt.Errorf("QIF line %d: %s", lineno, err)
+			}
+			section = header
+			record = nil
+		case '^':
+			if err := i.processRecord(section, record); err != nil {
+				return nil, fmt.Errorf("QIF line %d: %s", lineno, err)
+			}
+			record = nil
+		default:
+			record = append(record, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := i.processRecord(section, record); err != nil {
+		return nil, fmt.Errorf("QIF: %s", err)
+	}
+
+	return &i.QIFImport, nil
+}
+
+func QIFImportHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method != "POST" {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	currency, err := context.Tx.GetSecurity(user.DefaultCurrency, user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	// Assume there is only one 'part' and it's the one we care about
+	part, err := multipartReader.NextPart()
+	if err != nil {
+		if err == io.EOF {
+			return NewError(3 /*Invalid Request*/)
+		} else {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(part.FileName()), filepath.Ext(part.FileName()))
+	if len(name) == 0 || name == "." {
+		name = "QIF Import"
+	}
+
+	qifImport, err := ImportQIF(part, currency, name)
+	if err != nil {
+		log.Print(err)
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	state, err := newImportState(context.Tx, user, models.ImportOptions{})
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return importBookHelper(context.Tx, user, state, qifImport.Securities, qifImport.Prices, qifImport.Accounts, qifImport.Transactions)
+}
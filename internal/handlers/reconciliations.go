@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"log"
+	"net/http"
+	"time"
+)
+
+// reconciliationStatus sets r's StartingBalance, ClearedBalance, Difference,
+// and Transactions from the account's current splits. Any selected splits
+// which can't be reconciled (because they are no longer in the account, are
+// dated after the statement, or are already reconciled or voided) are dropped
+// from r's SplitIds, and false is returned.
+func reconciliationStatus(tx store.Tx, user *models.User, r *models.Reconciliation) (bool, error) {
+	starting, err := tx.GetAccountReconciledBalance(user, r.AccountId)
+	if err != nil {
+		return false, err
+	}
+	transactions, err := tx.GetUnreconciledTransactions(user, r.AccountId, &r.StatementDate)
+	if err != nil {
+		return false, err
+	}
+
+	candidates := make(map[int64]*models.Split)
+	for _, t := range *transactions {
+		for _, split := range t.Splits {
+			if split.AccountId == r.AccountId && split.Status != models.Reconciled && split.Status != models.Voided {
+				candidates[split.SplitId] = split
+			}
+		}
+	}
+
+	valid := true
+	var splitids []int64
+	selected := make(map[int64]bool)
+	r.ClearedBalance.Set(&starting.Rat)
+	for _, splitid := range r.SplitIds {
+		split, ok := candidates[splitid]
+		if !ok {
+			valid = false
+			continue
+		}
+		if selected[splitid] {
+			continue
+		}
+		selected[splitid] = true
+		splitids = append(splitids, splitid)
+		r.ClearedBalance.Add(&r.ClearedBalance.Rat, &split.Amount.Rat)
+	}
+
+	r.SplitIds = splitids
+	r.StartingBalance.Set(&starting.Rat)
+	r.Difference.Sub(&r.StatementBalance.Rat, &r.ClearedBalance.Rat)
+	r.Transactions = transactions
+	return valid, nil
+}
+
+// AccountReconciliationHandler starts, updates, finishes, or cancels
+// reconciliations of an account against its statements. A reconciliation is
+// finished by updating it with Finished set, which fails with an 'Unbalanced
+// Reconciliation' error unless the cleared balance matches the statement's.
+func AccountReconciliationHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
+	account, err := context.Tx.GetAccount(accountid, user.UserId)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	if r.Method == "POST" {
+		if !context.LastLevel() {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		var reconciliation models.Reconciliation
+		if err := ReadJSON(r, &reconciliation); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Only one reconciliation may be in progress for an account at once
+		existing, err := context.Tx.GetReconciliations(account.AccountId, user.UserId)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		for _, e := range *existing {
+			if !e.Finished {
+				return NewError(3 /*Invalid Request*/)
+			}
+		}
+
+		reconciliation.ReconciliationId = -1
+		reconciliation.UserId = user.UserId
+		reconciliation.AccountId = account.AccountId
+		reconciliation.StatementDate = reconciliation.StatementDate.UTC()
+		reconciliation.Finished = false
+		reconciliation.FinishedDate = time.Time{}
+
+		// Start with the splits which have already been cleared selected
+		transactions, err := context.Tx.GetUnreconciledTransactions(user, account.AccountId, &reconciliation.StatementDate)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		reconciliation.SplitIds = nil
+		for _, t := range *transactions {
+			for _, split := range t.Splits {
+				if split.AccountId == account.AccountId && split.Status == models.Cleared {
+					reconciliation.SplitIds = append(reconciliation.SplitIds, split.SplitId)
+				}
+			}
+		}
+		if _, err := reconciliationStatus(context.Tx, user, &reconciliation); err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		err = context.Tx.InsertReconciliation(&reconciliation)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return ResponseWrapper{201, &reconciliation}
+	} else if r.Method == "GET" && context.LastLevel() {
+		//Return all Reconciliations for this account
+		var rl models.ReconciliationList
+		reconciliations, err := context.Tx.GetReconciliations(account.AccountId, user.UserId)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		rl.Reconciliations = reconciliations
+		return &rl
+	}
+
+	reconciliationid, err := context.NextID()
+	if err != nil || !context.LastLevel() {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	reconciliation, err := context.Tx.GetReconciliation(reconciliationid, account.AccountId, user.UserId)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	if r.Method == "GET" {
+		if !reconciliation.Finished {
+			if _, err := reconciliationStatus(context.Tx, user, reconciliation); err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+		}
+		return reconciliation
+	}
+
+	// Finished reconciliations are kept as a record, and can't be changed
+	if reconciliation.Finished {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	if r.Method == "PUT" {
+		var newreconciliation models.Reconciliation
+		if err := ReadJSON(r, &newreconciliation); err != nil || newreconciliation.ReconciliationId != reconciliationid {
+			return NewError(3 /*Invalid Request*/)
+		}
+		reconciliation.StatementDate = newreconciliation.StatementDate.UTC()
+		reconciliation.StatementBalance = newreconciliation.StatementBalance
+		reconciliation.SplitIds = newreconciliation.SplitIds
+
+		valid, err := reconciliationStatus(context.Tx, user, reconciliation)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		if !valid {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if newreconciliation.Finished {
+			if reconciliation.Difference.Sign() != 0 {
+				return NewError(9 /*Unbalanced Reconciliation*/)
+			}
+			reconciliation.FinishedDate = time.Now().UTC()
+			err = context.Tx.FinishReconciliation(reconciliation, user)
+			reconciliation.Transactions = nil
+		} else {
+			err = context.Tx.UpdateReconciliation(reconciliation)
+		}
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return reconciliation
+	} else if r.Method == "DELETE" {
+		err = context.Tx.DeleteReconciliation(reconciliation)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return SuccessWriter{}
+	}
+	return NewError(3 /*Invalid Request*/)
+}
+
+// reconciledSplitsChanged returns whether replacing existing with t, or
+// deleting existing if t is nil, would change or remove any of existing's
+// Reconciled splits. Moving a transaction with Reconciled splits to another
+// date, or marking any split Reconciled which isn't already, also counts as a
+// change, since only reconciliations may reconcile splits.
+func reconciledSplitsChanged(existing, t *models.Transaction) bool {
+	reconciled := make(map[int64]bool)
+	for _, split := range existing.Splits {
+		if split.Status != models.Reconciled {
+			continue
+		}
+		if t == nil || !t.Date.Equal(existing.Date) {
+			return true
+		}
+		unchanged := false
+		for _, s := range t.Splits {
+			if s.SplitId == split.SplitId {
+				unchanged = s.Status == split.Status && s.AccountId == split.AccountId && s.SecurityId == split.SecurityId && s.Amount.Cmp(&split.Amount.Rat) == 0
+				break
+			}
+		}
+		if !unchanged {
+			return true
+		}
+		reconciled[split.SplitId] = true
+	}
+	if t != nil {
+		for _, s := range t.Splits {
+			if s.Status == models.Reconciled && !reconciled[s.SplitId] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// importRules holds a user's rules, along with the accounts they assign splits
+// to, while categorizing the transactions from an import
+type importRules struct {
+	rules    []*models.Rule
+	accounts map[int64]*models.Account
+}
+
+func getImportRules(tx store.Tx, user *models.User) (*importRules, error) {
+	rules, err := tx.GetRules(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	ir := importRules{rules: *rules, accounts: make(map[int64]*models.Account)}
+	for _, rule := range *rules {
+		for _, target := range rule.Targets {
+			if _, ok := ir.accounts[target.AccountId]; ok {
+				continue
+			}
+			account, err := tx.GetAccount(target.AccountId, user.UserId)
+			if err != nil {
+				return nil, err
+			}
+			ir.accounts[target.AccountId] = account
+		}
+	}
+	return &ir, nil
+}
+
+func ruleTextMatches(matchType int64, pattern, text string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if matchType == models.RegexpMatch {
+		matched, err := regexp.MatchString(pattern, text)
+		return err == nil && matched
+	}
+	return strings.Contains(strings.ToUpper(text), strings.ToUpper(pattern))
+}
+
+// match returns the first rule matching split, which isn't yet associated with
+// an account, from a transaction with the given description being imported
+// into account
+func (ir *importRules) match(description string, split *models.Split, account *models.Account) *models.Rule {
+	for _, rule := range ir.rules {
+		if rule.SourceAccountId != -1 && rule.SourceAccountId != account.AccountId {
+			continue
+		}
+		if rule.ImportSplitType != -1 && rule.ImportSplitType != split.ImportSplitType {
+			continue
+		}
+		if rule.MinAmount != nil && split.Amount.Cmp(&rule.MinAmount.Rat) < 0 {
+			continue
+		}
+		if rule.MaxAmount != nil && split.Amount.Cmp(&rule.MaxAmount.Rat) > 0 {
+			continue
+		}
+		if !ruleTextMatches(rule.MatchType, rule.DescriptionMatch, description) ||
+			!ruleTextMatches(rule.MatchType, rule.MemoMatch, split.Memo) {
+			continue
+		}
+
+		// Assigning the split to an account holding a different security
+		// would unbalance the transaction
+		compatible := true
+		for _, target := range rule.Targets {
+			if ir.accounts[target.AccountId].SecurityId != split.SecurityId {
+				compatible = false
+			}
+		}
+		if compatible {
+			return rule
+		}
+	}
+	return nil
+}
+
+// categorize applies the first matching rule to each of transaction's splits
+// which isn't yet associated with an account. Rules are matched against the
+// transaction's description as imported, even if an earlier split's rule
+// rewrote it.
+func (ir *importRules) categorize(tx store.Tx, user *models.User, account *models.Account, transaction *models.Transaction) error {
+	description := transaction.Description
+	var splits []*models.Split
+	for _, split := range transaction.Splits {
+		var rule *models.Rule
+		if split.AccountId == -1 {
+			rule = ir.match(description, split, account)
+		}
+		if rule == nil {
+			splits = append(splits, split)
+			continue
+		}
+
+		if len(rule.Description) > 0 {
+			transaction.Description = rule.Description
+		}
+		if len(rule.Targets) == 0 {
+			splits = append(splits, split)
+			continue
+		}
+
+		security, err := tx.GetSecurity(split.SecurityId, user.UserId)
+		if err != nil {
+			return err
+		}
+
+		// Divide the split's amount between the targets, giving any
+		// remainder left after rounding to the last
+		var remaining big.Rat
+		remaining.Set(&split.Amount.Rat)
+		for i, target := range rule.Targets {
+			s := *split
+			s.Amount = models.Amount{} // Don't share the underlying big.Rat
+			s.AccountId = target.AccountId
+			s.SecurityId = -1
+			if i == len(rule.Targets)-1 {
+				s.Amount.Set(&remaining)
+			} else {
+				s.Amount.Mul(&split.Amount.Rat, &target.Percent.Rat)
+				s.Amount.Quo(&s.Amount.Rat, big.NewRat(100, 1))
+				s.Amount.Round(security.Precision)
+				remaining.Sub(&remaining, &s.Amount.Rat)
+			}
+			splits = append(splits, &s)
+		}
+	}
+	transaction.Splits = splits
+	return nil
+}
+
+// validRule returns whether rule is valid and only refers to user's accounts
+func validRule(tx store.Tx, user *models.User, rule *models.Rule) bool {
+	if !rule.Valid() {
+		return false
+	}
+	if rule.SourceAccountId != -1 {
+		if _, err := tx.GetAccount(rule.SourceAccountId, user.UserId); err != nil {
+			return false
+		}
+	}
+	for _, target := range rule.Targets {
+		if _, err := tx.GetAccount(target.AccountId, user.UserId); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func RuleHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method == "POST" {
+		var rule models.Rule
+		if err := ReadJSON(r, &rule); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		rule.RuleId = -1
+		rule.UserId = user.UserId
+
+		if !validRule(context.Tx, user, &rule) {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		err = context.Tx.InsertRule(&rule)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return ResponseWrapper{201, &rule}
+	} else if r.Method == "GET" {
+		if context.LastLevel() {
+			//Return all Rules
+			var rl models.RuleList
+			rules, err := context.Tx.GetRules(user.UserId)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			rl.Rules = rules
+			return &rl
+		}
+
+		ruleid, err := context.NextID()
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Return Rule with this Id
+		rule, err := context.Tx.GetRule(ruleid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		return rule
+	} else {
+		ruleid, err := context.NextID()
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Ensure the rule exists and belongs to this user
+		rule, err := context.Tx.GetRule(ruleid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if r.Method == "PUT" {
+			var newrule models.Rule
+			if err := ReadJSON(r, &newrule); err != nil || newrule.RuleId != ruleid {
+				return NewError(3 /*Invalid Request*/)
+			}
+			newrule.UserId = user.UserId
+
+			if !validRule(context.Tx, user, &newrule) {
+				return NewError(3 /*Invalid Request*/)
+			}
+
+			err = context.Tx.UpdateRule(&newrule)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return &newrule
+		} else if r.Method == "DELETE" {
+			err = context.Tx.DeleteRule(rule)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return SuccessWriter{}
+		}
+	}
+	return NewError(3 /*Invalid Request*/)
+}
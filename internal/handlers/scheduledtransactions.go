@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	maxScheduledDueDays = 366
+	// Most occurrences of one scheduled transaction created by a single
+	// check (the rest are created by later checks), or listed as due
+	maxScheduledOccurrences = 1000
+)
+
+// Scheduler creates the transactions (or reminders) for scheduled
+// transactions as they come due
+type Scheduler struct {
+	Store store.Store
+}
+
+// Run creates the scheduled transactions which have come due, checking every
+// interval, until stop is closed
+func (s *Scheduler) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.CreateDue(time.Now()); err != nil {
+			log.Print(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CreateDue creates a transaction (or reminder) for every occurrence of a
+// scheduled transaction which has come due by now, including any which were
+// missed while the scheduler wasn't running (up to maxScheduledOccurrences of
+// each per call). Failing to create those for one scheduled transaction
+// doesn't prevent creating them for the others.
+func (s *Scheduler) CreateDue(now time.Time) error {
+	now = now.UTC()
+
+	tx, err := s.Store.Begin()
+	if err != nil {
+		return err
+	}
+	due, err := tx.GetDueScheduledTransactions(&now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, scheduled := range *due {
+		if err := s.createDue(scheduled, now); err != nil {
+			log.Printf("Error creating scheduled transaction %d: %s", scheduled.ScheduledTransactionId, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) createDue(scheduled *models.ScheduledTransaction, now time.Time) error {
+	tx, err := s.Store.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Re-read it in this transaction, in case it was changed since
+	scheduled, err = tx.GetScheduledTransaction(scheduled.ScheduledTransactionId, scheduled.UserId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	user, err := tx.GetUser(scheduled.UserId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for n := 0; n < maxScheduledOccurrences && !scheduled.Finished && !scheduled.NextDate.After(now); n++ {
+		if scheduled.RemindOnly {
+			err = tx.InsertReminder(&models.Reminder{
+				ReminderId:             -1,
+				UserId:                 scheduled.UserId,
+				ScheduledTransactionId: scheduled.ScheduledTransactionId,
+				Date:                   scheduled.NextDate,
+			})
+		} else {
+			err = tx.InsertTransaction(scheduledOccurrence(scheduled, scheduled.NextDate), user)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		scheduled.Advance()
+	}
+
+	if err := tx.UpdateScheduledTransaction(scheduled); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// scheduledOccurrence returns the transaction for the occurrence of scheduled
+// on date
+func scheduledOccurrence(scheduled *models.ScheduledTransaction, date time.Time) *models.Transaction {
+	t := &models.Transaction{
+		TransactionId: -1,
+		UserId:        scheduled.UserId,
+		Description:   scheduled.Template.Description,
+		Date:          date,
+	}
+	for _, split := range scheduled.Template.Splits {
+		s := &models.Split{
+			SplitId:       -1,
+			TransactionId: -1,
+			Status:        models.Entered,
+			AccountId:     split.AccountId,
+			SecurityId:    -1,
+			Number:        split.Number,
+			Memo:          split.Memo,
+		}
+		s.Amount.Set(&split.Amount.Rat)
+		t.Splits = append(t.Splits, s)
+	}
+	return t
+}
+
+// validScheduledTransaction returns whether scheduled is valid, only refers to
+// user's accounts with amounts they can hold, and would create balanced
+// transactions
+func validScheduledTransaction(tx store.Tx, user *models.User, scheduled *models.ScheduledTransaction) bool {
+	if !scheduled.Valid() {
+		return false
+	}
+	for _, split := range scheduled.Template.Splits {
+		account, err := tx.GetAccount(split.AccountId, user.UserId)
+		if err != nil {
+			return false
+		}
+		security, err := tx.GetSecurity(account.SecurityId, user.UserId)
+		if err != nil {
+			return false
+		}
+		if _, err := split.Amount.Whole(); err != nil || split.Amount.Precision() > security.Precision {
+			return false
+		}
+	}
+	balanced, err := TransactionBalanced(tx, scheduledOccurrence(scheduled, scheduled.Start))
+	return err == nil && balanced
+}
+
+// ScheduledTransactionsDueHandler returns the occurrences of the user's
+// scheduled transactions which will come due within the number of days in the
+// 'days' query parameter (7 by default, at most maxScheduledDueDays), along
+// with any which have come due but haven't been created yet, in the order
+// they come due. At most maxScheduledOccurrences are returned for each
+// scheduled transaction.
+func ScheduledTransactionsDueHandler(r *http.Request, tx store.Tx, user *models.User) ResponseWriterWriter {
+	days := int64(7)
+	query, _ := url.ParseQuery(r.URL.RawQuery)
+	if daysstr := query.Get("days"); len(daysstr) > 0 {
+		var err error
+		days, err = strconv.ParseInt(daysstr, 10, 0)
+		if err != nil || days < 0 || days > maxScheduledDueDays {
+			return NewError(3 /*Invalid Request*/)
+		}
+	}
+	until := time.Now().UTC().AddDate(0, 0, int(days))
+
+	scheduled, err := tx.GetScheduledTransactions(user.UserId)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	occurrences := []*models.ScheduledOccurrence{}
+	for _, s := range *scheduled {
+		for n := 0; n < maxScheduledOccurrences && !s.Finished && !s.NextDate.After(until); n++ {
+			occurrences = append(occurrences, &models.ScheduledOccurrence{
+				ScheduledTransactionId: s.ScheduledTransactionId,
+				Date:                   s.NextDate,
+				Transaction:            scheduledOccurrence(s, s.NextDate),
+			})
+			s.Advance()
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].Date.Before(occurrences[j].Date)
+	})
+
+	return &models.ScheduledOccurrenceList{ScheduledOccurrences: &occurrences}
+}
+
+func ScheduledTransactionHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method == "POST" {
+		var scheduled models.ScheduledTransaction
+		if err := ReadJSON(r, &scheduled); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		scheduled.ScheduledTransactionId = -1
+		scheduled.UserId = user.UserId
+		scheduled.Start = scheduled.Start.UTC()
+		scheduled.End = scheduled.End.UTC()
+
+		if !validScheduledTransaction(context.Tx, user, &scheduled) {
+			return NewError(3 /*Invalid Request*/)
+		}
+		scheduled.Template = *scheduledOccurrence(&scheduled, time.Time{})
+
+		scheduled.Count = 0
+		scheduled.LastDate = time.Time{}
+		scheduled.Reschedule()
+
+		err = context.Tx.InsertScheduledTransaction(&scheduled)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return ResponseWrapper{201, &scheduled}
+	} else if r.Method == "GET" {
+		if context.LastLevel() {
+			//Return all ScheduledTransactions
+			var sl models.ScheduledTransactionList
+			scheduled, err := context.Tx.GetScheduledTransactions(user.UserId)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			sl.ScheduledTransactions = scheduled
+			return &sl
+		}
+
+		level := context.NextLevel()
+		if level == "due" {
+			if !context.LastLevel() {
+				return NewError(3 /*Invalid Request*/)
+			}
+			return ScheduledTransactionsDueHandler(r, context.Tx, user)
+		}
+		scheduledid, err := strconv.ParseInt(level, 0, 64)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Return ScheduledTransaction with this Id
+		scheduled, err := context.Tx.GetScheduledTransaction(scheduledid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		return scheduled
+	} else {
+		scheduledid, err := context.NextID()
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Ensure the scheduled transaction exists and belongs to this user
+		scheduled, err := context.Tx.GetScheduledTransaction(scheduledid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if r.Method == "PUT" {
+			var newscheduled models.ScheduledTransaction
+			if err := ReadJSON(r, &newscheduled); err != nil || newscheduled.ScheduledTransactionId != scheduledid {
+				return NewError(3 /*Invalid Request*/)
+			}
+			newscheduled.UserId = user.UserId
+			newscheduled.Start = newscheduled.Start.UTC()
+			newscheduled.End = newscheduled.End.UTC()
+
+			if !validScheduledTransaction(context.Tx, user, &newscheduled) {
+				return NewError(3 /*Invalid Request*/)
+			}
+			newscheduled.Template = *scheduledOccurrence(&newscheduled, time.Time{})
+
+			// Continue after the last occurrence which came due, even if
+			// the recurrence rule changed
+			newscheduled.Count = scheduled.Count
+			newscheduled.LastDate = scheduled.LastDate
+			newscheduled.Reschedule()
+
+			err = context.Tx.UpdateScheduledTransaction(&newscheduled)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return &newscheduled
+		} else if r.Method == "DELETE" {
+			err = context.Tx.DeleteScheduledTransaction(scheduled)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return SuccessWriter{}
+		}
+	}
+	return NewError(3 /*Invalid Request*/)
+}
+
+// ReminderHandler returns the user's reminders, or dismisses one of them
+func ReminderHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method == "GET" && context.LastLevel() {
+		//Return all Reminders
+		var rl models.ReminderList
+		reminders, err := context.Tx.GetReminders(user.UserId)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		rl.Reminders = reminders
+		return &rl
+	}
+
+	reminderid, err := context.NextID()
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	reminder, err := context.Tx.GetReminder(reminderid, user.UserId)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	if r.Method == "GET" {
+		return reminder
+	} else if r.Method == "DELETE" {
+		err = context.Tx.DeleteReminder(reminder)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return SuccessWriter{}
+	}
+	return NewError(3 /*Invalid Request*/)
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SuggestionThreshold is the confidence at or above which the top suggestion
+// for an imported split is applied automatically
+const SuggestionThreshold float64 = 0.75
+
+// maxSuggestions is the most suggestions returned for any one split
+const maxSuggestions = 5
+
+// payeeNoise contains tokens common in the descriptions of imported
+// transactions which say nothing about who the payee is
+var payeeNoise = map[string]bool{
+	"ACH":      true,
+	"CARD":     true,
+	"CHECK":    true,
+	"CRD":      true,
+	"CREDIT":   true,
+	"DEBIT":    true,
+	"DEPOSIT":  true,
+	"PAYMENT":  true,
+	"POS":      true,
+	"PURCHASE": true,
+	"TRAN":     true,
+}
+
+// payeeTokens normalizes a transaction's description into the set of tokens
+// identifying its payee, ignoring case, digits, and punctuation
+func payeeTokens(description string) map[string]bool {
+	tokens := make(map[string]bool)
+	notLetter := func(r rune) bool { return !unicode.IsLetter(r) }
+	for _, token := range strings.FieldsFunc(strings.ToUpper(description), notLetter) {
+		if len(token) > 1 && !payeeNoise[token] {
+			tokens[token] = true
+		}
+	}
+	return tokens
+}
+
+// tokenSimilarity returns the Jaccard index of two sets of tokens
+func tokenSimilarity(a, b map[string]bool) float64 {
+	var intersection int
+	for token := range a {
+		if b[token] {
+			intersection++
+		}
+	}
+	union := len(a) + len(b) - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
+// amountSimilarity returns 1 for identical amounts, approaching 0 as their
+// magnitudes diverge, and 0 if they have different signs
+func amountSimilarity(a, b *big.Rat) float64 {
+	if a.Sign() != b.Sign() {
+		return 0
+	} else if a.Sign() == 0 {
+		return 1
+	}
+	x, _ := a.Float64()
+	y, _ := b.Float64()
+	x, y = math.Abs(x), math.Abs(y)
+	return math.Min(x, y) / math.Max(x, y)
+}
+
+// uncategorizedAccounts returns the set of accounts whose splits haven't
+// really been categorized: the Imbalances accounts, and Trading accounts
+func uncategorizedAccounts(accounts []*models.Account) map[int64]bool {
+	uncategorized := make(map[int64]bool)
+	for _, account := range accounts {
+		if account.Type == models.Trading || (account.ParentAccountId == -1 && account.Name == "Imbalances") {
+			uncategorized[account.AccountId] = true
+		}
+	}
+	for _, account := range accounts {
+		if uncategorized[account.ParentAccountId] {
+			uncategorized[account.AccountId] = true
+		}
+	}
+	return uncategorized
+}
+
+// categorizedSplit is an example, from an existing transaction, of a split
+// categorized into accountId in a transaction also involving sourceAccountId
+type categorizedSplit struct {
+	transactionId   int64
+	tokens          map[string]bool
+	amount          *big.Rat
+	sourceAccountId int64
+	accountId       int64
+}
+
+// suggester predicts which accounts splits belong in by comparing them to
+// those in a user's existing, categorized transactions
+type suggester struct {
+	examples      []categorizedSplit
+	accounts      map[int64]*models.Account
+	uncategorized map[int64]bool
+}
+
+func newSuggester(tx store.Tx, user *models.User) (*suggester, error) {
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	s := suggester{
+		accounts:      make(map[int64]*models.Account),
+		uncategorized: uncategorizedAccounts(*accounts),
+	}
+	for _, account := range *accounts {
+		s.accounts[account.AccountId] = account
+	}
+
+	transactions, err := tx.GetTransactions(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range *transactions {
+		tokens := payeeTokens(t.Description)
+		if len(tokens) == 0 {
+			continue
+		}
+		for _, split := range t.Splits {
+			if split.AccountId == -1 || s.uncategorized[split.AccountId] {
+				continue
+			}
+			for _, source := range t.Splits {
+				if source.AccountId == -1 || source.AccountId == split.AccountId || s.uncategorized[source.AccountId] {
+					continue
+				}
+				s.examples = append(s.examples, categorizedSplit{
+					transactionId:   t.TransactionId,
+					tokens:          tokens,
+					amount:          &split.Amount.Rat,
+					sourceAccountId: source.AccountId,
+					accountId:       split.AccountId,
+				})
+			}
+		}
+	}
+	return &s, nil
+}
+
+// suggest returns suggested accounts holding securityid for split, from a
+// transaction with the given description involving sourceAccountId, in
+// descending order of confidence. Examples from the transaction with
+// excludeTransactionId are ignored.
+func (s *suggester) suggest(description string, split *models.Split, sourceAccountId, securityid, excludeTransactionId int64) []*models.Suggestion {
+	tokens := payeeTokens(description)
+	scores := make(map[int64]float64)
+	best := make(map[int64]float64)
+	var total float64
+	for _, e := range s.examples {
+		if e.transactionId == excludeTransactionId || e.accountId == sourceAccountId || s.accounts[e.accountId].SecurityId != securityid {
+			continue
+		}
+		similarity := tokenSimilarity(tokens, e.tokens)
+		if similarity == 0 {
+			continue
+		}
+
+		// The payee counts for the most, but similar amounts and coming
+		// from the same account make a match more likely
+		weight := similarity * (0.8 + 0.2*amountSimilarity(&split.Amount.Rat, e.amount))
+		if e.sourceAccountId != sourceAccountId {
+			weight *= 0.8
+		}
+		scores[e.accountId] += weight
+		total += weight
+		if weight > best[e.accountId] {
+			best[e.accountId] = weight
+		}
+	}
+
+	// An account's confidence is how well its best example matches, scaled
+	// by how much of the evidence points to it over the alternatives
+	var suggestions []*models.Suggestion
+	for accountid, score := range scores {
+		suggestions = append(suggestions, &models.Suggestion{
+			SplitId:    split.SplitId,
+			AccountId:  accountid,
+			Confidence: best[accountid] * score / total,
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].AccountId < suggestions[j].AccountId
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions
+}
+
+// categorize assigns each of transaction's splits which isn't yet associated
+// with an account to its top suggestion, if that is at least
+// SuggestionThreshold confident
+func (s *suggester) categorize(account *models.Account, transaction *models.Transaction) {
+	for _, split := range transaction.Splits {
+		if split.AccountId != -1 {
+			continue
+		}
+		suggestions := s.suggest(transaction.Description, split, account.AccountId, split.SecurityId, -1)
+		if len(suggestions) > 0 && suggestions[0].Confidence >= SuggestionThreshold {
+			split.AccountId = suggestions[0].AccountId
+			split.SecurityId = -1
+		}
+	}
+}
+
+// TransactionSuggestionsHandler returns suggestions for the splits of a
+// transaction which are still in an Imbalances account
+func TransactionSuggestionsHandler(tx store.Tx, user *models.User, transactionid int64) ResponseWriterWriter {
+	transaction, err := tx.GetTransaction(transactionid, user.UserId)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	s, err := newSuggester(tx, user)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	// Use the first categorized split as the source account
+	sourceAccountId := int64(-1)
+	for _, split := range transaction.Splits {
+		if !s.uncategorized[split.AccountId] {
+			sourceAccountId = split.AccountId
+			break
+		}
+	}
+
+	suggestions := []*models.Suggestion{}
+	for _, split := range transaction.Splits {
+		account, ok := s.accounts[split.AccountId]
+		if !ok || account.Type == models.Trading || !s.uncategorized[split.AccountId] {
+			continue
+		}
+		suggestions = append(suggestions, s.suggest(transaction.Description, split, sourceAccountId, account.SecurityId, transaction.TransactionId)...)
+	}
+
+	return &models.SuggestionList{Suggestions: &suggestions}
+}
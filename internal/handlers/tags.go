@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// validTag returns whether tag is valid and its name isn't already used by
+// another of the user's tags
+func validTag(tx store.Tx, user *models.User, tag *models.Tag) (bool, error) {
+	if !tag.Valid() {
+		return false, nil
+	}
+	tags, err := tx.GetTags(user.UserId)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range *tags {
+		if t.TagId != tag.TagId && strings.EqualFold(t.Name, tag.Name) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// validTransactionTags returns whether all the tags attached to t and its
+// splits belong to the user
+func validTransactionTags(tx store.Tx, user *models.User, t *models.Transaction) bool {
+	for _, tagid := range t.TagIds {
+		if _, err := tx.GetTag(tagid, user.UserId); err != nil {
+			return false
+		}
+	}
+	for _, split := range t.Splits {
+		for _, tagid := range split.TagIds {
+			if _, err := tx.GetTag(tagid, user.UserId); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// queryTagId returns the ID of the user's tag in the 'tag' query parameter, or
+// -1 if it wasn't given
+func queryTagId(tx store.Tx, user *models.User, query url.Values) (int64, error) {
+	tagstring := query.Get("tag")
+	if tagstring == "" {
+		return -1, nil
+	}
+	tagid, err := strconv.ParseInt(tagstring, 10, 64)
+	if err != nil {
+		return -1, err
+	}
+	if _, err := tx.GetTag(tagid, user.UserId); err != nil {
+		return -1, errors.New("Tag not found")
+	}
+	return tagid, nil
+}
+
+func TagHandler(r *http.Request, context *Context) ResponseWriterWriter {
+	user, err := GetUserFromSession(context.Tx, r)
+	if err != nil {
+		return NewError(1 /*Not Signed In*/)
+	}
+
+	if r.Method == "POST" {
+		var tag models.Tag
+		if err := ReadJSON(r, &tag); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		tag.TagId = -1
+		tag.UserId = user.UserId
+		tag.Name = strings.TrimSpace(tag.Name)
+
+		valid, err := validTag(context.Tx, user, &tag)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+		if !valid {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		err = context.Tx.InsertTag(&tag)
+		if err != nil {
+			log.Print(err)
+			return NewError(999 /*Internal Error*/)
+		}
+
+		return ResponseWrapper{201, &tag}
+	} else if r.Method == "GET" {
+		if context.LastLevel() {
+			//Return all Tags
+			var tl models.TagList
+			tags, err := context.Tx.GetTags(user.UserId)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			tl.Tags = tags
+			return &tl
+		} else {
+			//Return Tag with this Id
+			tagid, err := context.NextID()
+			if err != nil {
+				return NewError(3 /*Invalid Request*/)
+			}
+			tag, err := context.Tx.GetTag(tagid, user.UserId)
+			if err != nil {
+				return NewError(3 /*Invalid Request*/)
+			}
+			return tag
+		}
+	} else {
+		tagid, err := context.NextID()
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		// Ensure the tag exists and belongs to this user
+		tag, err := context.Tx.GetTag(tagid, user.UserId)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+
+		if r.Method == "PUT" {
+			var newtag models.Tag
+			if err := ReadJSON(r, &newtag); err != nil || newtag.TagId != tagid {
+				return NewError(3 /*Invalid Request*/)
+			}
+			newtag.UserId = user.UserId
+			newtag.Name = strings.TrimSpace(newtag.Name)
+
+			valid, err := validTag(context.Tx, user, &newtag)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+			if !valid {
+				return NewError(3 /*Invalid Request*/)
+			}
+
+			err = context.Tx.UpdateTag(&newtag)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return &newtag
+		} else if r.Method == "DELETE" {
+			err = context.Tx.DeleteTag(tag)
+			if err != nil {
+				log.Print(err)
+				return NewError(999 /*Internal Error*/)
+			}
+
+			return SuccessWriter{}
+		}
+	}
+	return NewError(3 /*Invalid Request*/)
+}
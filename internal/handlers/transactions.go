@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 // Return a map of security ID's to big.Rat's containing the amount that
@@ -82,6 +83,10 @@ func TransactionHandler(r *http.Request, context *Context) ResponseWriterWriter
 			}
 		}
 
+		if !validTransactionTags(context.Tx, user, &transaction) {
+			return NewError(3 /*Invalid Request*/)
+		}
+
 		balanced, err := TransactionBalanced(context.Tx, &transaction)
 		if err != nil {
 			return NewError(999 /*Internal Error*/)
@@ -113,11 +118,25 @@ func TransactionHandler(r *http.Request, context *Context) ResponseWriterWriter
 			al.Transactions = transactions
 			return &al
 		} else {
+			level := context.NextLevel()
+			if level == "search" {
+				if !context.LastLevel() {
+					return NewError(3 /*Invalid Request*/)
+				}
+				return TransactionSearchHandler(r, context.Tx, user)
+			}
+
 			//Return Transaction with this Id
-			transactionid, err := context.NextID()
+			transactionid, err := strconv.ParseInt(level, 0, 64)
 			if err != nil {
 				return NewError(3 /*Invalid Request*/)
 			}
+			if !context.LastLevel() {
+				if context.NextLevel() != "suggestions" {
+					return NewError(3 /*Invalid Request*/)
+				}
+				return TransactionSuggestionsHandler(context.Tx, user, transactionid)
+			}
 			transaction, err := context.Tx.GetTransaction(transactionid, user.UserId)
 			if err != nil {
 				return NewError(3 /*Invalid Request*/)
@@ -129,6 +148,12 @@ func TransactionHandler(r *http.Request, context *Context) ResponseWriterWriter
 		if err != nil {
 			return NewError(3 /*Invalid Request*/)
 		}
+
+		// Reconciled splits may only be changed or removed if 'force' is
+		// 'true'
+		query, _ := url.ParseQuery(r.URL.RawQuery)
+		force := query.Get("force") == "true"
+
 		if r.Method == "PUT" {
 			var transaction models.Transaction
 			if err := ReadJSON(r, &transaction); err != nil || transaction.TransactionId != transactionid {
@@ -156,6 +181,18 @@ func TransactionHandler(r *http.Request, context *Context) ResponseWriterWriter
 				}
 			}
 
+			if !validTransactionTags(context.Tx, user, &transaction) {
+				return NewError(3 /*Invalid Request*/)
+			}
+
+			existing, err := context.Tx.GetTransaction(transactionid, user.UserId)
+			if err != nil {
+				return NewError(3 /*Invalid Request*/)
+			}
+			if !force && reconciledSplitsChanged(existing, &transaction) {
+				return NewError(10 /*Reconciled Split*/)
+			}
+
 			err = context.Tx.UpdateTransaction(&transaction, user)
 			if err != nil {
 				log.Print(err)
@@ -168,6 +205,9 @@ func TransactionHandler(r *http.Request, context *Context) ResponseWriterWriter
 			if err != nil {
 				return NewError(3 /*Invalid Request*/)
 			}
+			if !force && reconciledSplitsChanged(transaction, nil) {
+				return NewError(10 /*Reconciled Split*/)
+			}
 
 			err = context.Tx.DeleteTransaction(transaction, user)
 			if err != nil {
@@ -182,7 +222,8 @@ func TransactionHandler(r *http.Request, context *Context) ResponseWriterWriter
 }
 
 // Return only those transactions which have at least one split pertaining to
-// an account
+// an account, and, if the 'tag' query parameter is given, which have that tag
+// attached to them or one of their splits
 func AccountTransactionsHandler(context *Context, r *http.Request, user *models.User, accountid int64) ResponseWriterWriter {
 	var page uint64 = 0
 	var limit uint64 = 50
@@ -216,7 +257,12 @@ func AccountTransactionsHandler(context *Context, r *http.Request, user *models.
 		sort = sortstring
 	}
 
-	accountTransactions, err := context.Tx.GetAccountTransactions(user, accountid, sort, page, limit)
+	tagid, err := queryTagId(context.Tx, user, query)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+
+	accountTransactions, err := context.Tx.GetAccountTransactions(user, accountid, tagid, sort, page, limit)
 	if err != nil {
 		log.Print(err)
 		return NewError(999 /*Internal Error*/)
@@ -224,3 +270,127 @@ func AccountTransactionsHandler(context *Context, r *http.Request, user *models.
 
 	return accountTransactions
 }
+
+// TransactionSearchHandler returns a page of the user's transactions matching
+// the criteria in the query parameters (see docs/transaction_search.md). The
+// amount, account, status, and security criteria must all be met by the same
+// split. Transactions are paged by 'page' and 'limit' like an account's
+// transactions.
+func TransactionSearchHandler(r *http.Request, tx store.Tx, user *models.User) ResponseWriterWriter {
+	search := models.TransactionSearch{
+		AccountId:  -1,
+		SecurityId: -1,
+		TagId:      -1,
+		Sort:       "date-desc",
+		Limit:      50,
+	}
+
+	query, _ := url.ParseQuery(r.URL.RawQuery)
+
+	search.Text = query.Get("text")
+
+	for _, param := range []struct {
+		name string
+		date **time.Time
+	}{{"begin", &search.Begin}, {"end", &search.End}} {
+		if datestring := query.Get(param.name); datestring != "" {
+			date, err := time.Parse("2006-01-02", datestring)
+			if err != nil {
+				return NewError(3 /*Invalid Request*/)
+			}
+			*param.date = &date
+		}
+	}
+
+	for _, param := range []struct {
+		name   string
+		amount **models.Amount
+	}{{"minamount", &search.MinAmount}, {"maxamount", &search.MaxAmount}} {
+		if amountstring := query.Get(param.name); amountstring != "" {
+			var amount models.Amount
+			if _, ok := amount.SetString(amountstring); !ok {
+				return NewError(3 /*Invalid Request*/)
+			}
+			// Amounts are only stored to MaxPrecision, with an int64
+			// integral portion, so more precise or larger ones can't be
+			// searched for
+			if _, err := amount.Whole(); err != nil || amount.Precision() > models.MaxPrecision {
+				return NewError(3 /*Invalid Request*/)
+			}
+			*param.amount = &amount
+		}
+	}
+
+	if accountstring := query.Get("account"); accountstring != "" {
+		accountid, err := strconv.ParseInt(accountstring, 10, 64)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		if _, err := tx.GetAccount(accountid, user.UserId); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		search.AccountId = accountid
+	}
+	search.SubAccounts = query.Get("subaccounts") == "true"
+
+	if statusstring := query.Get("status"); statusstring != "" {
+		status, err := strconv.ParseInt(statusstring, 10, 64)
+		if err != nil || status < models.Imported || status > models.Voided {
+			return NewError(3 /*Invalid Request*/)
+		}
+		search.Status = status
+	}
+
+	if securitystring := query.Get("security"); securitystring != "" {
+		securityid, err := strconv.ParseInt(securitystring, 10, 64)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		if _, err := tx.GetSecurity(securityid, user.UserId); err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		search.SecurityId = securityid
+	}
+
+	tagid, err := queryTagId(tx, user, query)
+	if err != nil {
+		return NewError(3 /*Invalid Request*/)
+	}
+	search.TagId = tagid
+
+	search.HasImbalance = query.Get("imbalance") == "true"
+
+	sortstring := query.Get("sort")
+	if sortstring != "" {
+		if sortstring != "date-asc" && sortstring != "date-desc" && sortstring != "description-asc" && sortstring != "description-desc" {
+			return NewError(3 /*Invalid Request*/)
+		}
+		search.Sort = sortstring
+	}
+
+	pagestring := query.Get("page")
+	if pagestring != "" {
+		p, err := strconv.ParseUint(pagestring, 10, 0)
+		if err != nil {
+			return NewError(3 /*Invalid Request*/)
+		}
+		search.Page = p
+	}
+
+	limitstring := query.Get("limit")
+	if limitstring != "" {
+		l, err := strconv.ParseUint(limitstring, 10, 0)
+		if err != nil || l > 100 {
+			return NewError(3 /*Invalid Request*/)
+		}
+		search.Limit = l
+	}
+
+	results, err := tx.SearchTransactions(user, &search)
+	if err != nil {
+		log.Print(err)
+		return NewError(999 /*Internal Error*/)
+	}
+
+	return results
+}
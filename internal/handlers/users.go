@@ -80,7 +80,7 @@ func UpdateUser(tx store.Tx, u *models.User) error {
 }
 
 func UserHandler(r *http.Request, context *Context) ResponseWriterWriter {
-	if r.Method == "POST" {
+	if r.Method == "POST" && context.LastLevel() {
 		var user models.User
 		if err := ReadJSON(r, &user); err != nil {
 			return NewError(3 /*Invalid Request*/)
@@ -114,6 +114,16 @@ func UserHandler(r *http.Request, context *Context) ResponseWriterWriter {
 			return NewError(2 /*Unauthorized Access*/)
 		}
 
+		if !context.LastLevel() {
+			route := context.NextLevel()
+			if route == "backup" && r.Method == "GET" {
+				return UserBackupHandler(context, user)
+			} else if route == "restore" && r.Method == "POST" {
+				return UserRestoreHandler(r, context, user)
+			}
+			return NewError(3 /*Invalid Request*/)
+		}
+
 		if r.Method == "GET" {
 			return user
 		} else if r.Method == "PUT" {
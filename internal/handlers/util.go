@@ -33,3 +33,29 @@ func (s SuccessWriter) Write(w http.ResponseWriter) error {
 	fmt.Fprint(w, "{}")
 	return nil
 }
+
+// RollbackWriter writes Writer as the response, but causes the database
+// transaction the request was handled in to be rolled back rather than
+// committed
+type RollbackWriter struct {
+	Writer ResponseWriterWriter
+}
+
+func (r RollbackWriter) Write(w http.ResponseWriter) error {
+	return r.Writer.Write(w)
+}
+
+// FileWriter writes a file (i.e. an export) as the response, suggesting
+// Filename as the name to save it under
+type FileWriter struct {
+	ContentType string
+	Filename    string
+	Data        []byte
+}
+
+func (f FileWriter) Write(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", f.Filename))
+	_, err := w.Write(f.Data)
+	return err
+}
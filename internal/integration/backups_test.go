@@ -0,0 +1,497 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"github.com/aclindsa/moneygo/internal/models"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func backupUser(client *http.Client, userid int64) ([]byte, error) {
+	return download(client, "/v1/users/"+strconv.FormatInt(userid, 10)+"/backup")
+}
+
+func restoreUser(client *http.Client, userid int64, filename string) error {
+	return uploadFile(client, filename, "/v1/users/"+strconv.FormatInt(userid, 10)+"/restore")
+}
+
+func TestBackupRestoreUser(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		backup, err := backupUser(d.clients[0], d.users[0].UserId)
+		if err != nil {
+			t.Fatalf("Error backing up user: %s\n", err)
+		}
+
+		var b models.Backup
+		if err := json.Unmarshal(backup, &b); err != nil {
+			t.Fatalf("Error decoding backup: %s\n", err)
+		}
+		if b.Version != models.BackupVersion {
+			t.Errorf("Expected backup version %d, found %d\n", models.BackupVersion, b.Version)
+		}
+		if b.User.UserId != d.users[0].UserId || len(b.User.PasswordHash) != 0 {
+			t.Errorf("Unexpected user in backup: %+v\n", b.User)
+		}
+		var accounts, transactions, reports int
+		for _, a := range d.accounts {
+			if a.UserId == d.users[0].UserId {
+				accounts++
+			}
+		}
+		for _, tr := range d.transactions {
+			if tr.UserId == d.users[0].UserId {
+				transactions++
+			}
+		}
+		for _, r := range d.reports {
+			if r.UserId == d.users[0].UserId {
+				reports++
+			}
+		}
+		if len(b.Accounts) != accounts || len(b.Transactions) != transactions || len(b.Reports) != reports {
+			t.Errorf("Expected %d accounts, %d transactions, and %d reports in backup, found %d, %d, and %d\n", accounts, transactions, reports, len(b.Accounts), len(b.Transactions), len(b.Reports))
+		}
+
+		if _, err := backupUser(d.clients[0], d.users[1].UserId); err == nil {
+			t.Errorf("Expected error backing up another user\n")
+		}
+
+		dir, err := ioutil.TempDir("", "moneygo")
+		if err != nil {
+			t.Fatalf("Error creating temporary directory: %s\n", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "backup.json")
+		if err := ioutil.WriteFile(filename, backup, 0600); err != nil {
+			t.Fatalf("Error writing backup: %s\n", err)
+		}
+
+		// Restoring over a user with existing data isn't allowed
+		if err := restoreUser(d.clients[0], d.users[0].UserId, filename); err == nil {
+			t.Errorf("Expected error restoring backup to a user with existing accounts\n")
+		}
+
+		newuser := User{
+			DefaultCurrency: 978, // Euro
+			Name:            "Restored User",
+			Username:        "restored",
+			Password:        "correct horse battery staple",
+			Email:           "restored@example.com",
+		}
+		created, err := createUser(&newuser)
+		if err != nil {
+			t.Fatalf("Error creating user: %s\n", err)
+		}
+		newuser.UserId = created.UserId
+		client, err := newSession(&newuser)
+		if err != nil {
+			t.Fatalf("Error creating session: %s\n", err)
+		}
+		defer deleteUser(client, created)
+
+		if err := restoreUser(client, created.UserId, filename); err != nil {
+			t.Fatalf("Error restoring backup: %s\n", err)
+		}
+
+		u, err := getUser(client, created.UserId)
+		if err != nil {
+			t.Fatalf("Error fetching restored user: %s\n", err)
+		}
+		if u.Username != "restored" || u.Name != d.users[0].Name || u.Email != d.users[0].Email {
+			t.Errorf("Unexpected restored user: %+v\n", u)
+		}
+		currency, err := getSecurity(client, u.DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching restored default currency: %s\n", err)
+		}
+		if currency.AlternateId != "840" {
+			t.Errorf("Expected restored default currency to be USD, found %s\n", currency.AlternateId)
+		}
+
+		securities, err := getSecurities(client)
+		if err != nil {
+			t.Fatalf("Error fetching securities: %s\n", err)
+		}
+		if len(*securities.Securities) != len(b.Securities) {
+			t.Errorf("Expected %d restored securities, found %d\n", len(b.Securities), len(*securities.Securities))
+		}
+		for _, s := range b.Securities {
+			var restored *models.Security
+			for _, rs := range *securities.Securities {
+				if rs.Name == s.Name && rs.Symbol == s.Symbol && rs.AlternateId == s.AlternateId && rs.Type == s.Type && rs.Precision == s.Precision {
+					restored = rs
+				}
+			}
+			if restored == nil {
+				t.Errorf("Unable to find restored security %s\n", s.Name)
+				continue
+			}
+			var expected []*models.Price
+			for _, p := range b.Prices {
+				if p.SecurityId == s.SecurityId {
+					expected = append(expected, p)
+				}
+			}
+			if len(expected) == 0 {
+				continue
+			}
+			prices, err := getPrices(client, restored.SecurityId)
+			if err != nil {
+				t.Fatalf("Error fetching prices: %s\n", err)
+			}
+			if len(*prices.Prices) != len(expected) {
+				t.Errorf("Expected %d restored prices for %s, found %d\n", len(expected), s.Name, len(*prices.Prices))
+			}
+			for _, p := range expected {
+				found := false
+				for _, rp := range *prices.Prices {
+					if rp.Date.Equal(p.Date) && rp.Value.Cmp(&p.Value.Rat) == 0 && rp.RemoteId == p.RemoteId {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("Unable to find restored price %+v\n", p)
+				}
+			}
+		}
+
+		restoredAccounts, err := getAccounts(client)
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		if len(*restoredAccounts.Accounts) != len(b.Accounts) {
+			t.Errorf("Expected %d restored accounts, found %d\n", len(b.Accounts), len(*restoredAccounts.Accounts))
+		}
+		for _, a := range b.Accounts {
+			found := false
+			for _, ra := range *restoredAccounts.Accounts {
+				if ra.Name == a.Name && ra.Type == a.Type && (ra.ParentAccountId == -1) == (a.ParentAccountId == -1) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Unable to find restored account %s\n", a.Name)
+			}
+		}
+		checking := findAccountByName(t, restoredAccounts, "Credit Union Checking")
+		accountBalanceHelper(t, client, checking, "-127.18")
+
+		restoredTransactions, err := getTransactions(client)
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		if len(*restoredTransactions.Transactions) != len(b.Transactions) {
+			t.Errorf("Expected %d restored transactions, found %d\n", len(b.Transactions), len(*restoredTransactions.Transactions))
+		}
+		for _, tr := range b.Transactions {
+			found := false
+			for _, rt := range *restoredTransactions.Transactions {
+				if rt.Description == tr.Description && rt.Date.Equal(tr.Date) && len(rt.Splits) == len(tr.Splits) {
+					found = true
+					for i := range tr.Splits {
+						if rt.Splits[i].Amount.String() != tr.Splits[i].Amount.String() {
+							found = false
+						}
+					}
+				}
+			}
+			if !found {
+				t.Errorf("Unable to find restored transaction %s\n", tr.Description)
+			}
+		}
+
+		restoredReports, err := getReports(client)
+		if err != nil {
+			t.Fatalf("Error fetching reports: %s\n", err)
+		}
+		if len(*restoredReports.Reports) != len(b.Reports) {
+			t.Errorf("Expected %d restored reports, found %d\n", len(b.Reports), len(*restoredReports.Reports))
+		}
+
+		// A second restore should fail now that the user has accounts
+		if err := restoreUser(client, created.UserId, filename); err == nil {
+			t.Errorf("Expected error restoring backup twice\n")
+		}
+	})
+}
+
+// restoreToNewUser backs up d.users[0] and restores the backup to a newly
+// created user, returning the backup, a client signed in as the new user, and
+// a function deleting them
+func restoreToNewUser(t *testing.T, d *TestData) (*models.Backup, *http.Client, func()) {
+	t.Helper()
+	backup, err := backupUser(d.clients[0], d.users[0].UserId)
+	if err != nil {
+		t.Fatalf("Error backing up user: %s\n", err)
+	}
+	var b models.Backup
+	if err := json.Unmarshal(backup, &b); err != nil {
+		t.Fatalf("Error decoding backup: %s\n", err)
+	}
+
+	dir, err := ioutil.TempDir("", "moneygo")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "backup.json")
+	if err := ioutil.WriteFile(filename, backup, 0600); err != nil {
+		t.Fatalf("Error writing backup: %s\n", err)
+	}
+
+	newuser := User{
+		DefaultCurrency: 978, // Euro
+		Name:            "Restored User",
+		Username:        "restored",
+		Password:        "correct horse battery staple",
+		Email:           "restored@example.com",
+	}
+	created, err := createUser(&newuser)
+	if err != nil {
+		t.Fatalf("Error creating user: %s\n", err)
+	}
+	newuser.UserId = created.UserId
+	client, err := newSession(&newuser)
+	if err != nil {
+		deleteUser(d.clients[0], created)
+		t.Fatalf("Error creating session: %s\n", err)
+	}
+	cleanup := func() { deleteUser(client, created) }
+
+	if err := restoreUser(client, created.UserId, filename); err != nil {
+		cleanup()
+		t.Fatalf("Error restoring backup: %s\n", err)
+	}
+	return &b, client, cleanup
+}
+
+func TestBackupRestoreScheduledTransactions(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		rent, err := createScheduledTransaction(d.clients[0], &models.ScheduledTransaction{
+			Template:    scheduledTemplate("Rent", d.accounts[1].AccountId, d.accounts[4].AccountId, "850"),
+			RemindOnly:  true,
+			Unit:        models.RecurMonths,
+			Interval:    1,
+			Start:       time.Date(2017, time.January, 31, 0, 0, 0, 0, time.UTC),
+			Occurrences: 12,
+		})
+		if err != nil {
+			t.Fatalf("Error creating scheduled transaction: %s\n", err)
+		}
+		if err := scheduler.CreateDue(time.Date(2017, time.March, 15, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("Error creating due scheduled transactions: %s\n", err)
+		}
+
+		b, client, cleanup := restoreToNewUser(t, d)
+		defer cleanup()
+		if len(b.ScheduledTransactions) != 1 || len(b.Reminders) != 2 {
+			t.Fatalf("Expected 1 scheduled transaction and 2 reminders in backup, found %d and %d\n", len(b.ScheduledTransactions), len(b.Reminders))
+		}
+
+		sl, err := getScheduledTransactions(client)
+		if err != nil {
+			t.Fatalf("Error fetching scheduled transactions: %s\n", err)
+		}
+		if len(*sl.ScheduledTransactions) != 1 {
+			t.Fatalf("Expected 1 restored scheduled transaction, found %d\n", len(*sl.ScheduledTransactions))
+		}
+		restored := (*sl.ScheduledTransactions)[0]
+		if restored.ScheduledTransactionId == rent.ScheduledTransactionId || restored.Count != 2 || !restored.NextDate.Equal(time.Date(2017, time.March, 31, 0, 0, 0, 0, time.UTC)) || !restored.RemindOnly || len(restored.Template.Splits) != 2 {
+			t.Errorf("Unexpected restored scheduled transaction: %+v\n", restored)
+		}
+		accounts, err := getAccounts(client)
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		cable := findAccountByName(t, accounts, d.accounts[4].Name)
+		if restored.Template.Splits[1].AccountId != cable.AccountId || restored.Template.Splits[1].Amount.String() != "850" {
+			t.Errorf("Expected restored scheduled transaction to refer to restored accounts: %+v\n", restored.Template.Splits[1])
+		}
+
+		rl, err := getReminders(client)
+		if err != nil {
+			t.Fatalf("Error fetching reminders: %s\n", err)
+		}
+		if len(*rl.Reminders) != 2 {
+			t.Fatalf("Expected 2 restored reminders, found %d\n", len(*rl.Reminders))
+		}
+		for _, r := range *rl.Reminders {
+			if r.ScheduledTransactionId != restored.ScheduledTransactionId {
+				t.Errorf("Expected restored reminder to refer to restored scheduled transaction: %+v\n", r)
+			}
+		}
+	})
+}
+
+func TestBackupRestoreReconciliations(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		card := d.accounts[7]
+		charge, err := createTransaction(d.clients[0], &models.Transaction{
+			UserId:      d.users[0].UserId,
+			Description: "Charge",
+			Date:        time.Date(2017, time.October, 5, 0, 0, 0, 0, time.UTC),
+			Splits: []*models.Split{
+				{Status: models.Cleared, AccountId: card.AccountId, SecurityId: -1, Amount: NewAmount("-25")},
+				{Status: models.Entered, AccountId: d.accounts[4].AccountId, SecurityId: -1, Amount: NewAmount("25")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating transaction: %s\n", err)
+		}
+		r, err := createReconciliation(d.clients[0], &models.Reconciliation{
+			AccountId:        card.AccountId,
+			StatementDate:    time.Date(2017, time.October, 31, 0, 0, 0, 0, time.UTC),
+			StatementBalance: NewAmount("-25"),
+		})
+		if err != nil {
+			t.Fatalf("Error starting reconciliation: %s\n", err)
+		}
+		r.Finished = true
+		if _, err := updateReconciliation(d.clients[0], r); err != nil {
+			t.Fatalf("Error finishing reconciliation: %s\n", err)
+		}
+
+		b, client, cleanup := restoreToNewUser(t, d)
+		defer cleanup()
+		if len(b.Reconciliations) != 1 || len(b.Reconciliations[0].SplitIds) != 1 || b.Reconciliations[0].SplitIds[0] != accountSplit(charge, card.AccountId).SplitId {
+			t.Fatalf("Unexpected reconciliations in backup: %+v\n", b.Reconciliations)
+		}
+
+		accounts, err := getAccounts(client)
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		restoredCard := findAccountByName(t, accounts, card.Name)
+		rl, err := getReconciliations(client, restoredCard.AccountId)
+		if err != nil {
+			t.Fatalf("Error fetching reconciliations: %s\n", err)
+		}
+		if len(*rl.Reconciliations) != 1 {
+			t.Fatalf("Expected 1 restored reconciliation, found %d\n", len(*rl.Reconciliations))
+		}
+		restored := (*rl.Reconciliations)[0]
+		if !restored.Finished || !restored.StatementDate.Equal(r.StatementDate) || !amountsMatch(restored.StatementBalance, "-25") || len(restored.SplitIds) != 1 {
+			t.Fatalf("Unexpected restored reconciliation: %+v\n", restored)
+		}
+
+		transactions, err := getAccountTransactions(client, restoredCard.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		var found bool
+		for _, tr := range *transactions.Transactions {
+			if split := accountSplit(tr, restoredCard.AccountId); split != nil && split.SplitId == restored.SplitIds[0] {
+				found = true
+				if tr.Description != "Charge" || split.Status != models.Reconciled {
+					t.Errorf("Expected restored reconciliation to refer to restored reconciled split: %+v\n", split)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Couldn't find restored reconciliation's split\n")
+		}
+	})
+}
+
+func TestBackupRestoreBudgets(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		budget, err := createBudget(d.clients[0], householdBudget(d))
+		if err != nil {
+			t.Fatalf("Error creating budget: %s\n", err)
+		}
+
+		b, client, cleanup := restoreToNewUser(t, d)
+		defer cleanup()
+		if len(b.Budgets) != 1 {
+			t.Fatalf("Expected 1 budget in backup, found %d\n", len(b.Budgets))
+		}
+
+		bl, err := getBudgets(client)
+		if err != nil {
+			t.Fatalf("Error fetching budgets: %s\n", err)
+		}
+		if len(*bl.Budgets) != 1 {
+			t.Fatalf("Expected 1 restored budget, found %d\n", len(*bl.Budgets))
+		}
+		restored := (*bl.Budgets)[0]
+		if restored.Name != budget.Name || restored.Period != budget.Period || !restored.Start.Equal(budget.Start) || len(restored.Amounts) != 2 {
+			t.Fatalf("Unexpected restored budget: %+v\n", restored)
+		}
+		accounts, err := getAccounts(client)
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		cable := findAccountByName(t, accounts, d.accounts[4].Name)
+		var found bool
+		for _, amount := range restored.Amounts {
+			if amount.AccountId == cable.AccountId {
+				found = true
+				if !amountsMatch(amount.Amount, "40") || amount.Rollover {
+					t.Errorf("Unexpected restored budget amount: %+v\n", amount)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Expected restored budget to refer to restored accounts: %+v\n", restored.Amounts)
+		}
+	})
+}
+
+func TestBackupRestoreTags(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		vacation, reimbursable, hotel, dinner := createTaggedTransactions(t, d)
+
+		b, client, cleanup := restoreToNewUser(t, d)
+		defer cleanup()
+		if len(b.Tags) != 2 {
+			t.Fatalf("Expected 2 tags in backup, found %d\n", len(b.Tags))
+		}
+
+		tl, err := getTags(client)
+		if err != nil {
+			t.Fatalf("Error fetching tags: %s\n", err)
+		}
+		if len(*tl.Tags) != 2 {
+			t.Fatalf("Expected 2 restored tags, found %d\n", len(*tl.Tags))
+		}
+		restoredTags := make(map[string]int64)
+		for _, tag := range *tl.Tags {
+			if tag.TagId == vacation.TagId || tag.TagId == reimbursable.TagId {
+				t.Errorf("Expected restored tag to have a new TagId: %+v\n", tag)
+			}
+			restoredTags[tag.Name] = tag.TagId
+		}
+
+		accounts, err := getAccounts(client)
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		groceries := findAccountByName(t, accounts, d.accounts[3].Name)
+		transactions, err := getTransactions(client)
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		var foundHotel, foundDinner bool
+		for _, tr := range *transactions.Transactions {
+			if tr.Description == hotel.Description {
+				foundHotel = true
+				if !tagIdsMatch(tr.TagIds, restoredTags[vacation.Name]) {
+					t.Errorf("Expected restored transaction to have restored tag: %+v\n", tr)
+				}
+			} else if tr.Description == dinner.Description {
+				foundDinner = true
+				if len(tr.TagIds) != 0 || !tagIdsMatch(accountSplit(tr, groceries.AccountId).TagIds, restoredTags[vacation.Name], restoredTags[reimbursable.Name]) {
+					t.Errorf("Expected restored split to have restored tags: %+v, %+v\n", tr, tr.Splits)
+				}
+			}
+		}
+		if !foundHotel || !foundDinner {
+			t.Errorf("Unable to find restored tagged transactions\n")
+		}
+	})
+}
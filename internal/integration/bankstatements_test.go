@@ -0,0 +1,130 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func importCamt053(client *http.Client, accountid int64, filename string) error {
+	return uploadFile(client, filename, "/v1/accounts/"+strconv.FormatInt(accountid, 10)+"/imports/camt053")
+}
+
+func TestImportBankStatements(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one EUR currency
+		oldDefault, err := getSecurity(d.clients[1], d.users[1].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[1].DefaultCurrency = d.securities[2].SecurityId
+		if _, err := updateUser(d.clients[1], &d.users[1]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[1], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		account := &d.accounts[5]
+		accountBalanceHelper(t, d.clients[1], account, "-24.56")
+
+		// The MT940 statements continue from the camt.053 one, so their
+		// balances shouldn't match until that's been imported
+		var result models.ImportResult
+		if err := uploadFileWithResponse(d.clients[1], "testdata/mt940_20171212.sta", "/v1/accounts/"+strconv.FormatInt(account.AccountId, 10)+"/imports/mt940", nil, &result); err != nil {
+			t.Fatalf("Error importing MT940 statement: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[1], account, "-71.55")
+		discrepancies := []struct {
+			Reported string
+			Computed string
+		}{
+			{"1310.34", "-24.56"},
+			{"1275.35", "-59.55"},
+			{"1275.35", "-59.55"},
+			{"1263.35", "-71.55"},
+		}
+		if len(result.Discrepancies) != len(discrepancies) {
+			t.Fatalf("Expected %d discrepancies, found %d\n", len(discrepancies), len(result.Discrepancies))
+		}
+		for i, expected := range discrepancies {
+			discrepancy := result.Discrepancies[i]
+			if discrepancy.AccountId != account.AccountId || discrepancy.SecurityId != account.SecurityId || discrepancy.Reported.String() != expected.Reported || discrepancy.Computed.String() != expected.Computed {
+				t.Errorf("Unexpected discrepancy %d: %+v\n", i, discrepancy)
+			}
+		}
+
+		// Importing twice shouldn't duplicate any transactions
+		for i := 0; i < 2; i++ {
+			result = models.ImportResult{}
+			if err := uploadFileWithResponse(d.clients[1], "testdata/camt053_20171205.xml", "/v1/accounts/"+strconv.FormatInt(account.AccountId, 10)+"/imports/camt053", nil, &result); err != nil {
+				t.Fatalf("Error importing camt.053 statement: %s\n", err)
+			}
+			if len(result.Discrepancies) != 0 {
+				t.Errorf("Expected no discrepancies importing camt.053 statement, found %d\n", len(result.Discrepancies))
+			}
+			accountBalanceHelper(t, d.clients[1], account, "1263.35")
+		}
+
+		// Earlier statements should still be accepted (and ignored)
+		if err := importCamt053(d.clients[1], account.AccountId, "testdata/camt053_20171205.xml"); err != nil {
+			t.Fatalf("Error re-importing camt.053 statement: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[1], account, "1263.35")
+
+		transactions, err := getAccountTransactions(d.clients[1], account.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		if len(*transactions.Transactions) != 7 {
+			t.Fatalf("Expected 7 transactions, found %d\n", len(*transactions.Transactions))
+		}
+
+		expected := []struct {
+			date        time.Time
+			description string
+			amount      string
+			remoteid    string
+			number      string
+			memo        string
+		}{
+			{time.Date(2017, time.December, 1, 0, 0, 0, 0, time.UTC), "Example Employer GmbH", "1500", "camt:2017120100001", "SAL-2017-11", "Gehalt November 2017"},
+			{time.Date(2017, time.December, 4, 0, 0, 0, 0, time.UTC), "Supermarkt Mitte", "-45.10", "camt:2017120400002", "", "Kartenzahlung 2017-12-02 18:03"},
+			{time.Date(2017, time.December, 5, 0, 0, 0, 0, time.UTC), "Sammellastschrift", "-120", "camt:2017120500003", "", "Sammellastschrift"},
+			{time.Date(2017, time.December, 8, 0, 0, 0, 0, time.UTC), "Telekom Deutschland GmbH", "-59.99", "mt940:B7L08AB12345", "", "EREF+2017120800123SVWZ+Mobilfunk Dezember"},
+			{time.Date(2017, time.December, 11, 0, 0, 0, 0, time.UTC), "Jane Doe", "25", "", "", "Dinner share"},
+			{time.Date(2017, time.December, 12, 0, 0, 0, 0, time.UTC), "Kontofuehrungsgebuehr Dezember", "-12", "mt940:B7L12XY99999", "", "Kontofuehrung"},
+		}
+		for _, e := range expected {
+			var found bool
+			for _, tran := range *transactions.Transactions {
+				if !tran.Date.Equal(e.date) || tran.Description != e.description {
+					continue
+				}
+				for _, split := range tran.Splits {
+					if split.AccountId != account.AccountId {
+						continue
+					}
+					found = true
+					if !amountsMatch(split.Amount, e.amount) {
+						t.Errorf("Expected '%s' amount to be %s, found %s\n", e.description, e.amount, split.Amount)
+					}
+					if len(e.remoteid) > 0 && split.RemoteId != e.remoteid {
+						t.Errorf("Expected '%s' RemoteId to be %s, found %s\n", e.description, e.remoteid, split.RemoteId)
+					}
+					if split.Number != e.number || split.Memo != e.memo {
+						t.Errorf("Expected '%s' number and memo to be '%s' and '%s', found '%s' and '%s'\n", e.description, e.number, e.memo, split.Number, split.Memo)
+					}
+					if split.Status != models.Imported {
+						t.Errorf("Expected '%s' split to be imported, found status %d\n", e.description, split.Status)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("Couldn't find imported '%s' transaction\n", e.description)
+			}
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package integration_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func exportBeancount(client *http.Client) (string, error) {
+	ledger, err := download(client, "/v1/exports/beancount")
+	if err != nil {
+		return "", err
+	}
+	return string(ledger), nil
+}
+
+func TestExportBeancount(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		cable := d.transactions[2]
+		cable.Splits[1].Memo = "October \"promotional\" rate"
+		if _, err := updateTransaction(d.clients[0], &cable); err != nil {
+			t.Fatalf("Error updating transaction: %s\n", err)
+		}
+
+		ledger, err := exportBeancount(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error exporting beancount ledger: %s\n", err)
+		}
+		for _, expected := range []string{
+			"option \"operating_currency\" \"USD\"\n",
+			"2017-01-02 commodity SPY\n  name: \"SPY\"\n  description: \"SPDR S&P 500 ETF Trust\"\n  alternateid: \"78462F103\"\n  precision: 5\n",
+			"2017-01-02 commodity EUR\n  name: \"EUR\"\n  symbol: \"€\"\n",
+			"2017-09-02 open Assets:Credit-Union-Checking USD-2\n  name: \"Credit Union Checking\"\n  type: \"Bank\"\n",
+			"2017-01-02 open Liabilities:Credit-Card USD-2\n",
+			"2017-01-03 price SPY 226.58 USD-2\n",
+			"2017-11-16 price USD-2 0.85 EUR\n",
+			"2017-09-02 * \"Cable\"\n",
+			"Expenses:Cable                                 39.99 USD-2\n    memo: \"October \\\"promotional\\\" rate\"\n",
+			"2017-10-16 balance Assets:Credit-Union-Checking  -45.59 USD-2\n2017-10-16 balance Expenses:Groceries  5.60 USD-2\n",
+			"2017-11-01 balance Assets:Credit-Union-Checking  -127.18 USD-2\n",
+		} {
+			if !strings.Contains(ledger, expected) {
+				t.Errorf("Expected exported ledger to contain %q:\n%s", expected, ledger)
+			}
+		}
+
+		// Only accounts with reconciled splits should have their balances
+		// asserted
+		if strings.Contains(ledger, "balance Expenses:Cable") {
+			t.Errorf("Unexpected balance assertion for account without reconciled splits:\n%s", ledger)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package integration_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestLuaBudgets(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		b, err := createBudget(d.clients[0], householdBudget(d))
+		if err != nil {
+			t.Fatalf("Error creating budget: %s\n", err)
+		}
+		expenses := d.accounts[2].AccountId
+		cable := d.accounts[4].AccountId
+
+		simpleLuaTest(t, d.clients[0], []LuaTest{
+			{"BudgetId", fmt.Sprintf("return get_budgets()[%d].BudgetId", b.BudgetId), strconv.FormatInt(b.BudgetId, 10)},
+			{"Name", fmt.Sprintf("return get_budgets()[%d].Name", b.BudgetId), "Household"},
+			{"Period", fmt.Sprintf("return get_budgets()[%d].Period == budget.Monthly", b.BudgetId), "true"},
+			{"Start", fmt.Sprintf("return get_budgets()[%d].Start == date.new(2017, 9, 1)", b.BudgetId), "true"},
+			{"__tostring", fmt.Sprintf("return get_budgets()[%d]", b.BudgetId), "Household"},
+			{"Amount()", fmt.Sprintf("return get_budgets()[%d]:Amount(get_accounts()[%d]).Amount", b.BudgetId, expenses), "150"},
+			{"Amount() unbudgeted", fmt.Sprintf("return get_budgets()[%d]:Amount(get_accounts()[%d])", b.BudgetId, d.accounts[3].AccountId), "nil"},
+			{"Status() Budgeted", fmt.Sprintf("return get_budgets()[%d]:Status(date.new(2017, 10, 20))[%d].Budgeted.Amount", b.BudgetId, expenses), "260.01"},
+			{"Status() Actual", fmt.Sprintf("return get_budgets()[%d]:Status(date.new(2017, 10, 20))[%d].Actual.Amount", b.BudgetId, expenses), "87.19"},
+			{"Status() Remaining", fmt.Sprintf("return get_budgets()[%d]:Status(date.new(2017, 10, 20))[%d].Remaining.Amount", b.BudgetId, expenses), "172.82"},
+			{"Status() Security", fmt.Sprintf("return get_budgets()[%d]:Status(date.new(2017, 9, 30))[%d].Remaining.Security.SecurityId", b.BudgetId, cable), strconv.FormatInt(d.securities[0].SecurityId, 10)},
+		})
+	})
+}
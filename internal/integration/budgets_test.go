@@ -0,0 +1,204 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/handlers"
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func createBudget(client *http.Client, budget *models.Budget) (*models.Budget, error) {
+	var b models.Budget
+	err := create(client, budget, &b, "/v1/budgets/")
+	return &b, err
+}
+
+func getBudget(client *http.Client, budgetid int64) (*models.Budget, error) {
+	var b models.Budget
+	err := read(client, &b, "/v1/budgets/"+strconv.FormatInt(budgetid, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func getBudgets(client *http.Client) (*models.BudgetList, error) {
+	var bl models.BudgetList
+	err := read(client, &bl, "/v1/budgets/")
+	if err != nil {
+		return nil, err
+	}
+	return &bl, nil
+}
+
+func updateBudget(client *http.Client, budget *models.Budget) (*models.Budget, error) {
+	var b models.Budget
+	err := update(client, budget, &b, "/v1/budgets/"+strconv.FormatInt(budget.BudgetId, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func deleteBudget(client *http.Client, b *models.Budget) error {
+	return remove(client, "/v1/budgets/"+strconv.FormatInt(b.BudgetId, 10))
+}
+
+func getBudgetStatus(client *http.Client, budgetid int64, query string) (*models.BudgetStatus, error) {
+	var bs models.BudgetStatus
+	err := read(client, &bs, "/v1/budgets/"+strconv.FormatInt(budgetid, 10)+"/status"+query)
+	if err != nil {
+		return nil, err
+	}
+	return &bs, nil
+}
+
+// householdBudget returns a monthly budget for user 0's expenses, some of which
+// roll over, starting in September 2017
+func householdBudget(d *TestData) *models.Budget {
+	return &models.Budget{
+		Name:   "Household",
+		Period: models.MonthlyBudget,
+		Start:  time.Date(2017, time.September, 15, 0, 0, 0, 0, time.UTC),
+		Amounts: []*models.BudgetAmount{
+			{AccountId: d.accounts[2].AccountId, Amount: NewAmount("150"), Rollover: true},
+			{AccountId: d.accounts[4].AccountId, Amount: NewAmount("40")},
+		},
+	}
+}
+
+func checkBudgetAccountStatus(t *testing.T, p *models.BudgetPeriodStatus, accountid int64, budgeted, actual, remaining string) {
+	t.Helper()
+	for _, s := range p.Accounts {
+		if s.AccountId == accountid {
+			if !amountsMatch(s.Budgeted, budgeted) || !amountsMatch(s.Actual, actual) || !amountsMatch(s.Remaining, remaining) {
+				t.Errorf("Budget status for account %d in period beginning %s was %s/%s/%s, expected %s/%s/%s\n", accountid, p.Begin, s.Budgeted, s.Actual, s.Remaining, budgeted, actual, remaining)
+			}
+			return
+		}
+	}
+	t.Errorf("Account %d missing from budget status for period beginning %s\n", accountid, p.Begin)
+}
+
+func TestBudgets(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		b, err := createBudget(d.clients[0], householdBudget(d))
+		if err != nil {
+			t.Fatalf("Error creating budget: %s\n", err)
+		}
+		if b.Name != "Household" || b.Period != models.MonthlyBudget || len(b.Amounts) != 2 {
+			t.Errorf("Unexpected budget created: %+v\n", b)
+		}
+		if !b.Start.Equal(time.Date(2017, time.September, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected budget start to be moved to the beginning of its period: %s\n", b.Start)
+		}
+
+		bl, err := getBudgets(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching budgets: %s\n", err)
+		}
+		if len(*bl.Budgets) != 1 || (*bl.Budgets)[0].BudgetId != b.BudgetId || len((*bl.Budgets)[0].Amounts) != 2 {
+			t.Errorf("Unexpected budgets returned: %+v\n", bl)
+		}
+		if _, err := getBudget(d.clients[1], b.BudgetId); err == nil {
+			t.Errorf("Expected error fetching another user's budget\n")
+		}
+
+		invalid := []func(*models.Budget){
+			func(b *models.Budget) { b.Name = "" },
+			func(b *models.Budget) { b.Period = 4 },
+			func(b *models.Budget) { b.Amounts[1].AccountId = b.Amounts[0].AccountId },
+			func(b *models.Budget) { b.Amounts[1].AccountId = d.accounts[1].AccountId }, // not an income or expense account
+			func(b *models.Budget) { b.Amounts[1].AccountId = d.accounts[6].AccountId }, // another user's account
+		}
+		for i, f := range invalid {
+			budget := householdBudget(d)
+			f(budget)
+			if _, err := createBudget(d.clients[0], budget); err == nil {
+				t.Errorf("Expected error creating invalid budget %d\n", i)
+			}
+		}
+		nullAmount := householdBudget(d)
+		nullAmount.Amounts = append(nullAmount.Amounts, nil)
+		if _, err := createBudget(d.clients[0], nullAmount); err == nil {
+			t.Errorf("Expected error creating budget with a null amount\n")
+		} else if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 3 {
+			t.Errorf("Unexpected error creating budget with a null amount: %s\n", err)
+		}
+
+		// Amounts can only be rolled over through a limited number of periods
+		if _, err := getBudgetStatus(d.clients[0], b.BudgetId, "?date=2037-09-20"); err != nil {
+			t.Errorf("Error fetching budget status 240 periods after its start: %s\n", err)
+		}
+		if _, err := getBudgetStatus(d.clients[0], b.BudgetId, "?date=2037-10-20"); err == nil {
+			t.Errorf("Expected error fetching budget status more than 240 periods after its start\n")
+		} else if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 3 {
+			t.Errorf("Unexpected error fetching budget status more than 240 periods after its start: %s\n", err)
+		}
+
+		// Groceries are included in the expenses account's actual amount, and
+		// the amount left over in September is rolled over into October
+		bs, err := getBudgetStatus(d.clients[0], b.BudgetId, "?date=2017-10-20&periods=2")
+		if err != nil {
+			t.Fatalf("Error fetching budget status: %s\n", err)
+		}
+		if bs.BudgetId != b.BudgetId || len(bs.Periods) != 2 {
+			t.Fatalf("Unexpected budget status: %+v\n", bs)
+		}
+		if !bs.Periods[0].Begin.Equal(time.Date(2017, time.October, 1, 0, 0, 0, 0, time.UTC)) || !bs.Periods[0].End.Equal(time.Date(2017, time.November, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Unexpected first budget period: %s to %s\n", bs.Periods[0].Begin, bs.Periods[0].End)
+		}
+		checkBudgetAccountStatus(t, bs.Periods[0], d.accounts[2].AccountId, "260.01", "87.19", "172.82")
+		checkBudgetAccountStatus(t, bs.Periods[0], d.accounts[4].AccountId, "40", "0", "40")
+		checkBudgetAccountStatus(t, bs.Periods[1], d.accounts[2].AccountId, "322.82", "0", "322.82")
+
+		bs, err = getBudgetStatus(d.clients[0], b.BudgetId, "?date=2017-09-01")
+		if err != nil {
+			t.Fatalf("Error fetching budget status: %s\n", err)
+		}
+		if len(bs.Periods) != 1 {
+			t.Fatalf("Expected one period of budget status by default: %+v\n", bs)
+		}
+		checkBudgetAccountStatus(t, bs.Periods[0], d.accounts[2].AccountId, "150", "39.99", "110.01")
+		checkBudgetAccountStatus(t, bs.Periods[0], d.accounts[4].AccountId, "40", "39.99", "0.01")
+
+		for _, query := range []string{"?date=20171020", "?periods=0", "?periods=x"} {
+			if _, err := getBudgetStatus(d.clients[0], b.BudgetId, query); err == nil {
+				t.Errorf("Expected error fetching budget status with '%s'\n", query)
+			}
+		}
+
+		b.Period = models.QuarterlyBudget
+		b.Amounts = b.Amounts[:1]
+		b, err = updateBudget(d.clients[0], b)
+		if err != nil {
+			t.Fatalf("Error updating budget: %s\n", err)
+		}
+		if !b.Start.Equal(time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)) || len(b.Amounts) != 1 {
+			t.Errorf("Unexpected budget after update: %+v\n", b)
+		}
+		bs, err = getBudgetStatus(d.clients[0], b.BudgetId, "?date=2017-10-20")
+		if err != nil {
+			t.Fatalf("Error fetching budget status: %s\n", err)
+		}
+		if len(bs.Periods) != 1 || len(bs.Periods[0].Accounts) != 1 {
+			t.Fatalf("Unexpected budget status after update: %+v\n", bs)
+		}
+		checkBudgetAccountStatus(t, bs.Periods[0], d.accounts[2].AccountId, "260.01", "87.19", "172.82")
+
+		if _, err := updateBudget(d.clients[1], b); err == nil {
+			t.Errorf("Expected error updating another user's budget\n")
+		}
+		if err := deleteBudget(d.clients[1], b); err == nil {
+			t.Errorf("Expected error deleting another user's budget\n")
+		}
+		if err := deleteBudget(d.clients[0], b); err != nil {
+			t.Fatalf("Error deleting budget: %s\n", err)
+		}
+		if _, err := getBudget(d.clients[0], b.BudgetId); err == nil {
+			t.Errorf("Expected error fetching deleted budget\n")
+		}
+	})
+}
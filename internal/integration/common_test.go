@@ -18,6 +18,8 @@ import (
 )
 
 var server *httptest.Server
+var ofxSyncer *handlers.OFXSyncer
+var scheduler *handlers.Scheduler
 
 func Delete(client *http.Client, url string) (*http.Response, error) {
 	request, err := http.NewRequest(http.MethodDelete, url, nil)
@@ -102,6 +104,28 @@ func read(client *http.Client, output TransactType, urlsuffix string) error {
 	return nil
 }
 
+// download fetches a file (i.e. an export), returning an error if the server
+// returned one instead
+func download(client *http.Client, urlsuffix string) ([]byte, error) {
+	response, err := client.Get(server.URL + urlsuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var e handlers.Error
+	if err := (&e).Read(string(body)); err == nil && (e.ErrorId != 0 || len(e.ErrorString) != 0) {
+		return nil, &e
+	}
+
+	return body, nil
+}
+
 func update(client *http.Client, input, output TransactType, urlsuffix string) error {
 	obj, err := json.MarshalIndent(input, "", "  ")
 	if err != nil {
@@ -160,9 +184,27 @@ func remove(client *http.Client, urlsuffix string) error {
 }
 
 func uploadFile(client *http.Client, filename, urlsuffix string) error {
+	return uploadFileWithFields(client, filename, urlsuffix, nil)
+}
+
+// uploadFileWithFields uploads filename, preceded by a part for each of the
+// fields supplied
+func uploadFileWithFields(client *http.Client, filename, urlsuffix string, fields map[string]string) error {
+	return uploadFileWithResponse(client, filename, urlsuffix, fields, nil)
+}
+
+// uploadFileWithResponse is like uploadFileWithFields, but also reads the
+// response into output, if it is non-nil
+func uploadFileWithResponse(client *http.Client, filename, urlsuffix string, fields map[string]string, output TransactType) error {
 	var buf bytes.Buffer
 	mw := multipart.NewWriter(&buf)
 
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+
 	file, err := os.Open(filename)
 	if err != nil {
 		return err
@@ -199,6 +241,9 @@ func uploadFile(client *http.Client, filename, urlsuffix string) error {
 		return &e
 	}
 
+	if output != nil {
+		return output.Read(string(body))
+	}
 	return nil
 }
 
@@ -273,7 +318,14 @@ func RunTests(m *testing.M) int {
 
 	db.Empty() // clear the DB tables
 
-	server = httptest.NewTLSServer(&handlers.APIHandler{Store: db})
+	ofxSyncer, err = handlers.NewOFXSyncer(db, "moneygo test key")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scheduler = &handlers.Scheduler{Store: db}
+
+	server = httptest.NewTLSServer(&handlers.APIHandler{Store: db, OFXSync: ofxSyncer})
 	defer server.Close()
 
 	return m.Run()
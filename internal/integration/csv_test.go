@@ -0,0 +1,127 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"github.com/aclindsa/moneygo/internal/handlers"
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func importCSV(client *http.Client, accountid int64, filename string, mapping *models.CSVImportMapping) error {
+	fields := make(map[string]string)
+	if mapping != nil {
+		m, err := json.Marshal(mapping)
+		if err != nil {
+			return err
+		}
+		fields["mapping"] = string(m)
+	}
+	return uploadFileWithFields(client, filename, "/v1/accounts/"+strconv.FormatInt(accountid, 10)+"/imports/csv", fields)
+}
+
+func getCSVImportMapping(client *http.Client, accountid int64) (*models.CSVImportMapping, error) {
+	var m models.CSVImportMapping
+	err := read(client, &m, "/v1/accounts/"+strconv.FormatInt(accountid, 10)+"/imports/csv")
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func TestImportCSV(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		account := &d.accounts[1]
+
+		// Importing without a mapping shouldn't work until one has been saved
+		if err := importCSV(d.clients[0], account.AccountId, "testdata/checking.csv", nil); err == nil {
+			t.Fatalf("Expected error importing CSV without a mapping\n")
+		} else if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 3 {
+			t.Fatalf("Unexpected error importing CSV without a mapping: %s\n", err)
+		}
+
+		mapping := models.CSVImportMapping{
+			DateColumn:        0,
+			DateFormat:        "MM/DD/YYYY",
+			DescriptionColumn: 1,
+			MemoColumn:        5,
+			NumberColumn:      2,
+			AmountColumn:      -1,
+			DebitColumn:       3,
+			CreditColumn:      4,
+			HeaderRows:        1,
+		}
+		if err := importCSV(d.clients[0], account.AccountId, "testdata/checking.csv", &mapping); err != nil {
+			t.Fatalf("Error importing CSV: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], account, "1322.45")
+
+		saved, err := getCSVImportMapping(d.clients[0], account.AccountId)
+		if err != nil {
+			t.Fatalf("Error fetching saved CSV import mapping: %s\n", err)
+		}
+		if saved.AccountId != account.AccountId || saved.DebitColumn != 3 || saved.DateFormat != "MM/DD/YYYY" || saved.Delimiter != "," {
+			t.Errorf("Saved CSV import mapping doesn't match: %+v\n", saved)
+		}
+
+		// Re-importing the same file with the saved mapping shouldn't create
+		// duplicate transactions
+		if err := importCSV(d.clients[0], account.AccountId, "testdata/checking.csv", nil); err != nil {
+			t.Fatalf("Error re-importing CSV: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], account, "1322.45")
+
+		transactions, err := getAccountTransactions(d.clients[0], account.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		var found bool
+		for _, tran := range *transactions.Transactions {
+			for _, split := range tran.Splits {
+				if split.AccountId == account.AccountId && split.Number == "1042" {
+					found = true
+					if tran.Description != "CHECK 1042" || split.Memo != "Rent" || !amountsMatch(split.Amount, "-500") {
+						t.Errorf("Imported check transaction doesn't match: %+v\n", split)
+					}
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Unable to find imported check transaction\n")
+		}
+	})
+}
+
+func TestImportCSVPartialMapping(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		account := &d.accounts[1]
+		url := "/v1/accounts/" + strconv.FormatInt(account.AccountId, 10) + "/imports/csv"
+
+		// Two roles can't share a column
+		duplicate := map[string]string{"mapping": `{"DateColumn": 0, "DateFormat": "MM/DD/YYYY", "DescriptionColumn": 0, "DebitColumn": 3, "CreditColumn": 4, "HeaderRows": 1}`}
+		if err := uploadFileWithFields(d.clients[0], "testdata/checking.csv", url, duplicate); err == nil {
+			t.Fatalf("Expected error importing CSV with a column mapped twice\n")
+		} else if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 3 {
+			t.Fatalf("Unexpected error importing CSV with a column mapped twice: %s\n", err)
+		}
+		if _, err := getCSVImportMapping(d.clients[0], account.AccountId); err == nil {
+			t.Errorf("Expected invalid CSV import mapping not to be saved\n")
+		}
+
+		// Columns left out of the mapping are unused, rather than column 0
+		partial := map[string]string{"mapping": `{"DateColumn": 0, "DateFormat": "MM/DD/YYYY", "DescriptionColumn": 1, "DebitColumn": 3, "CreditColumn": 4, "HeaderRows": 1}`}
+		if err := uploadFileWithFields(d.clients[0], "testdata/checking.csv", url, partial); err != nil {
+			t.Fatalf("Error importing CSV with partial mapping: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], account, "1322.45")
+
+		saved, err := getCSVImportMapping(d.clients[0], account.AccountId)
+		if err != nil {
+			t.Fatalf("Error fetching saved CSV import mapping: %s\n", err)
+		}
+		if saved.MemoColumn != -1 || saved.NumberColumn != -1 || saved.AmountColumn != -1 || saved.DebitColumn != 3 {
+			t.Errorf("Expected columns left out of mapping to be saved as unused: %+v\n", saved)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package integration_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/aclindsa/moneygo/internal/models"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func exportGnucash(client *http.Client) ([]byte, error) {
+	return download(client, "/v1/exports/gnucash")
+}
+
+func TestExportGnucash(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		gnucash, err := exportGnucash(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error exporting Gnucash book: %s\n", err)
+		}
+		gzr, err := gzip.NewReader(bytes.NewReader(gnucash))
+		if err != nil {
+			t.Fatalf("Expected exported Gnucash book to be compressed: %s\n", err)
+		}
+		book, err := ioutil.ReadAll(gzr)
+		if err != nil {
+			t.Fatalf("Error decompressing exported Gnucash book: %s\n", err)
+		}
+
+		for _, expected := range []string{
+			"<gnc-v2 xmlns:gnc=\"http://www.gnucash.org/XML/gnc\" xmlns:act=\"http://www.gnucash.org/XML/act\"",
+			"<gnc:count-data cd:type=\"book\">1</gnc:count-data>",
+			"<gnc:book version=\"2.0.0\">",
+			"<gnc:count-data cd:type=\"transaction\">3</gnc:count-data>",
+			"<cmdty:space>MONEYGO</cmdty:space>",
+			"<cmdty:id>SPY</cmdty:id>",
+			"<cmdty:name>SPDR S&amp;P 500 ETF Trust</cmdty:name>",
+			"<cmdty:xcode>78462F103</cmdty:xcode>",
+			"<cmdty:fraction>100000</cmdty:fraction>",
+			"<price:value>11329/50</price:value>",
+			"<trn:splits>",
+			"<act:type>ROOT</act:type>",
+			"<act:name>Credit Union Checking</act:name>",
+			"<act:type>BANK</act:type>",
+			"<act:commodity-scu>100</act:commodity-scu>",
+			"<trn:description>Cable</trn:description>",
+			"<split:value>-3999/100</split:value>",
+			"<split:quantity>-3999/100</split:quantity>",
+		} {
+			if !bytes.Contains(book, []byte(expected)) {
+				t.Errorf("Expected exported Gnucash book to contain %q:\n%s", expected, book)
+			}
+		}
+		if strings.Count(string(book), "<act:parent type=\"guid\">") != len(d.accounts)-2 {
+			t.Errorf("Expected all of user's accounts to have parents in exported Gnucash book\n")
+		}
+
+		// Ensure the exported book can be imported for another user
+		dir, err := ioutil.TempDir("", "moneygo")
+		if err != nil {
+			t.Fatalf("Error creating temporary directory: %s\n", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "moneygo.gnucash")
+		if err := ioutil.WriteFile(filename, gnucash, 0600); err != nil {
+			t.Fatalf("Error writing exported Gnucash book: %s\n", err)
+		}
+		if err := importGnucash(d.clients[1], filename); err != nil {
+			t.Fatalf("Error importing exported Gnucash book: %s\n", err)
+		}
+
+		accounts, err := getAccounts(d.clients[1])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		var checking *models.Account
+		for _, account := range *accounts.Accounts {
+			if account.Name == "Credit Union Checking" {
+				checking = account
+			}
+		}
+		if checking == nil {
+			t.Fatalf("Couldn't find 'Credit Union Checking' account after importing exported book\n")
+		}
+		if checking.Type != models.Bank {
+			t.Errorf("Expected imported 'Credit Union Checking' to be a bank account\n")
+		}
+		accountBalanceHelper(t, d.clients[1], checking, "-127.18")
+	})
+}
+
+func TestExportGnucashRoundTrip(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		if err = importGnucash(d.clients[0], "testdata/example.gnucash"); err != nil {
+			t.Fatalf("Error importing from Gnucash: %s\n", err)
+		}
+
+		gnucash, err := exportGnucash(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error exporting Gnucash book: %s\n", err)
+		}
+		gzr, err := gzip.NewReader(bytes.NewReader(gnucash))
+		if err != nil {
+			t.Fatalf("Expected exported Gnucash book to be compressed: %s\n", err)
+		}
+		book, err := ioutil.ReadAll(gzr)
+		if err != nil {
+			t.Fatalf("Error decompressing exported Gnucash book: %s\n", err)
+		}
+
+		// Splits and prices imported from Gnucash should keep their GUIDs
+		for _, expected := range []string{
+			"<split:id type=\"guid\">2f0b18a5bd47e6b36f92fd004820f3e9</split:id>",
+			"<price:id type=\"guid\">6aada06cffde44c2a3e9c70f8a41850c</price:id>",
+			"<cmdty:id>GE</cmdty:id>",
+		} {
+			if !bytes.Contains(book, []byte(expected)) {
+				t.Errorf("Expected exported Gnucash book to contain %q\n", expected)
+			}
+		}
+
+		// So re-importing the exported book shouldn't duplicate them
+		dir, err := ioutil.TempDir("", "moneygo")
+		if err != nil {
+			t.Fatalf("Error creating temporary directory: %s\n", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "moneygo.gnucash")
+		if err := ioutil.WriteFile(filename, gnucash, 0600); err != nil {
+			t.Fatalf("Error writing exported Gnucash book: %s\n", err)
+		}
+		transactions, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		if err := importGnucash(d.clients[0], filename); err != nil {
+			t.Fatalf("Error importing exported Gnucash book: %s\n", err)
+		}
+		reimported, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		// Only the user's 3 transactions which weren't imported from Gnucash
+		// in the first place should be duplicated
+		if len(*reimported.Transactions) != len(*transactions.Transactions)+3 {
+			t.Errorf("Expected re-importing exported Gnucash book to only duplicate 3 transactions (%d before, %d after)\n", len(*transactions.Transactions), len(*reimported.Transactions))
+		}
+	})
+}
@@ -10,7 +10,9 @@ func importGnucash(client *http.Client, filename string) error {
 	return uploadFile(client, filename, "/v1/imports/gnucash")
 }
 
-func TestImportGnucash(t *testing.T) {
+// gnucashImportHelper imports filename, which should contain the same book as
+// testdata/example.gnucash, and verifies the results
+func gnucashImportHelper(t *testing.T, filename string) {
 	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
 		// Ensure there's only one USD currency
 		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
@@ -26,7 +28,7 @@ func TestImportGnucash(t *testing.T) {
 		}
 
 		// Import and ensure it didn't return a nasty error code
-		if err = importGnucash(d.clients[0], "testdata/example.gnucash"); err != nil {
+		if err = importGnucash(d.clients[0], filename); err != nil {
 			t.Fatalf("Error importing from Gnucash: %s\n", err)
 		}
 
@@ -127,3 +129,11 @@ func TestImportGnucash(t *testing.T) {
 		}
 	})
 }
+
+func TestImportGnucash(t *testing.T) {
+	gnucashImportHelper(t, "testdata/example.gnucash")
+}
+
+func TestImportGnucashSQLite(t *testing.T) {
+	gnucashImportHelper(t, "testdata/example_sqlite.gnucash")
+}
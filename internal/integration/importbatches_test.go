@@ -0,0 +1,350 @@
+package integration_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/aclindsa/moneygo/internal/handlers"
+	"github.com/aclindsa/moneygo/internal/models"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func getImportBatches(client *http.Client) (*models.ImportBatchList, error) {
+	var bl models.ImportBatchList
+	err := read(client, &bl, "/v1/imports/")
+	if err != nil {
+		return nil, err
+	}
+	return &bl, nil
+}
+
+func getImportBatch(client *http.Client, importbatchid int64) (*models.ImportBatch, error) {
+	var b models.ImportBatch
+	err := read(client, &b, "/v1/imports/"+strconv.FormatInt(importbatchid, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func rollbackImportBatch(client *http.Client, b *models.ImportBatch, force bool) error {
+	urlsuffix := "/v1/imports/" + strconv.FormatInt(b.ImportBatchId, 10)
+	if force {
+		urlsuffix += "?force=true"
+	}
+	return remove(client, urlsuffix)
+}
+
+func fileHash(t *testing.T, filename string) string {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s\n", filename, err)
+	}
+	hash := sha256.Sum256(contents)
+	return hex.EncodeToString(hash[:])
+}
+
+func TestImportBatches(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "-127.18")
+		accountsBefore, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		securitiesBefore, err := getSecurities(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching securities: %s\n", err)
+		}
+
+		// Previews aren't recorded
+		if err := uploadFileWithFields(d.clients[0], "testdata/checking_20171129.ofx", "/v1/accounts/"+strconv.FormatInt(d.accounts[1].AccountId, 10)+"/imports/ofxfile", map[string]string{"options": `{"Preview": true}`}); err != nil {
+			t.Fatalf("Error previewing OFX import: %s\n", err)
+		}
+		if bl, err := getImportBatches(d.clients[0]); err != nil || len(*bl.ImportBatches) != 0 {
+			t.Fatalf("Expected no import batches after preview: %+v, %s\n", bl, err)
+		}
+
+		if err := importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171129.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4862.85")
+		if err := importGnucash(d.clients[0], "testdata/example.gnucash"); err != nil {
+			t.Fatalf("Error importing from Gnucash: %s\n", err)
+		}
+
+		bl, err := getImportBatches(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching import batches: %s\n", err)
+		}
+		if len(*bl.ImportBatches) != 2 {
+			t.Fatalf("Expected 2 import batches, found %d\n", len(*bl.ImportBatches))
+		}
+		gnucash, ofx := (*bl.ImportBatches)[0], (*bl.ImportBatches)[1]
+		if gnucash.Date.Before(ofx.Date) {
+			t.Errorf("Expected import batches to be returned most recent first\n")
+		}
+
+		if ofx.Source != models.OFXImport || ofx.UserId != d.users[0].UserId || ofx.FileHash != fileHash(t, "testdata/checking_20171129.ofx") {
+			t.Errorf("Unexpected OFX import batch: %+v\n", ofx)
+		}
+		if len(ofx.TransactionIds) == 0 || len(ofx.PriceIds) != 0 {
+			t.Errorf("Unexpected objects created by OFX import batch: %+v\n", ofx)
+		}
+		if gnucash.Source != models.GnucashImport || gnucash.FileHash != fileHash(t, "testdata/example.gnucash") {
+			t.Errorf("Unexpected Gnucash import batch: %+v\n", gnucash)
+		}
+		if len(gnucash.TransactionIds) == 0 || len(gnucash.AccountIds) == 0 || len(gnucash.PriceIds) == 0 {
+			t.Errorf("Unexpected objects created by Gnucash import batch: %+v\n", gnucash)
+		}
+
+		if bl, err := getImportBatches(d.clients[1]); err != nil || len(*bl.ImportBatches) != 0 {
+			t.Errorf("Expected other user not to see import batches\n")
+		}
+		if _, err := getImportBatch(d.clients[1], ofx.ImportBatchId); err == nil {
+			t.Errorf("Expected error fetching another user's import batch\n")
+		}
+		if err := rollbackImportBatch(d.clients[1], ofx, true); err == nil {
+			t.Errorf("Expected error rolling back another user's import batch\n")
+		}
+
+		// Rolling back the Gnucash import removes everything it created
+		if err := rollbackImportBatch(d.clients[0], gnucash, false); err != nil {
+			t.Fatalf("Error rolling back Gnucash import batch: %s\n", err)
+		}
+		if _, err := getImportBatch(d.clients[0], gnucash.ImportBatchId); err == nil {
+			t.Errorf("Expected error fetching rolled back import batch\n")
+		}
+		for _, transactionid := range gnucash.TransactionIds {
+			if _, err := getTransaction(d.clients[0], transactionid); err == nil {
+				t.Errorf("Expected transaction %d to be deleted by rollback\n", transactionid)
+			}
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4862.85")
+
+		// Transactions edited since they were imported require confirmation
+		edited, err := getTransaction(d.clients[0], ofx.TransactionIds[0])
+		if err != nil {
+			t.Fatalf("Error fetching imported transaction: %s\n", err)
+		}
+		edited.Description = "Edited after importing"
+		if _, err := updateTransaction(d.clients[0], edited); err != nil {
+			t.Fatalf("Error updating imported transaction: %s\n", err)
+		}
+		err = rollbackImportBatch(d.clients[0], ofx, false)
+		if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 8 { // Edited Since Import
+			t.Fatalf("Expected 'Edited Since Import' error rolling back edited import batch: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4862.85")
+
+		if err := rollbackImportBatch(d.clients[0], ofx, true); err != nil {
+			t.Fatalf("Error forcing rollback of OFX import batch: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "-127.18")
+		if _, err := getAccount(d.clients[0], d.accounts[1].AccountId); err != nil {
+			t.Errorf("Expected the account imported into to remain after rollback: %s\n", err)
+		}
+		if bl, err := getImportBatches(d.clients[0]); err != nil || len(*bl.ImportBatches) != 0 {
+			t.Errorf("Expected no import batches after rolling them back: %+v, %s\n", bl, err)
+		}
+
+		// Along with the transactions, the accounts and securities created by
+		// both imports are gone
+		accounts, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		if len(*accounts.Accounts) != len(*accountsBefore.Accounts) {
+			t.Errorf("Expected %d accounts after rolling back imports, found %d\n", len(*accountsBefore.Accounts), len(*accounts.Accounts))
+		}
+		securities, err := getSecurities(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching securities: %s\n", err)
+		}
+		if len(*securities.Securities) != len(*securitiesBefore.Securities) {
+			t.Errorf("Expected %d securities after rolling back imports, found %d\n", len(*securitiesBefore.Securities), len(*securities.Securities))
+		}
+	})
+}
+
+func TestImportBatchRollbackModified(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		tag, err := createTag(d.clients[0], &models.Tag{Name: "streaming"})
+		if err != nil {
+			t.Fatalf("Error creating tag: %s\n", err)
+		}
+		netflix, err := createTransaction(d.clients[0], &models.Transaction{
+			UserId:      d.users[0].UserId,
+			Description: "Netflix",
+			Date:        time.Date(2017, time.November, 19, 0, 0, 0, 0, time.UTC),
+			TagIds:      []int64{tag.TagId},
+			Splits: []*models.Split{
+				{Status: models.Entered, AccountId: d.accounts[1].AccountId, SecurityId: -1, Amount: NewAmount("-10.71")},
+				{Status: models.Entered, AccountId: d.accounts[4].AccountId, SecurityId: -1, Amount: NewAmount("10.71")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating transaction: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "-137.89")
+
+		// The first import merges into the Netflix transaction, and the
+		// second replaces it and voids the power bill
+		if err := importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171129.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4862.85")
+		merged, err := getTransaction(d.clients[0], netflix.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching merged transaction: %s\n", err)
+		}
+		if err := importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171202_corrections.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4953.05")
+
+		bl, err := getImportBatches(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching import batches: %s\n", err)
+		}
+		if len(*bl.ImportBatches) != 2 {
+			t.Fatalf("Expected 2 import batches, found %d\n", len(*bl.ImportBatches))
+		}
+		corrections, ofx := (*bl.ImportBatches)[0], (*bl.ImportBatches)[1]
+		if len(ofx.ModifiedTransactionIds) != 1 || ofx.ModifiedTransactionIds[0] != netflix.TransactionId {
+			t.Errorf("Expected OFX import batch to have modified only the Netflix transaction: %+v\n", ofx)
+		}
+		if len(corrections.ModifiedTransactionIds) != 2 {
+			t.Errorf("Expected corrections import batch to have modified 2 transactions: %+v\n", corrections)
+		}
+
+		// Rolling back the corrections restores the Netflix transaction as
+		// it was merged, and the power bill
+		if err := rollbackImportBatch(d.clients[0], corrections, false); err != nil {
+			t.Fatalf("Error rolling back corrections import batch: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4862.85")
+		restored, err := getTransaction(d.clients[0], netflix.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching restored transaction: %s\n", err)
+		}
+		if restored.Description != merged.Description || len(restored.Splits) != 2 || !tagIdsMatch(restored.TagIds, tag.TagId) {
+			t.Errorf("Expected corrected transaction to be restored: %+v\n", restored)
+		}
+		for _, split := range restored.Splits {
+			if split.AccountId == d.accounts[1].AccountId && (split.Status != models.Cleared || split.RemoteId == "" || split.Amount.String() != "-10.71") {
+				t.Errorf("Expected restored split to be as it was merged: %+v\n", split)
+			}
+		}
+
+		// Rolling back the first import then restores the Netflix transaction
+		// as it was entered
+		if err := rollbackImportBatch(d.clients[0], ofx, false); err != nil {
+			t.Fatalf("Error rolling back OFX import batch: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "-137.89")
+		restored, err = getTransaction(d.clients[0], netflix.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching restored transaction: %s\n", err)
+		}
+		if len(restored.Splits) != 2 || !tagIdsMatch(restored.TagIds, tag.TagId) {
+			t.Errorf("Expected merged transaction to be restored: %+v\n", restored)
+		}
+		for _, split := range restored.Splits {
+			if split.Status != models.Entered || split.RemoteId != "" {
+				t.Errorf("Expected restored split to be as it was entered: %+v\n", split)
+			}
+		}
+	})
+}
+
+func TestImportBatchRollbackModifiedEdited(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		netflix, err := createTransaction(d.clients[0], &models.Transaction{
+			UserId:      d.users[0].UserId,
+			Description: "Netflix",
+			Date:        time.Date(2017, time.November, 19, 0, 0, 0, 0, time.UTC),
+			Splits: []*models.Split{
+				{Status: models.Entered, AccountId: d.accounts[1].AccountId, SecurityId: -1, Amount: NewAmount("-10.71")},
+				{Status: models.Entered, AccountId: d.accounts[4].AccountId, SecurityId: -1, Amount: NewAmount("10.71")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating transaction: %s\n", err)
+		}
+		if err := importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171129.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		bl, err := getImportBatches(d.clients[0])
+		if err != nil || len(*bl.ImportBatches) != 1 {
+			t.Fatalf("Expected 1 import batch: %+v, %s\n", bl, err)
+		}
+		ofx := (*bl.ImportBatches)[0]
+
+		// Transactions merged into and then edited require confirmation too
+		edited, err := getTransaction(d.clients[0], netflix.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching merged transaction: %s\n", err)
+		}
+		edited.Description = "Edited after importing"
+		if _, err := updateTransaction(d.clients[0], edited); err != nil {
+			t.Fatalf("Error updating merged transaction: %s\n", err)
+		}
+		err = rollbackImportBatch(d.clients[0], ofx, false)
+		if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 8 { // Edited Since Import
+			t.Fatalf("Expected 'Edited Since Import' error rolling back edited import batch: %s\n", err)
+		}
+
+		if err := rollbackImportBatch(d.clients[0], ofx, true); err != nil {
+			t.Fatalf("Error forcing rollback of OFX import batch: %s\n", err)
+		}
+		restored, err := getTransaction(d.clients[0], netflix.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching restored transaction: %s\n", err)
+		}
+		if restored.Description != "Netflix" {
+			t.Errorf("Expected forced rollback to restore the merged transaction: %+v\n", restored)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestImportOFXMatchEntered(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		entered := func(description string, date time.Time, amount string, accountid int64) *models.Transaction {
+			t.Helper()
+			negated := NewAmount(amount)
+			negated.Neg(&negated.Rat)
+			tr, err := createTransaction(d.clients[0], &models.Transaction{
+				UserId:      d.users[0].UserId,
+				Description: description,
+				Date:        date,
+				Splits: []*models.Split{
+					{Status: models.Entered, AccountId: d.accounts[1].AccountId, SecurityId: -1, Amount: NewAmount(amount)},
+					{Status: models.Entered, AccountId: accountid, SecurityId: -1, Amount: negated},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Error creating transaction: %s\n", err)
+			}
+			return tr
+		}
+		netflix := entered("Netflix", time.Date(2017, time.November, 19, 0, 0, 0, 0, time.UTC), "-10.71", d.accounts[4].AccountId)
+		// Too far from the imported transaction's date to match by default
+		power := entered("Dukeengyprogress bill", time.Date(2017, time.October, 30, 0, 0, 0, 0, time.UTC), "-98.20", d.accounts[4].AccountId)
+		// The amount and date match a Target transaction, but not the description
+		entered("Dinner", time.Date(2017, time.November, 20, 0, 0, 0, 0, time.UTC), "-25.18", d.accounts[3].AccountId)
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "-261.27")
+
+		url := "/v1/accounts/" + strconv.FormatInt(d.accounts[1].AccountId, 10) + "/imports/ofxfile"
+
+		// A wider window also matches the power bill
+		var preview models.ImportPreview
+		err = uploadFileWithResponse(d.clients[0], "testdata/checking_20171129.ofx", url, importOptionsFields(t, &models.ImportOptions{Preview: true, MatchDays: 14}), &preview)
+		if err != nil {
+			t.Fatalf("Error previewing OFX import: %s\n", err)
+		}
+		if len(preview.Matches) != 2 || len(preview.Transactions) != 8 {
+			t.Errorf("Expected 2 matches and 8 transactions in preview, found %d and %d\n", len(preview.Matches), len(preview.Transactions))
+		}
+		for _, match := range preview.Matches {
+			if match.Transaction.TransactionId != netflix.TransactionId && match.Transaction.TransactionId != power.TransactionId {
+				t.Errorf("Unexpected transaction matched in preview: %+v\n", match.Transaction)
+			}
+		}
+
+		// Matching can be disabled
+		err = uploadFileWithResponse(d.clients[0], "testdata/checking_20171129.ofx", url, importOptionsFields(t, &models.ImportOptions{Preview: true, MatchDays: -1}), &preview)
+		if err != nil {
+			t.Fatalf("Error previewing OFX import: %s\n", err)
+		}
+		if len(preview.Matches) != 0 || len(preview.Transactions) != 10 {
+			t.Errorf("Expected no matches and 10 transactions in preview, found %d and %d\n", len(preview.Matches), len(preview.Transactions))
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "-261.27")
+
+		var result models.ImportResult
+		err = uploadFileWithResponse(d.clients[0], "testdata/checking_20171129.ofx", url, nil, &result)
+		if err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if len(result.Matches) != 1 {
+			t.Fatalf("Expected 1 match, found %d\n", len(result.Matches))
+		}
+		match := result.Matches[0]
+		if match.Index != 6 || match.Transaction.TransactionId != netflix.TransactionId || match.SplitId != netflix.Splits[0].SplitId {
+			t.Errorf("Unexpected match: %+v\n", match)
+		}
+		// The Netflix transaction was merged rather than imported again
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4739.47")
+		accountBalanceHelper(t, d.clients[0], &d.accounts[4], "148.9")
+
+		merged, err := getTransaction(d.clients[0], netflix.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching transaction: %s\n", err)
+		}
+		for _, split := range merged.Splits {
+			if split.SplitId == netflix.Splits[0].SplitId {
+				if split.Status != models.Cleared || split.RemoteId != "ofx:9a463f21-c6e1-4fe0-b37b-f9a8cc942cf0" {
+					t.Errorf("Expected matched split to be cleared and adopt imported RemoteId: %+v\n", split)
+				}
+			} else if split.Status != models.Entered || len(split.RemoteId) != 0 {
+				t.Errorf("Expected unmatched split to be unchanged: %+v\n", split)
+			}
+		}
+		if merged.Description != "Netflix" {
+			t.Errorf("Expected matched transaction to keep its description, found %s\n", merged.Description)
+		}
+
+		// Importing again finds the merged transaction by its RemoteId
+		result = models.ImportResult{}
+		err = uploadFileWithResponse(d.clients[0], "testdata/checking_20171129.ofx", url, nil, &result)
+		if err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if len(result.Matches) != 0 {
+			t.Errorf("Expected no matches importing again, found %d\n", len(result.Matches))
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4739.47")
+	})
+}
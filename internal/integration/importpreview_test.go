@@ -0,0 +1,267 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func importOptionsFields(t *testing.T, options *models.ImportOptions) map[string]string {
+	t.Helper()
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		t.Fatalf("Error encoding import options: %s\n", err)
+	}
+	return map[string]string{"options": string(encoded)}
+}
+
+func previewOFX(t *testing.T, client *http.Client, accountid int64, filename string) *models.ImportPreview {
+	t.Helper()
+	var preview models.ImportPreview
+	err := uploadFileWithResponse(client, filename, "/v1/accounts/"+strconv.FormatInt(accountid, 10)+"/imports/ofxfile", importOptionsFields(t, &models.ImportOptions{Preview: true}), &preview)
+	if err != nil {
+		t.Fatalf("Error previewing OFX import: %s\n", err)
+	}
+	return &preview
+}
+
+func importOFXAccepting(t *testing.T, client *http.Client, accountid int64, filename string, accept []int) {
+	t.Helper()
+	err := uploadFileWithFields(client, filename, "/v1/accounts/"+strconv.FormatInt(accountid, 10)+"/imports/ofxfile", importOptionsFields(t, &models.ImportOptions{Accept: accept}))
+	if err != nil {
+		t.Fatalf("Error importing OFX: %s\n", err)
+	}
+}
+
+func previewGnucash(t *testing.T, client *http.Client, filename string) *models.ImportPreview {
+	t.Helper()
+	var preview models.ImportPreview
+	err := uploadFileWithResponse(client, filename, "/v1/imports/gnucash", importOptionsFields(t, &models.ImportOptions{Preview: true}), &preview)
+	if err != nil {
+		t.Fatalf("Error previewing Gnucash import: %s\n", err)
+	}
+	return &preview
+}
+
+func TestPreviewOFXImport(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		transactions, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+
+		preview := previewOFX(t, d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171126.ofx")
+		if len(preview.Transactions) == 0 || len(preview.Duplicates) != 0 {
+			t.Fatalf("Expected only new transactions in preview, found %d new and %d duplicates\n", len(preview.Transactions), len(preview.Duplicates))
+		}
+		for _, it := range preview.Transactions {
+			found := false
+			for _, split := range it.Transaction.Splits {
+				if split.AccountId == d.accounts[1].AccountId {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected previewed transaction %d to have a split in the account being imported to\n", it.Index)
+			}
+		}
+
+		// Previewing shouldn't have changed anything
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "-127.18")
+		previewed, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		if len(*previewed.Transactions) != len(*transactions.Transactions) {
+			t.Errorf("Expected preview not to import transactions (%d before, %d after)\n", len(*transactions.Transactions), len(*previewed.Transactions))
+		}
+
+		// Accept only the first proposed transaction
+		first := preview.Transactions[0]
+		importOFXAccepting(t, d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171126.ofx", []int{first.Index})
+		accepted, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		if len(*accepted.Transactions) != len(*transactions.Transactions)+1 {
+			t.Errorf("Expected accepting one transaction to import one (%d before, %d after)\n", len(*transactions.Transactions), len(*accepted.Transactions))
+		}
+
+		// Now it should show up as a duplicate
+		preview = previewOFX(t, d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171126.ofx")
+		if len(preview.Duplicates) != 1 || preview.Duplicates[0].Index != first.Index {
+			t.Fatalf("Expected accepted transaction to be a duplicate in the next preview\n")
+		}
+
+		if err = importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171126.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "2493.19")
+	})
+}
+
+func TestPreviewOFXImportNewSecurities(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		account, err := createAccount(d.clients[0], &models.Account{
+			SecurityId:      d.securities[0].SecurityId,
+			UserId:          d.users[0].UserId,
+			ParentAccountId: -1,
+			Type:            models.Investment,
+			Name:            "401k",
+		})
+		if err != nil {
+			t.Fatalf("Error creating 401k account: %s\n", err)
+		}
+		securities, err := getSecurities(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching securities: %s\n", err)
+		}
+		accounts, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+
+		preview := previewOFX(t, d.clients[0], account.AccountId, "testdata/401k_mutualfunds.ofx")
+		if len(preview.Securities) == 0 {
+			t.Errorf("Expected preview to include new securities\n")
+		}
+		if len(preview.Accounts) == 0 {
+			t.Errorf("Expected preview to include new accounts\n")
+		}
+		if len(preview.Transactions) == 0 {
+			t.Errorf("Expected preview to include new transactions\n")
+		}
+		for _, a := range preview.Accounts {
+			if a.AccountId == account.AccountId {
+				t.Errorf("Expected existing account not to be listed as new in preview\n")
+			}
+		}
+
+		previewedSecurities, err := getSecurities(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching securities: %s\n", err)
+		}
+		if len(*previewedSecurities.Securities) != len(*securities.Securities) {
+			t.Errorf("Expected preview not to create securities\n")
+		}
+		previewedAccounts, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		if len(*previewedAccounts.Accounts) != len(*accounts.Accounts) {
+			t.Errorf("Expected preview not to create accounts\n")
+		}
+	})
+}
+
+func TestPreviewGnucashImport(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		accounts, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		transactions, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+
+		preview := previewGnucash(t, d.clients[0], "testdata/example.gnucash")
+		if len(preview.Transactions) == 0 || len(preview.Duplicates) != 0 {
+			t.Fatalf("Expected only new transactions in preview, found %d new and %d duplicates\n", len(preview.Transactions), len(preview.Duplicates))
+		}
+		if len(preview.Accounts) == 0 {
+			t.Errorf("Expected preview to include new accounts\n")
+		}
+		found := false
+		for _, s := range preview.Securities {
+			if s.Symbol == "GE" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected preview to include new GE security\n")
+		}
+
+		previewedAccounts, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		if len(*previewedAccounts.Accounts) != len(*accounts.Accounts) {
+			t.Errorf("Expected preview not to create accounts\n")
+		}
+
+		// Accepting none of the transactions should still create the book's
+		// accounts
+		err = uploadFileWithFields(d.clients[0], "testdata/example.gnucash", "/v1/imports/gnucash", importOptionsFields(t, &models.ImportOptions{Accept: []int{}}))
+		if err != nil {
+			t.Fatalf("Error importing from Gnucash: %s\n", err)
+		}
+		imported, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		if len(*imported.Transactions) != len(*transactions.Transactions) {
+			t.Errorf("Expected no transactions to be imported when none were accepted\n")
+		}
+		importedAccounts, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		if len(*importedAccounts.Accounts) != len(*accounts.Accounts)+len(preview.Accounts) {
+			t.Errorf("Expected %d accounts to be created, found %d\n", len(preview.Accounts), len(*importedAccounts.Accounts)-len(*accounts.Accounts))
+		}
+
+		// After a full import, previewing again should find only duplicates
+		if err = importGnucash(d.clients[0], "testdata/example.gnucash"); err != nil {
+			t.Fatalf("Error importing from Gnucash: %s\n", err)
+		}
+		reimport := previewGnucash(t, d.clients[0], "testdata/example.gnucash")
+		if len(reimport.Transactions) != 0 || len(reimport.Duplicates) != len(preview.Transactions) {
+			t.Errorf("Expected all %d transactions to be duplicates, found %d new and %d duplicates\n", len(preview.Transactions), len(reimport.Transactions), len(reimport.Duplicates))
+		}
+		if len(reimport.Accounts) != 0 || len(reimport.Securities) != 0 {
+			t.Errorf("Expected no new accounts or securities when previewing re-import\n")
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportLedger(client *http.Client) (string, error) {
+	journal, err := download(client, "/v1/exports/ledger")
+	if err != nil {
+		return "", err
+	}
+	return string(journal), nil
+}
+
+func importLedger(client *http.Client, filename string) error {
+	return uploadFile(client, filename, "/v1/imports/ledger")
+}
+
+func findAccountByName(t *testing.T, accounts *models.AccountList, name string) *models.Account {
+	t.Helper()
+	var found *models.Account
+	for _, account := range *accounts.Accounts {
+		if account.Name == name {
+			if found != nil {
+				t.Fatalf("Found more than one '%s' account\n", name)
+			}
+			found = account
+		}
+	}
+	if found == nil {
+		t.Fatalf("Couldn't find '%s' account\n", name)
+	}
+	return found
+}
+
+func TestLedgerRoundTrip(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		journal, err := exportLedger(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error exporting ledger journal: %s\n", err)
+		}
+		for _, expected := range []string{
+			"commodity $\n;   name: USD\n;   description: US Dollar\n;   securitytype: Currency\n;   precision: 2\n;   alternateid: 840\n",
+			"account Assets:Credit Union Checking\n;   type: Bank\n;   commodity: $\n",
+			"P 2017-01-02 21:00:00 SPY 225.24 $\n",
+			"2017-09-02 Cable\n",
+			"    * Assets:Credit Union Checking",
+			"-39.99 $\n",
+		} {
+			if !strings.Contains(journal, expected) {
+				t.Errorf("Expected exported journal to contain %q:\n%s", expected, journal)
+			}
+		}
+
+		f, err := ioutil.TempFile("", "moneygo-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.WriteString(journal); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		// Import the journal into another user's book, twice, to make sure
+		// transactions aren't duplicated
+		for i := 0; i < 2; i++ {
+			if err := importLedger(d.clients[1], f.Name()); err != nil {
+				t.Fatalf("Error importing ledger journal: %s\n", err)
+			}
+		}
+
+		accounts, err := getAccounts(d.clients[1])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		checking := findAccountByName(t, accounts, "Credit Union Checking")
+		if checking.Type != models.Bank {
+			t.Errorf("Expected imported checking account to be a bank account\n")
+		}
+		accountBalanceHelper(t, d.clients[1], checking, "-127.18")
+		accountBalanceHelper(t, d.clients[1], findAccountByName(t, accounts, "Groceries"), "87.19")
+		accountBalanceHelper(t, d.clients[1], findAccountByName(t, accounts, "Credit Card"), "0")
+
+		cable := findAccountByName(t, accounts, "Cable")
+		transactions, err := getAccountTransactions(d.clients[1], cable.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		if len(*transactions.Transactions) != 1 {
+			t.Fatalf("Expected 1 cable transaction, found %d\n", len(*transactions.Transactions))
+		}
+		tran := (*transactions.Transactions)[0]
+		if tran.Description != "Cable" || !tran.Date.Equal(time.Date(2017, time.September, 2, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Imported cable transaction doesn't match: %+v\n", tran)
+		}
+		for _, split := range tran.Splits {
+			if split.AccountId == cable.AccountId && (split.Status != models.Entered || !amountsMatch(split.Amount, "39.99")) {
+				t.Errorf("Imported cable split doesn't match: %+v\n", split)
+			} else if split.AccountId == checking.AccountId && (split.Status != models.Reconciled || !amountsMatch(split.Amount, "-39.99")) {
+				t.Errorf("Imported checking split doesn't match: %+v\n", split)
+			}
+		}
+
+		transactions, err = getAccountTransactions(d.clients[1], checking.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		var foundTime bool
+		for _, tran := range *transactions.Transactions {
+			if tran.Date.Equal(time.Date(2017, time.October, 15, 1, 16, 59, 0, time.UTC)) {
+				foundTime = true
+			}
+		}
+		if !foundTime {
+			t.Errorf("Expected imported transaction's time to be preserved\n")
+		}
+
+		securities, err := getSecurities(d.clients[1])
+		if err != nil {
+			t.Fatalf("Error fetching securities: %s\n", err)
+		}
+		var spy *models.Security
+		for i, security := range *securities.Securities {
+			if security.Symbol == "SPY" {
+				spy = (*securities.Securities)[i]
+			}
+		}
+		if spy == nil || spy.Name != "SPY" || spy.Description != "SPDR S&P 500 ETF Trust" || spy.Precision != 5 || spy.AlternateId != "78462F103" || spy.Type != models.Stock {
+			t.Fatalf("Imported SPY security doesn't match: %+v\n", spy)
+		}
+		prices, err := getPrices(d.clients[1], spy.SecurityId)
+		if err != nil {
+			t.Fatalf("Error fetching prices: %s\n", err)
+		}
+		if len(*prices.Prices) != 4 {
+			t.Errorf("Expected 4 SPY prices, found %d\n", len(*prices.Prices))
+		}
+	})
+}
+
+func TestImportLedger(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		if err := importLedger(d.clients[1], "testdata/example.journal"); err != nil {
+			t.Fatalf("Error importing ledger journal: %s\n", err)
+		}
+
+		accounts, err := getAccounts(d.clients[1])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		balances := []struct {
+			name    string
+			t       models.AccountType
+			balance string
+		}{
+			{"Checking", models.Asset, "503.90"},
+			{"Opening Balances", models.Equity, "-1000"},
+			{"Groceries", models.Expense, "45.10"},
+			{"Brokerage", models.Asset, "2"},
+		}
+		for _, b := range balances {
+			account := findAccountByName(t, accounts, b.name)
+			if account.Type != b.t {
+				t.Errorf("Expected '%s' account type to be %s, found %s\n", b.name, b.t, account.Type)
+			}
+			accountBalanceHelper(t, d.clients[1], account, b.balance)
+		}
+
+		// The stock purchase should be balanced using trading accounts
+		trading := findAccountByPath(accounts, "Trading")
+		if trading == nil || trading.Type != models.Trading {
+			t.Fatalf("Couldn't find 'Trading' account\n")
+		}
+		for _, child := range []struct {
+			name    string
+			balance string
+		}{{"SPY", "-2"}, {"USD", "451"}} {
+			account := findAccountByPath(accounts, "Trading", child.name)
+			if account == nil {
+				t.Fatalf("Couldn't find 'Trading:%s' account\n", child.name)
+			}
+			accountBalanceHelper(t, d.clients[1], account, child.balance)
+		}
+
+		groceries := findAccountByName(t, accounts, "Groceries")
+		transactions, err := getAccountTransactions(d.clients[1], groceries.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		if len(*transactions.Transactions) != 1 {
+			t.Fatalf("Expected 1 groceries transaction, found %d\n", len(*transactions.Transactions))
+		}
+		tran := (*transactions.Transactions)[0]
+		if tran.Description != "Corner Market" {
+			t.Errorf("Expected description 'Corner Market', found '%s'\n", tran.Description)
+		}
+		for _, split := range tran.Splits {
+			if split.Status != models.Cleared {
+				t.Errorf("Expected pending transaction's splits to be cleared, found %d\n", split.Status)
+			}
+			if split.AccountId == groceries.AccountId && split.Memo != "produce" {
+				t.Errorf("Expected memo 'produce', found '%s'\n", split.Memo)
+			}
+		}
+	})
+}
@@ -5,7 +5,9 @@ import (
 	"github.com/aclindsa/moneygo/internal/models"
 	"net/http"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func importOFX(client *http.Client, accountid int64, filename string) error {
@@ -227,3 +229,480 @@ func TestImportOFXBrokerage(t *testing.T) {
 		// TODO check reinvestment/income to make sure they're registered as income?
 	})
 }
+
+func TestImportOFXCorrections(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		if err = importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171129.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4862.85")
+
+		url := "/v1/accounts/" + strconv.FormatInt(d.accounts[1].AccountId, 10) + "/imports/ofxfile"
+		var result models.ImportResult
+		if err = uploadFileWithResponse(d.clients[0], "testdata/checking_20171202_corrections.ofx", url, nil, &result); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if len(result.Corrections) != 2 {
+			t.Errorf("Expected 2 corrections, found %d\n", len(result.Corrections))
+		}
+		// Netflix was replaced with -12.71, the power bill deleted, and the
+		// coffee replaced within the same statement
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4953.05")
+
+		checkCorrections := func() {
+			t.Helper()
+			transactions, err := getTransactions(d.clients[0])
+			if err != nil {
+				t.Fatalf("Error fetching transactions: %s\n", err)
+			}
+			var netflix, power, coffee int
+			for _, tr := range *transactions.Transactions {
+				if strings.HasPrefix(tr.Description, "NETFLIX") {
+					netflix++
+					for _, split := range tr.Splits {
+						if split.AccountId == d.accounts[1].AccountId && (split.RemoteId != "ofx:d1f6a0c2-5e0b-4f55-9c1e-3b1c7f0e8a11" || split.Amount.String() != "-12.71") {
+							t.Errorf("Expected replaced Netflix split to have new RemoteId and amount: %+v\n", split)
+						}
+					}
+				} else if strings.HasPrefix(tr.Description, "DUKEENGY") {
+					power++
+					for _, split := range tr.Splits {
+						if split.Status != models.Voided || split.Amount.Sign() != 0 {
+							t.Errorf("Expected deleted transaction's splits to be voided: %+v\n", split)
+						}
+					}
+				} else if strings.HasPrefix(tr.Description, "COFFEE SHOP") {
+					coffee++
+				}
+			}
+			if netflix != 1 || power != 1 || coffee != 1 {
+				t.Errorf("Expected one each of Netflix, power, and coffee transactions, found %d, %d, and %d\n", netflix, power, coffee)
+			}
+		}
+		checkCorrections()
+
+		// Importing the corrections again shouldn't change anything
+		result = models.ImportResult{}
+		if err = uploadFileWithResponse(d.clients[0], "testdata/checking_20171202_corrections.ofx", url, nil, &result); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if len(result.Corrections) != 0 {
+			t.Errorf("Expected no corrections importing again, found %d\n", len(result.Corrections))
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4953.05")
+		checkCorrections()
+	})
+}
+
+func TestImportOFXCorrectionsReconciled(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		if err = importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171129.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+
+		// Reconcile the transactions which will be replaced and deleted
+		transactions, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		for _, tr := range *transactions.Transactions {
+			if !strings.HasPrefix(tr.Description, "NETFLIX") && !strings.HasPrefix(tr.Description, "DUKEENGY") {
+				continue
+			}
+			for _, split := range tr.Splits {
+				if split.AccountId == d.accounts[1].AccountId {
+					split.Status = models.Reconciled
+				}
+			}
+			if _, err := forceUpdateTransaction(d.clients[0], tr); err != nil {
+				t.Fatalf("Error reconciling transaction: %s\n", err)
+			}
+		}
+
+		url := "/v1/accounts/" + strconv.FormatInt(d.accounts[1].AccountId, 10) + "/imports/ofxfile"
+		var result models.ImportResult
+		if err = uploadFileWithResponse(d.clients[0], "testdata/checking_20171202_corrections.ofx", url, nil, &result); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if len(result.Corrections) != 0 {
+			t.Errorf("Expected no corrections, found %d\n", len(result.Corrections))
+		}
+		if len(result.Rejected) != 2 {
+			t.Fatalf("Expected 2 rejected corrections, found %d\n", len(result.Rejected))
+		}
+		for _, rejected := range result.Rejected {
+			if !strings.HasPrefix(rejected.Transaction.Description, "NETFLIX") && !strings.HasPrefix(rejected.Transaction.Description, "DUKEENGY") {
+				t.Errorf("Unexpected rejected correction: %+v\n", rejected.Transaction)
+			}
+		}
+		// Only the coffee, which wasn't reconciled, was imported
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4856.85")
+	})
+}
+
+func TestImportOFXReversal(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		account, err := createAccount(d.clients[0], &models.Account{
+			SecurityId:      d.securities[0].SecurityId,
+			UserId:          d.users[0].UserId,
+			ParentAccountId: -1,
+			Type:            models.Investment,
+			Name:            "Personal Brokerage",
+		})
+		if err != nil {
+			t.Fatalf("Error creating 'Personal Brokerage' account: %s\n", err)
+		}
+
+		if err = importOFX(d.clients[0], account.AccountId, "testdata/brokerage.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if err = importOFX(d.clients[0], account.AccountId, "testdata/brokerage_reversal.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+
+		// The reversed purchase of 15 shares no longer counts
+		accountBalanceHelper(t, d.clients[0], account, "1065.18")
+		security, err := findSecurity(d.clients[0], "921909768", models.Stock)
+		if err != nil {
+			t.Fatalf("Error finding security: %s\n", err)
+		}
+		stock, err := findAccount(d.clients[0], "VANGUARD TOTAL INTL STOCK INDE", models.Investment, security.SecurityId)
+		if err != nil {
+			t.Fatalf("Error finding account: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], stock, "-10.00000")
+		usdtrading, err := findAccount(d.clients[0], "USD", models.Trading, d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error finding USD trading account: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], usdtrading, "-57.74")
+
+		// Reversing it again has no effect
+		if err = importOFX(d.clients[0], account.AccountId, "testdata/brokerage_reversal.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], account, "1065.18")
+	})
+}
+
+func TestImportOFXBrokerageActions(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		account, err := createAccount(d.clients[0], &models.Account{
+			SecurityId:      d.securities[0].SecurityId,
+			UserId:          d.users[0].UserId,
+			ParentAccountId: -1,
+			Type:            models.Investment,
+			Name:            "Personal Brokerage",
+		})
+		if err != nil {
+			t.Fatalf("Error creating 'Personal Brokerage' account: %s\n", err)
+		}
+
+		if err = importOFX(d.clients[0], account.AccountId, "testdata/brokerage.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		// Contains option trades and closures, a stock split, and journals
+		if err = importOFX(d.clients[0], account.AccountId, "testdata/brokerage_actions.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], account, "219.82")
+
+		checks := []struct {
+			Ticker  string
+			Name    string
+			Balance string
+		}{
+			{"921909768", "VANGUARD TOTAL INTL STOCK INDE", "10.00000"},
+			{"VXUS180119C50", "VXUS JAN 19 2018 50 CALL", "0.00000"},
+			{"VXUS180119P45", "VXUS JAN 19 2018 45 PUT", "0.00000"},
+		}
+		for _, check := range checks {
+			security, err := findSecurity(d.clients[0], check.Ticker, models.Stock)
+			if err != nil {
+				t.Fatalf("Error finding security: %s\n", err)
+			}
+			account, err := findAccount(d.clients[0], check.Name, models.Investment, security.SecurityId)
+			if err != nil {
+				t.Fatalf("Error finding account: %s\n", err)
+			}
+			accountBalanceHelper(t, d.clients[0], account, check.Balance)
+		}
+
+		atl, err := getAccountTransactions(d.clients[0], account.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+
+		// Importing again shouldn't duplicate anything, including journals
+		// with multiple splits in the same account
+		if err = importOFX(d.clients[0], account.AccountId, "testdata/brokerage_actions.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		atl2, err := getAccountTransactions(d.clients[0], account.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		if atl2.TotalTransactions != atl.TotalTransactions {
+			t.Errorf("Expected %d transactions after importing again, found %d\n", atl.TotalTransactions, atl2.TotalTransactions)
+		}
+		accountBalanceHelper(t, d.clients[0], account, "219.82")
+	})
+}
+
+func TestImportOFXPositions(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		account, err := createAccount(d.clients[0], &models.Account{
+			SecurityId:      d.securities[0].SecurityId,
+			UserId:          d.users[0].UserId,
+			ParentAccountId: -1,
+			Type:            models.Investment,
+			Name:            "Personal Brokerage",
+		})
+		if err != nil {
+			t.Fatalf("Error creating 'Personal Brokerage' account: %s\n", err)
+		}
+
+		url := "/v1/accounts/" + strconv.FormatInt(account.AccountId, 10) + "/imports/ofxfile"
+		var result models.ImportResult
+		if err = uploadFileWithResponse(d.clients[0], "testdata/brokerage.ofx", url, nil, &result); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+
+		// The transactions in the file don't account for all the positions it
+		// reports, but do for its cash balance
+		discrepancies := map[string]struct {
+			Reported string
+			Computed string
+		}{
+			"ATO":   {"6.086", "0.086"},
+			"VBMFX": {"37.77", "37.7"},
+			"VMFXX": {"24.87", "-21.57"},
+		}
+		if len(result.Discrepancies) != len(discrepancies) {
+			t.Fatalf("Expected %d discrepancies, found %d\n", len(discrepancies), len(result.Discrepancies))
+		}
+		for ticker, expected := range discrepancies {
+			security, err := findSecurity(d.clients[0], ticker, models.Stock)
+			if err != nil {
+				t.Fatalf("Error finding security: %s\n", err)
+			}
+			var found bool
+			for _, discrepancy := range result.Discrepancies {
+				if discrepancy.SecurityId != security.SecurityId {
+					continue
+				}
+				found = true
+				if discrepancy.AccountId != account.AccountId || discrepancy.Reported.String() != expected.Reported || discrepancy.Computed.String() != expected.Computed {
+					t.Errorf("Unexpected discrepancy for %s: %+v\n", ticker, discrepancy)
+				}
+			}
+			if !found {
+				t.Errorf("Expected discrepancy for %s\n", ticker)
+			}
+		}
+
+		// Each position's price should have been imported, once
+		if err = importOFX(d.clients[0], account.AccountId, "testdata/brokerage.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		ato, err := findSecurity(d.clients[0], "ATO", models.Stock)
+		if err != nil {
+			t.Fatalf("Error finding security: %s\n", err)
+		}
+		pl, err := getPrices(d.clients[0], ato.SecurityId)
+		if err != nil {
+			t.Fatalf("Error fetching prices: %s\n", err)
+		}
+		if len(*pl.Prices) != 1 {
+			t.Fatalf("Expected 1 price for ATO, found %d\n", len(*pl.Prices))
+		}
+		price := (*pl.Prices)[0]
+		if price.CurrencyId != d.securities[0].SecurityId || price.Value.String() != "90.51" || !price.Date.Equal(time.Date(2017, time.November, 28, 21, 0, 0, 0, time.UTC)) {
+			t.Errorf("Unexpected price imported for ATO: %+v\n", price)
+		}
+
+		// Bank statements' ledger balances are checked too
+		var checking models.ImportResult
+		if err = uploadFileWithResponse(d.clients[0], "testdata/checking_20171129.ofx", "/v1/accounts/"+strconv.FormatInt(d.accounts[1].AccountId, 10)+"/imports/ofxfile", nil, &checking); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if len(checking.Discrepancies) != 1 {
+			t.Fatalf("Expected 1 discrepancy, found %d\n", len(checking.Discrepancies))
+		}
+		discrepancy := checking.Discrepancies[0]
+		if discrepancy.SecurityId != d.accounts[1].SecurityId || discrepancy.Reported.String() != "5463.45" || discrepancy.Computed.String() != "4862.85" {
+			t.Errorf("Unexpected discrepancy for checking account: %+v\n", discrepancy)
+		}
+	})
+}
+
+func TestImportOFXMultipleStatements(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		// Statements are matched to accounts by either OFXAcctID or
+		// ExternalAccountId
+		checking := d.accounts[1]
+		checking.OFXAcctID = "11112222"
+		if _, err := updateAccount(d.clients[0], &checking); err != nil {
+			t.Fatalf("Error updating account: %s\n", err)
+		}
+		creditcard := d.accounts[7]
+		creditcard.ExternalAccountId = "5555666677778888"
+		if _, err := updateAccount(d.clients[0], &creditcard); err != nil {
+			t.Fatalf("Error updating account: %s\n", err)
+		}
+
+		var preview models.ImportPreview
+		err = uploadFileWithResponse(d.clients[0], "testdata/multistatement.ofx", "/v1/imports/ofxfile", importOptionsFields(t, &models.ImportOptions{Preview: true}), &preview)
+		if err != nil {
+			t.Fatalf("Error previewing OFX import: %s\n", err)
+		}
+		if len(preview.Transactions) != 5 {
+			t.Errorf("Expected preview to import 5 transactions, found %d\n", len(preview.Transactions))
+		}
+		for _, account := range preview.Accounts {
+			if account.ExternalAccountId == "33334444" {
+				t.Errorf("Expected no account to be created for unmatched statement: %+v\n", account)
+			}
+		}
+		if len(preview.Unmatched) != 1 {
+			t.Fatalf("Expected 1 unmatched statement, found %d\n", len(preview.Unmatched))
+		}
+		savings := preview.Unmatched[0]
+		if savings.ExternalAccountId != "33334444" || savings.OFXAcctID != "33334444" || savings.OFXAcctType != "SAVINGS" || savings.Type != models.Bank || savings.SecurityId != d.securities[0].SecurityId {
+			t.Errorf("Unexpected unmatched statement's account: %+v\n", savings)
+		}
+		// The transactions of unmatched statements still take up indices
+		for _, imported := range preview.Transactions {
+			if imported.Index == 3 || imported.Index == 4 {
+				t.Errorf("Expected savings transactions' indices not to be imported: %+v\n", imported)
+			}
+		}
+		accountBalanceHelper(t, d.clients[0], &checking, "-127.18")
+
+		// Importing into a single account still routes each statement to
+		// the account it matches
+		var result models.ImportResult
+		err = uploadFileWithResponse(d.clients[0], "testdata/multistatement.ofx", "/v1/accounts/"+strconv.FormatInt(creditcard.AccountId, 10)+"/imports/ofxfile", nil, &result)
+		if err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if len(result.Unmatched) != 1 || result.Unmatched[0].ExternalAccountId != "33334444" {
+			t.Errorf("Expected savings statement to be unmatched: %+v\n", result.Unmatched)
+		}
+		accountBalanceHelper(t, d.clients[0], &checking, "1108.61")
+		accountBalanceHelper(t, d.clients[0], &creditcard, "-48.09")
+
+		// Accounts can be created for unmatched statements
+		result = models.ImportResult{}
+		err = uploadFileWithResponse(d.clients[0], "testdata/multistatement.ofx", "/v1/imports/ofxfile", importOptionsFields(t, &models.ImportOptions{CreateAccounts: true}), &result)
+		if err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		if len(result.Unmatched) != 0 {
+			t.Errorf("Expected no unmatched statements when creating accounts: %+v\n", result.Unmatched)
+		}
+		accountBalanceHelper(t, d.clients[0], &checking, "1108.61")
+		accountBalanceHelper(t, d.clients[0], &creditcard, "-48.09")
+
+		accounts, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+		var created *models.Account
+		for _, account := range *accounts.Accounts {
+			if account.ExternalAccountId == "33334444" {
+				created = account
+			}
+		}
+		if created == nil {
+			t.Fatalf("Expected account to be created for savings statement\n")
+		}
+		if created.Name != "33334444" || created.OFXBankID != "987654321" || created.ParentAccountId != -1 {
+			t.Errorf("Unexpected account created for savings statement: %+v\n", created)
+		}
+		accountBalanceHelper(t, d.clients[0], created, "200.42")
+
+		// Files without any statements can't be imported
+		if err := uploadFile(d.clients[0], "testdata/acctinfo.ofx", "/v1/imports/ofxfile"); err == nil {
+			t.Errorf("Expected error importing OFX file without statements\n")
+		}
+	})
+}
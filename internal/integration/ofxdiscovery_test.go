@@ -0,0 +1,127 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func discoverOFXAccounts(client *http.Client, discovery *models.OFXDiscovery) (*models.DiscoveredAccountList, error) {
+	var dl models.DiscoveredAccountList
+	err := create(client, discovery, &dl, "/v1/ofxdiscovery/")
+	if err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+func TestOFXDiscovery(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		standin := &ofxStandIn{filename: "testdata/acctinfo.ofx"}
+		ofxServer := httptest.NewTLSServer(standin)
+		defer ofxServer.Close()
+		// Direct connect requests are made using the default client
+		defaultClient := http.DefaultClient
+		http.DefaultClient = ofxServer.Client()
+		defer func() { http.DefaultClient = defaultClient }()
+
+		discovery := &models.OFXDiscovery{
+			OFXURL:      ofxServer.URL,
+			OFXORG:      "Example",
+			OFXFID:      "12345",
+			OFXUser:     "aclindsa",
+			OFXPassword: "hunter2",
+		}
+		dl, err := discoverOFXAccounts(d.clients[0], discovery)
+		if err != nil {
+			t.Fatalf("Error discovering OFX accounts: %s\n", err)
+		}
+		if _, request := standin.lastRequest(); !strings.Contains(request, "<ACCTINFORQ>") || !strings.Contains(request, "hunter2") {
+			t.Errorf("Expected ACCTINFO request with password: %s\n", request)
+		}
+
+		expected := []models.Account{
+			{Name: "Checking", Type: models.Bank, OFXBankID: "123456789", OFXAcctID: "111222333", OFXAcctType: "CHECKING"},
+			{Name: "Visa", Type: models.Liability, OFXAcctID: "4111111111111111", OFXAcctType: "CC"},
+			{Name: "Brokerage", Type: models.Investment, OFXBankID: "investing.example.com", OFXAcctID: "73728292"},
+		}
+		if len(*dl.Accounts) != len(expected) {
+			t.Fatalf("Expected %d accounts discovered, found %d\n", len(expected), len(*dl.Accounts))
+		}
+		for i, da := range *dl.Accounts {
+			a := da.Account
+			if da.Index != i || da.LinkedAccountId != -1 || a.AccountId != -1 || a.SecurityId != d.users[0].DefaultCurrency || a.ParentAccountId != -1 {
+				t.Errorf("Unexpected discovered account: %+v\n", da)
+			}
+			if a.Name != expected[i].Name || a.Type != expected[i].Type || a.OFXBankID != expected[i].OFXBankID || a.OFXAcctID != expected[i].OFXAcctID || a.OFXAcctType != expected[i].OFXAcctType {
+				t.Errorf("Expected discovered account %+v, found %+v\n", expected[i], a)
+			}
+			if a.OFXURL != ofxServer.URL || a.OFXORG != "Example" || a.OFXFID != "12345" || a.OFXUser != "aclindsa" {
+				t.Errorf("Expected discovered account to have connection details filled in: %+v\n", a)
+			}
+		}
+
+		// Invalid actions
+		for _, invalid := range [][]*models.OFXDiscoveryAction{
+			{{Index: 3, AccountId: -1}},
+			{{Index: 0, AccountId: -1}, {Index: 0, AccountId: -1}},
+			{{Index: 0, AccountId: d.accounts[5].AccountId}},
+			// Can't link a credit card to an investment account
+			{{Index: 1, AccountId: d.accounts[1].AccountId}, {Index: 2, AccountId: d.accounts[1].AccountId}},
+		} {
+			discovery.Actions = invalid
+			if _, err := discoverOFXAccounts(d.clients[0], discovery); err == nil {
+				t.Errorf("Expected error with invalid discovery actions: %+v\n", invalid)
+			}
+		}
+		checking, err := getAccount(d.clients[0], d.accounts[1].AccountId)
+		if err != nil {
+			t.Fatalf("Error fetching account: %s\n", err)
+		}
+		if len(checking.OFXAcctID) != 0 {
+			t.Errorf("Expected failed discovery not to link accounts\n")
+		}
+
+		// Link the checking account, and create the brokerage account
+		discovery.Actions = []*models.OFXDiscoveryAction{
+			{Index: 0, AccountId: d.accounts[1].AccountId},
+			{Index: 2, AccountId: -1},
+		}
+		dl, err = discoverOFXAccounts(d.clients[0], discovery)
+		if err != nil {
+			t.Fatalf("Error discovering OFX accounts: %s\n", err)
+		}
+		checking, err = getAccount(d.clients[0], d.accounts[1].AccountId)
+		if err != nil {
+			t.Fatalf("Error fetching account: %s\n", err)
+		}
+		if checking.Name != d.accounts[1].Name || checking.OFXURL != ofxServer.URL || checking.OFXBankID != "123456789" || checking.OFXAcctID != "111222333" || checking.OFXAcctType != "CHECKING" {
+			t.Errorf("Expected linked account to have OFX fields filled in: %+v\n", checking)
+		}
+		brokerage := (*dl.Accounts)[2].Account
+		if brokerage.AccountId == -1 || (*dl.Accounts)[2].LinkedAccountId != brokerage.AccountId {
+			t.Fatalf("Expected brokerage account to be created: %+v\n", (*dl.Accounts)[2])
+		}
+		created, err := getAccount(d.clients[0], brokerage.AccountId)
+		if err != nil {
+			t.Fatalf("Error fetching created account: %s\n", err)
+		}
+		if created.Name != "Brokerage" || created.Type != models.Investment || created.OFXBankID != "investing.example.com" || created.OFXAcctID != "73728292" {
+			t.Errorf("Unexpected account created: %+v\n", created)
+		}
+
+		// Accounts already set up are recognized
+		discovery.Actions = nil
+		dl, err = discoverOFXAccounts(d.clients[0], discovery)
+		if err != nil {
+			t.Fatalf("Error discovering OFX accounts: %s\n", err)
+		}
+		for i, linked := range []int64{d.accounts[1].AccountId, -1, brokerage.AccountId} {
+			if (*dl.Accounts)[i].LinkedAccountId != linked {
+				t.Errorf("Expected discovered account %d to be linked to %d, found %d\n", i, linked, (*dl.Accounts)[i].LinkedAccountId)
+			}
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package integration_test
+
+import (
+	"bytes"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/ofxgo"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func exportOFX(client *http.Client, accountid int64, query string) (*ofxgo.Response, []byte, error) {
+	ofx, err := download(client, "/v1/accounts/"+strconv.FormatInt(accountid, 10)+"/exports/ofx"+query)
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := ofxgo.ParseResponse(bytes.NewReader(ofx))
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, ofx, nil
+}
+
+func TestExportOFXChecking(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		response, ofx, err := exportOFX(d.clients[0], d.accounts[1].AccountId, "")
+		if err != nil {
+			t.Fatalf("Error exporting OFX: %s\n", err)
+		}
+		if len(response.Bank) != 1 {
+			t.Fatalf("Expected one bank statement, found %d\n", len(response.Bank))
+		}
+		stmt, ok := response.Bank[0].(*ofxgo.StatementResponse)
+		if !ok {
+			t.Fatalf("Bank statement is of unexpected type %T\n", response.Bank[0])
+		}
+		if stmt.CurDef.String() != "USD" {
+			t.Errorf("Expected CURDEF of USD, found %s\n", stmt.CurDef)
+		}
+		if stmt.BankAcctFrom.AcctType != ofxgo.AcctTypeChecking {
+			t.Errorf("Expected ACCTTYPE of CHECKING, found %s\n", stmt.BankAcctFrom.AcctType)
+		}
+		if stmt.BalAmt.String() != "-127.18" {
+			t.Errorf("Expected BALAMT of -127.18, found %s\n", stmt.BalAmt.String())
+		}
+		if stmt.BankTranList == nil || len(stmt.BankTranList.Transactions) != 3 {
+			t.Fatalf("Expected 3 transactions in exported statement\n")
+		}
+		cable := stmt.BankTranList.Transactions[0]
+		if cable.Name != "Cable" || cable.TrnAmt.String() != "-39.99" || cable.TrnType != ofxgo.TrnTypeDebit {
+			t.Errorf("Unexpected first transaction: %+v\n", cable)
+		}
+		fitid := strconv.FormatInt(d.transactions[2].Splits[0].SplitId, 10)
+		if string(cable.FiTID) != fitid {
+			t.Errorf("Expected FITID of %s, found %s\n", fitid, cable.FiTID)
+		}
+
+		// Limiting the dates should limit the transactions, and report the
+		// balance as of the end date
+		response, _, err = exportOFX(d.clients[0], d.accounts[1].AccountId, "?begin=2017-10-01&end=2017-10-20")
+		if err != nil {
+			t.Fatalf("Error exporting OFX: %s\n", err)
+		}
+		stmt = response.Bank[0].(*ofxgo.StatementResponse)
+		if len(stmt.BankTranList.Transactions) != 1 || stmt.BankTranList.Transactions[0].TrnAmt.String() != "-5.6" {
+			t.Errorf("Expected only the first groceries transaction between 2017-10-01 and 2017-10-20\n")
+		}
+		if stmt.BalAmt.String() != "-45.59" {
+			t.Errorf("Expected BALAMT of -45.59, found %s\n", stmt.BalAmt.String())
+		}
+
+		if _, _, err := exportOFX(d.clients[0], d.accounts[1].AccountId, "?begin=2017-10-20&end=2017-10-01"); err == nil {
+			t.Errorf("Expected error exporting OFX ending before it begins\n")
+		}
+		if _, _, err := exportOFX(d.clients[0], d.accounts[5].AccountId, ""); err == nil {
+			t.Errorf("Expected error exporting OFX for another user's account\n")
+		}
+
+		// Ensure the export can be imported into a new account
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		account, err := createAccount(d.clients[0], &models.Account{
+			SecurityId:      d.securities[0].SecurityId,
+			UserId:          d.users[0].UserId,
+			ParentAccountId: -1,
+			Type:            models.Bank,
+			Name:            "Checking Copy",
+		})
+		if err != nil {
+			t.Fatalf("Error creating account: %s\n", err)
+		}
+
+		dir, err := ioutil.TempDir("", "moneygo")
+		if err != nil {
+			t.Fatalf("Error creating temporary directory: %s\n", err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "export.ofx")
+		if err := ioutil.WriteFile(filename, ofx, 0600); err != nil {
+			t.Fatalf("Error writing exported OFX: %s\n", err)
+		}
+		if err := importOFX(d.clients[0], account.AccountId, filename); err != nil {
+			t.Fatalf("Error importing exported OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], account, "-127.18")
+	})
+}
+
+func TestExportOFXInvestment(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		account, err := createAccount(d.clients[0], &models.Account{
+			SecurityId:      d.securities[0].SecurityId,
+			UserId:          d.users[0].UserId,
+			ParentAccountId: -1,
+			Type:            models.Investment,
+			Name:            "401k",
+		})
+		if err != nil {
+			t.Fatalf("Error creating 401k account: %s\n", err)
+		}
+		if err = importOFX(d.clients[0], account.AccountId, "testdata/401k_mutualfunds.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+
+		response, _, err := exportOFX(d.clients[0], account.AccountId, "")
+		if err != nil {
+			t.Fatalf("Error exporting OFX: %s\n", err)
+		}
+		if len(response.InvStmt) != 1 {
+			t.Fatalf("Expected one investment statement, found %d\n", len(response.InvStmt))
+		}
+		stmt, ok := response.InvStmt[0].(*ofxgo.InvStatementResponse)
+		if !ok {
+			t.Fatalf("Investment statement is of unexpected type %T\n", response.InvStmt[0])
+		}
+		if stmt.InvBal == nil || stmt.InvBal.AvailCash.String() != "-192.1" {
+			t.Errorf("Expected AVAILCASH of -192.10\n")
+		}
+		if len(stmt.InvPosList) != 1 {
+			t.Fatalf("Expected one position, found %d\n", len(stmt.InvPosList))
+		}
+		position, ok := stmt.InvPosList[0].(ofxgo.StockPosition)
+		if !ok {
+			t.Fatalf("Position is of unexpected type %T\n", stmt.InvPosList[0])
+		}
+		if position.InvPos.Units.FloatString(3) != "3.354" {
+			t.Errorf("Expected position of 3.354 units, found %s\n", position.InvPos.Units.String())
+		}
+		if stmt.InvTranList == nil || len(stmt.InvTranList.InvTransactions) == 0 {
+			t.Errorf("Expected investment transactions in exported statement\n")
+		}
+		if len(response.SecList) != 1 {
+			t.Errorf("Expected security list in exported statement\n")
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func createOFXSync(client *http.Client, sync *models.OFXSync) (*models.OFXSync, error) {
+	var s models.OFXSync
+	err := create(client, sync, &s, "/v1/ofxsyncs/")
+	return &s, err
+}
+
+func getOFXSync(client *http.Client, ofxsyncid int64) (*models.OFXSync, error) {
+	var s models.OFXSync
+	err := read(client, &s, "/v1/ofxsyncs/"+strconv.FormatInt(ofxsyncid, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func getOFXSyncs(client *http.Client) (*models.OFXSyncList, error) {
+	var sl models.OFXSyncList
+	err := read(client, &sl, "/v1/ofxsyncs/")
+	if err != nil {
+		return nil, err
+	}
+	return &sl, nil
+}
+
+func updateOFXSync(client *http.Client, sync *models.OFXSync) (*models.OFXSync, error) {
+	var s models.OFXSync
+	err := update(client, sync, &s, "/v1/ofxsyncs/"+strconv.FormatInt(sync.OFXSyncId, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func deleteOFXSync(client *http.Client, s *models.OFXSync) error {
+	err := remove(client, "/v1/ofxsyncs/"+strconv.FormatInt(s.OFXSyncId, 10))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ofxStandIn is a local OFX server which responds to every request with the
+// contents of a file, and remembers the requests it receives
+type ofxStandIn struct {
+	mutex    sync.Mutex
+	filename string // responds with an error if empty
+	requests []string
+}
+
+func (o *ofxStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	o.requests = append(o.requests, string(body))
+	if len(o.filename) == 0 {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	http.ServeFile(w, r, o.filename)
+}
+
+func (o *ofxStandIn) lastRequest() (int, string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if len(o.requests) == 0 {
+		return 0, ""
+	}
+	return len(o.requests), o.requests[len(o.requests)-1]
+}
+
+func TestOFXSync(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		standin := &ofxStandIn{filename: "testdata/checking_20171129.ofx"}
+		ofxServer := httptest.NewTLSServer(standin)
+		defer ofxServer.Close()
+		ofxSyncer.Client = ofxServer.Client()
+		defer func() { ofxSyncer.Client = nil }()
+
+		checking := d.accounts[1]
+		checking.OFXURL = ofxServer.URL
+		checking.OFXUser = "aclindsa"
+		checking.OFXBankID = "12345"
+		checking.OFXAcctID = "6789"
+		checking.OFXAcctType = "CHECKING"
+		if _, err := updateAccount(d.clients[0], &checking); err != nil {
+			t.Fatalf("Error updating account: %s\n", err)
+		}
+
+		// Accounts must be configured for OFX, and syncs need an interval
+		// and password
+		for _, invalid := range []models.OFXSync{
+			{AccountId: d.accounts[3].AccountId, Interval: 60, Password: "hunter2"},
+			{AccountId: checking.AccountId, Interval: 0, Password: "hunter2"},
+			{AccountId: checking.AccountId, Interval: 60},
+			{AccountId: d.accounts[5].AccountId, Interval: 60, Password: "hunter2"},
+		} {
+			if _, err := createOFXSync(d.clients[0], &invalid); err == nil {
+				t.Errorf("Expected error creating invalid OFX sync: %+v\n", invalid)
+			}
+		}
+
+		s, err := createOFXSync(d.clients[0], &models.OFXSync{AccountId: checking.AccountId, Interval: 60, Password: "hunter2"})
+		if err != nil {
+			t.Fatalf("Error creating OFX sync: %s\n", err)
+		}
+		if s.UserId != d.users[0].UserId || len(s.Password) != 0 || !s.LastAttempt.IsZero() {
+			t.Errorf("Unexpected OFX sync created: %+v\n", s)
+		}
+		if _, err := createOFXSync(d.clients[0], &models.OFXSync{AccountId: checking.AccountId, Interval: 60, Password: "hunter2"}); err == nil {
+			t.Errorf("Expected error creating second OFX sync for the same account\n")
+		}
+		if _, err := getOFXSync(d.clients[1], s.OFXSyncId); err == nil {
+			t.Errorf("Expected error fetching another user's OFX sync\n")
+		}
+
+		// New syncs are due immediately
+		now := time.Now()
+		if err := ofxSyncer.SyncDue(now); err != nil {
+			t.Fatalf("Error syncing: %s\n", err)
+		}
+		count, request := standin.lastRequest()
+		if count != 1 {
+			t.Fatalf("Expected 1 OFX request, found %d\n", count)
+		}
+		if !strings.Contains(request, "hunter2") || !strings.Contains(request, "6789") {
+			t.Errorf("Expected OFX request to contain decrypted password and account: %s\n", request)
+		}
+		if strings.Contains(request, "<DTSTART>") {
+			t.Errorf("Expected first OFX request not to have a start date: %s\n", request)
+		}
+		accountBalanceHelper(t, d.clients[0], &checking, "4862.85")
+
+		s, err = getOFXSync(d.clients[0], s.OFXSyncId)
+		if err != nil {
+			t.Fatalf("Error fetching OFX sync: %s\n", err)
+		}
+		if len(s.Error) != 0 || s.LastSuccess.IsZero() || !s.LastAttempt.Equal(s.LastSuccess) || s.NextSync.Sub(s.LastAttempt) != time.Hour {
+			t.Errorf("Unexpected OFX sync status after syncing: %+v\n", s)
+		}
+
+		// Not due again for an hour
+		if err := ofxSyncer.SyncDue(now.Add(30 * time.Minute)); err != nil {
+			t.Fatalf("Error syncing: %s\n", err)
+		}
+		if count, _ := standin.lastRequest(); count != 1 {
+			t.Errorf("Expected no more OFX requests before sync is due, found %d\n", count)
+		}
+
+		// The next sync starts from the last successful one, and doesn't
+		// duplicate the transactions already imported
+		if err := ofxSyncer.SyncDue(now.Add(time.Hour)); err != nil {
+			t.Fatalf("Error syncing: %s\n", err)
+		}
+		count, request = standin.lastRequest()
+		if count != 2 {
+			t.Fatalf("Expected 2 OFX requests, found %d\n", count)
+		}
+		if !strings.Contains(request, "<DTSTART>"+s.LastSuccess.Format("20060102150405")) {
+			t.Errorf("Expected OFX request to start at last successful sync (%s): %s\n", s.LastSuccess, request)
+		}
+		accountBalanceHelper(t, d.clients[0], &checking, "4862.85")
+
+		// Failures are recorded, and don't move the start date forward
+		lastSuccess := now.Add(time.Hour).UTC().Truncate(time.Second)
+		standin.mutex.Lock()
+		standin.filename = ""
+		standin.mutex.Unlock()
+		if err := ofxSyncer.SyncDue(now.Add(2 * time.Hour)); err != nil {
+			t.Fatalf("Error syncing: %s\n", err)
+		}
+		s, err = getOFXSync(d.clients[0], s.OFXSyncId)
+		if err != nil {
+			t.Fatalf("Error fetching OFX sync: %s\n", err)
+		}
+		if !strings.Contains(s.Error, "503") || !s.LastSuccess.Equal(lastSuccess) || s.LastAttempt.Equal(s.LastSuccess) {
+			t.Errorf("Unexpected OFX sync status after failing: %+v\n", s)
+		}
+
+		// Changing the interval reschedules the next sync, and the password
+		// is kept unless a new one is given
+		s.Interval = 10
+		s.Password = models.BogusPassword
+		updated, err := updateOFXSync(d.clients[0], s)
+		if err != nil {
+			t.Fatalf("Error updating OFX sync: %s\n", err)
+		}
+		if updated.NextSync.Sub(updated.LastAttempt) != 10*time.Minute || updated.Error != s.Error {
+			t.Errorf("Unexpected OFX sync after update: %+v\n", updated)
+		}
+		standin.mutex.Lock()
+		standin.filename = "testdata/checking_20171129.ofx"
+		standin.mutex.Unlock()
+		if err := ofxSyncer.SyncDue(now.Add(2*time.Hour + 10*time.Minute)); err != nil {
+			t.Fatalf("Error syncing: %s\n", err)
+		}
+		if _, request := standin.lastRequest(); !strings.Contains(request, "hunter2") {
+			t.Errorf("Expected OFX request to contain unchanged password: %s\n", request)
+		}
+		if s, err = getOFXSync(d.clients[0], s.OFXSyncId); err != nil || len(s.Error) != 0 {
+			t.Errorf("Expected OFX sync to succeed again: %+v\n", s)
+		}
+
+		sl, err := getOFXSyncs(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching OFX syncs: %s\n", err)
+		}
+		if len(*sl.OFXSyncs) != 1 || (*sl.OFXSyncs)[0].OFXSyncId != s.OFXSyncId {
+			t.Errorf("Unexpected OFX syncs: %+v\n", *sl.OFXSyncs)
+		}
+		if sl, err := getOFXSyncs(d.clients[1]); err != nil || len(*sl.OFXSyncs) != 0 {
+			t.Errorf("Expected other user not to see OFX syncs\n")
+		}
+
+		if err := deleteOFXSync(d.clients[0], s); err != nil {
+			t.Fatalf("Error deleting OFX sync: %s\n", err)
+		}
+		if _, err := getOFXSync(d.clients[0], s.OFXSyncId); err == nil {
+			t.Errorf("Expected error fetching deleted OFX sync\n")
+		}
+	})
+}
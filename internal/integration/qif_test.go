@@ -0,0 +1,132 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"testing"
+)
+
+func importQIF(client *http.Client, filename string) error {
+	return uploadFile(client, filename, "/v1/imports/qif")
+}
+
+// findAccountByPath returns the account at the end of the given path of
+// account names, starting from the top level
+func findAccountByPath(accounts *models.AccountList, path ...string) *models.Account {
+	var parentid int64 = -1
+	var found *models.Account
+	for _, name := range path {
+		found = nil
+		for _, account := range *accounts.Accounts {
+			if account.Name == name && account.ParentAccountId == parentid {
+				found = account
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		parentid = found.AccountId
+	}
+	return found
+}
+
+func TestImportQIF(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		// Importing twice shouldn't duplicate any transactions
+		for i := 0; i < 2; i++ {
+			if err = importQIF(d.clients[0], "testdata/example.qif"); err != nil {
+				t.Fatalf("Error importing from QIF: %s\n", err)
+			}
+		}
+
+		accounts, err := getAccounts(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching accounts: %s\n", err)
+		}
+
+		balances := []struct {
+			path    []string
+			t       models.AccountType
+			balance string
+		}{
+			{[]string{"Checking"}, models.Bank, "707.05"},
+			{[]string{"Savings"}, models.Bank, "501.25"},
+			{[]string{"Visa"}, models.Liability, "-45.99"},
+			{[]string{"Brokerage"}, models.Investment, "96.15"},
+			{[]string{"Brokerage", "SPDR S&P 500 ETF"}, models.Investment, "4"},
+			{[]string{"Income", "Salary"}, models.Income, "-2500"},
+			{[]string{"Income", "Interest Income"}, models.Income, "-1.25"},
+			{[]string{"Income", "Dividends"}, models.Income, "-3.15"},
+			{[]string{"Expenses", "Groceries", "Produce"}, models.Expense, "82.45"},
+			{[]string{"Expenses", "Utilities", "Electric"}, models.Expense, "150.25"},
+			{[]string{"Expenses", "Utilities", "Water"}, models.Expense, "60.25"},
+			{[]string{"Expenses", "Books"}, models.Expense, "45.99"},
+			{[]string{"Expenses", "Commissions"}, models.Expense, "5"},
+		}
+		for _, b := range balances {
+			account := findAccountByPath(accounts, b.path...)
+			if account == nil {
+				t.Fatalf("Couldn't find '%v' account\n", b.path)
+			}
+			if account.Type != b.t {
+				t.Errorf("Expected '%v' account type to be %s, found %s\n", b.path, b.t, account.Type)
+			}
+			accountBalanceHelper(t, d.clients[0], account, b.balance)
+		}
+
+		// The transfer from checking to savings appears in both registers,
+		// but should only be imported once, with each side's cleared status
+		savings := findAccountByPath(accounts, "Savings")
+		transactions, err := getAccountTransactions(d.clients[0], savings.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		if len(*transactions.Transactions) != 2 {
+			t.Fatalf("Expected 2 savings transactions, found %d\n", len(*transactions.Transactions))
+		}
+		for _, tran := range *transactions.Transactions {
+			for _, split := range tran.Splits {
+				if split.AccountId == savings.AccountId && amountsMatch(split.Amount, "500") && split.Status != models.Reconciled {
+					t.Errorf("Expected transfer into savings to be reconciled, found status %d\n", split.Status)
+				} else if split.AccountId != savings.AccountId && amountsMatch(split.Amount, "-500") && split.Status != models.Imported {
+					t.Errorf("Expected transfer out of checking to be imported, found status %d\n", split.Status)
+				}
+			}
+		}
+
+		var spy *models.Security
+		securities, err := getSecurities(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching securities: %s\n", err)
+		}
+		for i, security := range *securities.Securities {
+			if security.Symbol == "SPY" && security.Name == "SPDR S&P 500 ETF" {
+				spy = (*securities.Securities)[i]
+			}
+		}
+		if spy == nil {
+			t.Fatalf("Couldn't find SPY security")
+		}
+		prices, err := getPrices(d.clients[0], spy.SecurityId)
+		if err != nil {
+			t.Fatalf("Error fetching prices: %s\n", err)
+		}
+		if len(*prices.Prices) != 1 || (*prices.Prices)[0].CurrencyId != d.securities[0].SecurityId || !amountsMatch((*prices.Prices)[0].Value, "227.53") {
+			t.Errorf("Error finding expected SPY price\n")
+		}
+	})
+}
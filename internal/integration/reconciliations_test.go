@@ -0,0 +1,241 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/handlers"
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func reconciliationsURL(accountid int64) string {
+	return "/v1/accounts/" + strconv.FormatInt(accountid, 10) + "/reconciliations/"
+}
+
+func createReconciliation(client *http.Client, r *models.Reconciliation) (*models.Reconciliation, error) {
+	var reconciliation models.Reconciliation
+	err := create(client, r, &reconciliation, reconciliationsURL(r.AccountId))
+	return &reconciliation, err
+}
+
+func getReconciliation(client *http.Client, accountid, reconciliationid int64) (*models.Reconciliation, error) {
+	var r models.Reconciliation
+	err := read(client, &r, reconciliationsURL(accountid)+strconv.FormatInt(reconciliationid, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func getReconciliations(client *http.Client, accountid int64) (*models.ReconciliationList, error) {
+	var rl models.ReconciliationList
+	err := read(client, &rl, reconciliationsURL(accountid))
+	if err != nil {
+		return nil, err
+	}
+	return &rl, nil
+}
+
+func updateReconciliation(client *http.Client, r *models.Reconciliation) (*models.Reconciliation, error) {
+	var reconciliation models.Reconciliation
+	err := update(client, r, &reconciliation, reconciliationsURL(r.AccountId)+strconv.FormatInt(r.ReconciliationId, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &reconciliation, nil
+}
+
+func deleteReconciliation(client *http.Client, r *models.Reconciliation) error {
+	return remove(client, reconciliationsURL(r.AccountId)+strconv.FormatInt(r.ReconciliationId, 10))
+}
+
+// accountSplit returns t's split in the account
+func accountSplit(t *models.Transaction, accountid int64) *models.Split {
+	for _, s := range t.Splits {
+		if s.AccountId == accountid {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestReconciliations(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		card := d.accounts[7].AccountId
+		charge := func(date time.Time, amount string, status int64) *models.Transaction {
+			transaction, err := createTransaction(d.clients[0], &models.Transaction{
+				UserId:      d.users[0].UserId,
+				Description: "Charge",
+				Date:        date,
+				Splits: []*models.Split{
+					{Status: status, AccountId: card, SecurityId: -1, Amount: NewAmount("-" + amount)},
+					{Status: models.Entered, AccountId: d.accounts[4].AccountId, SecurityId: -1, Amount: NewAmount(amount)},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Error creating transaction: %s\n", err)
+			}
+			return transaction
+		}
+		cleared := charge(time.Date(2017, time.October, 5, 0, 0, 0, 0, time.UTC), "25.00", models.Cleared)
+		entered := charge(time.Date(2017, time.October, 12, 0, 0, 0, 0, time.UTC), "40.50", models.Entered)
+		later := charge(time.Date(2017, time.November, 3, 0, 0, 0, 0, time.UTC), "10.00", models.Entered)
+
+		r, err := createReconciliation(d.clients[0], &models.Reconciliation{
+			AccountId:        card,
+			StatementDate:    time.Date(2017, time.October, 31, 0, 0, 0, 0, time.UTC),
+			StatementBalance: NewAmount("-65.50"),
+		})
+		if err != nil {
+			t.Fatalf("Error starting reconciliation: %s\n", err)
+		}
+		// Splits which were already cleared start out selected
+		if r.Finished || len(r.SplitIds) != 1 || r.SplitIds[0] != accountSplit(cleared, card).SplitId {
+			t.Errorf("Unexpected splits selected in new reconciliation: %+v\n", r)
+		}
+		if !amountsMatch(r.StartingBalance, "0") || !amountsMatch(r.ClearedBalance, "-25") || !amountsMatch(r.Difference, "-40.5") {
+			t.Errorf("Unexpected balances in new reconciliation: %+v\n", r)
+		}
+		if r.Transactions == nil || len(*r.Transactions) != 2 {
+			t.Errorf("Expected the 2 transactions up to the statement date to be returned: %+v\n", r.Transactions)
+		}
+
+		if _, err := createReconciliation(d.clients[0], &models.Reconciliation{AccountId: card, StatementDate: r.StatementDate}); err == nil {
+			t.Errorf("Expected error starting second reconciliation for an account\n")
+		}
+		if _, err := getReconciliation(d.clients[1], card, r.ReconciliationId); err == nil {
+			t.Errorf("Expected error fetching another user's reconciliation\n")
+		}
+
+		// It can't be finished until it balances
+		r.Finished = true
+		_, err = updateReconciliation(d.clients[0], r)
+		if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 9 { // Unbalanced Reconciliation
+			t.Fatalf("Expected 'Unbalanced Reconciliation' error finishing reconciliation: %s\n", err)
+		}
+
+		// Splits after the statement date can't be selected
+		r.Finished = false
+		r.SplitIds = []int64{accountSplit(cleared, card).SplitId, accountSplit(later, card).SplitId}
+		if _, err := updateReconciliation(d.clients[0], r); err == nil {
+			t.Errorf("Expected error selecting split after statement date\n")
+		}
+
+		r.SplitIds = []int64{accountSplit(cleared, card).SplitId, accountSplit(entered, card).SplitId}
+		r, err = updateReconciliation(d.clients[0], r)
+		if err != nil {
+			t.Fatalf("Error updating reconciliation: %s\n", err)
+		}
+		if !amountsMatch(r.ClearedBalance, "-65.50") || !amountsMatch(r.Difference, "0") {
+			t.Errorf("Unexpected balances after selecting splits: %+v\n", r)
+		}
+
+		before, err := getAccount(d.clients[0], card)
+		if err != nil {
+			t.Fatalf("Error fetching account: %s\n", err)
+		}
+		r.Finished = true
+		r, err = updateReconciliation(d.clients[0], r)
+		if err != nil {
+			t.Fatalf("Error finishing reconciliation: %s\n", err)
+		}
+		if !r.Finished || r.FinishedDate.IsZero() {
+			t.Errorf("Expected reconciliation to be finished: %+v\n", r)
+		}
+		after, err := getAccount(d.clients[0], card)
+		if err != nil {
+			t.Fatalf("Error fetching account: %s\n", err)
+		}
+		if after.AccountVersion <= before.AccountVersion {
+			t.Errorf("Expected finishing reconciliation to bump AccountVersion\n")
+		}
+		for _, transaction := range []*models.Transaction{cleared, entered, later} {
+			tr, err := getTransaction(d.clients[0], transaction.TransactionId)
+			if err != nil {
+				t.Fatalf("Error fetching transaction: %s\n", err)
+			}
+			reconciled := accountSplit(tr, card).Status == models.Reconciled
+			if reconciled != (transaction != later) {
+				t.Errorf("Unexpected split status after reconciling: %+v\n", accountSplit(tr, card))
+			}
+		}
+
+		// Finished reconciliations are kept, and can't be changed
+		r.StatementBalance = NewAmount("-100")
+		if _, err := updateReconciliation(d.clients[0], r); err == nil {
+			t.Errorf("Expected error updating finished reconciliation\n")
+		}
+		if err := deleteReconciliation(d.clients[0], r); err == nil {
+			t.Errorf("Expected error deleting finished reconciliation\n")
+		}
+
+		// Reconciled splits can't be changed or deleted unless forced, though
+		// the rest of their transactions can be
+		tr, err := getTransaction(d.clients[0], entered.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching transaction: %s\n", err)
+		}
+		tr.Description = "Restaurant"
+		if tr, err = updateTransaction(d.clients[0], tr); err != nil {
+			t.Fatalf("Error updating description of reconciled transaction: %s\n", err)
+		}
+		moved := *tr
+		moved.Date = moved.Date.AddDate(0, 1, 0)
+		_, err = updateTransaction(d.clients[0], &moved)
+		if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 10 { // Reconciled Split
+			t.Errorf("Expected 'Reconciled Split' error changing the date of a reconciled transaction: %s\n", err)
+		}
+		added := *tr
+		added.Splits = append([]*models.Split{}, tr.Splits...)
+		added.Splits = append(added.Splits,
+			&models.Split{Status: models.Reconciled, AccountId: card, SecurityId: -1, Amount: NewAmount("-1")},
+			&models.Split{Status: models.Entered, AccountId: d.accounts[4].AccountId, SecurityId: -1, Amount: NewAmount("1")})
+		_, err = updateTransaction(d.clients[0], &added)
+		if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 10 { // Reconciled Split
+			t.Errorf("Expected 'Reconciled Split' error adding a reconciled split: %s\n", err)
+		}
+		accountSplit(tr, card).Amount = NewAmount("-41.50")
+		accountSplit(tr, d.accounts[4].AccountId).Amount = NewAmount("41.50")
+		_, err = updateTransaction(d.clients[0], tr)
+		if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 10 { // Reconciled Split
+			t.Errorf("Expected 'Reconciled Split' error updating reconciled split: %s\n", err)
+		}
+		err = deleteTransaction(d.clients[0], tr)
+		if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 10 { // Reconciled Split
+			t.Errorf("Expected 'Reconciled Split' error deleting reconciled transaction: %s\n", err)
+		}
+		if _, err := forceUpdateTransaction(d.clients[0], tr); err != nil {
+			t.Errorf("Error forcing update of reconciled split: %s\n", err)
+		}
+
+		// The next reconciliation starts from the reconciled balance
+		next, err := createReconciliation(d.clients[0], &models.Reconciliation{
+			AccountId:        card,
+			StatementDate:    time.Date(2017, time.November, 30, 0, 0, 0, 0, time.UTC),
+			StatementBalance: NewAmount("-76.50"),
+		})
+		if err != nil {
+			t.Fatalf("Error starting reconciliation: %s\n", err)
+		}
+		if !amountsMatch(next.StartingBalance, "-66.50") || len(next.SplitIds) != 0 || next.Transactions == nil || len(*next.Transactions) != 1 {
+			t.Errorf("Unexpected second reconciliation: %+v\n", next)
+		}
+
+		rl, err := getReconciliations(d.clients[0], card)
+		if err != nil {
+			t.Fatalf("Error fetching reconciliations: %s\n", err)
+		}
+		if len(*rl.Reconciliations) != 2 || (*rl.Reconciliations)[0].ReconciliationId != next.ReconciliationId {
+			t.Errorf("Expected 2 reconciliations, most recent first: %+v\n", rl)
+		}
+
+		// Reconciliations in progress can be abandoned
+		if err := deleteReconciliation(d.clients[0], next); err != nil {
+			t.Fatalf("Error deleting reconciliation: %s\n", err)
+		}
+		if rl, err := getReconciliations(d.clients[0], card); err != nil || len(*rl.Reconciliations) != 1 {
+			t.Errorf("Expected 1 reconciliation after deleting one in progress: %+v, %s\n", rl, err)
+		}
+	})
+}
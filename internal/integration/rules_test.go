@@ -0,0 +1,273 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func createRule(client *http.Client, rule *models.Rule) (*models.Rule, error) {
+	var r models.Rule
+	err := create(client, rule, &r, "/v1/rules/")
+	return &r, err
+}
+
+func getRule(client *http.Client, ruleid int64) (*models.Rule, error) {
+	var r models.Rule
+	err := read(client, &r, "/v1/rules/"+strconv.FormatInt(ruleid, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func getRules(client *http.Client) (*models.RuleList, error) {
+	var rl models.RuleList
+	err := read(client, &rl, "/v1/rules/")
+	if err != nil {
+		return nil, err
+	}
+	return &rl, nil
+}
+
+func updateRule(client *http.Client, rule *models.Rule) (*models.Rule, error) {
+	var r models.Rule
+	err := update(client, rule, &r, "/v1/rules/"+strconv.FormatInt(rule.RuleId, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func deleteRule(client *http.Client, r *models.Rule) error {
+	err := remove(client, "/v1/rules/"+strconv.FormatInt(r.RuleId, 10))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func newAmountPtr(amt string) *models.Amount {
+	a := NewAmount(amt)
+	return &a
+}
+
+func TestRules(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		groceries := &models.Rule{
+			Name:             "Groceries",
+			Priority:         10,
+			MatchType:        models.SubstringMatch,
+			DescriptionMatch: "grocery",
+			SourceAccountId:  -1,
+			ImportSplitType:  -1,
+			Targets:          []*models.RuleTarget{{AccountId: d.accounts[3].AccountId, Percent: NewAmount("100")}},
+		}
+		r, err := createRule(d.clients[0], groceries)
+		if err != nil {
+			t.Fatalf("Error creating rule: %s\n", err)
+		}
+		if r.RuleId == 0 || r.UserId != d.users[0].UserId || r.Name != "Groceries" || len(r.Targets) != 1 || r.Targets[0].AccountId != d.accounts[3].AccountId || r.Targets[0].Percent.String() != "100" {
+			t.Errorf("Unexpected rule created: %+v\n", r)
+		}
+
+		split := &models.Rule{
+			Name:            "Cable and groceries",
+			Priority:        5,
+			MatchType:       models.RegexpMatch,
+			MemoMatch:       "^[0-9]+$",
+			MinAmount:       newAmountPtr("10.5"),
+			MaxAmount:       newAmountPtr("100"),
+			SourceAccountId: d.accounts[1].AccountId,
+			ImportSplitType: models.ExternalAccount,
+			Description:     "Split",
+			Targets: []*models.RuleTarget{
+				{AccountId: d.accounts[3].AccountId, Percent: NewAmount("33.333")},
+				{AccountId: d.accounts[4].AccountId, Percent: NewAmount("66.667")},
+			},
+		}
+		r2, err := createRule(d.clients[0], split)
+		if err != nil {
+			t.Fatalf("Error creating rule: %s\n", err)
+		}
+		r2, err = getRule(d.clients[0], r2.RuleId)
+		if err != nil {
+			t.Fatalf("Error fetching rule: %s\n", err)
+		}
+		if r2.MinAmount == nil || r2.MinAmount.String() != "10.5" || r2.MaxAmount == nil || r2.MaxAmount.String() != "100" {
+			t.Errorf("Rule's amount bounds don't match\n")
+		}
+		if len(r2.Targets) != 2 || r2.Targets[1].Percent.String() != "66.667" {
+			t.Errorf("Rule's targets don't match\n")
+		}
+
+		rl, err := getRules(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching rules: %s\n", err)
+		}
+		if len(*rl.Rules) != 2 || (*rl.Rules)[0].RuleId != r2.RuleId || (*rl.Rules)[1].RuleId != r.RuleId {
+			t.Errorf("Expected rules to be returned in priority order\n")
+		}
+		if rl, err := getRules(d.clients[1]); err != nil || len(*rl.Rules) != 0 {
+			t.Errorf("Expected other user not to see rules\n")
+		}
+		if _, err := getRule(d.clients[1], r.RuleId); err == nil {
+			t.Errorf("Expected error fetching another user's rule\n")
+		}
+
+		// Invalid rules
+		for _, invalid := range []models.Rule{
+			{MatchType: models.SubstringMatch, SourceAccountId: -1, ImportSplitType: -1},
+			{MatchType: 0, SourceAccountId: -1, ImportSplitType: -1, Description: "x"},
+			{MatchType: models.RegexpMatch, DescriptionMatch: "(", SourceAccountId: -1, ImportSplitType: -1, Description: "x"},
+			{MatchType: models.SubstringMatch, SourceAccountId: -1, ImportSplitType: -1, MinAmount: newAmountPtr("2"), MaxAmount: newAmountPtr("1"), Description: "x"},
+			{MatchType: models.SubstringMatch, SourceAccountId: d.accounts[5].AccountId, ImportSplitType: -1, Description: "x"},
+			{MatchType: models.SubstringMatch, SourceAccountId: -1, ImportSplitType: -1, Targets: []*models.RuleTarget{{AccountId: d.accounts[3].AccountId, Percent: NewAmount("99")}}},
+			{MatchType: models.SubstringMatch, SourceAccountId: -1, ImportSplitType: -1, Targets: []*models.RuleTarget{{AccountId: d.accounts[6].AccountId, Percent: NewAmount("100")}}},
+		} {
+			if _, err := createRule(d.clients[0], &invalid); err == nil {
+				t.Errorf("Expected error creating invalid rule: %+v\n", invalid)
+			}
+		}
+
+		r.Priority = 1
+		r.Targets = []*models.RuleTarget{{AccountId: d.accounts[4].AccountId, Percent: NewAmount("100")}}
+		updated, err := updateRule(d.clients[0], r)
+		if err != nil {
+			t.Fatalf("Error updating rule: %s\n", err)
+		}
+		updated, err = getRule(d.clients[0], updated.RuleId)
+		if err != nil {
+			t.Fatalf("Error fetching rule: %s\n", err)
+		}
+		if updated.Priority != 1 || len(updated.Targets) != 1 || updated.Targets[0].AccountId != d.accounts[4].AccountId {
+			t.Errorf("Rule wasn't updated: %+v\n", updated)
+		}
+		if _, err := updateRule(d.clients[1], r); err == nil {
+			t.Errorf("Expected error updating another user's rule\n")
+		}
+
+		if err := deleteRule(d.clients[0], r); err != nil {
+			t.Fatalf("Error deleting rule: %s\n", err)
+		}
+		if _, err := getRule(d.clients[0], r.RuleId); err == nil {
+			t.Errorf("Expected error fetching deleted rule\n")
+		}
+
+		// Deleting an account a rule refers to deletes the rule
+		if err := deleteAccount(d.clients[0], &d.accounts[4]); err != nil {
+			t.Fatalf("Error deleting account: %s\n", err)
+		}
+		if _, err := getRule(d.clients[0], r2.RuleId); err == nil {
+			t.Errorf("Expected rule to be deleted along with its target account\n")
+		}
+	})
+}
+
+func TestImportOFXWithRules(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		var expenses [2]*models.Account
+		for i, name := range []string{"Shopping", "Utilities"} {
+			expenses[i], err = createAccount(d.clients[0], &models.Account{
+				SecurityId:      d.securities[0].SecurityId,
+				UserId:          d.users[0].UserId,
+				ParentAccountId: d.accounts[2].AccountId,
+				Type:            models.Expense,
+				Name:            name,
+			})
+			if err != nil {
+				t.Fatalf("Error creating account: %s\n", err)
+			}
+		}
+		shopping, utilities := expenses[0], expenses[1]
+
+		for _, rule := range []*models.Rule{
+			{
+				Name:             "Target",
+				Priority:         2,
+				MatchType:        models.SubstringMatch,
+				DescriptionMatch: "target debit",
+				SourceAccountId:  d.accounts[1].AccountId,
+				ImportSplitType:  -1,
+				Description:      "Target",
+				Targets:          []*models.RuleTarget{{AccountId: shopping.AccountId, Percent: NewAmount("100")}},
+			},
+			{
+				// Takes precedence over the above for large purchases
+				Name:             "Large Target purchases",
+				Priority:         1,
+				MatchType:        models.SubstringMatch,
+				DescriptionMatch: "TARGET",
+				MinAmount:        newAmountPtr("50"),
+				SourceAccountId:  -1,
+				ImportSplitType:  models.ExternalAccount,
+				Targets:          []*models.RuleTarget{{AccountId: utilities.AccountId, Percent: NewAmount("100")}},
+			},
+			{
+				Name:             "Power",
+				Priority:         3,
+				MatchType:        models.RegexpMatch,
+				DescriptionMatch: "^DUKE",
+				SourceAccountId:  -1,
+				ImportSplitType:  -1,
+				Targets: []*models.RuleTarget{
+					{AccountId: utilities.AccountId, Percent: NewAmount("60")},
+					{AccountId: shopping.AccountId, Percent: NewAmount("40")},
+				},
+			},
+			{
+				// Shouldn't match, since this is for another account
+				Name:             "Netflix",
+				Priority:         0,
+				MatchType:        models.SubstringMatch,
+				DescriptionMatch: "NETFLIX",
+				SourceAccountId:  d.accounts[7].AccountId,
+				ImportSplitType:  -1,
+				Targets:          []*models.RuleTarget{{AccountId: shopping.AccountId, Percent: NewAmount("100")}},
+			},
+		} {
+			if _, err := createRule(d.clients[0], rule); err != nil {
+				t.Fatalf("Error creating rule: %s\n", err)
+			}
+		}
+
+		if err = importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171126.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "2493.19")
+		accountBalanceHelper(t, d.clients[0], shopping, "64.46")
+		accountBalanceHelper(t, d.clients[0], utilities, "110.67")
+
+		atl, err := getAccountTransactions(d.clients[0], shopping.AccountId, 0, 0, "")
+		if err != nil {
+			t.Fatalf("Error fetching account transactions: %s\n", err)
+		}
+		if len(*atl.Transactions) != 2 {
+			t.Fatalf("Expected 2 transactions in Shopping, found %d\n", len(*atl.Transactions))
+		}
+		for _, tr := range *atl.Transactions {
+			power := strings.HasPrefix(tr.Description, "DUKEENGYPROGRESS")
+			if tr.Description != "Target" && !power {
+				t.Errorf("Unexpected transaction description: %s\n", tr.Description)
+			}
+			if power && len(tr.Splits) != 3 {
+				t.Errorf("Expected split power transaction to have 3 splits, found %d\n", len(tr.Splits))
+			}
+		}
+	})
+}
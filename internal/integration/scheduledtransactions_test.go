@@ -0,0 +1,334 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func createScheduledTransaction(client *http.Client, scheduled *models.ScheduledTransaction) (*models.ScheduledTransaction, error) {
+	var s models.ScheduledTransaction
+	err := create(client, scheduled, &s, "/v1/scheduledtransactions/")
+	return &s, err
+}
+
+func getScheduledTransaction(client *http.Client, scheduledtransactionid int64) (*models.ScheduledTransaction, error) {
+	var s models.ScheduledTransaction
+	err := read(client, &s, "/v1/scheduledtransactions/"+strconv.FormatInt(scheduledtransactionid, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func getScheduledTransactions(client *http.Client) (*models.ScheduledTransactionList, error) {
+	var sl models.ScheduledTransactionList
+	err := read(client, &sl, "/v1/scheduledtransactions/")
+	if err != nil {
+		return nil, err
+	}
+	return &sl, nil
+}
+
+func getScheduledTransactionsDue(client *http.Client, days int64) (*models.ScheduledOccurrenceList, error) {
+	var ol models.ScheduledOccurrenceList
+	err := read(client, &ol, "/v1/scheduledtransactions/due?days="+strconv.FormatInt(days, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &ol, nil
+}
+
+func updateScheduledTransaction(client *http.Client, scheduled *models.ScheduledTransaction) (*models.ScheduledTransaction, error) {
+	var s models.ScheduledTransaction
+	err := update(client, scheduled, &s, "/v1/scheduledtransactions/"+strconv.FormatInt(scheduled.ScheduledTransactionId, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func deleteScheduledTransaction(client *http.Client, s *models.ScheduledTransaction) error {
+	return remove(client, "/v1/scheduledtransactions/"+strconv.FormatInt(s.ScheduledTransactionId, 10))
+}
+
+func getReminders(client *http.Client) (*models.ReminderList, error) {
+	var rl models.ReminderList
+	err := read(client, &rl, "/v1/reminders/")
+	if err != nil {
+		return nil, err
+	}
+	return &rl, nil
+}
+
+func deleteReminder(client *http.Client, r *models.Reminder) error {
+	return remove(client, "/v1/reminders/"+strconv.FormatInt(r.ReminderId, 10))
+}
+
+// transactionDatesWithDescription returns the dates of the user's transactions
+// with the given description, in order
+func transactionDatesWithDescription(t *testing.T, client *http.Client, description string) []time.Time {
+	t.Helper()
+	tl, err := getTransactions(client)
+	if err != nil {
+		t.Fatalf("Error fetching transactions: %s\n", err)
+	}
+	var dates []time.Time
+	for _, transaction := range *tl.Transactions {
+		if transaction.Description != description {
+			continue
+		}
+		dates = append(dates, transaction.Date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates
+}
+
+func scheduledTemplate(description string, from, to int64, amount string) models.Transaction {
+	return models.Transaction{
+		Description: description,
+		Splits: []*models.Split{
+			{AccountId: from, SecurityId: -1, Amount: NewAmount("-" + amount)},
+			{AccountId: to, SecurityId: -1, Amount: NewAmount(amount)},
+		},
+	}
+}
+
+func TestScheduledTransactions(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		rent := &models.ScheduledTransaction{
+			Template:    scheduledTemplate("Rent", d.accounts[1].AccountId, d.accounts[4].AccountId, "850"),
+			Unit:        models.RecurMonths,
+			Interval:    1,
+			Start:       time.Date(2017, time.January, 31, 0, 0, 0, 0, time.UTC),
+			Occurrences: 3,
+		}
+		s, err := createScheduledTransaction(d.clients[0], rent)
+		if err != nil {
+			t.Fatalf("Error creating scheduled transaction: %s\n", err)
+		}
+		if s.ScheduledTransactionId == 0 || s.UserId != d.users[0].UserId || !s.NextDate.Equal(rent.Start) || s.Count != 0 || s.Finished || len(s.Template.Splits) != 2 || s.Template.Splits[0].Status != models.Entered {
+			t.Errorf("Unexpected scheduled transaction created: %+v\n", s)
+		}
+		rent = s
+
+		// Invalid scheduled transactions are refused
+		invalid := *rent
+		invalid.Template = scheduledTemplate("Unbalanced", d.accounts[1].AccountId, d.accounts[4].AccountId, "850")
+		invalid.Template.Splits[1].Amount = NewAmount("800")
+		if _, err := createScheduledTransaction(d.clients[0], &invalid); err == nil {
+			t.Errorf("Expected error creating unbalanced scheduled transaction\n")
+		}
+		invalid = *rent
+		invalid.Interval = 0
+		if _, err := createScheduledTransaction(d.clients[0], &invalid); err == nil {
+			t.Errorf("Expected error creating scheduled transaction with zero interval\n")
+		}
+		invalid.Interval = models.MaxScheduleInterval + 1
+		if _, err := createScheduledTransaction(d.clients[0], &invalid); err == nil {
+			t.Errorf("Expected error creating scheduled transaction with too large an interval\n")
+		}
+		invalid = *rent
+		invalid.Template = scheduledTemplate("Too precise", d.accounts[1].AccountId, d.accounts[4].AccountId, "850.001")
+		if _, err := createScheduledTransaction(d.clients[0], &invalid); err == nil {
+			t.Errorf("Expected error creating scheduled transaction with amounts more precise than its accounts' securities\n")
+		}
+		if _, err := createScheduledTransaction(d.clients[1], rent); err == nil {
+			t.Errorf("Expected error creating scheduled transaction using another user's accounts\n")
+		}
+		if _, err := getScheduledTransaction(d.clients[1], rent.ScheduledTransactionId); err == nil {
+			t.Errorf("Expected error fetching another user's scheduled transaction\n")
+		}
+
+		// Occurrences which have come due are created, including missed ones
+		if err := scheduler.CreateDue(time.Date(2017, time.March, 15, 12, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("Error creating due scheduled transactions: %s\n", err)
+		}
+		dates := transactionDatesWithDescription(t, d.clients[0], "Rent")
+		if len(dates) != 2 || !dates[0].Equal(rent.Start) || !dates[1].Equal(time.Date(2017, time.February, 28, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Unexpected scheduled transactions created: %v\n", dates)
+		}
+		s, err = getScheduledTransaction(d.clients[0], rent.ScheduledTransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching scheduled transaction: %s\n", err)
+		}
+		if s.Count != 2 || !s.NextDate.Equal(time.Date(2017, time.March, 31, 0, 0, 0, 0, time.UTC)) || s.Finished {
+			t.Errorf("Unexpected scheduled transaction after creating occurrences: %+v\n", s)
+		}
+
+		// Running it again for the same time doesn't create duplicates, and it
+		// stops after Occurrences
+		if err := scheduler.CreateDue(time.Date(2017, time.March, 15, 12, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("Error creating due scheduled transactions: %s\n", err)
+		}
+		if err := scheduler.CreateDue(time.Date(2017, time.December, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("Error creating due scheduled transactions: %s\n", err)
+		}
+		if dates := transactionDatesWithDescription(t, d.clients[0], "Rent"); len(dates) != 3 {
+			t.Errorf("Expected 3 scheduled transactions to be created, found %d\n", len(dates))
+		}
+		s, err = getScheduledTransaction(d.clients[0], rent.ScheduledTransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching scheduled transaction: %s\n", err)
+		}
+		if s.Count != 3 || !s.Finished {
+			t.Errorf("Expected scheduled transaction to be finished: %+v\n", s)
+		}
+
+		// Reminders are created instead of transactions when RemindOnly is set
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		bill := &models.ScheduledTransaction{
+			Template:   scheduledTemplate("Credit card payment", d.accounts[1].AccountId, d.accounts[7].AccountId, "100"),
+			RemindOnly: true,
+			Unit:       models.RecurWeeks,
+			Interval:   1,
+			Start:      today.AddDate(0, 0, -10),
+		}
+		bill, err = createScheduledTransaction(d.clients[0], bill)
+		if err != nil {
+			t.Fatalf("Error creating scheduled transaction: %s\n", err)
+		}
+		if err := scheduler.CreateDue(time.Now()); err != nil {
+			t.Fatalf("Error creating due scheduled transactions: %s\n", err)
+		}
+		if dates := transactionDatesWithDescription(t, d.clients[0], "Credit card payment"); len(dates) != 0 {
+			t.Errorf("Expected no transactions to be created for RemindOnly scheduled transaction: %v\n", dates)
+		}
+		rl, err := getReminders(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching reminders: %s\n", err)
+		}
+		if len(*rl.Reminders) != 2 || (*rl.Reminders)[0].ScheduledTransactionId != bill.ScheduledTransactionId || !(*rl.Reminders)[0].Date.Equal(bill.Start) {
+			t.Fatalf("Unexpected reminders: %+v\n", rl)
+		}
+		if rl, err := getReminders(d.clients[1]); err != nil || len(*rl.Reminders) != 0 {
+			t.Errorf("Expected other user not to see reminders\n")
+		}
+		if err := deleteReminder(d.clients[1], (*rl.Reminders)[0]); err == nil {
+			t.Errorf("Expected error dismissing another user's reminder\n")
+		}
+		if err := deleteReminder(d.clients[0], (*rl.Reminders)[0]); err != nil {
+			t.Fatalf("Error dismissing reminder: %s\n", err)
+		}
+		if rl, err := getReminders(d.clients[0]); err != nil || len(*rl.Reminders) != 1 {
+			t.Errorf("Expected one reminder after dismissing the other: %+v, %s\n", rl, err)
+		}
+
+		// Due soon lists the upcoming occurrences of unfinished scheduled
+		// transactions, in order
+		paycheck := &models.ScheduledTransaction{
+			Template: scheduledTemplate("Paycheck", d.accounts[0].AccountId, d.accounts[1].AccountId, "1500"),
+			Unit:     models.RecurDays,
+			Interval: 14,
+			Start:    today.AddDate(0, 0, 1),
+		}
+		paycheck, err = createScheduledTransaction(d.clients[0], paycheck)
+		if err != nil {
+			t.Fatalf("Error creating scheduled transaction: %s\n", err)
+		}
+		ol, err := getScheduledTransactionsDue(d.clients[0], 14)
+		if err != nil {
+			t.Fatalf("Error fetching scheduled transactions due: %s\n", err)
+		}
+		counts := make(map[int64]int)
+		for i, o := range *ol.ScheduledOccurrences {
+			counts[o.ScheduledTransactionId]++
+			if i > 0 && o.Date.Before((*ol.ScheduledOccurrences)[i-1].Date) {
+				t.Errorf("Expected scheduled occurrences in order: %+v\n", ol)
+			}
+			if o.Transaction == nil || !o.Transaction.Date.Equal(o.Date) || len(o.Transaction.Splits) != 2 {
+				t.Errorf("Unexpected transaction for scheduled occurrence: %+v\n", o)
+			}
+		}
+		if counts[rent.ScheduledTransactionId] != 0 || counts[bill.ScheduledTransactionId] != 2 || counts[paycheck.ScheduledTransactionId] != 1 {
+			t.Errorf("Unexpected scheduled occurrences due: %v\n", counts)
+		}
+		if _, err := getScheduledTransactionsDue(d.clients[0], -1); err == nil {
+			t.Errorf("Expected error fetching scheduled transactions due with negative days\n")
+		}
+
+		// Changing the recurrence rule continues after the last occurrence
+		// which came due
+		bill.Interval = 2
+		s, err = updateScheduledTransaction(d.clients[0], bill)
+		if err != nil {
+			t.Fatalf("Error updating scheduled transaction: %s\n", err)
+		}
+		if s.Count != 2 || !s.NextDate.Equal(bill.Start.AddDate(0, 0, 14)) {
+			t.Errorf("Unexpected scheduled transaction after update: %+v\n", s)
+		}
+
+		sl, err := getScheduledTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching scheduled transactions: %s\n", err)
+		}
+		if len(*sl.ScheduledTransactions) != 3 {
+			t.Errorf("Expected 3 scheduled transactions, found %d\n", len(*sl.ScheduledTransactions))
+		}
+
+		// Deleting a scheduled transaction deletes its reminders
+		if err := deleteScheduledTransaction(d.clients[0], bill); err != nil {
+			t.Fatalf("Error deleting scheduled transaction: %s\n", err)
+		}
+		if _, err := getScheduledTransaction(d.clients[0], bill.ScheduledTransactionId); err == nil {
+			t.Errorf("Expected error fetching deleted scheduled transaction\n")
+		}
+		if rl, err := getReminders(d.clients[0]); err != nil || len(*rl.Reminders) != 0 {
+			t.Errorf("Expected deleting scheduled transaction to delete its reminders: %+v, %s\n", rl, err)
+		}
+	})
+}
+
+func TestScheduledTransactionsCatchUp(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		if _, err := getScheduledTransactionsDue(d.clients[0], 367); err == nil {
+			t.Errorf("Expected error fetching scheduled transactions due in more than a year\n")
+		}
+
+		// A schedule which started long ago is caught up a limited number
+		// of occurrences at a time
+		daily := &models.ScheduledTransaction{
+			Template:   scheduledTemplate("Coffee", d.accounts[1].AccountId, d.accounts[3].AccountId, "3.50"),
+			RemindOnly: true,
+			Unit:       models.RecurDays,
+			Interval:   1,
+			Start:      time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC),
+		}
+		daily, err := createScheduledTransaction(d.clients[0], daily)
+		if err != nil {
+			t.Fatalf("Error creating scheduled transaction: %s\n", err)
+		}
+
+		ol, err := getScheduledTransactionsDue(d.clients[0], 0)
+		if err != nil {
+			t.Fatalf("Error fetching scheduled transactions due: %s\n", err)
+		}
+		if len(*ol.ScheduledOccurrences) != 1000 {
+			t.Errorf("Expected 1000 scheduled occurrences due, found %d\n", len(*ol.ScheduledOccurrences))
+		}
+
+		now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+		for _, expected := range []int64{1000, 1096} {
+			if err := scheduler.CreateDue(now); err != nil {
+				t.Fatalf("Error creating due scheduled transactions: %s\n", err)
+			}
+			s, err := getScheduledTransaction(d.clients[0], daily.ScheduledTransactionId)
+			if err != nil {
+				t.Fatalf("Error fetching scheduled transaction: %s\n", err)
+			}
+			if s.Count != expected {
+				t.Errorf("Expected %d occurrences to have come due, found %d\n", expected, s.Count)
+			}
+		}
+		rl, err := getReminders(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching reminders: %s\n", err)
+		}
+		if len(*rl.Reminders) != 1096 {
+			t.Errorf("Expected 1096 reminders, found %d\n", len(*rl.Reminders))
+		}
+	})
+}
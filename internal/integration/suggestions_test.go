@@ -0,0 +1,122 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/handlers"
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getSuggestions(client *http.Client, transactionid int64) (*models.SuggestionList, error) {
+	var sl models.SuggestionList
+	err := read(client, &sl, "/v1/transactions/"+strconv.FormatInt(transactionid, 10)+"/suggestions")
+	if err != nil {
+		return nil, err
+	}
+	return &sl, nil
+}
+
+func TestSuggestions(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		// Ensure there's only one USD currency
+		oldDefault, err := getSecurity(d.clients[0], d.users[0].DefaultCurrency)
+		if err != nil {
+			t.Fatalf("Error fetching default security: %s\n", err)
+		}
+		d.users[0].DefaultCurrency = d.securities[0].SecurityId
+		if _, err := updateUser(d.clients[0], &d.users[0]); err != nil {
+			t.Fatalf("Error updating user: %s\n", err)
+		}
+		if err := deleteSecurity(d.clients[0], oldDefault); err != nil {
+			t.Fatalf("Error removing default security: %s\n", err)
+		}
+
+		// Teach it that Target is groceries
+		_, err = createTransaction(d.clients[0], &models.Transaction{
+			UserId:      d.users[0].UserId,
+			Description: "Target #1234",
+			Date:        time.Date(2017, time.November, 1, 0, 0, 0, 0, time.UTC),
+			Splits: []*models.Split{
+				{Status: models.Reconciled, AccountId: d.accounts[1].AccountId, SecurityId: -1, Amount: NewAmount("-40.00")},
+				{Status: models.Reconciled, AccountId: d.accounts[3].AccountId, SecurityId: -1, Amount: NewAmount("40.00")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating transaction: %s\n", err)
+		}
+
+		if err = importOFX(d.clients[0], d.accounts[1].AccountId, "testdata/checking_20171129.ofx"); err != nil {
+			t.Fatalf("Error importing OFX: %s\n", err)
+		}
+		accountBalanceHelper(t, d.clients[0], &d.accounts[1], "4822.85")
+		// Both Target transactions should have been categorized automatically
+		accountBalanceHelper(t, d.clients[0], &d.accounts[3], "204.12")
+
+		transactions, err := getTransactions(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching transactions: %s\n", err)
+		}
+		var netflix *models.Transaction
+		for _, tr := range *transactions.Transactions {
+			if strings.HasPrefix(tr.Description, "NETFLIX") {
+				netflix = tr
+			}
+		}
+		if netflix == nil {
+			t.Fatalf("Couldn't find imported Netflix transaction\n")
+		}
+
+		// Nothing has been learned about Netflix yet
+		sl, err := getSuggestions(d.clients[0], netflix.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching suggestions: %s\n", err)
+		}
+		if len(*sl.Suggestions) != 0 {
+			t.Errorf("Expected no suggestions, found %d\n", len(*sl.Suggestions))
+		}
+
+		_, err = createTransaction(d.clients[0], &models.Transaction{
+			UserId:      d.users[0].UserId,
+			Description: "Netflix",
+			Date:        time.Date(2017, time.October, 20, 0, 0, 0, 0, time.UTC),
+			Splits: []*models.Split{
+				{Status: models.Reconciled, AccountId: d.accounts[1].AccountId, SecurityId: -1, Amount: NewAmount("-10.71")},
+				{Status: models.Reconciled, AccountId: d.accounts[4].AccountId, SecurityId: -1, Amount: NewAmount("10.71")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating transaction: %s\n", err)
+		}
+
+		sl, err = getSuggestions(d.clients[0], netflix.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching suggestions: %s\n", err)
+		}
+		if len(*sl.Suggestions) != 1 {
+			t.Fatalf("Expected one suggestion, found %d\n", len(*sl.Suggestions))
+		}
+		suggestion := (*sl.Suggestions)[0]
+		if suggestion.AccountId != d.accounts[4].AccountId {
+			t.Errorf("Expected Netflix to be suggested as cable, found account %d\n", suggestion.AccountId)
+		}
+		if suggestion.Confidence <= 0 || suggestion.Confidence >= handlers.SuggestionThreshold {
+			t.Errorf("Expected suggestion with partially-matching description to have low confidence, found %f\n", suggestion.Confidence)
+		}
+		var found bool
+		for _, split := range netflix.Splits {
+			if split.SplitId == suggestion.SplitId && split.AccountId != d.accounts[1].AccountId {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected suggestion for Netflix transaction's uncategorized split\n")
+		}
+
+		if _, err := getSuggestions(d.clients[1], netflix.TransactionId); err == nil {
+			t.Errorf("Expected error fetching suggestions for another user's transaction\n")
+		}
+	})
+}
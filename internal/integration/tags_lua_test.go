@@ -0,0 +1,26 @@
+package integration_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestLuaTags(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		vacation, _, _, _ := createTaggedTransactions(t, d)
+		id := vacation.TagId
+
+		simpleLuaTest(t, d.clients[0], []LuaTest{
+			{"TagId", fmt.Sprintf("return get_tags()[%d].TagId", id), strconv.FormatInt(id, 10)},
+			{"Name", fmt.Sprintf("return get_tags()[%d].Name", id), "vacation-2026"},
+			{"__tostring", fmt.Sprintf("return get_tags()[%d]", id), "vacation-2026"},
+			{"__eq", fmt.Sprintf("return get_tags()[%d] == get_tags()[%d]", id, id), "true"},
+			// The whole hotel transaction is tagged, but only dinner's groceries split
+			{"Balance()", fmt.Sprintf("return get_tags()[%d]:Balance(get_accounts()[%d]).Amount", id, d.accounts[1].AccountId), "-200"},
+			{"Balance() split", fmt.Sprintf("return get_tags()[%d]:Balance(get_accounts()[%d]).Amount", id, d.accounts[3].AccountId), "50"},
+			{"Balance(1)", fmt.Sprintf("return get_tags()[%d]:Balance(get_accounts()[%d], date.new(2017, 12, 2)).Amount", id, d.accounts[2].AccountId), "0"},
+			{"Balance(2)", fmt.Sprintf("return get_tags()[%d]:Balance(get_accounts()[%d], date.new(2017, 12, 1), date.new(2017, 12, 3)).Amount", id, d.accounts[2].AccountId), "200"},
+		})
+	})
+}
@@ -0,0 +1,272 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func createTag(client *http.Client, tag *models.Tag) (*models.Tag, error) {
+	var t models.Tag
+	err := create(client, tag, &t, "/v1/tags/")
+	return &t, err
+}
+
+func getTag(client *http.Client, tagid int64) (*models.Tag, error) {
+	var t models.Tag
+	err := read(client, &t, "/v1/tags/"+strconv.FormatInt(tagid, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func getTags(client *http.Client) (*models.TagList, error) {
+	var tl models.TagList
+	err := read(client, &tl, "/v1/tags/")
+	if err != nil {
+		return nil, err
+	}
+	return &tl, nil
+}
+
+func updateTag(client *http.Client, tag *models.Tag) (*models.Tag, error) {
+	var t models.Tag
+	err := update(client, tag, &t, "/v1/tags/"+strconv.FormatInt(tag.TagId, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func deleteTag(client *http.Client, t *models.Tag) error {
+	return remove(client, "/v1/tags/"+strconv.FormatInt(t.TagId, 10))
+}
+
+func getTaggedAccountTransactions(client *http.Client, accountid, tagid int64) (*models.AccountTransactionsList, error) {
+	var atl models.AccountTransactionsList
+	err := read(client, &atl, "/v1/accounts/"+strconv.FormatInt(accountid, 10)+"/transactions/?tag="+strconv.FormatInt(tagid, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &atl, nil
+}
+
+func tagIdsMatch(tagids []int64, expected ...int64) bool {
+	if len(tagids) != len(expected) {
+		return false
+	}
+	for i := range tagids {
+		if tagids[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// createTaggedTransactions creates tags for a vacation and for reimbursable
+// expenses, a hotel transaction tagged as part of the vacation, and a dinner
+// transaction whose groceries split is tagged with both
+func createTaggedTransactions(t *testing.T, d *TestData) (vacation, reimbursable *models.Tag, hotel, dinner *models.Transaction) {
+	t.Helper()
+	vacation, err := createTag(d.clients[0], &models.Tag{Name: "vacation-2026"})
+	if err != nil {
+		t.Fatalf("Error creating tag: %s\n", err)
+	}
+	reimbursable, err = createTag(d.clients[0], &models.Tag{Name: "reimbursable"})
+	if err != nil {
+		t.Fatalf("Error creating tag: %s\n", err)
+	}
+
+	hotel, err = createTransaction(d.clients[0], &models.Transaction{
+		UserId:      d.users[0].UserId,
+		Description: "Hotel",
+		Date:        time.Date(2017, time.December, 2, 0, 0, 0, 0, time.UTC),
+		TagIds:      []int64{vacation.TagId},
+		Splits: []*models.Split{
+			{Status: models.Entered, AccountId: d.accounts[1].AccountId, SecurityId: -1, Amount: NewAmount("-200")},
+			{Status: models.Entered, AccountId: d.accounts[2].AccountId, SecurityId: -1, Amount: NewAmount("200")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s\n", err)
+	}
+	dinner, err = createTransaction(d.clients[0], &models.Transaction{
+		UserId:      d.users[0].UserId,
+		Description: "Dinner",
+		Date:        time.Date(2017, time.December, 3, 0, 0, 0, 0, time.UTC),
+		Splits: []*models.Split{
+			{Status: models.Entered, AccountId: d.accounts[1].AccountId, SecurityId: -1, Amount: NewAmount("-50")},
+			{Status: models.Entered, AccountId: d.accounts[3].AccountId, SecurityId: -1, Amount: NewAmount("50"), TagIds: []int64{vacation.TagId, reimbursable.TagId}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s\n", err)
+	}
+	return
+}
+
+func TestTags(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		vacation, reimbursable, hotel, dinner := createTaggedTransactions(t, d)
+
+		tl, err := getTags(d.clients[0])
+		if err != nil {
+			t.Fatalf("Error fetching tags: %s\n", err)
+		}
+		if len(*tl.Tags) != 2 || (*tl.Tags)[0].TagId != reimbursable.TagId || (*tl.Tags)[1].TagId != vacation.TagId {
+			t.Errorf("Expected both tags, sorted by name: %+v\n", tl)
+		}
+		if _, err := getTag(d.clients[1], vacation.TagId); err == nil {
+			t.Errorf("Expected error fetching another user's tag\n")
+		}
+		for _, name := range []string{"", " ", "Vacation-2026"} {
+			if _, err := createTag(d.clients[0], &models.Tag{Name: name}); err == nil {
+				t.Errorf("Expected error creating tag named '%s'\n", name)
+			}
+		}
+		renamed := *reimbursable
+		renamed.Name = "vacation-2026"
+		if _, err := updateTag(d.clients[0], &renamed); err == nil {
+			t.Errorf("Expected error renaming tag to an existing tag's name\n")
+		}
+		renamed.Name = "work-reimbursable"
+		if r, err := updateTag(d.clients[0], &renamed); err != nil || r.Name != "work-reimbursable" {
+			t.Errorf("Error renaming tag: %+v, %s\n", r, err)
+		}
+
+		// Tags are returned with transactions and their splits
+		tr, err := getTransaction(d.clients[0], dinner.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching transaction: %s\n", err)
+		}
+		if len(tr.TagIds) != 0 || !tagIdsMatch(accountSplit(tr, d.accounts[3].AccountId).TagIds, vacation.TagId, reimbursable.TagId) || len(accountSplit(tr, d.accounts[1].AccountId).TagIds) != 0 {
+			t.Errorf("Unexpected tags on transaction: %+v, %+v\n", tr, tr.Splits)
+		}
+
+		// Other users' tags can't be attached
+		other, err := createTag(d.clients[1], &models.Tag{Name: "other"})
+		if err != nil {
+			t.Fatalf("Error creating tag: %s\n", err)
+		}
+		tr.TagIds = []int64{other.TagId}
+		if _, err := updateTransaction(d.clients[0], tr); err == nil {
+			t.Errorf("Expected error attaching another user's tag\n")
+		}
+
+		// Tags can be moved between a transaction and its splits
+		tr.TagIds = []int64{reimbursable.TagId}
+		accountSplit(tr, d.accounts[3].AccountId).TagIds = []int64{vacation.TagId}
+		tr, err = updateTransaction(d.clients[0], tr)
+		if err != nil {
+			t.Fatalf("Error updating transaction tags: %s\n", err)
+		}
+		tr, err = getTransaction(d.clients[0], dinner.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching transaction: %s\n", err)
+		}
+		if !tagIdsMatch(tr.TagIds, reimbursable.TagId) || !tagIdsMatch(accountSplit(tr, d.accounts[3].AccountId).TagIds, vacation.TagId) {
+			t.Errorf("Unexpected tags on transaction after update: %+v, %+v\n", tr, tr.Splits)
+		}
+
+		// Account transactions can be filtered by tag
+		checking := d.accounts[1].AccountId
+		atl, err := getTaggedAccountTransactions(d.clients[0], checking, vacation.TagId)
+		if err != nil {
+			t.Fatalf("Error fetching tagged account transactions: %s\n", err)
+		}
+		if atl.TotalTransactions != 2 || len(*atl.Transactions) != 2 || !amountsMatch(atl.EndingBalance, "-250") {
+			t.Errorf("Unexpected tagged account transactions: %+v\n", atl)
+		}
+		// Balances are subtotals of just the tagged transactions, not the
+		// account's balance
+		var page models.AccountTransactionsList
+		if err := read(d.clients[0], &page, "/v1/accounts/"+strconv.FormatInt(checking, 10)+"/transactions/?tag="+strconv.FormatInt(vacation.TagId, 10)+"&sort=date-asc&page=1&limit=1"); err != nil {
+			t.Fatalf("Error fetching tagged account transactions: %s\n", err)
+		}
+		if len(*page.Transactions) != 1 || (*page.Transactions)[0].TransactionId != dinner.TransactionId || !amountsMatch(page.BeginningBalance, "-200") || !amountsMatch(page.EndingBalance, "-250") {
+			t.Errorf("Expected tagged account transaction balances to be subtotals: %+v\n", page)
+		}
+		if atl, err = getTaggedAccountTransactions(d.clients[0], checking, reimbursable.TagId); err != nil || atl.TotalTransactions != 1 || (*atl.Transactions)[0].TransactionId != dinner.TransactionId {
+			t.Errorf("Unexpected tagged account transactions: %+v, %s\n", atl, err)
+		}
+		if _, err := getTaggedAccountTransactions(d.clients[0], checking, other.TagId); err == nil {
+			t.Errorf("Expected error filtering account transactions by another user's tag\n")
+		}
+
+		// Transaction search matches splits with the tag attached to them or
+		// their transactions
+		tagquery := "?tag=" + strconv.FormatInt(vacation.TagId, 10)
+		if tsr, err := searchTransactions(d.clients[0], tagquery); err != nil || tsr.TotalTransactions != 2 {
+			t.Errorf("Unexpected transactions searching by tag: %+v, %s\n", tsr, err)
+		}
+		if tsr, err := searchTransactions(d.clients[0], tagquery+"&account="+strconv.FormatInt(d.accounts[3].AccountId, 10)); err != nil || tsr.TotalTransactions != 1 {
+			t.Errorf("Unexpected transactions searching by tag and account: %+v, %s\n", tsr, err)
+		}
+		if tsr, err := searchTransactions(d.clients[0], tagquery+"&account="+strconv.FormatInt(checking, 10)); err != nil || tsr.TotalTransactions != 1 || (*tsr.Transactions)[0].TransactionId != hotel.TransactionId {
+			t.Errorf("Unexpected transactions searching by tag and account: %+v, %s\n", tsr, err)
+		}
+
+		// Deleting a tag removes it from transactions and splits
+		if err := deleteTag(d.clients[0], vacation); err != nil {
+			t.Fatalf("Error deleting tag: %s\n", err)
+		}
+		if tr, err = getTransaction(d.clients[0], hotel.TransactionId); err != nil || len(tr.TagIds) != 0 {
+			t.Errorf("Expected deleted tag to be removed from transaction: %+v, %s\n", tr, err)
+		}
+		if tr, err = getTransaction(d.clients[0], dinner.TransactionId); err != nil || len(accountSplit(tr, d.accounts[3].AccountId).TagIds) != 0 {
+			t.Errorf("Expected deleted tag to be removed from split: %+v, %s\n", tr, err)
+		}
+		if _, err := getTag(d.clients[0], vacation.TagId); err == nil {
+			t.Errorf("Expected error fetching deleted tag\n")
+		}
+	})
+}
+
+func TestTagsKeptWithoutTagIds(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		vacation, reimbursable, _, dinner := createTaggedTransactions(t, d)
+
+		// Clients which don't send TagIds, such as the web interface, leave
+		// the tags of the transaction and its existing splits alone
+		tr, err := getTransaction(d.clients[0], dinner.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching transaction: %s\n", err)
+		}
+		tr.TagIds = []int64{reimbursable.TagId}
+		if tr, err = updateTransaction(d.clients[0], tr); err != nil {
+			t.Fatalf("Error updating transaction tags: %s\n", err)
+		}
+		tr.Description = "Dinner with clients"
+		tr.TagIds = nil
+		for _, split := range tr.Splits {
+			split.TagIds = nil
+		}
+		if _, err := updateTransaction(d.clients[0], tr); err != nil {
+			t.Fatalf("Error updating transaction: %s\n", err)
+		}
+		tr, err = getTransaction(d.clients[0], dinner.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching transaction: %s\n", err)
+		}
+		if tr.Description != "Dinner with clients" || !tagIdsMatch(tr.TagIds, reimbursable.TagId) || !tagIdsMatch(accountSplit(tr, d.accounts[3].AccountId).TagIds, vacation.TagId, reimbursable.TagId) {
+			t.Errorf("Expected tags to be kept when updating without TagIds: %+v, %+v\n", tr, tr.Splits)
+		}
+
+		// Empty lists remove them
+		tr.TagIds = []int64{}
+		accountSplit(tr, d.accounts[3].AccountId).TagIds = []int64{}
+		if _, err := updateTransaction(d.clients[0], tr); err != nil {
+			t.Fatalf("Error updating transaction: %s\n", err)
+		}
+		tr, err = getTransaction(d.clients[0], dinner.TransactionId)
+		if err != nil {
+			t.Fatalf("Error fetching transaction: %s\n", err)
+		}
+		if len(tr.TagIds) != 0 || len(accountSplit(tr, d.accounts[3].AccountId).TagIds) != 0 {
+			t.Errorf("Expected empty TagIds to remove tags: %+v, %+v\n", tr, tr.Splits)
+		}
+	})
+}
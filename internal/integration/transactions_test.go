@@ -67,6 +67,17 @@ func updateTransaction(client *http.Client, transaction *models.Transaction) (*m
 	return &s, nil
 }
 
+// forceUpdateTransaction updates transaction even if that changes its
+// reconciled splits
+func forceUpdateTransaction(client *http.Client, transaction *models.Transaction) (*models.Transaction, error) {
+	var s models.Transaction
+	err := update(client, transaction, &s, "/v1/transactions/"+strconv.FormatInt(transaction.TransactionId, 10)+"?force=true")
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
 func deleteTransaction(client *http.Client, s *models.Transaction) error {
 	err := remove(client, "/v1/transactions/"+strconv.FormatInt(s.TransactionId, 10))
 	if err != nil {
@@ -75,6 +86,10 @@ func deleteTransaction(client *http.Client, s *models.Transaction) error {
 	return nil
 }
 
+func forceDeleteTransaction(client *http.Client, s *models.Transaction) error {
+	return remove(client, "/v1/transactions/"+strconv.FormatInt(s.TransactionId, 10)+"?force=true")
+}
+
 func ensureTransactionsMatch(t *testing.T, expected, tran *models.Transaction, accounts *[]models.Account, matchtransactionids, matchsplitids bool) {
 	t.Helper()
 
@@ -307,7 +322,9 @@ func TestUpdateTransaction(t *testing.T) {
 
 			accountMap := getAccountVersionMap(t, d.clients[orig.UserId], &curr)
 
-			tran, err := updateTransaction(d.clients[orig.UserId], &curr)
+			// Some of the transactions have reconciled splits, so changing
+			// their dates must be forced
+			tran, err := forceUpdateTransaction(d.clients[orig.UserId], &curr)
 			if err != nil {
 				t.Fatalf("Error updating transaction: %s\n", err)
 			}
@@ -371,7 +388,19 @@ func TestDeleteTransaction(t *testing.T) {
 
 			accountMap := getAccountVersionMap(t, d.clients[orig.UserId], &curr)
 
+			// Transactions with reconciled splits can only be deleted when
+			// forced
+			var reconciled bool
+			for _, s := range curr.Splits {
+				reconciled = reconciled || s.Status == models.Reconciled
+			}
 			err := deleteTransaction(d.clients[orig.UserId], &curr)
+			if reconciled {
+				if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 10 { // Reconciled Split
+					t.Fatalf("Expected 'Reconciled Split' error deleting transaction with reconciled splits: %s\n", err)
+				}
+				err = forceDeleteTransaction(d.clients[orig.UserId], &curr)
+			}
 			if err != nil {
 				t.Fatalf("Error deleting transaction: %s\n", err)
 			}
@@ -0,0 +1,135 @@
+package integration_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/handlers"
+	"github.com/aclindsa/moneygo/internal/models"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func searchTransactions(client *http.Client, query string) (*models.TransactionSearchResults, error) {
+	var tsr models.TransactionSearchResults
+	err := read(client, &tsr, "/v1/transactions/search"+query)
+	if err != nil {
+		return nil, err
+	}
+	return &tsr, nil
+}
+
+func TestSearchTransactions(t *testing.T) {
+	RunWith(t, &data[0], func(t *testing.T, d *TestData) {
+		checking := d.accounts[1].AccountId
+		paycheck, err := createTransaction(d.clients[0], &models.Transaction{
+			UserId:      d.users[0].UserId,
+			Description: "Paycheck",
+			Date:        time.Date(2017, time.November, 15, 0, 0, 0, 0, time.UTC),
+			Splits: []*models.Split{
+				{Status: models.Entered, AccountId: checking, SecurityId: -1, Number: "1234", Memo: "Includes 100% bonus", Amount: NewAmount("1000")},
+				{Status: models.Entered, AccountId: d.accounts[0].AccountId, SecurityId: -1, Amount: NewAmount("-1000")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating transaction: %s\n", err)
+		}
+		groceries1 := d.transactions[0].TransactionId
+		groceries2 := d.transactions[1].TransactionId
+		cable := d.transactions[2].TransactionId
+
+		expect := func(query string, transactionids ...int64) *models.TransactionSearchResults {
+			t.Helper()
+			tsr, err := searchTransactions(d.clients[0], query)
+			if err != nil {
+				t.Fatalf("Error searching transactions with '%s': %s\n", query, err)
+			}
+			if len(*tsr.Transactions) != len(transactionids) {
+				t.Fatalf("Expected %d transactions searching with '%s', found %d\n", len(transactionids), query, len(*tsr.Transactions))
+			}
+			for i, transactionid := range transactionids {
+				if (*tsr.Transactions)[i].TransactionId != transactionid {
+					t.Errorf("Expected transaction %d at position %d searching with '%s', found %d\n", transactionid, i, query, (*tsr.Transactions)[i].TransactionId)
+				}
+				if len((*tsr.Transactions)[i].Splits) < 2 {
+					t.Errorf("Expected transactions to be returned with all their splits: %+v\n", (*tsr.Transactions)[i])
+				}
+			}
+			return tsr
+		}
+
+		tsr := expect("", paycheck.TransactionId, groceries2, groceries1, cable)
+		if tsr.TotalTransactions != 4 {
+			t.Errorf("Expected 4 total transactions, found %d\n", tsr.TotalTransactions)
+		}
+
+		// Text is matched against the description, memo, and number, with
+		// wildcards matched literally
+		expect("?text=GROCER", groceries2, groceries1)
+		expect("?text=100%25", paycheck.TransactionId)
+		expect("?text=234", paycheck.TransactionId)
+		expect("?text=_")
+
+		expect("?begin=2017-10-01&end=2017-10-31", groceries1)
+		expect("?begin=2017-10-31&sort=date-asc", groceries2, paycheck.TransactionId)
+
+		expect("?minamount=50", paycheck.TransactionId, groceries2)
+		expect("?minamount=-40&maxamount=-39", cable)
+		expect("?maxamount=-1000", paycheck.TransactionId)
+
+		expenses := strconv.FormatInt(d.accounts[2].AccountId, 10)
+		expect("?account=" + expenses)
+		expect("?account="+expenses+"&subaccounts=true", groceries2, groceries1, cable)
+
+		// Criteria on splits must all be met by the same split
+		expect("?status=2", paycheck.TransactionId, cable)
+		expect("?status=2&account="+strconv.FormatInt(checking, 10), paycheck.TransactionId)
+		expect("?security="+strconv.FormatInt(d.securities[0].SecurityId, 10), paycheck.TransactionId, groceries2, groceries1, cable)
+		expect("?security=" + strconv.FormatInt(d.securities[1].SecurityId, 10))
+
+		expect("?imbalance=true")
+		imbalances, err := createAccount(d.clients[0], &models.Account{UserId: d.users[0].UserId, SecurityId: d.securities[0].SecurityId, ParentAccountId: -1, Type: models.Bank, Name: "Imbalances"})
+		if err != nil {
+			t.Fatalf("Error creating account: %s\n", err)
+		}
+		usd, err := createAccount(d.clients[0], &models.Account{UserId: d.users[0].UserId, SecurityId: d.securities[0].SecurityId, ParentAccountId: imbalances.AccountId, Type: models.Bank, Name: "USD"})
+		if err != nil {
+			t.Fatalf("Error creating account: %s\n", err)
+		}
+		imbalanced, err := createTransaction(d.clients[0], &models.Transaction{
+			UserId:      d.users[0].UserId,
+			Description: "Unknown",
+			Date:        time.Date(2017, time.December, 1, 0, 0, 0, 0, time.UTC),
+			Splits: []*models.Split{
+				{Status: models.Imported, AccountId: checking, SecurityId: -1, Amount: NewAmount("-5")},
+				{Status: models.Imported, AccountId: usd.AccountId, SecurityId: -1, Amount: NewAmount("5")},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error creating transaction: %s\n", err)
+		}
+		expect("?imbalance=true", imbalanced.TransactionId)
+
+		expect("?sort=description-asc", cable, paycheck.TransactionId, imbalanced.TransactionId, groceries2, groceries1)
+		tsr = expect("?sort=date-asc&limit=2&page=1", groceries2, paycheck.TransactionId)
+		if tsr.TotalTransactions != 5 {
+			t.Errorf("Expected 5 total transactions when paging, found %d\n", tsr.TotalTransactions)
+		}
+
+		for _, query := range []string{"?sort=amount-asc", "?limit=101", "?begin=20171001", "?minamount=x", "?minamount=0.0000000000000001", "?maxamount=100000000000000000000", "?status=6", "?account=" + strconv.FormatInt(d.accounts[5].AccountId, 10)} {
+			if _, err := searchTransactions(d.clients[0], query); err == nil {
+				t.Errorf("Expected error searching transactions with '%s'\n", query)
+			} else if herr, ok := err.(*handlers.Error); !ok || herr.ErrorId != 3 {
+				t.Errorf("Unexpected error searching transactions with '%s': %s\n", query, err)
+			}
+		}
+
+		// Other users' transactions aren't returned
+		tsr, err = searchTransactions(d.clients[1], "?text=groceries")
+		if err != nil {
+			t.Fatalf("Error searching transactions: %s\n", err)
+		}
+		if len(*tsr.Transactions) != 0 || tsr.TotalTransactions != 0 {
+			t.Errorf("Expected no transactions searching another user's: %+v\n", tsr)
+		}
+	})
+}
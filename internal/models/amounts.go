@@ -149,8 +149,35 @@ func (amount Amount) Precision() uint64 {
 		result.Exp(ten, &power, nil)
 	}
 
+	// 10^power may still not be a multiple of d (i.e. d=4 for 0.25), but if
+	// d has no prime factors other than 2 and 5, some larger power will be
+	if onlyFactorsOfTen(&d) {
+		var rem big.Int
+		for rem.Rem(&result, &d).Sign() != 0 {
+			power.Add(&power, one)
+			result.Exp(ten, &power, nil)
+		}
+	}
+
 	if !power.IsUint64() {
 		panic("Unable to represent Amount's precision as a uint64")
 	}
 	return power.Uint64()
 }
+
+// onlyFactorsOfTen returns whether d's only prime factors are 2 and 5
+func onlyFactorsOfTen(d *big.Int) bool {
+	var n, q, r big.Int
+	n.Set(d)
+	for _, f := range []int64{2, 5} {
+		factor := big.NewInt(f)
+		for n.Sign() != 0 {
+			q.QuoRem(&n, factor, &r)
+			if r.Sign() != 0 {
+				break
+			}
+			n.Set(&q)
+		}
+	}
+	return n.Cmp(big.NewInt(1)) == 0
+}
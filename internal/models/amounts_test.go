@@ -24,6 +24,10 @@ func TestAmountPrecision(t *testing.T) {
 	expectedPrecision(t, &a, 118)
 	a.SetInt64(1050)
 	expectedPrecision(t, &a, 0)
+	a.SetString("1.25")
+	expectedPrecision(t, &a, 2)
+	a.SetString("-0.0625")
+	expectedPrecision(t, &a, 4)
 }
 
 func TestAmountRound(t *testing.T) {
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// BackupVersion is the version of the backup format written by this version of
+// moneygo. Backups with versions up to and including it can be restored.
+const BackupVersion int64 = 2
+
+// Backup is an archive of everything a user owns. The objects in it refer to
+// each other using the IDs they had when the backup was made, which are
+// remapped when it is restored.
+type Backup struct {
+	Version           int64
+	Created           time.Time
+	User              User
+	Securities        []*Security
+	Prices            []*Price
+	Accounts          []*Account
+	Transactions      []*Transaction
+	Reports           []*Report
+	CSVImportMappings []*CSVImportMapping
+	Rules             []*Rule
+
+	// Added in version 2
+	ScheduledTransactions []*ScheduledTransaction
+	Reminders             []*Reminder
+	Reconciliations       []*Reconciliation
+	Budgets               []*Budget
+	Tags                  []*Tag
+}
+
+func (b *Backup) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(b)
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Budget.Period
+const (
+	MonthlyBudget   int64 = 1
+	QuarterlyBudget       = 2 // Quarters begin in January, April, July, and October
+	YearlyBudget          = 3
+)
+
+// MaxBudgetRolloverPeriods is the most periods amounts may be rolled over
+// through to find a budget's status, which limits how long after its Start
+// the status of a budget with amounts which roll over can be found
+const MaxBudgetRolloverPeriods = 240
+
+// BudgetAmount is the amount budgeted in each period for an income or expense
+// account, including its sub-accounts
+type BudgetAmount struct {
+	AccountId int64
+	Amount    Amount // For income accounts, the amount expected to be earned
+
+	// Whether the amount remaining at the end of each period is added to the
+	// next period's budget (or, if it was overspent, subtracted)
+	Rollover bool
+}
+
+// Budget holds the amounts a user plans to spend or earn in each period
+type Budget struct {
+	BudgetId int64
+	UserId   int64
+	Name     string
+	Period   int64
+	Start    time.Time       // Amounts roll over beginning with the period containing Start
+	Amounts  []*BudgetAmount `db:"-"`
+}
+
+type BudgetList struct {
+	Budgets *[]*Budget `json:"budgets"`
+}
+
+// BudgetAccountStatus compares the amount budgeted for an account in a period
+// with the amount actually spent (or earned). Budgeted includes any amount
+// rolled over from previous periods.
+type BudgetAccountStatus struct {
+	AccountId int64
+	Budgeted  Amount
+	Actual    Amount
+	Remaining Amount // Budgeted minus Actual
+}
+
+// BudgetPeriodStatus is the status of each of a budget's accounts in the
+// period from Begin up to, but not including, End
+type BudgetPeriodStatus struct {
+	Begin    time.Time
+	End      time.Time
+	Accounts []*BudgetAccountStatus
+}
+
+type BudgetStatus struct {
+	BudgetId int64
+	Periods  []*BudgetPeriodStatus
+}
+
+// Valid returns whether b has a name and a valid period, and budgets for each
+// of its accounts at most once
+func (b *Budget) Valid() bool {
+	if len(b.Name) == 0 || b.Period < MonthlyBudget || b.Period > YearlyBudget {
+		return false
+	}
+	seen := make(map[int64]bool)
+	for _, amount := range b.Amounts {
+		if amount == nil || seen[amount.AccountId] {
+			return false
+		}
+		seen[amount.AccountId] = true
+	}
+	return true
+}
+
+// PeriodBegin returns the beginning of b's period which contains date
+func (b *Budget) PeriodBegin(date time.Time) time.Time {
+	year, month, _ := date.Date()
+	switch b.Period {
+	case QuarterlyBudget:
+		month -= (month - 1) % 3
+	case YearlyBudget:
+		month = time.January
+	}
+	return time.Date(year, month, 1, 0, 0, 0, 0, date.Location())
+}
+
+// NextPeriod returns the beginning of the period after the one beginning on
+// begin
+func (b *Budget) NextPeriod(begin time.Time) time.Time {
+	switch b.Period {
+	case QuarterlyBudget:
+		return begin.AddDate(0, 3, 0)
+	case YearlyBudget:
+		return begin.AddDate(1, 0, 0)
+	}
+	return begin.AddDate(0, 1, 0)
+}
+
+func (b *Budget) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(b)
+}
+
+func (b *Budget) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(b)
+}
+
+func (bl *BudgetList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(bl)
+}
+
+func (bl *BudgetList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(bl)
+}
+
+func (bs *BudgetStatus) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(bs)
+}
+
+func (bs *BudgetStatus) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(bs)
+}
@@ -0,0 +1,60 @@
+package models_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"testing"
+)
+
+func TestBudgetPeriods(t *testing.T) {
+	tests := []struct {
+		period int64
+		begin  int
+		next   int
+	}{
+		{models.MonthlyBudget, 8, 9},
+		{models.QuarterlyBudget, 7, 10},
+		{models.YearlyBudget, 1, 13},
+	}
+	for _, test := range tests {
+		b := models.Budget{Period: test.period}
+		begin := b.PeriodBegin(date(2017, 8, 31))
+		if !begin.Equal(date(2017, 1, 1).AddDate(0, test.begin-1, 0)) {
+			t.Errorf("Unexpected beginning of period %d: %s", test.period, begin)
+		}
+		if next := b.NextPeriod(begin); !next.Equal(date(2017, 1, 1).AddDate(0, test.next-1, 0)) {
+			t.Errorf("Unexpected beginning of period %d after %s: %s", test.period, begin, next)
+		}
+	}
+}
+
+func TestBudgetValid(t *testing.T) {
+	b := models.Budget{
+		Name:    "Household",
+		Period:  models.MonthlyBudget,
+		Amounts: []*models.BudgetAmount{{AccountId: 1}, {AccountId: 2}},
+	}
+	if !b.Valid() {
+		t.Errorf("Expected budget to be valid: %+v", b)
+	}
+	b.Amounts[1].AccountId = 1
+	if b.Valid() {
+		t.Errorf("Expected budget including an account twice to be invalid")
+	}
+	b.Amounts = b.Amounts[:1]
+	for _, period := range []int64{0, 4} {
+		b.Period = period
+		if b.Valid() {
+			t.Errorf("Expected budget with period %d to be invalid", period)
+		}
+	}
+	b.Amounts = append(b.Amounts, nil)
+	if b.Valid() {
+		t.Errorf("Expected budget with a null amount to be invalid")
+	}
+	b.Amounts = b.Amounts[:1]
+	b.Period = models.YearlyBudget
+	b.Name = ""
+	if b.Valid() {
+		t.Errorf("Expected budget without a name to be invalid")
+	}
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// CSVImportMapping describes how to turn the rows of a CSV file into
+// transactions for a single account. Column indices start at 0, and a column
+// index of -1 denotes that the column is not present in the file.
+type CSVImportMapping struct {
+	CSVImportMappingId int64
+	UserId             int64
+	AccountId          int64
+
+	DateColumn        int64
+	DateFormat        string // e.g. "MM/DD/YYYY" or a Go time layout, such as "01/02/2006"
+	DescriptionColumn int64
+	MemoColumn        int64
+	NumberColumn      int64 // Check or reference number
+
+	// Either AmountColumn or at least one of DebitColumn and CreditColumn
+	// must be set. Amounts in DebitColumn decrease the account's balance,
+	// those in CreditColumn increase it.
+	AmountColumn int64
+	DebitColumn  int64
+	CreditColumn int64
+
+	Delimiter        string // defaults to ","
+	HeaderRows       int64  // number of rows at the top of the file to skip
+	DecimalSeparator string // defaults to "."
+}
+
+func (m *CSVImportMapping) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(m)
+}
+
+func (m *CSVImportMapping) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(m)
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ImportBatch.Source
+const (
+	OFXImport     int64 = 1
+	GnucashImport       = 2
+)
+
+// ImportBatch records what a single import created or changed, so that it can
+// be rolled back
+type ImportBatch struct {
+	ImportBatchId int64
+	UserId        int64
+	Source        int64
+	FileHash      string // hex-encoded SHA-256 hash of the imported file
+	Date          time.Time
+
+	TransactionIds []int64 `db:"-"`
+	AccountIds     []int64 `db:"-"` // In the order they were created
+	SecurityIds    []int64 `db:"-"`
+	PriceIds       []int64 `db:"-"`
+
+	// TransactionHashes holds a hash of each of the transactions in
+	// TransactionIds as it was imported, for detecting whether it has been
+	// edited since
+	TransactionHashes []string `db:"-" json:"-"`
+
+	// ModifiedTransactionIds holds the existing transactions the import
+	// merged into, corrected, or voided. PreviousTransactions holds each as
+	// it was before the import changed it, so it can be restored, and
+	// ModifiedTransactionHashes a hash of each as the import left it.
+	ModifiedTransactionIds    []int64        `db:"-"`
+	ModifiedTransactionHashes []string       `db:"-" json:"-"`
+	PreviousTransactions      []*Transaction `db:"-" json:"-"`
+}
+
+type ImportBatchList struct {
+	ImportBatches *[]*ImportBatch `json:"importbatches"`
+}
+
+func (b *ImportBatch) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(b)
+}
+
+func (b *ImportBatch) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(b)
+}
+
+func (bl *ImportBatchList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(bl)
+}
+
+func (bl *ImportBatchList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(bl)
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ImportOptions control how an import is carried out. If Preview is set, the
+// import is run in its entirety but not committed, and an ImportPreview is
+// returned describing what it would have done. If Accept is non-nil, only the
+// transactions with those indices (as reported by a preview of the same file)
+// are imported.
+//
+// Transactions being imported into an account are matched against splits in
+// that account which were entered by hand but not yet cleared, and merged
+// into them rather than imported again. MatchDays is how many days apart the
+// two transactions' dates may be for them to match; if zero,
+// DefaultMatchDays is used, and if negative, no matching is done.
+//
+// Each statement in an OFX file is imported into the account whose
+// ExternalAccountId or OFXAcctID matches the statement's account ID (unless
+// the file contains a single statement and is being imported into a
+// particular account). If CreateAccounts is set, accounts are created for
+// statements which don't match any existing account; otherwise those
+// statements are skipped and reported as Unmatched.
+type ImportOptions struct {
+	Preview        bool
+	Accept         []int
+	MatchDays      int
+	CreateAccounts bool
+}
+
+const DefaultMatchDays int = 3
+
+// ImportedTransaction is a transaction from an import, along with its index
+// in the order transactions were processed during that import
+type ImportedTransaction struct {
+	Index       int
+	Transaction *Transaction
+}
+
+// ImportMatch describes an imported transaction which was merged into an
+// existing transaction entered by hand. SplitId is the existing split the
+// imported one was matched to, and Transaction the existing transaction after
+// the merge.
+type ImportMatch struct {
+	Index       int
+	SplitId     int64
+	Transaction *Transaction
+}
+
+// BalanceDiscrepancy describes a balance reported by an import which doesn't
+// match the balance computed from the account's transactions. SecurityId is
+// the account's own security for its cash balance, or that of a security held
+// in one of its sub-accounts. Both balances are as of the end of Date.
+type BalanceDiscrepancy struct {
+	AccountId  int64
+	SecurityId int64
+	Date       time.Time
+	Reported   Amount
+	Computed   Amount
+}
+
+// ImportResult is returned after a successful import
+type ImportResult struct {
+	Matches       []*ImportMatch         // transactions merged into existing ones
+	Corrections   []*ImportedTransaction // existing transactions replaced or voided, after being corrected
+	Rejected      []*ImportedTransaction // corrections not applied because they would change reconciled splits
+	Discrepancies []*BalanceDiscrepancy  // reported balances which don't match those computed after the import
+	Unmatched     []*Account             // accounts of statements skipped because they didn't match an existing account
+}
+
+func (ir *ImportResult) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(ir)
+}
+
+func (ir *ImportResult) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(ir)
+}
+
+// ImportPreview describes what an import would do if committed. The IDs of
+// transactions, accounts, and securities which would be created are only
+// meaningful within the preview (i.e. to tell which of the new accounts a
+// proposed transaction's splits belong to).
+type ImportPreview struct {
+	Transactions  []*ImportedTransaction // transactions which would be imported
+	Duplicates    []*ImportedTransaction // transactions skipped as already imported
+	Matches       []*ImportMatch         // transactions which would be merged into existing ones
+	Corrections   []*ImportedTransaction // existing transactions which would be replaced or voided, after being corrected
+	Rejected      []*ImportedTransaction // corrections which wouldn't be applied because they would change reconciled splits
+	Discrepancies []*BalanceDiscrepancy  // reported balances which wouldn't match those computed after the import
+	Accounts      []*Account             // accounts which would be created
+	Securities    []*Security            // securities which would be created
+	Unmatched     []*Account             // accounts of statements which would be skipped because they don't match an existing account
+}
+
+func (ip *ImportPreview) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(ip)
+}
+
+func (ip *ImportPreview) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(ip)
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// OFXDiscovery requests the list of a user's accounts at a financial
+// institution, using the OFX connection details shared by all of them.
+// Actions optionally create or link some of the accounts found.
+type OFXDiscovery struct {
+	OFXURL       string
+	OFXORG       string
+	OFXFID       string
+	OFXUser      string
+	OFXPassword  string
+	OFXClientUID string
+	OFXAppID     string
+	OFXAppVer    string
+	OFXVersion   string
+	OFXNoIndent  bool
+
+	Actions []*OFXDiscoveryAction
+}
+
+// OFXDiscoveryAction creates a new account for the discovered account with
+// Index if AccountId is -1. Otherwise, it links the existing account with
+// AccountId to it by filling in the existing account's OFX fields.
+type OFXDiscoveryAction struct {
+	Index     int
+	AccountId int64
+}
+
+func (d *OFXDiscovery) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(d)
+}
+
+// DiscoveredAccount is an account found at a financial institution. Account
+// has all its OFX fields filled in, ready to be created (or, if an action was
+// taken for it, is the account created or linked). LinkedAccountId is the
+// existing account with the same OFX details, or -1 if there isn't one.
+type DiscoveredAccount struct {
+	Index           int
+	Account         *Account
+	LinkedAccountId int64
+}
+
+type DiscoveredAccountList struct {
+	Accounts *[]*DiscoveredAccount `json:"accounts"`
+}
+
+func (dl *DiscoveredAccountList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(dl)
+}
+
+func (dl *DiscoveredAccountList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(dl)
+}
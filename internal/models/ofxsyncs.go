@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OFXSync configures an account to be synced periodically in the background
+// with its financial institution over OFX direct connect, using the account's
+// OFX* fields, and records the outcome of the most recent attempt.
+type OFXSync struct {
+	OFXSyncId int64
+	UserId    int64
+	AccountId int64
+	Interval  int64 // minutes between syncs
+
+	// Password is the OFX password, which is accepted when creating or
+	// updating a sync but never returned. When updating, BogusPassword leaves
+	// the stored password unchanged.
+	Password          string `db:"-"`
+	EncryptedPassword string `json:"-"`
+
+	LastAttempt time.Time // zero if a sync hasn't been attempted
+	LastSuccess time.Time // when the last successful sync began; the next requests transactions since then
+	NextSync    time.Time
+	Error       string // why the last attempt failed, empty if it succeeded
+}
+
+type OFXSyncList struct {
+	OFXSyncs *[]*OFXSync `json:"ofxsyncs"`
+}
+
+func (s *OFXSync) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(s)
+}
+
+func (s *OFXSync) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(s)
+}
+
+func (sl *OFXSyncList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(sl)
+}
+
+func (sl *OFXSyncList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(sl)
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Reconciliation records reconciling an account against a statement from its
+// financial institution. While it is in progress, the user selects the splits
+// which appear on the statement, until the account's cleared balance matches
+// the statement's. Finishing it marks those splits Reconciled. Finished
+// reconciliations can no longer be changed, and are kept as a record.
+type Reconciliation struct {
+	ReconciliationId int64
+	UserId           int64
+	AccountId        int64
+	StatementDate    time.Time
+	StatementBalance Amount
+	Finished         bool
+	FinishedDate     time.Time // Zero until Finished
+
+	// Balance of the splits in the account which were already reconciled,
+	// as of when this reconciliation was last updated
+	StartingBalance Amount
+	SplitIds        []int64 `db:"-"` // The splits selected as appearing on the statement
+
+	// The following are only returned for reconciliations in progress
+	ClearedBalance Amount          `db:"-"` // StartingBalance plus the selected splits
+	Difference     Amount          `db:"-"` // StatementBalance minus ClearedBalance
+	Transactions   *[]*Transaction `db:"-"` // Transactions with splits which can be selected
+}
+
+type ReconciliationList struct {
+	Reconciliations *[]*Reconciliation `json:"reconciliations"`
+}
+
+func (r *Reconciliation) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(r)
+}
+
+func (r *Reconciliation) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(r)
+}
+
+func (rl *ReconciliationList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(rl)
+}
+
+func (rl *ReconciliationList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(rl)
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Rule.MatchType
+const (
+	SubstringMatch int64 = 1 // Case-insensitive substring match
+	RegexpMatch          = 2 // Go regular expression, as accepted by the regexp package
+)
+
+// RuleTarget is an account a Rule assigns (a portion of) a split to
+type RuleTarget struct {
+	AccountId int64
+	Percent   Amount // Portion of the split's amount to assign, out of 100
+}
+
+// Rule categorizes the splits of imported transactions which couldn't be
+// associated with an account (those which would otherwise be put in an
+// Imbalances account). Each such split is handled by the first rule, in
+// ascending order of Priority, whose criteria all match it.
+type Rule struct {
+	RuleId   int64
+	UserId   int64
+	Name     string
+	Priority int64
+
+	// Criteria
+	MatchType        int64   // How DescriptionMatch and MemoMatch are matched
+	DescriptionMatch string  // Matched against the transaction's description, if non-empty
+	MemoMatch        string  // Matched against the split's memo, if non-empty
+	MinAmount        *Amount // Inclusive bounds on the split's amount, if non-nil
+	MaxAmount        *Amount
+	SourceAccountId  int64 // The account being imported to, or -1 to match any
+	ImportSplitType  int64 // The split's ImportSplitType, or -1 to match any
+
+	// Actions
+	Description string        // If non-empty, replaces the transaction's description
+	Targets     []*RuleTarget `db:"-"` // Percents must add up to 100, if there are any
+}
+
+type RuleList struct {
+	Rules *[]*Rule `json:"rules"`
+}
+
+// Valid returns whether r is internally consistent. It does not check that
+// the accounts it refers to exist.
+func (r *Rule) Valid() bool {
+	if r.MatchType != SubstringMatch && r.MatchType != RegexpMatch {
+		return false
+	}
+	if r.MatchType == RegexpMatch {
+		if _, err := regexp.Compile(r.DescriptionMatch); err != nil {
+			return false
+		}
+		if _, err := regexp.Compile(r.MemoMatch); err != nil {
+			return false
+		}
+	}
+	if r.MinAmount != nil && r.MaxAmount != nil && r.MinAmount.Cmp(&r.MaxAmount.Rat) > 0 {
+		return false
+	}
+	if r.ImportSplitType < -1 || r.ImportSplitType > ExpenseAccount {
+		return false
+	}
+
+	if len(r.Targets) == 0 {
+		// A rule has to do *something*
+		return len(r.Description) > 0
+	}
+	var total, zero big.Rat
+	for _, target := range r.Targets {
+		if target.Percent.Cmp(&zero) <= 0 {
+			return false
+		}
+		total.Add(&total, &target.Percent.Rat)
+	}
+	return total.Cmp(big.NewRat(100, 1)) == 0
+}
+
+func (r *Rule) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(r)
+}
+
+func (r *Rule) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(r)
+}
+
+func (rl *RuleList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(rl)
+}
+
+func (rl *RuleList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(rl)
+}
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ScheduledTransaction.Unit
+const (
+	RecurDays            int64 = 1
+	RecurWeeks                 = 2
+	RecurMonths                = 3 // On the same day of the month as Start, or the month's last day if it is shorter
+	RecurYears                 = 4
+	RecurLastBusinessDay       = 5 // On the last weekday of the month
+)
+
+// MaxScheduleInterval is the largest Interval a recurrence rule may have
+const MaxScheduleInterval int64 = 1000
+
+// maxOccurrence bounds the occurrences Reschedule searches for the next one,
+// so it can't loop forever if occurrences stop increasing
+const maxOccurrence int64 = 1 << 32
+
+// ScheduledTransaction describes a transaction which recurs on a schedule,
+// such as rent or a salary. As each occurrence comes due, a transaction is
+// created from Template (or a Reminder, if RemindOnly is set).
+type ScheduledTransaction struct {
+	ScheduledTransactionId int64
+	UserId                 int64
+
+	// Template's Description and Splits are used for the transactions
+	// created. Of its splits, only the AccountId, Number, Memo, and Amount
+	// are kept; the transactions are created with every split Entered.
+	Template   Transaction `db:"-"`
+	RemindOnly bool
+
+	// Recurrence rule: occurs every Interval Units, beginning on Start (or,
+	// for RecurLastBusinessDay, the first last business day of a month which
+	// isn't before Start), until End if it is non-zero, and at most
+	// Occurrences times if it is non-zero
+	Unit        int64
+	Interval    int64
+	Start       time.Time
+	End         time.Time
+	Occurrences int64
+
+	// Status, which can't be changed directly
+	Count    int64     // How many occurrences have come due
+	LastDate time.Time // Date of the last occurrence to come due, zero if none have
+	NextDate time.Time // Date of the next occurrence
+	Finished bool      // Set once no more occurrences will come due
+}
+
+type ScheduledTransactionList struct {
+	ScheduledTransactions *[]*ScheduledTransaction `json:"scheduledtransactions"`
+}
+
+// ScheduledOccurrence is an occurrence of a scheduled transaction which has
+// not yet come due. Transaction is the transaction which would be created for
+// it.
+type ScheduledOccurrence struct {
+	ScheduledTransactionId int64
+	Date                   time.Time
+	Transaction            *Transaction
+}
+
+type ScheduledOccurrenceList struct {
+	ScheduledOccurrences *[]*ScheduledOccurrence `json:"scheduledoccurrences"`
+}
+
+// Reminder is an occurrence of a RemindOnly scheduled transaction which has
+// come due
+type Reminder struct {
+	ReminderId             int64
+	UserId                 int64
+	ScheduledTransactionId int64
+	Date                   time.Time
+}
+
+type ReminderList struct {
+	Reminders *[]*Reminder `json:"reminders"`
+}
+
+// Valid returns whether s's recurrence rule and template are internally
+// consistent. It does not check that the accounts it refers to exist, or that
+// the template is balanced.
+func (s *ScheduledTransaction) Valid() bool {
+	if s.Unit < RecurDays || s.Unit > RecurLastBusinessDay || s.Interval <= 0 || s.Interval > MaxScheduleInterval || s.Occurrences < 0 {
+		return false
+	}
+	if s.Start.IsZero() || (!s.End.IsZero() && s.End.Before(s.Start)) {
+		return false
+	}
+	if len(s.Template.Splits) == 0 {
+		return false
+	}
+	for _, split := range s.Template.Splits {
+		if split.AccountId == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// addMonths returns the date months months after t, on the same day of the
+// month if there is one, or otherwise the last day of that month
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// lastBusinessDay returns the last weekday of the month months after t's,
+// with t's time of day
+func lastBusinessDay(t time.Time, months int) time.Time {
+	year, month, _ := t.Date()
+	day := time.Date(year, month+time.Month(months)+1, 0, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, -1)
+	}
+	return day
+}
+
+// Occurrence returns the date of the nth occurrence (counting from zero) of
+// s's recurrence rule, disregarding End and Occurrences
+func (s *ScheduledTransaction) Occurrence(n int64) time.Time {
+	steps := int(n * s.Interval)
+	switch s.Unit {
+	case RecurDays:
+		return s.Start.AddDate(0, 0, steps)
+	case RecurWeeks:
+		return s.Start.AddDate(0, 0, 7*steps)
+	case RecurMonths:
+		return addMonths(s.Start, steps)
+	case RecurYears:
+		return addMonths(s.Start, 12*steps)
+	case RecurLastBusinessDay:
+		if lastBusinessDay(s.Start, 0).Before(s.Start) {
+			steps++
+		}
+		return lastBusinessDay(s.Start, steps)
+	}
+	return s.Start
+}
+
+// Reschedule sets NextDate to the first occurrence after LastDate (or the
+// first occurrence, if none have come due yet), and sets Finished if it
+// wouldn't come due because of End or Occurrences, or if there isn't one
+// within the first maxOccurrence occurrences
+func (s *ScheduledTransaction) Reschedule() {
+	// Occurrences are in increasing order, so find the first one after
+	// LastDate by doubling an upper bound and then bisecting
+	after := func(n int64) bool {
+		return s.LastDate.IsZero() || s.Occurrence(n).After(s.LastDate)
+	}
+	var low, high int64 = 0, 1
+	for !after(high) {
+		if high >= maxOccurrence {
+			s.NextDate = s.LastDate
+			s.Finished = true
+			return
+		}
+		low, high = high, high*2
+	}
+	for low < high {
+		mid := low + (high-low)/2
+		if after(mid) {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+
+	s.NextDate = s.Occurrence(low)
+	s.Finished = (s.Occurrences > 0 && s.Count >= s.Occurrences) ||
+		(!s.End.IsZero() && s.NextDate.After(s.End))
+}
+
+// Advance records that s's next occurrence has come due, and reschedules it
+func (s *ScheduledTransaction) Advance() {
+	s.Count++
+	s.LastDate = s.NextDate
+	s.Reschedule()
+}
+
+func (s *ScheduledTransaction) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(s)
+}
+
+func (s *ScheduledTransaction) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(s)
+}
+
+func (sl *ScheduledTransactionList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(sl)
+}
+
+func (sl *ScheduledTransactionList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(sl)
+}
+
+func (ol *ScheduledOccurrenceList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(ol)
+}
+
+func (ol *ScheduledOccurrenceList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(ol)
+}
+
+func (r *Reminder) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(r)
+}
+
+func (r *Reminder) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(r)
+}
+
+func (rl *ReminderList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(rl)
+}
+
+func (rl *ReminderList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(rl)
+}
@@ -0,0 +1,101 @@
+package models_test
+
+import (
+	"github.com/aclindsa/moneygo/internal/models"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func expectedOccurrences(t *testing.T, s *models.ScheduledTransaction, dates ...time.Time) {
+	t.Helper()
+	for i, d := range dates {
+		if o := s.Occurrence(int64(i)); !o.Equal(d) {
+			t.Errorf("Expected occurrence %d to be %s, found %s", i, d, o)
+		}
+	}
+}
+
+func TestScheduledTransactionOccurrences(t *testing.T) {
+	s := models.ScheduledTransaction{Unit: models.RecurDays, Interval: 10, Start: date(2017, 12, 25)}
+	expectedOccurrences(t, &s, date(2017, 12, 25), date(2018, 1, 4), date(2018, 1, 14))
+
+	s = models.ScheduledTransaction{Unit: models.RecurWeeks, Interval: 2, Start: date(2018, 2, 20)}
+	expectedOccurrences(t, &s, date(2018, 2, 20), date(2018, 3, 6), date(2018, 3, 20))
+
+	// Monthly occurrences are clamped to the end of shorter months, without
+	// drifting earlier in later months
+	s = models.ScheduledTransaction{Unit: models.RecurMonths, Interval: 1, Start: date(2018, 1, 31)}
+	expectedOccurrences(t, &s, date(2018, 1, 31), date(2018, 2, 28), date(2018, 3, 31), date(2018, 4, 30))
+
+	s = models.ScheduledTransaction{Unit: models.RecurYears, Interval: 1, Start: date(2016, 2, 29)}
+	expectedOccurrences(t, &s, date(2016, 2, 29), date(2017, 2, 28), date(2018, 2, 28), date(2019, 2, 28), date(2020, 2, 29))
+
+	// March 31st and June 30th 2018 are Saturdays, so the last business days
+	// of those months are the Fridays before. Starting after a month's last
+	// business day skips to the next month.
+	s = models.ScheduledTransaction{Unit: models.RecurLastBusinessDay, Interval: 1, Start: date(2018, 1, 31)}
+	expectedOccurrences(t, &s, date(2018, 1, 31), date(2018, 2, 28), date(2018, 3, 30))
+	s = models.ScheduledTransaction{Unit: models.RecurLastBusinessDay, Interval: 3, Start: date(2018, 2, 1)}
+	expectedOccurrences(t, &s, date(2018, 2, 28), date(2018, 5, 31), date(2018, 8, 31))
+	s = models.ScheduledTransaction{Unit: models.RecurLastBusinessDay, Interval: 1, Start: date(2018, 6, 30)}
+	expectedOccurrences(t, &s, date(2018, 7, 31))
+}
+
+func TestScheduledTransactionReschedule(t *testing.T) {
+	s := models.ScheduledTransaction{Unit: models.RecurDays, Interval: 1, Start: date(2018, 1, 1), Occurrences: 3}
+	s.Reschedule()
+	if !s.NextDate.Equal(date(2018, 1, 1)) || s.Finished {
+		t.Errorf("Unexpected schedule before any occurrences: %+v", s)
+	}
+	s.Advance()
+	s.Advance()
+	if !s.NextDate.Equal(date(2018, 1, 3)) || s.Count != 2 || s.Finished {
+		t.Errorf("Unexpected schedule after two occurrences: %+v", s)
+	}
+	s.Advance()
+	if !s.Finished {
+		t.Errorf("Expected schedule to be finished after three occurrences: %+v", s)
+	}
+
+	// Changing the recurrence rule continues after the last occurrence
+	s = models.ScheduledTransaction{Unit: models.RecurWeeks, Interval: 1, Start: date(2018, 1, 1), LastDate: date(2018, 3, 10)}
+	s.Reschedule()
+	if !s.NextDate.Equal(date(2018, 3, 12)) || s.Finished {
+		t.Errorf("Unexpected schedule after changing recurrence rule: %+v", s)
+	}
+
+	s = models.ScheduledTransaction{Unit: models.RecurMonths, Interval: 1, Start: date(2018, 1, 15), End: date(2018, 3, 1), LastDate: date(2018, 2, 15)}
+	s.Reschedule()
+	if !s.NextDate.Equal(date(2018, 3, 15)) || !s.Finished {
+		t.Errorf("Expected schedule to be finished after its end date: %+v", s)
+	}
+}
+
+func TestScheduledTransactionHugeInterval(t *testing.T) {
+	s := models.ScheduledTransaction{
+		Template: models.Transaction{Splits: []*models.Split{{AccountId: 1}}},
+		Unit:     models.RecurDays,
+		Interval: 1 << 62,
+		Start:    date(2018, 1, 1),
+	}
+	if s.Valid() {
+		t.Errorf("Expected schedule with a huge interval to be invalid: %+v", s)
+	}
+	s.Interval = models.MaxScheduleInterval
+	if !s.Valid() {
+		t.Errorf("Expected schedule with the largest interval to be valid: %+v", s)
+	}
+
+	// Occurrences overflow rather than increasing, so no next occurrence is
+	// found after the first one, and the schedule finishes
+	s.Interval = 1 << 62
+	s.Reschedule()
+	s.Advance()
+	if !s.Finished {
+		t.Errorf("Expected schedule with a huge interval to be finished: %+v", s)
+	}
+}
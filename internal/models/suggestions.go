@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Suggestion is a prediction, learned from a user's existing transactions, of
+// the account a split which hasn't been categorized belongs in
+type Suggestion struct {
+	SplitId    int64
+	AccountId  int64
+	Confidence float64 // Between 0 and 1, higher is more certain
+}
+
+type SuggestionList struct {
+	Suggestions *[]*Suggestion `json:"suggestions"`
+}
+
+func (sl *SuggestionList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(sl)
+}
+
+func (sl *SuggestionList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(sl)
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Tag is a label which can be attached to transactions, or to individual
+// splits, to group them across accounts (i.e. 'vacation-2026' or
+// 'reimbursable')
+type Tag struct {
+	TagId  int64
+	UserId int64
+	Name   string
+}
+
+type TagList struct {
+	Tags *[]*Tag `json:"tags"`
+}
+
+func (t *Tag) Valid() bool {
+	return len(strings.TrimSpace(t.Name)) > 0
+}
+
+func (t *Tag) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(t)
+}
+
+func (t *Tag) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(t)
+}
+
+func (tl *TagList) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(tl)
+}
+
+func (tl *TagList) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(tl)
+}
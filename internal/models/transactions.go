@@ -48,6 +48,7 @@ type Split struct {
 	Number   string // Check or reference number
 	Memo     string
 	Amount   Amount
+	TagIds   []int64 // Tags attached to just this split
 }
 
 func (s *Split) Valid() bool {
@@ -60,12 +61,50 @@ type Transaction struct {
 	Description   string
 	Date          time.Time
 	Splits        []*Split `db:"-"`
+	TagIds        []int64  `db:"-"` // Tags attached to the whole transaction
 }
 
 type TransactionList struct {
 	Transactions *[]*Transaction `json:"transactions"`
 }
 
+// TransactionSearch holds the criteria transactions must meet to be returned
+// from a search, and how to sort and page through them. A transaction matches
+// the split criteria if any one of its splits meets all of them.
+type TransactionSearch struct {
+	Text string // Matched case-insensitively against the description and splits' memos and numbers, if non-empty
+
+	Begin *time.Time // Inclusive bounds on the transaction's date, if non-nil
+	End   *time.Time // Exclusive
+
+	// Split criteria
+	MinAmount   *Amount // Inclusive bounds on the split's amount, if non-nil
+	MaxAmount   *Amount
+	AccountId   int64 // The split's account, or -1 to match any
+	SubAccounts bool  // Whether splits in AccountId's descendants also match
+	Status      int64 // The split's status, or 0 to match any
+	SecurityId  int64 // The security of the split's account, or -1 to match any
+	TagId       int64 // A tag attached to the split or its transaction, or -1 to match any
+
+	HasImbalance bool // Only match transactions with a split in an Imbalances account
+
+	Sort  string // One of "date-asc", "date-desc", "description-asc", or "description-desc"
+	Page  uint64
+	Limit uint64
+}
+
+// TransactionSearchResults is one page of the transactions matching a search,
+// along with how many matched in all
+type TransactionSearchResults struct {
+	Transactions      *[]*Transaction
+	TotalTransactions int64
+}
+
+// AccountTransactionsList is one page of an account's transactions.
+// BeginningBalance and EndingBalance are the account's balance before and
+// after the transactions on the page. When the transactions are filtered by a
+// tag, they are instead subtotals of only the tagged transactions, up to and
+// through the page.
 type AccountTransactionsList struct {
 	Account           *Account
 	Transactions      *[]*Transaction
@@ -94,6 +133,16 @@ func (tl *TransactionList) Read(json_str string) error {
 	return dec.Decode(tl)
 }
 
+func (tsr *TransactionSearchResults) Write(w http.ResponseWriter) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(tsr)
+}
+
+func (tsr *TransactionSearchResults) Read(json_str string) error {
+	dec := json.NewDecoder(strings.NewReader(json_str))
+	return dec.Decode(tsr)
+}
+
 func (atl *AccountTransactionsList) Write(w http.ResponseWriter) error {
 	enc := json.NewEncoder(w)
 	return enc.Encode(atl)
@@ -0,0 +1,323 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"github.com/yuin/gopher-lua"
+	"math/big"
+	"time"
+)
+
+const luaBudgetTypeName = "budget"
+
+// ErrBudgetRolloverTooLong is returned by BudgetStatus if amounts would have to
+// be rolled over through more than models.MaxBudgetRolloverPeriods periods
+var ErrBudgetRolloverTooLong = errors.New("Budget status requested too long after its start")
+
+// budgetActual returns the amount spent in an expense account, or earned in an
+// income account, between begin and end, including its sub-accounts in the
+// same security
+func budgetActual(tx store.Tx, user *models.User, account *models.Account, children map[int64][]*models.Account, begin, end *time.Time) (*big.Rat, error) {
+	var actual big.Rat
+	pending := []*models.Account{account}
+	for len(pending) > 0 {
+		a := pending[0]
+		pending = pending[1:]
+		balance, err := tx.GetAccountBalanceDateRange(user, a.AccountId, begin, end)
+		if err != nil {
+			return nil, err
+		}
+		actual.Add(&actual, &balance.Rat)
+		for _, child := range children[a.AccountId] {
+			if child.SecurityId == account.SecurityId {
+				pending = append(pending, child)
+			}
+		}
+	}
+	// Income is recorded as negative amounts in income accounts
+	if account.Type == models.Income {
+		actual.Neg(&actual)
+	}
+	return &actual, nil
+}
+
+// BudgetStatus returns the amounts budgeted, spent (or earned), and remaining
+// for each of budget's accounts in each of the given number of periods,
+// beginning with the one containing date. Periods are in UTC, so only date's
+// calendar day is used. If any amounts roll over, date may be at most
+// models.MaxBudgetRolloverPeriods periods after the budget's start.
+func BudgetStatus(tx store.Tx, user *models.User, budget *models.Budget, date time.Time, periods int) (*models.BudgetStatus, error) {
+	year, month, day := date.Date()
+	date = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	accountMap := make(map[int64]*models.Account)
+	children := make(map[int64][]*models.Account)
+	for _, account := range *accounts {
+		accountMap[account.AccountId] = account
+		children[account.ParentAccountId] = append(children[account.ParentAccountId], account)
+	}
+
+	begin := budget.PeriodBegin(date)
+	start := budget.PeriodBegin(budget.Start.UTC())
+	var rollover bool
+	for _, amount := range budget.Amounts {
+		rollover = rollover || amount.Rollover
+	}
+
+	// Amounts roll over from the period containing the budget's start, so
+	// begin there if that is earlier and any amounts roll over
+	period := begin
+	if rollover && start.Before(begin) {
+		period = start
+		n := 0
+		for p := start; p.Before(begin); p = budget.NextPeriod(p) {
+			if n++; n > models.MaxBudgetRolloverPeriods {
+				return nil, ErrBudgetRolloverTooLong
+			}
+		}
+	}
+
+	status := models.BudgetStatus{BudgetId: budget.BudgetId}
+	carried := make(map[int64]*big.Rat)
+	for len(status.Periods) < periods {
+		end := budget.NextPeriod(period)
+		include := !period.Before(begin)
+		periodStatus := models.BudgetPeriodStatus{Begin: period, End: end}
+
+		for _, amount := range budget.Amounts {
+			if !include && !amount.Rollover {
+				continue
+			}
+			account, ok := accountMap[amount.AccountId]
+			if !ok {
+				return nil, errors.New("Budget account not found")
+			}
+
+			var s models.BudgetAccountStatus
+			s.AccountId = amount.AccountId
+			s.Budgeted.Set(&amount.Amount.Rat)
+			if c, ok := carried[amount.AccountId]; ok {
+				s.Budgeted.Add(&s.Budgeted.Rat, c)
+			}
+			actual, err := budgetActual(tx, user, account, children, &period, &end)
+			if err != nil {
+				return nil, err
+			}
+			s.Actual.Set(actual)
+			s.Remaining.Sub(&s.Budgeted.Rat, &s.Actual.Rat)
+
+			if amount.Rollover && !period.Before(start) {
+				carried[amount.AccountId] = new(big.Rat).Set(&s.Remaining.Rat)
+			}
+			if include {
+				periodStatus.Accounts = append(periodStatus.Accounts, &s)
+			}
+		}
+
+		if include {
+			status.Periods = append(status.Periods, &periodStatus)
+		}
+		period = end
+	}
+	return &status, nil
+}
+
+func luaContextGetBudgets(L *lua.LState) (map[int64]*models.Budget, error) {
+	var budget_map map[int64]*models.Budget
+
+	ctx := L.Context()
+
+	tx, ok := ctx.Value(dbContextKey).(store.Tx)
+	if !ok {
+		return nil, errors.New("Couldn't find tx in lua's Context")
+	}
+
+	budget_map, ok = ctx.Value(budgetsContextKey).(map[int64]*models.Budget)
+	if !ok {
+		user, ok := ctx.Value(userContextKey).(*models.User)
+		if !ok {
+			return nil, errors.New("Couldn't find User in lua's Context")
+		}
+
+		budgets, err := tx.GetBudgets(user.UserId)
+		if err != nil {
+			return nil, err
+		}
+
+		budget_map = make(map[int64]*models.Budget)
+		for i := range *budgets {
+			budget_map[(*budgets)[i].BudgetId] = (*budgets)[i]
+		}
+
+		ctx = context.WithValue(ctx, budgetsContextKey, budget_map)
+		L.SetContext(ctx)
+	}
+
+	return budget_map, nil
+}
+
+func luaGetBudgets(L *lua.LState) int {
+	budget_map, err := luaContextGetBudgets(L)
+	if err != nil {
+		panic("luaGetBudgets couldn't fetch budgets")
+	}
+
+	table := L.NewTable()
+
+	for budgetid := range budget_map {
+		table.RawSetInt(int(budgetid), BudgetToLua(L, budget_map[budgetid]))
+	}
+
+	L.Push(table)
+	return 1
+}
+
+func luaRegisterBudgets(L *lua.LState) {
+	mt := L.NewTypeMetatable(luaBudgetTypeName)
+	L.SetGlobal("budget", mt)
+	L.SetField(mt, "__index", L.NewFunction(luaBudget__index))
+	L.SetField(mt, "__tostring", L.NewFunction(luaBudget__tostring))
+	L.SetField(mt, "__metatable", lua.LString("protected"))
+
+	L.SetField(mt, "Monthly", lua.LNumber(float64(models.MonthlyBudget)))
+	L.SetField(mt, "Quarterly", lua.LNumber(float64(models.QuarterlyBudget)))
+	L.SetField(mt, "Yearly", lua.LNumber(float64(models.YearlyBudget)))
+
+	getBudgetsFn := L.NewFunction(luaGetBudgets)
+	L.SetField(mt, "get_all", getBudgetsFn)
+	// also register the get_budgets function as a global in its own right
+	L.SetGlobal("get_budgets", getBudgetsFn)
+}
+
+func BudgetToLua(L *lua.LState, budget *models.Budget) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = budget
+	L.SetMetatable(ud, L.GetTypeMetatable(luaBudgetTypeName))
+	return ud
+}
+
+// Checks whether the first lua argument is a *LUserData with *Budget and returns this *Budget.
+func luaCheckBudget(L *lua.LState, n int) *models.Budget {
+	ud := L.CheckUserData(n)
+	if budget, ok := ud.Value.(*models.Budget); ok {
+		return budget
+	}
+	L.ArgError(n, "budget expected")
+	return nil
+}
+
+func luaBudget__index(L *lua.LState) int {
+	b := luaCheckBudget(L, 1)
+	field := L.CheckString(2)
+
+	switch field {
+	case "BudgetId", "budgetid":
+		L.Push(lua.LNumber(float64(b.BudgetId)))
+	case "Name", "name":
+		L.Push(lua.LString(b.Name))
+	case "Period", "period":
+		L.Push(lua.LNumber(float64(b.Period)))
+	case "Start", "start":
+		start := b.Start
+		L.Push(TimeToLua(L, &start))
+	case "Amount", "amount":
+		L.Push(L.NewFunction(luaBudgetAmount))
+	case "Status", "status":
+		L.Push(L.NewFunction(luaBudgetStatus))
+	default:
+		L.ArgError(2, "unexpected budget attribute: "+field)
+	}
+
+	return 1
+}
+
+// luaBudgetBalance returns amount as a balance in account's security
+func luaBudgetBalance(L *lua.LState, accountid int64, amount *models.Amount) *lua.LUserData {
+	account_map, err := luaContextGetAccounts(L)
+	if err != nil {
+		panic("budget couldn't fetch accounts")
+	}
+	account, ok := account_map[accountid]
+	if !ok {
+		panic("Budget's account not in lua account_map")
+	}
+	security_map, err := luaContextGetSecurities(L)
+	if err != nil {
+		panic("budget couldn't fetch securities")
+	}
+	security, ok := security_map[account.SecurityId]
+	if !ok {
+		panic("SecurityId not in lua security_map")
+	}
+	b := &Balance{Security: security}
+	b.Amount.Set(&amount.Rat)
+	return BalanceToLua(L, b)
+}
+
+// luaBudgetAmount returns the amount budgeted for an account in each period,
+// without any amount rolled over, or nil if the account isn't budgeted for
+func luaBudgetAmount(L *lua.LState) int {
+	b := luaCheckBudget(L, 1)
+	a := luaCheckAccount(L, 2)
+
+	for _, amount := range b.Amounts {
+		if amount.AccountId == a.AccountId {
+			L.Push(luaBudgetBalance(L, amount.AccountId, &amount.Amount))
+			return 1
+		}
+	}
+	L.Push(lua.LNil)
+	return 1
+}
+
+// luaBudgetStatus returns a table, indexed by account ID, of the amounts
+// Budgeted, Actual, and Remaining for each of the budget's accounts in the
+// period containing the given date (or the current period, if none is given)
+func luaBudgetStatus(L *lua.LState) int {
+	b := luaCheckBudget(L, 1)
+	date := time.Now()
+	if d := luaWeakCheckTime(L, 2); d != nil {
+		date = *d
+	}
+
+	ctx := L.Context()
+	tx, ok := ctx.Value(dbContextKey).(store.Tx)
+	if !ok {
+		panic("Couldn't find tx in lua's Context")
+	}
+	user, ok := ctx.Value(userContextKey).(*models.User)
+	if !ok {
+		panic("Couldn't find User in lua's Context")
+	}
+
+	status, err := BudgetStatus(tx, user, b, date, 1)
+	if err != nil {
+		panic("Failed to fetch budget status: " + err.Error())
+	}
+
+	table := L.NewTable()
+	for _, s := range status.Periods[0].Accounts {
+		accountTable := L.NewTable()
+		accountTable.RawSetString("Budgeted", luaBudgetBalance(L, s.AccountId, &s.Budgeted))
+		accountTable.RawSetString("Actual", luaBudgetBalance(L, s.AccountId, &s.Actual))
+		accountTable.RawSetString("Remaining", luaBudgetBalance(L, s.AccountId, &s.Remaining))
+		table.RawSetInt(int(s.AccountId), accountTable)
+	}
+
+	L.Push(table)
+	return 1
+}
+
+func luaBudget__tostring(L *lua.LState) int {
+	b := luaCheckBudget(L, 1)
+
+	L.Push(lua.LString(b.Name))
+
+	return 1
+}
@@ -19,6 +19,8 @@ const (
 	securitiesContextKey
 	balanceContextKey
 	dbContextKey
+	budgetsContextKey
+	tagsContextKey
 )
 
 const luaTimeoutSeconds time.Duration = 30 // maximum time a lua request can run for
@@ -60,6 +62,8 @@ func RunReport(tx store.Tx, user *models.User, report *models.Report) (*models.T
 	luaRegisterDates(L)
 	luaRegisterTabulations(L)
 	luaRegisterPrices(L)
+	luaRegisterBudgets(L)
+	luaRegisterTags(L)
 
 	err := L.DoString(report.Lua)
 
@@ -0,0 +1,175 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"github.com/aclindsa/moneygo/internal/models"
+	"github.com/aclindsa/moneygo/internal/store"
+	"github.com/yuin/gopher-lua"
+)
+
+const luaTagTypeName = "tag"
+
+func luaContextGetTags(L *lua.LState) (map[int64]*models.Tag, error) {
+	var tag_map map[int64]*models.Tag
+
+	ctx := L.Context()
+
+	tx, ok := ctx.Value(dbContextKey).(store.Tx)
+	if !ok {
+		return nil, errors.New("Couldn't find tx in lua's Context")
+	}
+
+	tag_map, ok = ctx.Value(tagsContextKey).(map[int64]*models.Tag)
+	if !ok {
+		user, ok := ctx.Value(userContextKey).(*models.User)
+		if !ok {
+			return nil, errors.New("Couldn't find User in lua's Context")
+		}
+
+		tags, err := tx.GetTags(user.UserId)
+		if err != nil {
+			return nil, err
+		}
+
+		tag_map = make(map[int64]*models.Tag)
+		for i := range *tags {
+			tag_map[(*tags)[i].TagId] = (*tags)[i]
+		}
+
+		ctx = context.WithValue(ctx, tagsContextKey, tag_map)
+		L.SetContext(ctx)
+	}
+
+	return tag_map, nil
+}
+
+func luaGetTags(L *lua.LState) int {
+	tag_map, err := luaContextGetTags(L)
+	if err != nil {
+		panic("luaGetTags couldn't fetch tags")
+	}
+
+	table := L.NewTable()
+
+	for tagid := range tag_map {
+		table.RawSetInt(int(tagid), TagToLua(L, tag_map[tagid]))
+	}
+
+	L.Push(table)
+	return 1
+}
+
+func luaRegisterTags(L *lua.LState) {
+	mt := L.NewTypeMetatable(luaTagTypeName)
+	L.SetGlobal("tag", mt)
+	L.SetField(mt, "__index", L.NewFunction(luaTag__index))
+	L.SetField(mt, "__tostring", L.NewFunction(luaTag__tostring))
+	L.SetField(mt, "__eq", L.NewFunction(luaTag__eq))
+	L.SetField(mt, "__metatable", lua.LString("protected"))
+
+	getTagsFn := L.NewFunction(luaGetTags)
+	L.SetField(mt, "get_all", getTagsFn)
+	// also register the get_tags function as a global in its own right
+	L.SetGlobal("get_tags", getTagsFn)
+}
+
+func TagToLua(L *lua.LState, tag *models.Tag) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = tag
+	L.SetMetatable(ud, L.GetTypeMetatable(luaTagTypeName))
+	return ud
+}
+
+// Checks whether the first lua argument is a *LUserData with *Tag and returns this *Tag.
+func luaCheckTag(L *lua.LState, n int) *models.Tag {
+	ud := L.CheckUserData(n)
+	if tag, ok := ud.Value.(*models.Tag); ok {
+		return tag
+	}
+	L.ArgError(n, "tag expected")
+	return nil
+}
+
+func luaTag__index(L *lua.LState) int {
+	t := luaCheckTag(L, 1)
+	field := L.CheckString(2)
+
+	switch field {
+	case "TagId", "tagid":
+		L.Push(lua.LNumber(float64(t.TagId)))
+	case "Name", "name":
+		L.Push(lua.LString(t.Name))
+	case "Balance", "balance":
+		L.Push(L.NewFunction(luaTagBalance))
+	default:
+		L.ArgError(2, "unexpected tag attribute: "+field)
+	}
+
+	return 1
+}
+
+// luaTagBalance returns the balance of an account's splits which have the tag
+// attached to them or their transaction, in the same way as account:Balance()
+func luaTagBalance(L *lua.LState) int {
+	t := luaCheckTag(L, 1)
+	a := luaCheckAccount(L, 2)
+
+	ctx := L.Context()
+	tx, ok := ctx.Value(dbContextKey).(store.Tx)
+	if !ok {
+		panic("Couldn't find tx in lua's Context")
+	}
+	user, ok := ctx.Value(userContextKey).(*models.User)
+	if !ok {
+		panic("Couldn't find User in lua's Context")
+	}
+	security_map, err := luaContextGetSecurities(L)
+	if err != nil {
+		panic("tag.balance couldn't fetch securities")
+	}
+	security, ok := security_map[a.SecurityId]
+	if !ok {
+		panic("SecurityId not in lua security_map")
+	}
+	date := luaWeakCheckTime(L, 3)
+	var balance *models.Amount
+	if date != nil {
+		end := luaWeakCheckTime(L, 4)
+		if end != nil {
+			balance, err = tx.GetAccountTagBalance(user, a.AccountId, t.TagId, date, end)
+		} else {
+			balance, err = tx.GetAccountTagBalance(user, a.AccountId, t.TagId, nil, date)
+		}
+	} else {
+		balance, err = tx.GetAccountTagBalance(user, a.AccountId, t.TagId, nil, nil)
+	}
+	if err != nil {
+		panic("Failed to fetch tagged balance for account:" + err.Error())
+	}
+	b := &Balance{
+		Amount:   *balance,
+		Security: security,
+	}
+
+	L.Push(BalanceToLua(L, b))
+
+	return 1
+}
+
+func luaTag__tostring(L *lua.LState) int {
+	t := luaCheckTag(L, 1)
+
+	L.Push(lua.LString(t.Name))
+
+	return 1
+}
+
+func luaTag__eq(L *lua.LState) int {
+	a := luaCheckTag(L, 1)
+	b := luaCheckTag(L, 2)
+
+	L.Push(lua.LBool(a.TagId == b.TagId))
+
+	return 1
+}
@@ -109,14 +109,69 @@ func (tx *Tx) DeleteAccount(account *models.Account) error {
 		}
 	} else {
 		// Delete splits if this account is a root account
-		_, err := tx.Exec("DELETE FROM splits WHERE AccountId=?", account.AccountId)
+		_, err := tx.Exec("DELETE FROM splittags WHERE splittags.SplitId IN (SELECT splits.SplitId FROM splits WHERE splits.AccountId=?)", account.AccountId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM splits WHERE AccountId=?", account.AccountId)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec("DELETE FROM csvimportmappings WHERE AccountId=?", account.AccountId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM ofxsyncs WHERE AccountId=?", account.AccountId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM reconciliationsplits WHERE reconciliationsplits.ReconciliationId IN (SELECT reconciliations.ReconciliationId FROM reconciliations WHERE reconciliations.AccountId=?)", account.AccountId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM reconciliations WHERE AccountId=?", account.AccountId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM budgetamounts WHERE AccountId=?", account.AccountId)
+	if err != nil {
+		return err
+	}
+
+	// Delete any rules which would otherwise refer to this account
+	var ruleids []int64
+	_, err = tx.Select(&ruleids, "SELECT DISTINCT rules.RuleId FROM rules LEFT JOIN ruletargets ON rules.RuleId=ruletargets.RuleId WHERE rules.SourceAccountId=? OR ruletargets.AccountId=?", account.AccountId, account.AccountId)
+	if err != nil {
+		return err
+	}
+	for _, ruleid := range ruleids {
+		err = tx.DeleteRule(&models.Rule{RuleId: ruleid})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete any scheduled transactions which would otherwise refer to this
+	// account
+	var scheduledids []int64
+	_, err = tx.Select(&scheduledids, "SELECT DISTINCT ScheduledTransactionId FROM scheduledsplits WHERE AccountId=?", account.AccountId)
+	if err != nil {
+		return err
+	}
+	for _, scheduledid := range scheduledids {
+		err = tx.DeleteScheduledTransaction(&models.ScheduledTransaction{ScheduledTransactionId: scheduledid})
 		if err != nil {
 			return err
 		}
 	}
 
 	// Re-parent child accounts to this account's parent account
-	_, err := tx.Exec("UPDATE accounts SET ParentAccountId=? WHERE ParentAccountId=?", account.ParentAccountId, account.AccountId)
+	_, err = tx.Exec("UPDATE accounts SET ParentAccountId=? WHERE ParentAccountId=?", account.ParentAccountId, account.AccountId)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+)
+
+// BudgetAmount is a mirror of models.BudgetAmount with the Amount broken out
+// into whole and fractional components
+type BudgetAmount struct {
+	BudgetAmountId   int64
+	BudgetId         int64
+	AccountId        int64
+	WholeAmount      int64
+	FractionalAmount int64
+	Rollover         bool
+}
+
+func (tx *Tx) getBudgetAmounts(budget *models.Budget) error {
+	var amounts []*BudgetAmount
+	_, err := tx.Select(&amounts, "SELECT * from budgetamounts where BudgetId=? ORDER BY BudgetAmountId", budget.BudgetId)
+	if err != nil {
+		return err
+	}
+	budget.Amounts = nil
+	for _, a := range amounts {
+		amount := &models.BudgetAmount{AccountId: a.AccountId, Rollover: a.Rollover}
+		amount.Amount.FromParts(a.WholeAmount, a.FractionalAmount, MaxPrecision)
+		budget.Amounts = append(budget.Amounts, amount)
+	}
+	return nil
+}
+
+func (tx *Tx) insertBudgetAmounts(budget *models.Budget) error {
+	for _, a := range budget.Amounts {
+		existing, err := tx.SelectInt("SELECT count(*) from accounts where AccountId=? AND UserId=?", a.AccountId, budget.UserId)
+		if err != nil {
+			return err
+		}
+		if existing != 1 {
+			return fmt.Errorf("Budget account %d doesn't exist", a.AccountId)
+		}
+
+		whole, fractional, err := amountParts(&a.Amount)
+		if err != nil {
+			return err
+		}
+		err = tx.Insert(&BudgetAmount{
+			BudgetAmountId:   -1,
+			BudgetId:         budget.BudgetId,
+			AccountId:        a.AccountId,
+			WholeAmount:      whole,
+			FractionalAmount: fractional,
+			Rollover:         a.Rollover,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) InsertBudget(budget *models.Budget) error {
+	err := tx.Insert(budget)
+	if err != nil {
+		return err
+	}
+	return tx.insertBudgetAmounts(budget)
+}
+
+func (tx *Tx) GetBudget(budgetid int64, userid int64) (*models.Budget, error) {
+	var b models.Budget
+
+	err := tx.SelectOne(&b, "SELECT * from budgets where UserId=? AND BudgetId=?", userid, budgetid)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.getBudgetAmounts(&b)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (tx *Tx) GetBudgets(userid int64) (*[]*models.Budget, error) {
+	var budgets []*models.Budget
+
+	_, err := tx.Select(&budgets, "SELECT * from budgets where UserId=? ORDER BY Name, BudgetId", userid)
+	if err != nil {
+		return nil, err
+	}
+	for _, budget := range budgets {
+		err = tx.getBudgetAmounts(budget)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &budgets, nil
+}
+
+func (tx *Tx) UpdateBudget(budget *models.Budget) error {
+	count, err := tx.Update(budget)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to update 1 budget, was going to update %d", count)
+	}
+
+	_, err = tx.Exec("DELETE FROM budgetamounts WHERE BudgetId=?", budget.BudgetId)
+	if err != nil {
+		return err
+	}
+	return tx.insertBudgetAmounts(budget)
+}
+
+func (tx *Tx) DeleteBudget(budget *models.Budget) error {
+	_, err := tx.Exec("DELETE FROM budgetamounts WHERE BudgetId=?", budget.BudgetId)
+	if err != nil {
+		return err
+	}
+
+	count, err := tx.Delete(budget)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to delete 1 budget, was going to delete %d", count)
+	}
+	return nil
+}
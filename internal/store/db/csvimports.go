@@ -0,0 +1,40 @@
+package db
+
+import (
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+)
+
+func (tx *Tx) CSVImportMappingExists(accountid int64, userid int64) (bool, error) {
+	count, err := tx.SelectInt("SELECT count(*) from csvimportmappings where UserId=? AND AccountId=?", userid, accountid)
+	return count != 0, err
+}
+
+func (tx *Tx) InsertCSVImportMapping(mapping *models.CSVImportMapping) error {
+	err := tx.Insert(mapping)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (tx *Tx) GetCSVImportMapping(accountid int64, userid int64) (*models.CSVImportMapping, error) {
+	var m models.CSVImportMapping
+
+	err := tx.SelectOne(&m, "SELECT * from csvimportmappings where UserId=? AND AccountId=?", userid, accountid)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (tx *Tx) UpdateCSVImportMapping(mapping *models.CSVImportMapping) error {
+	count, err := tx.Update(mapping)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to update 1 CSV import mapping, was going to update %d", count)
+	}
+	return nil
+}
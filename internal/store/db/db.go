@@ -19,6 +19,10 @@ import (
 // implementation's string type specified by the same.
 const luaMaxLengthBuffer int = 4096
 
+// previousTransactionMaxLength is the longest JSON-encoded transaction which
+// can be stored as the previous state of one modified by an import
+const previousTransactionMaxLength int = 65535
+
 func getDbMap(db *sql.DB, dbtype config.DbType) (*gorp.DbMap, error) {
 	var dialect gorp.Dialect
 	if dbtype == config.SQLite {
@@ -46,6 +50,23 @@ func getDbMap(db *sql.DB, dbtype config.DbType) (*gorp.DbMap, error) {
 	dbmap.AddTableWithName(Split{}, "splits").SetKeys(true, "SplitId")
 	rtable := dbmap.AddTableWithName(models.Report{}, "reports").SetKeys(true, "ReportId")
 	rtable.ColMap("Lua").SetMaxSize(models.LuaMaxLength + luaMaxLengthBuffer)
+	dbmap.AddTableWithName(models.CSVImportMapping{}, "csvimportmappings").SetKeys(true, "CSVImportMappingId")
+	dbmap.AddTableWithName(Rule{}, "rules").SetKeys(true, "RuleId")
+	dbmap.AddTableWithName(RuleTarget{}, "ruletargets").SetKeys(true, "RuleTargetId")
+	dbmap.AddTableWithName(models.OFXSync{}, "ofxsyncs").SetKeys(true, "OFXSyncId")
+	dbmap.AddTableWithName(models.ImportBatch{}, "importbatches").SetKeys(true, "ImportBatchId")
+	itable := dbmap.AddTableWithName(ImportBatchItem{}, "importbatchitems").SetKeys(true, "ImportBatchItemId")
+	itable.ColMap("Previous").SetMaxSize(previousTransactionMaxLength)
+	dbmap.AddTableWithName(ScheduledTransaction{}, "scheduledtransactions").SetKeys(true, "ScheduledTransactionId")
+	dbmap.AddTableWithName(ScheduledSplit{}, "scheduledsplits").SetKeys(true, "ScheduledSplitId")
+	dbmap.AddTableWithName(models.Reminder{}, "reminders").SetKeys(true, "ReminderId")
+	dbmap.AddTableWithName(Reconciliation{}, "reconciliations").SetKeys(true, "ReconciliationId")
+	dbmap.AddTableWithName(ReconciliationSplit{}, "reconciliationsplits").SetKeys(true, "ReconciliationSplitId")
+	dbmap.AddTableWithName(models.Budget{}, "budgets").SetKeys(true, "BudgetId")
+	dbmap.AddTableWithName(BudgetAmount{}, "budgetamounts").SetKeys(true, "BudgetAmountId")
+	dbmap.AddTableWithName(models.Tag{}, "tags").SetKeys(true, "TagId")
+	dbmap.AddTableWithName(TransactionTag{}, "transactiontags").SetKeys(true, "TransactionTagId")
+	dbmap.AddTableWithName(SplitTag{}, "splittags").SetKeys(true, "SplitTagId")
 
 	err := dbmap.CreateTablesIfNotExists()
 	if err != nil {
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+)
+
+// ImportBatchItem.Type
+const (
+	importedTransaction int64 = 1
+	importedAccount           = 2
+	importedSecurity          = 3
+	importedPrice             = 4
+	modifiedTransaction       = 5
+)
+
+// ImportBatchItem is one of the objects created by an import batch. Items are
+// stored in the order they appear in the batch's lists of IDs.
+type ImportBatchItem struct {
+	ImportBatchItemId int64
+	ImportBatchId     int64
+	Type              int64
+	ObjectId          int64
+	Hash              string // For transactions, the hash recorded when it was imported or modified
+	Previous          string // For modified transactions, the JSON-encoded transaction before it was
+}
+
+func (tx *Tx) getImportBatchItems(batch *models.ImportBatch) error {
+	var items []*ImportBatchItem
+	_, err := tx.Select(&items, "SELECT * from importbatchitems where ImportBatchId=? ORDER BY ImportBatchItemId", batch.ImportBatchId)
+	if err != nil {
+		return err
+	}
+
+	batch.TransactionIds = nil
+	batch.TransactionHashes = nil
+	batch.AccountIds = nil
+	batch.SecurityIds = nil
+	batch.PriceIds = nil
+	batch.ModifiedTransactionIds = nil
+	batch.ModifiedTransactionHashes = nil
+	batch.PreviousTransactions = nil
+	for _, item := range items {
+		switch item.Type {
+		case importedTransaction:
+			batch.TransactionIds = append(batch.TransactionIds, item.ObjectId)
+			batch.TransactionHashes = append(batch.TransactionHashes, item.Hash)
+		case importedAccount:
+			batch.AccountIds = append(batch.AccountIds, item.ObjectId)
+		case importedSecurity:
+			batch.SecurityIds = append(batch.SecurityIds, item.ObjectId)
+		case importedPrice:
+			batch.PriceIds = append(batch.PriceIds, item.ObjectId)
+		case modifiedTransaction:
+			var previous models.Transaction
+			if err := json.Unmarshal([]byte(item.Previous), &previous); err != nil {
+				return err
+			}
+			batch.ModifiedTransactionIds = append(batch.ModifiedTransactionIds, item.ObjectId)
+			batch.ModifiedTransactionHashes = append(batch.ModifiedTransactionHashes, item.Hash)
+			batch.PreviousTransactions = append(batch.PreviousTransactions, &previous)
+		default:
+			return fmt.Errorf("Unknown type (%d) for import batch item %d", item.Type, item.ImportBatchItemId)
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) insertImportBatchItems(batch *models.ImportBatch, itemtype int64, ids []int64, hashes []string) error {
+	for i, id := range ids {
+		item := ImportBatchItem{
+			ImportBatchItemId: -1,
+			ImportBatchId:     batch.ImportBatchId,
+			Type:              itemtype,
+			ObjectId:          id,
+		}
+		if hashes != nil {
+			item.Hash = hashes[i]
+		}
+		err := tx.Insert(&item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) insertModifiedTransactionItems(batch *models.ImportBatch) error {
+	for i, id := range batch.ModifiedTransactionIds {
+		previous, err := json.Marshal(batch.PreviousTransactions[i])
+		if err != nil {
+			return err
+		}
+		if len(previous) > previousTransactionMaxLength {
+			return fmt.Errorf("Modified transaction %d is too long (%d bytes) to store in import batch", id, len(previous))
+		}
+		item := ImportBatchItem{
+			ImportBatchItemId: -1,
+			ImportBatchId:     batch.ImportBatchId,
+			Type:              modifiedTransaction,
+			ObjectId:          id,
+			Hash:              batch.ModifiedTransactionHashes[i],
+			Previous:          string(previous),
+		}
+		err = tx.Insert(&item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) InsertImportBatch(batch *models.ImportBatch) error {
+	if len(batch.TransactionHashes) != len(batch.TransactionIds) {
+		return fmt.Errorf("Import batch has %d transactions, but %d hashes", len(batch.TransactionIds), len(batch.TransactionHashes))
+	}
+	if len(batch.ModifiedTransactionHashes) != len(batch.ModifiedTransactionIds) || len(batch.PreviousTransactions) != len(batch.ModifiedTransactionIds) {
+		return fmt.Errorf("Import batch has %d modified transactions, but %d hashes and %d previous transactions", len(batch.ModifiedTransactionIds), len(batch.ModifiedTransactionHashes), len(batch.PreviousTransactions))
+	}
+
+	err := tx.Insert(batch)
+	if err != nil {
+		return err
+	}
+
+	err = tx.insertImportBatchItems(batch, importedTransaction, batch.TransactionIds, batch.TransactionHashes)
+	if err != nil {
+		return err
+	}
+	err = tx.insertImportBatchItems(batch, importedAccount, batch.AccountIds, nil)
+	if err != nil {
+		return err
+	}
+	err = tx.insertImportBatchItems(batch, importedSecurity, batch.SecurityIds, nil)
+	if err != nil {
+		return err
+	}
+	err = tx.insertModifiedTransactionItems(batch)
+	if err != nil {
+		return err
+	}
+	return tx.insertImportBatchItems(batch, importedPrice, batch.PriceIds, nil)
+}
+
+func (tx *Tx) GetImportBatch(importbatchid int64, userid int64) (*models.ImportBatch, error) {
+	var b models.ImportBatch
+
+	err := tx.SelectOne(&b, "SELECT * from importbatches where UserId=? AND ImportBatchId=?", userid, importbatchid)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.getImportBatchItems(&b)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (tx *Tx) GetImportBatches(userid int64) (*[]*models.ImportBatch, error) {
+	var batches []*models.ImportBatch
+
+	_, err := tx.Select(&batches, "SELECT * from importbatches where UserId=? ORDER BY Date DESC, ImportBatchId DESC", userid)
+	if err != nil {
+		return nil, err
+	}
+	for _, batch := range batches {
+		err = tx.getImportBatchItems(batch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &batches, nil
+}
+
+func (tx *Tx) DeleteImportBatch(batch *models.ImportBatch) error {
+	_, err := tx.Exec("DELETE FROM importbatchitems WHERE ImportBatchId=?", batch.ImportBatchId)
+	if err != nil {
+		return err
+	}
+
+	count, err := tx.Delete(batch)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to delete 1 import batch, was going to delete %d", count)
+	}
+	return nil
+}
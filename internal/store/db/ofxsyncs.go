@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"time"
+)
+
+func (tx *Tx) InsertOFXSync(sync *models.OFXSync) error {
+	err := tx.Insert(sync)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (tx *Tx) GetOFXSync(ofxsyncid int64, userid int64) (*models.OFXSync, error) {
+	var s models.OFXSync
+
+	err := tx.SelectOne(&s, "SELECT * from ofxsyncs where UserId=? AND OFXSyncId=?", userid, ofxsyncid)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (tx *Tx) GetOFXSyncs(userid int64) (*[]*models.OFXSync, error) {
+	var syncs []*models.OFXSync
+
+	_, err := tx.Select(&syncs, "SELECT * from ofxsyncs where UserId=?", userid)
+	if err != nil {
+		return nil, err
+	}
+	return &syncs, nil
+}
+
+func (tx *Tx) OFXSyncExists(accountid int64, userid int64) (bool, error) {
+	count, err := tx.SelectInt("SELECT count(*) from ofxsyncs where UserId=? AND AccountId=?", userid, accountid)
+	return count != 0, err
+}
+
+func (tx *Tx) GetDueOFXSyncs(now *time.Time) (*[]*models.OFXSync, error) {
+	var syncs []*models.OFXSync
+
+	// Pass a time.Time so gorp doesn't mistake a lone *time.Time argument
+	// for a struct of named parameters
+	_, err := tx.Select(&syncs, "SELECT * from ofxsyncs where NextSync<=? ORDER BY NextSync, OFXSyncId", *now)
+	if err != nil {
+		return nil, err
+	}
+	return &syncs, nil
+}
+
+func (tx *Tx) UpdateOFXSync(sync *models.OFXSync) error {
+	count, err := tx.Update(sync)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to update 1 OFX sync, was going to update %d", count)
+	}
+	return nil
+}
+
+func (tx *Tx) DeleteOFXSync(sync *models.OFXSync) error {
+	count, err := tx.Delete(sync)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to delete 1 OFX sync, was going to delete %d", count)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"time"
+)
+
+// Reconciliation is a mirror of models.Reconciliation with the balances broken
+// out into whole and fractional components
+type Reconciliation struct {
+	ReconciliationId int64
+	UserId           int64
+	AccountId        int64
+	StatementDate    time.Time
+	Finished         bool
+	FinishedDate     time.Time
+
+	// StatementBalance.Whole and StatementBalance.Fractional(MaxPrecision)
+	WholeStatementBalance      int64
+	FractionalStatementBalance int64
+	// StartingBalance.Whole and StartingBalance.Fractional(MaxPrecision)
+	WholeStartingBalance      int64
+	FractionalStartingBalance int64
+}
+
+// ReconciliationSplit is one of the splits selected in a reconciliation
+type ReconciliationSplit struct {
+	ReconciliationSplitId int64
+	ReconciliationId      int64
+	SplitId               int64
+}
+
+func NewReconciliation(r *models.Reconciliation) (*Reconciliation, error) {
+	reconciliation := &Reconciliation{
+		ReconciliationId: r.ReconciliationId,
+		UserId:           r.UserId,
+		AccountId:        r.AccountId,
+		StatementDate:    r.StatementDate,
+		Finished:         r.Finished,
+		FinishedDate:     r.FinishedDate,
+	}
+	var err error
+	reconciliation.WholeStatementBalance, reconciliation.FractionalStatementBalance, err = amountParts(&r.StatementBalance)
+	if err != nil {
+		return nil, err
+	}
+	reconciliation.WholeStartingBalance, reconciliation.FractionalStartingBalance, err = amountParts(&r.StartingBalance)
+	if err != nil {
+		return nil, err
+	}
+	return reconciliation, nil
+}
+
+func (r Reconciliation) Reconciliation() *models.Reconciliation {
+	reconciliation := &models.Reconciliation{
+		ReconciliationId: r.ReconciliationId,
+		UserId:           r.UserId,
+		AccountId:        r.AccountId,
+		StatementDate:    r.StatementDate,
+		Finished:         r.Finished,
+		FinishedDate:     r.FinishedDate,
+	}
+	reconciliation.StatementBalance.FromParts(r.WholeStatementBalance, r.FractionalStatementBalance, MaxPrecision)
+	reconciliation.StartingBalance.FromParts(r.WholeStartingBalance, r.FractionalStartingBalance, MaxPrecision)
+	return reconciliation
+}
+
+func (tx *Tx) getReconciliationSplits(r *models.Reconciliation) error {
+	var splitids []int64
+	_, err := tx.Select(&splitids, "SELECT SplitId from reconciliationsplits where ReconciliationId=? ORDER BY ReconciliationSplitId", r.ReconciliationId)
+	if err != nil {
+		return err
+	}
+	r.SplitIds = splitids
+	return nil
+}
+
+func (tx *Tx) insertReconciliationSplits(r *models.Reconciliation) error {
+	for _, splitid := range r.SplitIds {
+		err := tx.Insert(&ReconciliationSplit{
+			ReconciliationSplitId: -1,
+			ReconciliationId:      r.ReconciliationId,
+			SplitId:               splitid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) InsertReconciliation(r *models.Reconciliation) error {
+	reconciliation, err := NewReconciliation(r)
+	if err != nil {
+		return err
+	}
+	err = tx.Insert(reconciliation)
+	if err != nil {
+		return err
+	}
+	r.ReconciliationId = reconciliation.ReconciliationId
+	return tx.insertReconciliationSplits(r)
+}
+
+func (tx *Tx) GetReconciliation(reconciliationid int64, accountid int64, userid int64) (*models.Reconciliation, error) {
+	var r Reconciliation
+
+	err := tx.SelectOne(&r, "SELECT * from reconciliations where UserId=? AND AccountId=? AND ReconciliationId=?", userid, accountid, reconciliationid)
+	if err != nil {
+		return nil, err
+	}
+	reconciliation := r.Reconciliation()
+	err = tx.getReconciliationSplits(reconciliation)
+	if err != nil {
+		return nil, err
+	}
+	return reconciliation, nil
+}
+
+func (tx *Tx) GetReconciliations(accountid int64, userid int64) (*[]*models.Reconciliation, error) {
+	var rs []*Reconciliation
+
+	_, err := tx.Select(&rs, "SELECT * from reconciliations where UserId=? AND AccountId=? ORDER BY StatementDate DESC, ReconciliationId DESC", userid, accountid)
+	if err != nil {
+		return nil, err
+	}
+
+	reconciliations := make([]*models.Reconciliation, 0, len(rs))
+	for _, r := range rs {
+		reconciliation := r.Reconciliation()
+		err = tx.getReconciliationSplits(reconciliation)
+		if err != nil {
+			return nil, err
+		}
+		reconciliations = append(reconciliations, reconciliation)
+	}
+	return &reconciliations, nil
+}
+
+func (tx *Tx) UpdateReconciliation(r *models.Reconciliation) error {
+	reconciliation, err := NewReconciliation(r)
+	if err != nil {
+		return err
+	}
+	count, err := tx.Update(reconciliation)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to update 1 reconciliation, was going to update %d", count)
+	}
+
+	_, err = tx.Exec("DELETE FROM reconciliationsplits WHERE ReconciliationId=?", r.ReconciliationId)
+	if err != nil {
+		return err
+	}
+	return tx.insertReconciliationSplits(r)
+}
+
+func (tx *Tx) FinishReconciliation(r *models.Reconciliation, user *models.User) error {
+	for _, splitid := range r.SplitIds {
+		count, err := tx.SelectInt("SELECT count(*) from splits INNER JOIN transactions ON transactions.TransactionId = splits.TransactionId WHERE splits.SplitId=? AND splits.AccountId=? AND transactions.UserId=?", splitid, r.AccountId, user.UserId)
+		if err != nil {
+			return err
+		}
+		if count != 1 {
+			return fmt.Errorf("Split %d isn't in reconciliation %d's account", splitid, r.ReconciliationId)
+		}
+		_, err = tx.Exec("UPDATE splits SET Status=? WHERE SplitId=?", models.Reconciled, splitid)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tx.incrementAccountVersions(user, []int64{r.AccountId})
+	if err != nil {
+		return err
+	}
+
+	r.Finished = true
+	return tx.UpdateReconciliation(r)
+}
+
+func (tx *Tx) DeleteReconciliation(r *models.Reconciliation) error {
+	_, err := tx.Exec("DELETE FROM reconciliationsplits WHERE ReconciliationId=?", r.ReconciliationId)
+	if err != nil {
+		return err
+	}
+
+	count, err := tx.Delete(&Reconciliation{ReconciliationId: r.ReconciliationId})
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to delete 1 reconciliation, was going to delete %d", count)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+)
+
+// Rule is a mirror of models.Rule with the amount bounds broken out into
+// whole and fractional components
+type Rule struct {
+	RuleId   int64
+	UserId   int64
+	Name     string
+	Priority int64
+
+	MatchType        int64
+	DescriptionMatch string
+	MemoMatch        string
+	SourceAccountId  int64
+	ImportSplitType  int64
+
+	// MinAmount.Whole and MinAmount.Fractional(MaxPrecision), if HasMinAmount
+	HasMinAmount        bool
+	WholeMinAmount      int64
+	FractionalMinAmount int64
+	// MaxAmount.Whole and MaxAmount.Fractional(MaxPrecision), if HasMaxAmount
+	HasMaxAmount        bool
+	WholeMaxAmount      int64
+	FractionalMaxAmount int64
+
+	Description string
+}
+
+// RuleTarget is a mirror of models.RuleTarget with the Percent broken out into
+// whole and fractional components
+type RuleTarget struct {
+	RuleTargetId      int64
+	RuleId            int64
+	AccountId         int64
+	WholePercent      int64
+	FractionalPercent int64
+}
+
+func amountParts(amount *models.Amount) (whole, fractional int64, err error) {
+	whole, err = amount.Whole()
+	if err != nil {
+		return 0, 0, err
+	}
+	fractional, err = amount.Fractional(MaxPrecision)
+	if err != nil {
+		return 0, 0, err
+	}
+	return whole, fractional, nil
+}
+
+func NewRule(r *models.Rule) (*Rule, error) {
+	rule := &Rule{
+		RuleId:           r.RuleId,
+		UserId:           r.UserId,
+		Name:             r.Name,
+		Priority:         r.Priority,
+		MatchType:        r.MatchType,
+		DescriptionMatch: r.DescriptionMatch,
+		MemoMatch:        r.MemoMatch,
+		SourceAccountId:  r.SourceAccountId,
+		ImportSplitType:  r.ImportSplitType,
+		Description:      r.Description,
+	}
+	var err error
+	if r.MinAmount != nil {
+		rule.HasMinAmount = true
+		rule.WholeMinAmount, rule.FractionalMinAmount, err = amountParts(r.MinAmount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.MaxAmount != nil {
+		rule.HasMaxAmount = true
+		rule.WholeMaxAmount, rule.FractionalMaxAmount, err = amountParts(r.MaxAmount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rule, nil
+}
+
+func (r Rule) Rule() *models.Rule {
+	rule := &models.Rule{
+		RuleId:           r.RuleId,
+		UserId:           r.UserId,
+		Name:             r.Name,
+		Priority:         r.Priority,
+		MatchType:        r.MatchType,
+		DescriptionMatch: r.DescriptionMatch,
+		MemoMatch:        r.MemoMatch,
+		SourceAccountId:  r.SourceAccountId,
+		ImportSplitType:  r.ImportSplitType,
+		Description:      r.Description,
+	}
+	if r.HasMinAmount {
+		rule.MinAmount = new(models.Amount)
+		rule.MinAmount.FromParts(r.WholeMinAmount, r.FractionalMinAmount, MaxPrecision)
+	}
+	if r.HasMaxAmount {
+		rule.MaxAmount = new(models.Amount)
+		rule.MaxAmount.FromParts(r.WholeMaxAmount, r.FractionalMaxAmount, MaxPrecision)
+	}
+	return rule
+}
+
+func (tx *Tx) getRuleTargets(rule *models.Rule) error {
+	var targets []*RuleTarget
+	_, err := tx.Select(&targets, "SELECT * from ruletargets where RuleId=? ORDER BY RuleTargetId", rule.RuleId)
+	if err != nil {
+		return err
+	}
+	rule.Targets = nil
+	for _, t := range targets {
+		target := &models.RuleTarget{AccountId: t.AccountId}
+		target.Percent.FromParts(t.WholePercent, t.FractionalPercent, MaxPrecision)
+		rule.Targets = append(rule.Targets, target)
+	}
+	return nil
+}
+
+func (tx *Tx) insertRuleTargets(rule *models.Rule) error {
+	for _, t := range rule.Targets {
+		existing, err := tx.SelectInt("SELECT count(*) from accounts where AccountId=? AND UserId=?", t.AccountId, rule.UserId)
+		if err != nil {
+			return err
+		}
+		if existing != 1 {
+			return fmt.Errorf("Rule target account %d doesn't exist", t.AccountId)
+		}
+
+		whole, fractional, err := amountParts(&t.Percent)
+		if err != nil {
+			return err
+		}
+		err = tx.Insert(&RuleTarget{
+			RuleTargetId:      -1,
+			RuleId:            rule.RuleId,
+			AccountId:         t.AccountId,
+			WholePercent:      whole,
+			FractionalPercent: fractional,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) InsertRule(rule *models.Rule) error {
+	r, err := NewRule(rule)
+	if err != nil {
+		return err
+	}
+	err = tx.Insert(r)
+	if err != nil {
+		return err
+	}
+	rule.RuleId = r.RuleId
+	return tx.insertRuleTargets(rule)
+}
+
+func (tx *Tx) GetRule(ruleid int64, userid int64) (*models.Rule, error) {
+	var r Rule
+
+	err := tx.SelectOne(&r, "SELECT * from rules where UserId=? AND RuleId=?", userid, ruleid)
+	if err != nil {
+		return nil, err
+	}
+	rule := r.Rule()
+	err = tx.getRuleTargets(rule)
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (tx *Tx) GetRules(userid int64) (*[]*models.Rule, error) {
+	var rs []*Rule
+
+	_, err := tx.Select(&rs, "SELECT * from rules where UserId=? ORDER BY Priority, RuleId", userid)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*models.Rule, 0, len(rs))
+	for _, r := range rs {
+		rule := r.Rule()
+		err = tx.getRuleTargets(rule)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return &rules, nil
+}
+
+func (tx *Tx) UpdateRule(rule *models.Rule) error {
+	r, err := NewRule(rule)
+	if err != nil {
+		return err
+	}
+	count, err := tx.Update(r)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to update 1 rule, was going to update %d", count)
+	}
+
+	_, err = tx.Exec("DELETE FROM ruletargets WHERE RuleId=?", rule.RuleId)
+	if err != nil {
+		return err
+	}
+	return tx.insertRuleTargets(rule)
+}
+
+func (tx *Tx) DeleteRule(rule *models.Rule) error {
+	_, err := tx.Exec("DELETE FROM ruletargets WHERE RuleId=?", rule.RuleId)
+	if err != nil {
+		return err
+	}
+
+	count, err := tx.Delete(&Rule{RuleId: rule.RuleId})
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to delete 1 rule, was going to delete %d", count)
+	}
+	return nil
+}
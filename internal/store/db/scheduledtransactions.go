@@ -0,0 +1,258 @@
+package db
+
+import (
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+	"time"
+)
+
+// ScheduledTransaction is a mirror of models.ScheduledTransaction with the
+// template transaction's Description broken out
+type ScheduledTransaction struct {
+	ScheduledTransactionId int64
+	UserId                 int64
+	Description            string
+	RemindOnly             bool
+
+	Unit        int64
+	Interval    int64
+	Start       time.Time
+	End         time.Time
+	Occurrences int64
+
+	Count    int64
+	LastDate time.Time
+	NextDate time.Time
+	Finished bool
+}
+
+// ScheduledSplit is one of the splits of a scheduled transaction's template,
+// with the Amount broken out into whole and fractional components
+type ScheduledSplit struct {
+	ScheduledSplitId       int64
+	ScheduledTransactionId int64
+	AccountId              int64
+	Number                 string
+	Memo                   string
+
+	// Amount.Whole and Amount.Fractional(MaxPrecision)
+	WholeAmount      int64
+	FractionalAmount int64
+}
+
+func NewScheduledTransaction(s *models.ScheduledTransaction) *ScheduledTransaction {
+	return &ScheduledTransaction{
+		ScheduledTransactionId: s.ScheduledTransactionId,
+		UserId:                 s.UserId,
+		Description:            s.Template.Description,
+		RemindOnly:             s.RemindOnly,
+		Unit:                   s.Unit,
+		Interval:               s.Interval,
+		Start:                  s.Start,
+		End:                    s.End,
+		Occurrences:            s.Occurrences,
+		Count:                  s.Count,
+		LastDate:               s.LastDate,
+		NextDate:               s.NextDate,
+		Finished:               s.Finished,
+	}
+}
+
+func (s ScheduledTransaction) ScheduledTransaction() *models.ScheduledTransaction {
+	return &models.ScheduledTransaction{
+		ScheduledTransactionId: s.ScheduledTransactionId,
+		UserId:                 s.UserId,
+		Template: models.Transaction{
+			TransactionId: -1,
+			UserId:        s.UserId,
+			Description:   s.Description,
+		},
+		RemindOnly:  s.RemindOnly,
+		Unit:        s.Unit,
+		Interval:    s.Interval,
+		Start:       s.Start,
+		End:         s.End,
+		Occurrences: s.Occurrences,
+		Count:       s.Count,
+		LastDate:    s.LastDate,
+		NextDate:    s.NextDate,
+		Finished:    s.Finished,
+	}
+}
+
+func (tx *Tx) getScheduledSplits(s *models.ScheduledTransaction) error {
+	var splits []*ScheduledSplit
+	_, err := tx.Select(&splits, "SELECT * from scheduledsplits where ScheduledTransactionId=? ORDER BY ScheduledSplitId", s.ScheduledTransactionId)
+	if err != nil {
+		return err
+	}
+	s.Template.Splits = nil
+	for _, ss := range splits {
+		split := &models.Split{
+			SplitId:       -1,
+			TransactionId: -1,
+			Status:        models.Entered,
+			AccountId:     ss.AccountId,
+			SecurityId:    -1,
+			Number:        ss.Number,
+			Memo:          ss.Memo,
+		}
+		split.Amount.FromParts(ss.WholeAmount, ss.FractionalAmount, MaxPrecision)
+		s.Template.Splits = append(s.Template.Splits, split)
+	}
+	return nil
+}
+
+func (tx *Tx) insertScheduledSplits(s *models.ScheduledTransaction) error {
+	for _, split := range s.Template.Splits {
+		existing, err := tx.SelectInt("SELECT count(*) from accounts where AccountId=? AND UserId=?", split.AccountId, s.UserId)
+		if err != nil {
+			return err
+		}
+		if existing != 1 {
+			return fmt.Errorf("Scheduled transaction split account %d doesn't exist", split.AccountId)
+		}
+
+		whole, fractional, err := amountParts(&split.Amount)
+		if err != nil {
+			return err
+		}
+		err = tx.Insert(&ScheduledSplit{
+			ScheduledSplitId:       -1,
+			ScheduledTransactionId: s.ScheduledTransactionId,
+			AccountId:              split.AccountId,
+			Number:                 split.Number,
+			Memo:                   split.Memo,
+			WholeAmount:            whole,
+			FractionalAmount:       fractional,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) getScheduledTransactions(query string, args ...interface{}) (*[]*models.ScheduledTransaction, error) {
+	var ss []*ScheduledTransaction
+
+	_, err := tx.Select(&ss, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := make([]*models.ScheduledTransaction, 0, len(ss))
+	for _, s := range ss {
+		st := s.ScheduledTransaction()
+		err = tx.getScheduledSplits(st)
+		if err != nil {
+			return nil, err
+		}
+		scheduled = append(scheduled, st)
+	}
+	return &scheduled, nil
+}
+
+func (tx *Tx) InsertScheduledTransaction(s *models.ScheduledTransaction) error {
+	st := NewScheduledTransaction(s)
+	err := tx.Insert(st)
+	if err != nil {
+		return err
+	}
+	s.ScheduledTransactionId = st.ScheduledTransactionId
+	return tx.insertScheduledSplits(s)
+}
+
+func (tx *Tx) GetScheduledTransaction(scheduledtransactionid int64, userid int64) (*models.ScheduledTransaction, error) {
+	var s ScheduledTransaction
+
+	err := tx.SelectOne(&s, "SELECT * from scheduledtransactions where UserId=? AND ScheduledTransactionId=?", userid, scheduledtransactionid)
+	if err != nil {
+		return nil, err
+	}
+	st := s.ScheduledTransaction()
+	err = tx.getScheduledSplits(st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (tx *Tx) GetScheduledTransactions(userid int64) (*[]*models.ScheduledTransaction, error) {
+	return tx.getScheduledTransactions("SELECT * from scheduledtransactions where UserId=? ORDER BY NextDate, ScheduledTransactionId", userid)
+}
+
+func (tx *Tx) GetDueScheduledTransactions(now *time.Time) (*[]*models.ScheduledTransaction, error) {
+	return tx.getScheduledTransactions("SELECT * from scheduledtransactions where Finished=? AND NextDate<=? ORDER BY NextDate, ScheduledTransactionId", false, *now)
+}
+
+func (tx *Tx) UpdateScheduledTransaction(s *models.ScheduledTransaction) error {
+	count, err := tx.Update(NewScheduledTransaction(s))
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to update 1 scheduled transaction, was going to update %d", count)
+	}
+
+	_, err = tx.Exec("DELETE FROM scheduledsplits WHERE ScheduledTransactionId=?", s.ScheduledTransactionId)
+	if err != nil {
+		return err
+	}
+	return tx.insertScheduledSplits(s)
+}
+
+func (tx *Tx) DeleteScheduledTransaction(s *models.ScheduledTransaction) error {
+	_, err := tx.Exec("DELETE FROM scheduledsplits WHERE ScheduledTransactionId=?", s.ScheduledTransactionId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM reminders WHERE ScheduledTransactionId=?", s.ScheduledTransactionId)
+	if err != nil {
+		return err
+	}
+
+	count, err := tx.Delete(&ScheduledTransaction{ScheduledTransactionId: s.ScheduledTransactionId})
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to delete 1 scheduled transaction, was going to delete %d", count)
+	}
+	return nil
+}
+
+func (tx *Tx) InsertReminder(reminder *models.Reminder) error {
+	return tx.Insert(reminder)
+}
+
+func (tx *Tx) GetReminder(reminderid int64, userid int64) (*models.Reminder, error) {
+	var r models.Reminder
+
+	err := tx.SelectOne(&r, "SELECT * from reminders where UserId=? AND ReminderId=?", userid, reminderid)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (tx *Tx) GetReminders(userid int64) (*[]*models.Reminder, error) {
+	var reminders []*models.Reminder
+
+	_, err := tx.Select(&reminders, "SELECT * from reminders where UserId=? ORDER BY Date, ReminderId", userid)
+	if err != nil {
+		return nil, err
+	}
+	return &reminders, nil
+}
+
+func (tx *Tx) DeleteReminder(reminder *models.Reminder) error {
+	count, err := tx.Delete(reminder)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to delete 1 reminder, was going to delete %d", count)
+	}
+	return nil
+}
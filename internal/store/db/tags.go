@@ -0,0 +1,174 @@
+package db
+
+import (
+	"fmt"
+	"github.com/aclindsa/moneygo/internal/models"
+)
+
+// TransactionTag attaches a tag to a whole transaction
+type TransactionTag struct {
+	TransactionTagId int64
+	TransactionId    int64
+	TagId            int64
+}
+
+// SplitTag attaches a tag to a single split
+type SplitTag struct {
+	SplitTagId int64
+	SplitId    int64
+	TagId      int64
+}
+
+func (tx *Tx) InsertTag(tag *models.Tag) error {
+	return tx.Insert(tag)
+}
+
+func (tx *Tx) GetTag(tagid int64, userid int64) (*models.Tag, error) {
+	var t models.Tag
+
+	err := tx.SelectOne(&t, "SELECT * from tags where UserId=? AND TagId=?", userid, tagid)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (tx *Tx) GetTags(userid int64) (*[]*models.Tag, error) {
+	var tags []*models.Tag
+
+	_, err := tx.Select(&tags, "SELECT * from tags where UserId=? ORDER BY Name, TagId", userid)
+	if err != nil {
+		return nil, err
+	}
+	return &tags, nil
+}
+
+func (tx *Tx) UpdateTag(tag *models.Tag) error {
+	count, err := tx.Update(tag)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to update 1 tag, was going to update %d", count)
+	}
+	return nil
+}
+
+func (tx *Tx) DeleteTag(tag *models.Tag) error {
+	_, err := tx.Exec("DELETE FROM transactiontags WHERE TagId=?", tag.TagId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM splittags WHERE TagId=?", tag.TagId)
+	if err != nil {
+		return err
+	}
+
+	count, err := tx.Delete(tag)
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Expected to delete 1 tag, was going to delete %d", count)
+	}
+	return nil
+}
+
+// getTransactionTags sets the TagIds of t and each of its splits
+func (tx *Tx) getTransactionTags(t *models.Transaction) error {
+	var tagids []int64
+	_, err := tx.Select(&tagids, "SELECT TagId from transactiontags where TransactionId=? ORDER BY TransactionTagId", t.TransactionId)
+	if err != nil {
+		return err
+	}
+	t.TagIds = tagids
+
+	for _, split := range t.Splits {
+		var tagids []int64
+		_, err := tx.Select(&tagids, "SELECT TagId from splittags where SplitId=? ORDER BY SplitTagId", split.SplitId)
+		if err != nil {
+			return err
+		}
+		split.TagIds = tagids
+	}
+	return nil
+}
+
+// keepTransactionTags sets the TagIds of t, and of each of its splits which
+// already exists, to the tags currently attached to them if they are nil
+func (tx *Tx) keepTransactionTags(t *models.Transaction) error {
+	existing := models.Transaction{TransactionId: t.TransactionId}
+	err := tx.getTransactionSplits(&existing)
+	if err != nil {
+		return err
+	}
+
+	if t.TagIds == nil {
+		t.TagIds = existing.TagIds
+	}
+	splitTags := make(map[int64][]int64)
+	for _, split := range existing.Splits {
+		splitTags[split.SplitId] = split.TagIds
+	}
+	for _, split := range t.Splits {
+		if split.TagIds == nil {
+			split.TagIds = splitTags[split.SplitId]
+		}
+	}
+	return nil
+}
+
+// deleteTransactionTags removes all tags from the transaction and its splits
+func (tx *Tx) deleteTransactionTags(transactionid int64) error {
+	_, err := tx.Exec("DELETE FROM splittags WHERE splittags.SplitId IN (SELECT splits.SplitId FROM splits WHERE splits.TransactionId=?)", transactionid)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM transactiontags WHERE TransactionId=?", transactionid)
+	return err
+}
+
+// uniqueTagIds returns tagids without any duplicates, in their original order
+func uniqueTagIds(tagids []int64) []int64 {
+	var unique []int64
+	seen := make(map[int64]bool)
+	for _, tagid := range tagids {
+		if !seen[tagid] {
+			seen[tagid] = true
+			unique = append(unique, tagid)
+		}
+	}
+	return unique
+}
+
+// insertTransactionTags attaches the tags in the TagIds of t and each of its
+// splits, which must already have been inserted, and whose tags must already
+// have been removed
+func (tx *Tx) insertTransactionTags(t *models.Transaction) error {
+	t.TagIds = uniqueTagIds(t.TagIds)
+	for _, tagid := range t.TagIds {
+		err := tx.Insert(&TransactionTag{
+			TransactionTagId: -1,
+			TransactionId:    t.TransactionId,
+			TagId:            tagid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, split := range t.Splits {
+		split.TagIds = uniqueTagIds(split.TagIds)
+		for _, tagid := range split.TagIds {
+			err := tx.Insert(&SplitTag{
+				SplitTagId: -1,
+				SplitId:    split.SplitId,
+				TagId:      tagid,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/aclindsa/moneygo/internal/models"
 	"github.com/aclindsa/moneygo/internal/store"
 	"math/big"
+	"strings"
 	"time"
 )
 
@@ -141,36 +142,104 @@ func (tx *Tx) InsertTransaction(t *models.Transaction, user *models.User) error
 		if err != nil {
 			return err
 		}
+		tagids := t.Splits[i].TagIds
 		*t.Splits[i] = *s.Split()
+		t.Splits[i].TagIds = tagids
 	}
 
-	return nil
+	return tx.insertTransactionTags(t)
 }
 
 func (tx *Tx) SplitExists(s *models.Split) (bool, error) {
+	// Splits without a RemoteId can't be matched against previous imports
+	if len(s.RemoteId) == 0 {
+		return false, nil
+	}
 	count, err := tx.SelectInt("SELECT COUNT(*) from splits where RemoteId=? and AccountId=?", s.RemoteId, s.AccountId)
-	return count == 1, err
+	return count > 0, err
+}
+
+// FindEnteredTransactions returns the user's transactions dated between begin
+// and end, inclusive, which contain a split with Entered status in the same
+// account and for the same amount as split
+func (tx *Tx) FindEnteredTransactions(user *models.User, split *models.Split, begin, end *time.Time) (*[]*models.Transaction, error) {
+	var transactions []*models.Transaction
+
+	whole, err := split.Amount.Whole()
+	if err != nil {
+		return nil, err
+	}
+	fractional, err := split.Amount.Fractional(MaxPrecision)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := "SELECT DISTINCT transactions.* FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId WHERE transactions.UserId=? AND splits.AccountId=? AND splits.Status=? AND splits.WholeAmount=? AND splits.FractionalAmount=? AND transactions.Date >= ? AND transactions.Date <= ? ORDER BY transactions.Date ASC, transactions.TransactionId ASC"
+	_, err = tx.Select(&transactions, sql, user.UserId, split.AccountId, models.Entered, whole, fractional, begin, end)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range transactions {
+		err := tx.getTransactionSplits(transactions[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &transactions, nil
+}
+
+// getTransactionSplits sets t's Splits, and the tags attached to t and its
+// splits
+func (tx *Tx) getTransactionSplits(t *models.Transaction) error {
+	var splits []*Split
+	_, err := tx.Select(&splits, "SELECT * from splits where TransactionId=?", t.TransactionId)
+	if err != nil {
+		return err
+	}
+
+	t.Splits = nil
+	for _, s := range splits {
+		t.Splits = append(t.Splits, s.Split())
+	}
+	return tx.getTransactionTags(t)
 }
 
 func (tx *Tx) GetTransaction(transactionid int64, userid int64) (*models.Transaction, error) {
 	var t models.Transaction
-	var splits []*Split
 
 	err := tx.SelectOne(&t, "SELECT * from transactions where UserId=? AND TransactionId=?", userid, transactionid)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = tx.Select(&splits, "SELECT * from splits where TransactionId=?", transactionid)
+	err = tx.getTransactionSplits(&t)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, split := range splits {
-		t.Splits = append(t.Splits, split.Split())
+	return &t, nil
+}
+
+// GetTransactionsByRemoteId returns the user's transactions containing a split
+// with remoteid
+func (tx *Tx) GetTransactionsByRemoteId(remoteid string, userid int64) (*[]*models.Transaction, error) {
+	var transactions []*models.Transaction
+
+	_, err := tx.Select(&transactions, "SELECT DISTINCT transactions.* FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId WHERE transactions.UserId=? AND splits.RemoteId=?", userid, remoteid)
+	if err != nil {
+		return nil, err
 	}
 
-	return &t, nil
+	for i := range transactions {
+		err := tx.getTransactionSplits(transactions[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &transactions, nil
 }
 
 func (tx *Tx) GetTransactions(userid int64) (*[]*models.Transaction, error) {
@@ -182,14 +251,10 @@ func (tx *Tx) GetTransactions(userid int64) (*[]*models.Transaction, error) {
 	}
 
 	for i := range transactions {
-		var splits []*Split
-		_, err := tx.Select(&splits, "SELECT * from splits where TransactionId=?", transactions[i].TransactionId)
+		err := tx.getTransactionSplits(transactions[i])
 		if err != nil {
 			return nil, err
 		}
-		for _, split := range splits {
-			transactions[i].Splits = append(transactions[i].Splits, split.Split())
-		}
 	}
 
 	return &transactions, nil
@@ -198,7 +263,19 @@ func (tx *Tx) GetTransactions(userid int64) (*[]*models.Transaction, error) {
 func (tx *Tx) UpdateTransaction(t *models.Transaction, user *models.User) error {
 	var existing_splits []*Split
 
-	_, err := tx.Select(&existing_splits, "SELECT * from splits where TransactionId=?", t.TransactionId)
+	// Tags are left as they are for t and any of its splits whose TagIds
+	// are nil. Remove all existing tags before any splits are deleted, and
+	// re-attach those in t once its splits are updated.
+	err := tx.keepTransactionTags(t)
+	if err != nil {
+		return err
+	}
+	err = tx.deleteTransactionTags(t.TransactionId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Select(&existing_splits, "SELECT * from splits where TransactionId=?", t.TransactionId)
 	if err != nil {
 		return err
 	}
@@ -236,7 +313,9 @@ func (tx *Tx) UpdateTransaction(t *models.Transaction, user *models.User) error
 				return err
 			}
 		}
+		tagids := t.Splits[i].TagIds
 		*t.Splits[i] = *s.Split()
+		t.Splits[i].TagIds = tagids
 		if t.Splits[i].AccountId != -1 {
 			a_map[s.AccountId] = true
 		}
@@ -274,7 +353,7 @@ func (tx *Tx) UpdateTransaction(t *models.Transaction, user *models.User) error
 		return fmt.Errorf("Updated %d transactions (expected 1)", count)
 	}
 
-	return nil
+	return tx.insertTransactionTags(t)
 }
 
 func (tx *Tx) DeleteTransaction(t *models.Transaction, user *models.User) error {
@@ -284,6 +363,11 @@ func (tx *Tx) DeleteTransaction(t *models.Transaction, user *models.User) error
 		return err
 	}
 
+	err = tx.deleteTransactionTags(t.TransactionId)
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.Exec("DELETE FROM splits WHERE TransactionId=?", t.TransactionId)
 	if err != nil {
 		return err
@@ -342,6 +426,31 @@ func (tx *Tx) GetAccountBalanceDateRange(user *models.User, accountid int64, beg
 	return tx.getAccountBalance(" AND transactions.Date >= ? AND transactions.Date < ?", accountid, user.UserId, begin, end)
 }
 
+// transactionTagSQL matches transactions with the tag attached to them or any
+// of their splits, and splitTagSQL matches splits with the tag attached to
+// them or their transaction. Each takes the tag's ID as both its arguments.
+const (
+	transactionTagSQL = " AND (transactions.TransactionId IN (SELECT transactiontags.TransactionId FROM transactiontags WHERE transactiontags.TagId=?) OR transactions.TransactionId IN (SELECT tagged.TransactionId FROM splittags INNER JOIN splits AS tagged ON tagged.SplitId = splittags.SplitId WHERE splittags.TagId=?))"
+	splitTagSQL       = " AND (transactions.TransactionId IN (SELECT transactiontags.TransactionId FROM transactiontags WHERE transactiontags.TagId=?) OR splits.SplitId IN (SELECT splittags.SplitId FROM splittags WHERE splittags.TagId=?))"
+)
+
+// GetAccountTagBalance returns the balance of the account's splits which have
+// the tag attached to them or their transaction, optionally only including
+// those dated on or after begin and/or before end
+func (tx *Tx) GetAccountTagBalance(user *models.User, accountid int64, tagid int64, begin, end *time.Time) (*models.Amount, error) {
+	xtrasql := splitTagSQL
+	args := []interface{}{accountid, user.UserId, tagid, tagid}
+	if begin != nil {
+		xtrasql += " AND transactions.Date >= ?"
+		args = append(args, *begin)
+	}
+	if end != nil {
+		xtrasql += " AND transactions.Date < ?"
+		args = append(args, *end)
+	}
+	return tx.getAccountBalance(xtrasql, args...)
+}
+
 func (tx *Tx) transactionsBalanceDifference(accountid int64, transactions []*models.Transaction) (*big.Rat, error) {
 	var pageDifference big.Rat
 	for i := range transactions {
@@ -359,14 +468,29 @@ func (tx *Tx) transactionsBalanceDifference(accountid int64, transactions []*mod
 				pageDifference.Add(&pageDifference, &transactions[i].Splits[j].Amount.Rat)
 			}
 		}
+
+		err = tx.getTransactionTags(transactions[i])
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &pageDifference, nil
 }
 
-func (tx *Tx) GetAccountTransactions(user *models.User, accountid int64, sort string, page uint64, limit uint64) (*models.AccountTransactionsList, error) {
+// GetAccountTransactions returns a page of the transactions with splits in the
+// account, only including those tagged with tagid unless it is -1
+func (tx *Tx) GetAccountTransactions(user *models.User, accountid int64, tagid int64, sort string, page uint64, limit uint64) (*models.AccountTransactionsList, error) {
 	var transactions []*models.Transaction
 	var atl models.AccountTransactionsList
 
+	// Only include transactions tagged with tagid, if one was given
+	where := " WHERE transactions.UserId=? AND splits.AccountId=?"
+	args := []interface{}{user.UserId, accountid}
+	if tagid != -1 {
+		where += transactionTagSQL
+		args = append(args, tagid, tagid)
+	}
+
 	var sqlsort, balanceLimitOffset string
 	var balanceLimitOffsetArg uint64
 	if sort == "date-asc" {
@@ -394,8 +518,8 @@ func (tx *Tx) GetAccountTransactions(user *models.User, accountid int64, sort st
 	}
 	atl.Account = account
 
-	sql := "SELECT DISTINCT transactions.* FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId WHERE transactions.UserId=? AND splits.AccountId=?" + sqlsort + " LIMIT ?" + sqloffset
-	_, err = tx.Select(&transactions, sql, user.UserId, accountid, limit)
+	sql := "SELECT DISTINCT transactions.* FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId" + where + sqlsort + " LIMIT ?" + sqloffset
+	_, err = tx.Select(&transactions, sql, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -406,7 +530,7 @@ func (tx *Tx) GetAccountTransactions(user *models.User, accountid int64, sort st
 		return nil, err
 	}
 
-	count, err := tx.SelectInt("SELECT count(DISTINCT transactions.TransactionId) FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId WHERE transactions.UserId=? AND splits.AccountId=?", user.UserId, accountid)
+	count, err := tx.SelectInt("SELECT count(DISTINCT transactions.TransactionId) FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId"+where, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -422,8 +546,9 @@ func (tx *Tx) GetAccountTransactions(user *models.User, accountid int64, sort st
 
 	// Sum all the splits for all transaction splits for this account that
 	// occurred before the page we're returning
-	sql = "FROM splits AS s INNER JOIN (SELECT DISTINCT transactions.Date, transactions.TransactionId FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId WHERE transactions.UserId=? AND splits.AccountId=?" + sqlsort + balanceLimitOffset + ") as t ON s.TransactionId = t.TransactionId WHERE s.AccountId=?"
-	count, err = tx.SelectInt("SELECT count(*) "+sql, user.UserId, accountid, balanceLimitOffsetArg, accountid)
+	sql = "FROM splits AS s INNER JOIN (SELECT DISTINCT transactions.Date, transactions.TransactionId FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId" + where + sqlsort + balanceLimitOffset + ") as t ON s.TransactionId = t.TransactionId WHERE s.AccountId=?"
+	balanceArgs := append(args, balanceLimitOffsetArg, accountid)
+	count, err = tx.SelectInt("SELECT count(*) "+sql, balanceArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -434,11 +559,11 @@ func (tx *Tx) GetAccountTransactions(user *models.User, accountid int64, sort st
 	// supposed to return null/nil in this case, which makes gorp angry since
 	// we're using SelectInt()
 	if count > 0 {
-		whole, err := tx.SelectInt("SELECT sum(s.WholeAmount) "+sql, user.UserId, accountid, balanceLimitOffsetArg, accountid)
+		whole, err := tx.SelectInt("SELECT sum(s.WholeAmount) "+sql, balanceArgs...)
 		if err != nil {
 			return nil, err
 		}
-		fractional, err := tx.SelectInt("SELECT sum(s.FractionalAmount) "+sql, user.UserId, accountid, balanceLimitOffsetArg, accountid)
+		fractional, err := tx.SelectInt("SELECT sum(s.FractionalAmount) "+sql, balanceArgs...)
 		if err != nil {
 			return nil, err
 		}
@@ -450,3 +575,198 @@ func (tx *Tx) GetAccountTransactions(user *models.User, accountid int64, sort st
 
 	return &atl, nil
 }
+
+func (tx *Tx) GetAccountReconciledBalance(user *models.User, accountid int64) (*models.Amount, error) {
+	return tx.getAccountBalance(" AND splits.Status=?", accountid, user.UserId, models.Reconciled)
+}
+
+// GetUnreconciledTransactions returns the user's transactions dated on or
+// before date which have a split in the account which is neither Reconciled
+// nor Voided
+func (tx *Tx) GetUnreconciledTransactions(user *models.User, accountid int64, date *time.Time) (*[]*models.Transaction, error) {
+	var transactions []*models.Transaction
+
+	sql := "SELECT DISTINCT transactions.* FROM transactions INNER JOIN splits ON transactions.TransactionId = splits.TransactionId WHERE transactions.UserId=? AND splits.AccountId=? AND splits.Status!=? AND splits.Status!=? AND transactions.Date <= ? ORDER BY transactions.Date ASC, transactions.TransactionId ASC"
+	_, err := tx.Select(&transactions, sql, user.UserId, accountid, models.Reconciled, models.Voided, date)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range transactions {
+		err := tx.getTransactionSplits(transactions[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &transactions, nil
+}
+
+// likeSubstring returns a pattern for case-insensitively matching s anywhere
+// in a lowercased column with "LIKE ? ESCAPE '!'"
+func likeSubstring(s string) string {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(s))
+	return "%" + escaped + "%"
+}
+
+// inPlaceholders returns "(?,?,...)" with n placeholders, and ids as a slice of
+// arguments to match them
+func inPlaceholders(ids []int64) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return "(" + strings.Join(placeholders, ",") + ")", args
+}
+
+// searchAccountIds returns the IDs of the user's accounts matched by the
+// search's AccountId and SubAccounts, and of its Imbalances accounts
+func (tx *Tx) searchAccountIds(user *models.User, search *models.TransactionSearch) (accountids, imbalanceids []int64, err error) {
+	accounts, err := tx.GetAccounts(user.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	children := make(map[int64][]int64)
+	for _, a := range *accounts {
+		children[a.ParentAccountId] = append(children[a.ParentAccountId], a.AccountId)
+	}
+	descendants := func(accountid int64) []int64 {
+		ids := []int64{accountid}
+		for i := 0; i < len(ids); i++ {
+			ids = append(ids, children[ids[i]]...)
+		}
+		return ids
+	}
+
+	if search.AccountId != -1 {
+		if search.SubAccounts {
+			accountids = descendants(search.AccountId)
+		} else {
+			accountids = []int64{search.AccountId}
+		}
+	}
+	for _, a := range *accounts {
+		if a.ParentAccountId == -1 && a.Name == "Imbalances" {
+			imbalanceids = append(imbalanceids, descendants(a.AccountId)...)
+		}
+	}
+	return accountids, imbalanceids, nil
+}
+
+// SearchTransactions returns the page of the user's transactions matching
+// search, each with all of its splits
+func (tx *Tx) SearchTransactions(user *models.User, search *models.TransactionSearch) (*models.TransactionSearchResults, error) {
+	var results models.TransactionSearchResults
+	transactions := []*models.Transaction{}
+	results.Transactions = &transactions
+
+	accountids, imbalanceids, err := tx.searchAccountIds(user, search)
+	if err != nil {
+		return nil, err
+	}
+	if search.HasImbalance && len(imbalanceids) == 0 {
+		return &results, nil
+	}
+
+	where := " WHERE transactions.UserId=?"
+	args := []interface{}{user.UserId}
+
+	if len(search.Text) > 0 {
+		pattern := likeSubstring(search.Text)
+		where += " AND (LOWER(transactions.Description) LIKE ? ESCAPE '!' OR EXISTS (SELECT 1 FROM splits WHERE splits.TransactionId = transactions.TransactionId AND (LOWER(splits.Memo) LIKE ? ESCAPE '!' OR LOWER(splits.Number) LIKE ? ESCAPE '!')))"
+		args = append(args, pattern, pattern, pattern)
+	}
+	if search.Begin != nil {
+		where += " AND transactions.Date >= ?"
+		args = append(args, *search.Begin)
+	}
+	if search.End != nil {
+		where += " AND transactions.Date < ?"
+		args = append(args, *search.End)
+	}
+
+	var splitwhere string
+	var splitargs []interface{}
+	if search.MinAmount != nil {
+		whole, fractional, err := amountParts(search.MinAmount)
+		if err != nil {
+			return nil, err
+		}
+		splitwhere += " AND (splits.WholeAmount > ? OR (splits.WholeAmount = ? AND splits.FractionalAmount >= ?))"
+		splitargs = append(splitargs, whole, whole, fractional)
+	}
+	if search.MaxAmount != nil {
+		whole, fractional, err := amountParts(search.MaxAmount)
+		if err != nil {
+			return nil, err
+		}
+		splitwhere += " AND (splits.WholeAmount < ? OR (splits.WholeAmount = ? AND splits.FractionalAmount <= ?))"
+		splitargs = append(splitargs, whole, whole, fractional)
+	}
+	if len(accountids) > 0 {
+		in, inargs := inPlaceholders(accountids)
+		splitwhere += " AND splits.AccountId IN " + in
+		splitargs = append(splitargs, inargs...)
+	}
+	if search.Status != 0 {
+		splitwhere += " AND splits.Status = ?"
+		splitargs = append(splitargs, search.Status)
+	}
+	if search.SecurityId != -1 {
+		splitwhere += " AND splits.AccountId IN (SELECT AccountId FROM accounts WHERE UserId=? AND SecurityId=?)"
+		splitargs = append(splitargs, user.UserId, search.SecurityId)
+	}
+	if search.TagId != -1 {
+		splitwhere += splitTagSQL
+		splitargs = append(splitargs, search.TagId, search.TagId)
+	}
+	if len(splitwhere) > 0 {
+		where += " AND EXISTS (SELECT 1 FROM splits WHERE splits.TransactionId = transactions.TransactionId" + splitwhere + ")"
+		args = append(args, splitargs...)
+	}
+
+	if search.HasImbalance {
+		in, inargs := inPlaceholders(imbalanceids)
+		where += " AND EXISTS (SELECT 1 FROM splits WHERE splits.TransactionId = transactions.TransactionId AND splits.AccountId IN " + in + ")"
+		args = append(args, inargs...)
+	}
+
+	count, err := tx.SelectInt("SELECT count(*) FROM transactions"+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	results.TotalTransactions = count
+
+	var sqlsort string
+	switch search.Sort {
+	case "date-asc":
+		sqlsort = " ORDER BY transactions.Date ASC, transactions.TransactionId ASC"
+	case "description-asc":
+		sqlsort = " ORDER BY transactions.Description ASC, transactions.Date DESC, transactions.TransactionId DESC"
+	case "description-desc":
+		sqlsort = " ORDER BY transactions.Description DESC, transactions.Date DESC, transactions.TransactionId DESC"
+	default:
+		sqlsort = " ORDER BY transactions.Date DESC, transactions.TransactionId DESC"
+	}
+
+	var sqloffset string
+	if search.Page > 0 {
+		sqloffset = fmt.Sprintf(" OFFSET %d", search.Page*search.Limit)
+	}
+
+	_, err = tx.Select(&transactions, "SELECT transactions.* FROM transactions"+where+sqlsort+" LIMIT ?"+sqloffset, append(args, search.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range transactions {
+		err := tx.getTransactionSplits(transactions[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &results, nil
+}
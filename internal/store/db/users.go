@@ -57,6 +57,18 @@ func (tx *Tx) DeleteUser(user *models.User) error {
 	if err != nil {
 		return err
 	}
+	_, err = tx.Exec("DELETE FROM splittags WHERE splittags.TagId IN (SELECT tags.TagId FROM tags WHERE tags.UserId=?)", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM transactiontags WHERE transactiontags.TagId IN (SELECT tags.TagId FROM tags WHERE tags.UserId=?)", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE tags.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
 	_, err = tx.Exec("DELETE FROM splits WHERE splits.TransactionId IN (SELECT transactions.TransactionId FROM transactions WHERE transactions.UserId=?)", user.UserId)
 	if err != nil {
 		return err
@@ -77,6 +89,58 @@ func (tx *Tx) DeleteUser(user *models.User) error {
 	if err != nil {
 		return err
 	}
+	_, err = tx.Exec("DELETE FROM csvimportmappings WHERE csvimportmappings.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM ruletargets WHERE ruletargets.RuleId IN (SELECT rules.RuleId FROM rules WHERE rules.UserId=?)", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM rules WHERE rules.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM ofxsyncs WHERE ofxsyncs.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM importbatchitems WHERE importbatchitems.ImportBatchId IN (SELECT importbatches.ImportBatchId FROM importbatches WHERE importbatches.UserId=?)", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM importbatches WHERE importbatches.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM scheduledsplits WHERE scheduledsplits.ScheduledTransactionId IN (SELECT scheduledtransactions.ScheduledTransactionId FROM scheduledtransactions WHERE scheduledtransactions.UserId=?)", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM scheduledtransactions WHERE scheduledtransactions.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM reminders WHERE reminders.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM reconciliationsplits WHERE reconciliationsplits.ReconciliationId IN (SELECT reconciliations.ReconciliationId FROM reconciliations WHERE reconciliations.UserId=?)", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM reconciliations WHERE reconciliations.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM budgetamounts WHERE budgetamounts.BudgetId IN (SELECT budgets.BudgetId FROM budgets WHERE budgets.UserId=?)", user.UserId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM budgets WHERE budgets.UserId=?", user.UserId)
+	if err != nil {
+		return err
+	}
 	_, err = tx.Exec("DELETE FROM sessions WHERE sessions.UserId=?", user.UserId)
 	if err != nil {
 		return err
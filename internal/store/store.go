@@ -84,15 +84,21 @@ func (ame AccountMissingError) Error() string {
 
 type TransactionStore interface {
 	SplitExists(s *models.Split) (bool, error)
+	FindEnteredTransactions(user *models.User, split *models.Split, begin, end *time.Time) (*[]*models.Transaction, error)
 	InsertTransaction(t *models.Transaction, user *models.User) error
 	GetTransaction(transactionid int64, userid int64) (*models.Transaction, error)
 	GetTransactions(userid int64) (*[]*models.Transaction, error)
+	GetTransactionsByRemoteId(remoteid string, userid int64) (*[]*models.Transaction, error)
 	UpdateTransaction(t *models.Transaction, user *models.User) error
 	DeleteTransaction(t *models.Transaction, user *models.User) error
 	GetAccountBalance(user *models.User, accountid int64) (*models.Amount, error)
 	GetAccountBalanceDate(user *models.User, accountid int64, date *time.Time) (*models.Amount, error)
 	GetAccountBalanceDateRange(user *models.User, accountid int64, begin, end *time.Time) (*models.Amount, error)
-	GetAccountTransactions(user *models.User, accountid int64, sort string, page uint64, limit uint64) (*models.AccountTransactionsList, error)
+	GetAccountTagBalance(user *models.User, accountid int64, tagid int64, begin, end *time.Time) (*models.Amount, error)
+	GetAccountTransactions(user *models.User, accountid int64, tagid int64, sort string, page uint64, limit uint64) (*models.AccountTransactionsList, error)
+	SearchTransactions(user *models.User, search *models.TransactionSearch) (*models.TransactionSearchResults, error)
+	GetAccountReconciledBalance(user *models.User, accountid int64) (*models.Amount, error)
+	GetUnreconciledTransactions(user *models.User, accountid int64, date *time.Time) (*[]*models.Transaction, error)
 }
 
 type ReportStore interface {
@@ -103,6 +109,79 @@ type ReportStore interface {
 	DeleteReport(report *models.Report) error
 }
 
+type CSVImportMappingStore interface {
+	CSVImportMappingExists(accountid int64, userid int64) (bool, error)
+	InsertCSVImportMapping(mapping *models.CSVImportMapping) error
+	GetCSVImportMapping(accountid int64, userid int64) (*models.CSVImportMapping, error)
+	UpdateCSVImportMapping(mapping *models.CSVImportMapping) error
+}
+
+type RuleStore interface {
+	InsertRule(rule *models.Rule) error
+	GetRule(ruleid int64, userid int64) (*models.Rule, error)
+	GetRules(userid int64) (*[]*models.Rule, error) // Sorted in the order they should be applied
+	UpdateRule(rule *models.Rule) error
+	DeleteRule(rule *models.Rule) error
+}
+
+type OFXSyncStore interface {
+	InsertOFXSync(sync *models.OFXSync) error
+	GetOFXSync(ofxsyncid int64, userid int64) (*models.OFXSync, error)
+	GetOFXSyncs(userid int64) (*[]*models.OFXSync, error)
+	OFXSyncExists(accountid int64, userid int64) (bool, error)
+	GetDueOFXSyncs(now *time.Time) (*[]*models.OFXSync, error) // For all users, in the order they became due
+	UpdateOFXSync(sync *models.OFXSync) error
+	DeleteOFXSync(sync *models.OFXSync) error
+}
+
+type ImportBatchStore interface {
+	InsertImportBatch(batch *models.ImportBatch) error
+	GetImportBatch(importbatchid int64, userid int64) (*models.ImportBatch, error)
+	GetImportBatches(userid int64) (*[]*models.ImportBatch, error) // Most recent first
+	DeleteImportBatch(batch *models.ImportBatch) error
+}
+
+type ScheduledTransactionStore interface {
+	InsertScheduledTransaction(s *models.ScheduledTransaction) error
+	GetScheduledTransaction(scheduledtransactionid int64, userid int64) (*models.ScheduledTransaction, error)
+	GetScheduledTransactions(userid int64) (*[]*models.ScheduledTransaction, error)      // Sorted by NextDate
+	GetDueScheduledTransactions(now *time.Time) (*[]*models.ScheduledTransaction, error) // For all users, unfinished ones in the order they became due
+	UpdateScheduledTransaction(s *models.ScheduledTransaction) error
+	DeleteScheduledTransaction(s *models.ScheduledTransaction) error // Also deletes its reminders
+}
+
+type ReminderStore interface {
+	InsertReminder(reminder *models.Reminder) error
+	GetReminder(reminderid int64, userid int64) (*models.Reminder, error)
+	GetReminders(userid int64) (*[]*models.Reminder, error) // Oldest first
+	DeleteReminder(reminder *models.Reminder) error
+}
+
+type ReconciliationStore interface {
+	InsertReconciliation(r *models.Reconciliation) error
+	GetReconciliation(reconciliationid int64, accountid int64, userid int64) (*models.Reconciliation, error)
+	GetReconciliations(accountid int64, userid int64) (*[]*models.Reconciliation, error) // Most recent statement first
+	UpdateReconciliation(r *models.Reconciliation) error
+	FinishReconciliation(r *models.Reconciliation, user *models.User) error // Marks its splits Reconciled
+	DeleteReconciliation(r *models.Reconciliation) error
+}
+
+type BudgetStore interface {
+	InsertBudget(budget *models.Budget) error
+	GetBudget(budgetid int64, userid int64) (*models.Budget, error)
+	GetBudgets(userid int64) (*[]*models.Budget, error)
+	UpdateBudget(budget *models.Budget) error
+	DeleteBudget(budget *models.Budget) error
+}
+
+type TagStore interface {
+	InsertTag(tag *models.Tag) error
+	GetTag(tagid int64, userid int64) (*models.Tag, error)
+	GetTags(userid int64) (*[]*models.Tag, error)
+	UpdateTag(tag *models.Tag) error
+	DeleteTag(tag *models.Tag) error // Also removes it from transactions and splits
+}
+
 type Tx interface {
 	Commit() error
 	Rollback() error
@@ -114,6 +193,15 @@ type Tx interface {
 	AccountStore
 	TransactionStore
 	ReportStore
+	CSVImportMappingStore
+	RuleStore
+	OFXSyncStore
+	ImportBatchStore
+	ScheduledTransactionStore
+	ReminderStore
+	ReconciliationStore
+	BudgetStore
+	TagStore
 }
 
 type Store interface {
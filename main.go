@@ -15,6 +15,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"time"
 )
 
 var configFile string
@@ -72,9 +73,22 @@ func main() {
 	}
 	defer db.Close()
 
+	apihandler := &handlers.APIHandler{Store: db}
+	if cfg.OFXSync.Enabled {
+		apihandler.OFXSync, err = handlers.NewOFXSyncer(db, cfg.OFXSync.Key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Syncing accounts via OFX, checking every %d minutes", cfg.OFXSync.CheckInterval)
+		go apihandler.OFXSync.Run(time.Duration(cfg.OFXSync.CheckInterval)*time.Minute, nil)
+	}
+
+	scheduler := &handlers.Scheduler{Store: db}
+	go scheduler.Run(time.Duration(cfg.Schedule.CheckInterval)*time.Minute, nil)
+
 	// Get ServeMux for API and add our own handlers for files
 	servemux := http.NewServeMux()
-	servemux.Handle("/v1/", &handlers.APIHandler{Store: db})
+	servemux.Handle("/v1/", apihandler)
 	servemux.HandleFunc("/", FileHandlerFunc(rootHandler, cfg.MoneyGo.Basedir))
 	servemux.HandleFunc("/static/", FileHandlerFunc(staticHandler, cfg.MoneyGo.Basedir))
 